@@ -0,0 +1,64 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+)
+
+// command 命令行子命令，不带子命令时执行 serve
+type command struct {
+	name   string
+	usage  string
+	needDB bool
+	run    func(args []string) error
+}
+
+var commands = []*command{
+	{name: "serve", usage: "启动 http 服务", needDB: true, run: runServe},
+	{name: "migrate", usage: "数据库结构变更：up [-to version] | down [-steps n] | status", needDB: true, run: runMigrate},
+	{name: "operator", usage: "操作员管理：create | disable | enable | reset-password", needDB: true, run: runOperator},
+	{name: "customer", usage: "会员导入导出：import -file | export [-file]", needDB: true, run: runCustomer},
+	{name: "report", usage: "报表：settle -date 2006-01-02 [-json]", needDB: true, run: runReport},
+	{name: "config", usage: "配置检查：check", run: runConfig},
+}
+
+func findCommand(name string) *command {
+	for _, cmd := range commands {
+		if cmd.name == name {
+			return cmd
+		}
+	}
+	return nil
+}
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "usage: %s [flags] [command] [args]\n\ncommands:\n", os.Args[0])
+	for _, cmd := range commands {
+		fmt.Fprintf(os.Stderr, "  %-10s %s\n", cmd.name, cmd.usage)
+	}
+	fmt.Fprintf(os.Stderr, "\nflags:\n")
+	flag.PrintDefaults()
+}
+
+// subcommand 从参数中取出二级子命令，如 operator create
+func subcommand(args []string) (string, []string, error) {
+	if len(args) == 0 || len(args[0]) == 0 || args[0][0] == '-' {
+		return "", nil, fmt.Errorf("missing subcommand")
+	}
+	return args[0], args[1:], nil
+}
+
+// requireFlags 检查必填的参数
+func requireFlags(fs *flag.FlagSet, names ...string) error {
+	set := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) {
+		set[f.Name] = f.Value.String() != ""
+	})
+	for _, name := range names {
+		if !set[name] {
+			return fmt.Errorf("-%s is required", name)
+		}
+	}
+	return nil
+}
@@ -2,10 +2,13 @@ package config
 
 import (
 	"encoding/json"
+	"fmt"
 	"io/ioutil"
-	"log"
-
-	simplejson "github.com/bitly/go-simplejson"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
 )
 
 // 系统运行环境
@@ -14,29 +17,122 @@ const (
 	Dev  = "dev"
 )
 
-//KafkaConfig kafka config
-type KafkaConfig struct {
-	RealtimeLogKafkaTopic string `json:"realtime_log_kafka_topic"`
-	ReqRespLogKafkaTopic  string `json:"req_resp_log_kafka_topic"`
-	KafkaService          string `json:"kafka_service"`
+// ServerConfig http 服务配置
+type ServerConfig struct {
+	Addr            string `json:"addr" env:"FLAMINGO_SERVER_ADDR"`
+	ReadTimeout     int    `json:"read_timeout"`                                     // 秒
+	WriteTimeout    int    `json:"write_timeout"`                                    // 秒
+	ShutdownTimeout int    `json:"shutdown_timeout" env:"FLAMINGO_SHUTDOWN_TIMEOUT"` // 退出时等待处理中请求的最长时间，秒
+	DrainSeconds    int    `json:"drain_seconds" env:"FLAMINGO_DRAIN_SECONDS"`       // 收到退出信号后 /readyz 返回失败、继续处理请求的时间，秒
+	MetricsAddr     string `json:"metrics_addr" env:"FLAMINGO_METRICS_ADDR"`         // 监控指标的内网监听地址，为空时 /metrics 需要管理员登录
+	BaseURL         string `json:"base_url" env:"FLAMINGO_BASE_URL"`                 // 对外访问地址，如 https://flamingo.example.com，用于生成回调地址
+}
+
+// DBConfig mysql 配置
+type DBConfig struct {
+	Host     string `json:"host" env:"FLAMINGO_DB_HOST"`
+	Username string `json:"username" env:"FLAMINGO_DB_USERNAME"`
+	Password string `json:"password" env:"FLAMINGO_DB_PASSWORD"`
+	Database string `json:"database" env:"FLAMINGO_DB_DATABASE"`
+	// AutoMigrate 启动时自动执行未执行的 migration
+	AutoMigrate bool `json:"auto_migrate" env:"FLAMINGO_DB_AUTO_MIGRATE"`
+}
+
+// SmsConfig 腾讯云短信配置
+type SmsConfig struct {
+	AppID        string `json:"app_id" env:"FLAMINGO_SMS_APP_ID"`
+	AppKey       string `json:"app_key" env:"FLAMINGO_SMS_APP_KEY"`
+	PayCodeTplID int    `json:"pay_code_tpl_id" env:"FLAMINGO_SMS_PAY_CODE_TPL_ID"`
+	// ReportToken 状态回调地址中的 token 参数，回调地址配置为 /sms/report?token=xxx，为空时拒绝所有回调
+	ReportToken string `json:"report_token" env:"FLAMINGO_SMS_REPORT_TOKEN"`
+	APIBase     string `json:"api_base" env:"FLAMINGO_SMS_API_BASE"` // 可配置为本地 mock 服务
+}
+
+// WechatConfig 微信公众号配置
+type WechatConfig struct {
+	Token          string            `json:"token" env:"FLAMINGO_WECHAT_TOKEN"`
+	AppID          string            `json:"app_id" env:"FLAMINGO_WECHAT_APP_ID"`
+	AppSecret      string            `json:"app_secret" env:"FLAMINGO_WECHAT_APP_SECRET"`
+	EncodingAESKey string            `json:"encoding_aes_key" env:"FLAMINGO_WECHAT_ENCODING_AES_KEY"`
+	OAuthScope     string            `json:"oauth_scope"`
+	OAuthRedirect  string            `json:"oauth_redirect"`
+	APIBase        string            `json:"api_base" env:"FLAMINGO_WECHAT_API_BASE"` // 可配置为本地 mock 服务
+	Templates      map[string]string `json:"templates"`                               // 模板消息类型 -> 模板ID
+	Menu           json.RawMessage   `json:"menu"`                                    // 自定义菜单
+}
+
+// SessionConfig 会话相关密钥
+type SessionConfig struct {
+	AESKey             string `json:"aes_key" env:"FLAMINGO_SESSION_AES_KEY"` // cookie 加密密钥，16/24/32 字节
+	PaymentTokenSecret string `json:"payment_token_secret" env:"FLAMINGO_PAYMENT_TOKEN_SECRET"`
 }
 
-// EncryptSettings 加密信息配置
-type EncryptSettings struct {
-	BusinessName string `json:"business_name"`
-	Token        string `json:"token"`
+// CookieConfig cookie 配置
+type CookieConfig struct {
+	Domain string `json:"domain" env:"FLAMINGO_COOKIE_DOMAIN"`
+	Secure bool   `json:"secure" env:"FLAMINGO_COOKIE_SECURE"`
+	MaxAge int    `json:"max_age" env:"FLAMINGO_COOKIE_MAX_AGE"` // 登录 cookie 和 bearer token 的有效期，秒
+}
+
+// WechatPayConfig 微信支付商户配置
+type WechatPayConfig struct {
+	AppID  string `json:"app_id"`
+	MchID  string `json:"mch_id"`
+	APIKey string `json:"api_key" env:"FLAMINGO_PAY_WECHAT_API_KEY"`
+}
+
+// AlipayConfig 支付宝配置
+type AlipayConfig struct {
+	AppID           string `json:"app_id"`
+	PrivateKey      string `json:"private_key" env:"FLAMINGO_PAY_ALIPAY_PRIVATE_KEY"`
+	AlipayPublicKey string `json:"alipay_public_key"`
+}
+
+// PayConfig 在线充值配置
+type PayConfig struct {
+	Gateway             string          `json:"gateway"`
+	NotifyURLBase       string          `json:"notify_url_base"`
+	OrderTimeoutMinutes int             `json:"order_timeout_minutes"`
+	MaxAmount           float64         `json:"max_amount"` // 单笔充值上限，元
+	Wechat              WechatPayConfig `json:"wechat"`
+	Alipay              AlipayConfig    `json:"alipay"`
+	// FakeEnabled 启用本地测试用的 fake 支付网关和 /pay/fake_complete，需要同时配置 FakeSecret，线上环境不能启用
+	FakeEnabled bool   `json:"fake_enabled" env:"FLAMINGO_PAY_FAKE_ENABLED"`
+	FakeSecret  string `json:"fake_secret" env:"FLAMINGO_PAY_FAKE_SECRET"`
+}
+
+// NotifyConfig 账户变动通知配置
+type NotifyConfig struct {
+	Channels   []string `json:"channels"`
+	SmsTplID   int      `json:"sms_tpl_id"`
+	QuietStart string   `json:"quiet_start"` // 免打扰开始时间，如 22:00
+	QuietEnd   string   `json:"quiet_end"`
 }
 
 // Config 配置信息
 type Config struct {
-	Env string `json:"env"`
+	Env                     string        `json:"env" env:"FLAMINGO_ENV"`
+	StoreName               string        `json:"store_name"`
+	Server                  ServerConfig  `json:"server"`
+	DB                      DBConfig      `json:"flamingo_db"`
+	Sms                     SmsConfig     `json:"sms"`
+	Wechat                  WechatConfig  `json:"wechat"`
+	Session                 SessionConfig `json:"session"`
+	Cookie                  CookieConfig  `json:"cookie"`
+	Pay                     PayConfig     `json:"pay"`
+	Notify                  NotifyConfig  `json:"notify"`
+	ConsumeConfirmThreshold float64       `json:"consume_confirm_threshold"` // 超过该金额(元)的消费需要会员确认码
+	AdminOperators          []string      `json:"admin_operators"`           // 管理员手机号
+
+	// 旧版配置中的字段，仅在新字段未配置时使用
+	LegacyHost      string `json:"host"`
+	LegacySmsAppID  string `json:"app_id"`
+	LegacySmsAppKey string `json:"app_key"`
 }
 
 var (
 	// ConfigInstance 当前环境配置信息
 	ConfigInstance *Config
-	// origin File 将配置信息解成json
-	ConfigJson *simplejson.Json
 )
 
 // Product 检查当前环境是否是线上环境
@@ -44,44 +140,213 @@ func (c *Config) Product() bool {
 	return c.Env == Prod
 }
 
-// Init 初始化配置文件
-func Init(file string) error {
-	conf, err := loadConfig(file)
+// Init 初始化配置，依次加载默认值、配置文件、密钥文件和环境变量，secretsFile 为空时不加载
+func Init(file, secretsFile string) error {
+	conf, err := Load(file, secretsFile)
 	if err != nil {
-		log.Fatalf("init conf instance error:%+v\n", err)
 		return err
 	}
 	ConfigInstance = conf
+	return nil
+}
 
-	js, err := loadDBSettings(file)
-	if err != nil {
-		log.Fatalf("init conf as DBSettings error: %+v\n", err)
-		return err
+// Load 加载并校验配置
+func Load(file, secretsFile string) (*Config, error) {
+	conf := defaultConfig()
+	if err := loadFile(file, conf); err != nil {
+		return nil, err
+	}
+	if secretsFile != "" {
+		if err := loadFile(secretsFile, conf); err != nil {
+			return nil, err
+		}
+	}
+	if err := applyEnv(reflect.ValueOf(conf).Elem()); err != nil {
+		return nil, err
+	}
+	conf.applyLegacy()
+	if err := conf.Validate(); err != nil {
+		return nil, err
+	}
+	return conf, nil
+}
+
+func defaultConfig() *Config {
+	return &Config{
+		Server: ServerConfig{
+			Addr:            ":8002",
+			ReadTimeout:     30,
+			WriteTimeout:    30,
+			ShutdownTimeout: 20,
+			DrainSeconds:    5,
+		},
+		Sms: SmsConfig{
+			APIBase: "https://yun.tim.qq.com",
+		},
+		Wechat: WechatConfig{
+			OAuthScope: "snsapi_base",
+			APIBase:    "https://api.weixin.qq.com",
+		},
+		Cookie: CookieConfig{MaxAge: 3600 * 24},
+		Pay: PayConfig{
+			OrderTimeoutMinutes: 15,
+			MaxAmount:           5000,
+		},
 	}
-	ConfigJson = js //Need 解析原始信息，被应用灵活使用
-	return nil
 }
 
-func loadDBSettings(file string) (*simplejson.Json, error) {
+// loadFile 将 json 文件中配置了的字段覆盖到 conf 上
+func loadFile(file string, conf *Config) error {
 	body, err := ioutil.ReadFile(file)
 	if err != nil {
-		log.Fatalf("load config file %s as DBSettings error: %+v", file, err)
-		return nil, err
+		return fmt.Errorf("read config file %s error: %v", file, err)
+	}
+	if err := json.Unmarshal(body, conf); err != nil {
+		return fmt.Errorf("parse config file %s error: %v", file, err)
 	}
-	return simplejson.NewJson(body)
+	return nil
 }
 
-func loadConfig(file string) (*Config, error) {
-	confContent, err := ioutil.ReadFile(file)
-	if err != nil {
-		log.Fatalf("create new config error: %+v\n", err)
-		return nil, err
+// applyEnv 使用 env tag 指定的环境变量覆盖配置
+func applyEnv(v reflect.Value) error {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		field := v.Field(i)
+		if field.Kind() == reflect.Struct {
+			if err := applyEnv(field); err != nil {
+				return err
+			}
+			continue
+		}
+		name := t.Field(i).Tag.Get("env")
+		if name == "" {
+			continue
+		}
+		value, ok := os.LookupEnv(name)
+		if !ok {
+			continue
+		}
+		switch field.Kind() {
+		case reflect.String:
+			field.SetString(value)
+		case reflect.Int:
+			n, err := strconv.Atoi(value)
+			if err != nil {
+				return fmt.Errorf("env %s must be an integer, got %q", name, value)
+			}
+			field.SetInt(int64(n))
+		case reflect.Bool:
+			b, err := strconv.ParseBool(value)
+			if err != nil {
+				return fmt.Errorf("env %s must be a boolean, got %q", name, value)
+			}
+			field.SetBool(b)
+		}
 	}
-	var conf Config
-	err = json.Unmarshal(confContent, &conf)
-	if err != nil {
-		log.Fatalf("json unmarshal error: %+v\n", err)
-		return nil, err
+	return nil
+}
+
+func (c *Config) applyLegacy() {
+	if c.Cookie.Domain == "" {
+		c.Cookie.Domain = c.LegacyHost
+	}
+	if c.Sms.AppID == "" {
+		c.Sms.AppID = c.LegacySmsAppID
+	}
+	if c.Sms.AppKey == "" {
+		c.Sms.AppKey = c.LegacySmsAppKey
+	}
+}
+
+// Validate 校验配置，返回所有不合法的配置项
+func (c *Config) Validate() error {
+	var errs []string
+	add := func(format string, args ...interface{}) {
+		errs = append(errs, fmt.Sprintf(format, args...))
+	}
+	switch c.Env {
+	case Prod, Dev:
+	case "":
+		add("env is required, must be %q or %q", Prod, Dev)
+	default:
+		add("env must be %q or %q, got %q", Prod, Dev, c.Env)
+	}
+	if c.Server.Addr == "" {
+		add("server.addr is required")
+	}
+	if c.Server.MetricsAddr != "" && c.Server.MetricsAddr == c.Server.Addr {
+		add("server.metrics_addr must differ from server.addr")
+	}
+	if c.Server.BaseURL != "" && !strings.HasPrefix(c.Server.BaseURL, "https://") && !strings.HasPrefix(c.Server.BaseURL, "http://") {
+		add("server.base_url must start with https:// or http://")
+	}
+	if c.Wechat.AppID != "" && c.Wechat.OAuthRedirect == "" && c.Server.BaseURL == "" {
+		add("wechat.oauth_redirect or server.base_url is required when wechat.app_id is set")
+	}
+	if c.Server.ReadTimeout <= 0 || c.Server.WriteTimeout <= 0 || c.Server.ShutdownTimeout <= 0 {
+		add("server.read_timeout, server.write_timeout and server.shutdown_timeout must be positive")
+	}
+	if c.Server.DrainSeconds < 0 {
+		add("server.drain_seconds must not be negative")
 	}
-	return &conf, nil
+	if c.DB.Host == "" || c.DB.Database == "" {
+		add("flamingo_db.host and flamingo_db.database are required")
+	}
+	switch len(c.Session.AESKey) {
+	case 16, 24, 32:
+	case 0:
+		if c.Product() {
+			add("session.aes_key is required in prod")
+		}
+	default:
+		add("session.aes_key must be 16, 24 or 32 bytes, got %d", len(c.Session.AESKey))
+	}
+	if c.Product() && c.Session.PaymentTokenSecret == "" {
+		add("session.payment_token_secret is required in prod")
+	}
+	if c.Cookie.MaxAge <= 0 {
+		add("cookie.max_age must be positive")
+	}
+	if c.Wechat.AppID != "" && c.Wechat.AppSecret == "" {
+		add("wechat.app_secret is required when wechat.app_id is set")
+	}
+	if c.Wechat.EncodingAESKey != "" && len(c.Wechat.EncodingAESKey) != 43 {
+		add("wechat.encoding_aes_key must be 43 characters")
+	}
+	if c.Wechat.OAuthScope != "snsapi_base" && c.Wechat.OAuthScope != "snsapi_userinfo" {
+		add("wechat.oauth_scope must be snsapi_base or snsapi_userinfo")
+	}
+	if len(c.Wechat.Menu) > 0 && !json.Valid(c.Wechat.Menu) {
+		add("wechat.menu is not valid json")
+	}
+	if c.Pay.OrderTimeoutMinutes <= 0 {
+		add("pay.order_timeout_minutes must be positive")
+	}
+	if c.Pay.MaxAmount <= 0 {
+		add("pay.max_amount must be positive")
+	}
+	if c.Pay.FakeEnabled && c.Product() {
+		add("pay.fake_enabled must not be set in prod")
+	}
+	if c.Pay.FakeEnabled && c.Pay.FakeSecret == "" {
+		add("pay.fake_secret is required when pay.fake_enabled is set")
+	}
+	if c.Pay.Wechat.MchID != "" && c.Pay.Wechat.APIKey == "" {
+		add("pay.wechat.api_key is required when pay.wechat.mch_id is set")
+	}
+	if c.Pay.Alipay.AppID != "" && (c.Pay.Alipay.PrivateKey == "" || c.Pay.Alipay.AlipayPublicKey == "") {
+		add("pay.alipay.private_key and pay.alipay.alipay_public_key are required when pay.alipay.app_id is set")
+	}
+	for _, clock := range []string{c.Notify.QuietStart, c.Notify.QuietEnd} {
+		if _, err := time.Parse("15:04", clock); clock != "" && err != nil {
+			add("notify quiet time must be HH:MM, got %q", clock)
+		}
+	}
+	if c.ConsumeConfirmThreshold < 0 {
+		add("consume_confirm_threshold must not be negative")
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("invalid config:\n  - %s", strings.Join(errs, "\n  - "))
+	}
+	return nil
 }
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+
+	"code.bean.com/flamingo/config"
+	"code.bean.com/flamingo/model"
+)
+
+// runConfig 执行 config 命令：
+//
+//	config check  校验配置文件，并检查数据库连接和未执行的 migration
+//
+// 配置不合法时 config.Init 已经失败退出，这里只需要检查依赖的外部服务
+func runConfig(args []string) error {
+	action, _, err := subcommand(args)
+	if err != nil {
+		return err
+	}
+	if action != "check" {
+		return fmt.Errorf("unknown config action: %s", action)
+	}
+	conf := config.ConfigInstance
+	fmt.Fprintf(os.Stdout, "config ok, env=%s, addr=%s\n", conf.Env, conf.Server.Addr)
+	if err := model.Init(); err != nil {
+		return fmt.Errorf("connect database error: %v", err)
+	}
+	defer model.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := model.Ping(ctx); err != nil {
+		return fmt.Errorf("ping database error: %v", err)
+	}
+	pending, err := model.PendingMigrations()
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stdout, "database ok, %d migrations pending\n", pending)
+	return nil
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"encoding/csv"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	"code.bean.com/flamingo/model"
+	"code.bean.com/flamingo/service"
+)
+
+// runCustomer 执行 customer 命令：
+//
+//	customer import -file members.xlsx [-dry-run]  从 csv 或 xlsx 导入会员和期初余额，-dry-run 只校验不写入
+//	customer export [-file out.csv]                导出所有会员和余额，不指定文件时输出到标准输出
+//
+// 导入文件首行为表头，必须有姓名和手机号列，可选卡号、期初余额和开卡日期列，表头见 service.importColumns
+func runCustomer(args []string) error {
+	action, args, err := subcommand(args)
+	if err != nil {
+		return err
+	}
+	fs := flag.NewFlagSet("customer "+action, flag.ContinueOnError)
+	file := fs.String("file", "", "csv or xlsx file path")
+	dryRun := fs.Bool("dry-run", false, "validate the import file without writing")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	switch action {
+	case "import":
+		if err := requireFlags(fs, "file"); err != nil {
+			return err
+		}
+		return importCustomers(*file, *dryRun)
+	case "export":
+		out := os.Stdout
+		if *file != "" {
+			f, err := os.Create(*file)
+			if err != nil {
+				return err
+			}
+			defer f.Close()
+			out = f
+		}
+		return exportCustomers(out)
+	default:
+		return fmt.Errorf("unknown customer action: %s", action)
+	}
+}
+
+func importCustomers(file string, dryRun bool) error {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return err
+	}
+	report, err := service.CustomerServiceInstance().ImportCustomers(filepath.Base(file), data, dryRun, nil)
+	if err != nil {
+		return fmt.Errorf("%s", errorMessage(err))
+	}
+	for _, e := range report.Errors {
+		fmt.Fprintf(os.Stderr, "row %d %s %q: %s\n", e.Row, e.Field, e.Value, e.Msg)
+	}
+	fmt.Fprintf(os.Stdout, "total %d, valid %d, imported %d, opening balance %s\n", report.Total, report.Valid, report.Imported, report.OpeningTotal)
+	if len(report.Errors) > 0 {
+		return fmt.Errorf("%d rows failed", len(report.Errors))
+	}
+	return nil
+}
+
+func exportCustomers(out io.Writer) error {
+	writer := csv.NewWriter(out)
+	if err := writer.Write([]string{"card_no", "name", "cellphone", "open_date", "balance", "wx_bound", "notify_off"}); err != nil {
+		return err
+	}
+	err := service.CustomerServiceInstance().EachCustomer(func(customer *model.KroCustomer, balance int) error {
+		return writer.Write([]string{
+			customer.CustomerID,
+			customer.Name,
+			customer.Cellphone,
+			customer.OpenDate.Format("2006-01-02 15:04:05"),
+			fmt.Sprintf("%.2f", float64(balance)/100.00),
+			strconv.FormatBool(customer.WxOpenID != ""),
+			strconv.FormatBool(customer.NotifyOff),
+		})
+	})
+	if err != nil {
+		return err
+	}
+	writer.Flush()
+	return writer.Error()
+}
+
+// errorMessage 业务错误输出中文错误信息
+func errorMessage(err error) string {
+	if se, ok := err.(*service.Error); ok {
+		return se.Message()
+	}
+	return err.Error()
+}
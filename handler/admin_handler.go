@@ -0,0 +1,130 @@
+package handler
+
+import (
+	"io"
+	"io/ioutil"
+
+	"code.bean.com/flamingo/handler/view"
+	"code.bean.com/flamingo/service"
+	"code.byted.org/gopkg/logs"
+	"github.com/gin-gonic/gin"
+)
+
+// AdminHandler 管理员api
+type AdminHandler struct{}
+
+// NewAdminHandler 实例化
+func NewAdminHandler() *AdminHandler {
+	return &AdminHandler{}
+}
+
+// Register 注册api
+func (handler *AdminHandler) Register(e *gin.Engine) {
+	group := e.Group("/admin", OperatorInfoMiddleware(), AdminMiddleware())
+	group.POST("/wx_menu/push", JSONWrapper(handler.PushWxMenu))
+	group.POST("/wx_menu/get", JSONWrapper(handler.GetWxMenu))
+	group.POST("/wx_menu/delete", JSONWrapper(handler.DeleteWxMenu))
+	group.POST("/wx_balance_reminder", JSONWrapper(handler.SendBalanceReminder))
+	group.POST("/settings/list", JSONWrapper(handler.ListSettings))
+	group.POST("/settings/update", JSONWrapper(handler.UpdateSetting))
+	group.POST("/settings/history", JSONWrapper(handler.GetSettingHistory))
+	group.POST("/customers/import", JSONWrapper(handler.ImportCustomers))
+}
+
+// maxImportFileSize 导入文件的大小上限
+const maxImportFileSize = 10 << 20
+
+// PushWxMenu 将配置中的菜单推送到公众号
+func (handler *AdminHandler) PushWxMenu(c *gin.Context) (interface{}, error) {
+	if err := service.WechatServiceInstance().PushMenu(c.Request.Context()); err != nil {
+		return nil, err
+	}
+	return "success", nil
+}
+
+// GetWxMenu 查询公众号当前的菜单
+func (handler *AdminHandler) GetWxMenu(c *gin.Context) (interface{}, error) {
+	return service.WechatServiceInstance().GetMenu(c.Request.Context())
+}
+
+// DeleteWxMenu 删除公众号菜单
+func (handler *AdminHandler) DeleteWxMenu(c *gin.Context) (interface{}, error) {
+	if err := service.WechatServiceInstance().DeleteMenu(c.Request.Context()); err != nil {
+		return nil, err
+	}
+	return "success", nil
+}
+
+// SendBalanceReminder 给会员发送余额提醒模板消息
+func (handler *AdminHandler) SendBalanceReminder(c *gin.Context) (interface{}, error) {
+	var req view.BalanceReminderReq
+	if err := bindRequest(c, &req); err != nil {
+		return nil, err
+	}
+	err := service.WechatServiceInstance().SendBalanceReminder(c.Request.Context(), req.Cell, req.Remark)
+	if err != nil {
+		return nil, err
+	}
+	return "success", nil
+}
+
+// ListSettings 查询运行时配置
+func (handler *AdminHandler) ListSettings(c *gin.Context) (interface{}, error) {
+	return service.SettingsServiceInstance().ListSettings()
+}
+
+// UpdateSetting 修改运行时配置
+func (handler *AdminHandler) UpdateSetting(c *gin.Context) (interface{}, error) {
+	op, err := OperatorInfo(c)
+	if err != nil {
+		logs.Error("invalid user,err=%+v", err)
+		return nil, err
+	}
+	var req view.UpdateSettingReq
+	if err := bindRequest(c, &req); err != nil {
+		return nil, err
+	}
+	if err := service.SettingsServiceInstance().Set(req.Key, req.Value, op); err != nil {
+		return nil, err
+	}
+	return "success", nil
+}
+
+// GetSettingHistory 查询运行时配置的修改记录
+func (handler *AdminHandler) GetSettingHistory(c *gin.Context) (interface{}, error) {
+	var req view.SettingKeyReq
+	if err := bindRequest(c, &req); err != nil {
+		return nil, err
+	}
+	return service.SettingsServiceInstance().GetSettingHistory(req.Key)
+}
+
+// ImportCustomers 从 csv 或 xlsx 文件批量导入会员，dry_run 为 1 时只返回校验结果
+func (handler *AdminHandler) ImportCustomers(c *gin.Context) (interface{}, error) {
+	op, err := OperatorInfo(c)
+	if err != nil {
+		logs.Error("invalid user,err=%+v", err)
+		return nil, err
+	}
+	var req view.ImportCustomersReq
+	if err := bindRequest(c, &req); err != nil {
+		return nil, err
+	}
+	header, err := c.FormFile("file")
+	if err != nil {
+		return nil, service.ErrMissParam.WithDetail("file")
+	}
+	if header.Size > maxImportFileSize {
+		return nil, service.ErrInvalidImportFile.WithDetail("file is larger than 10MB")
+	}
+	file, err := header.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	data, err := ioutil.ReadAll(io.LimitReader(file, maxImportFileSize))
+	if err != nil {
+		return nil, err
+	}
+	return service.CustomerServiceInstance().ImportCustomers(header.Filename, data, req.DryRun == "1", op)
+}
@@ -0,0 +1,241 @@
+package handler
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"code.bean.com/flamingo/handler/view"
+	"code.bean.com/flamingo/model"
+	"code.bean.com/flamingo/service"
+)
+
+// postImport 上传导入文件，dryRun 为 true 时只校验
+func postImport(t *testing.T, cookies []*http.Cookie, filename string, data []byte, dryRun bool) *testResponse {
+	var body bytes.Buffer
+	writer := multipart.NewWriter(&body)
+	if dryRun {
+		writer.WriteField("dry_run", "1")
+	}
+	part, _ := writer.CreateFormFile("file", filename)
+	part.Write(data)
+	writer.Close()
+
+	req := httptest.NewRequest(http.MethodPost, "/admin/customers/import", &body)
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+	for _, cookie := range cookies {
+		req.AddCookie(cookie)
+	}
+	rec := httptest.NewRecorder()
+	testRouter.ServeHTTP(rec, req)
+	var resp testResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("import %s: invalid json response %q: %v", filename, rec.Body.String(), err)
+	}
+	return &resp
+}
+
+// importReport 上传导入文件并解析导入结果
+func importReport(t *testing.T, cookies []*http.Cookie, filename string, data []byte, dryRun bool) *view.ImportReport {
+	resp := postImport(t, cookies, filename, data, dryRun)
+	var report view.ImportReport
+	if resp.Code != service.OK || json.Unmarshal(resp.Data, &report) != nil {
+		t.Fatalf("import %s: code=%d, msg=%s", filename, resp.Code, resp.Msg)
+	}
+	return &report
+}
+
+// buildXLSX 生成只有一个工作表的最小 xlsx 文件，sheet 为 sheetData 的内容
+func buildXLSX(t *testing.T, sheet string) []byte {
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	files := map[string]string{
+		"xl/workbook.xml":          `<workbook><sheets><sheet name="Sheet1" sheetId="1"/></sheets></workbook>`,
+		"xl/worksheets/sheet1.xml": `<worksheet><sheetData>` + sheet + `</sheetData></worksheet>`,
+	}
+	for name, content := range files {
+		w, err := zw.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.Write([]byte(content))
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// customerExists 内存数据中是否有该手机号的会员
+func customerExists(cell string) bool {
+	customer, err := testStore.GetCustomerByCellphone(cell)
+	return err == nil && customer.ID > 0
+}
+
+func TestImportCustomersCSV(t *testing.T) {
+	cookies := operatorLogin(t)
+	csv := []byte("\xef\xbb\xbf姓名,手机号,卡号,期初余额,开卡日期\n" +
+		"孙七,13700000081,C081,100.5,2020-01-02\n" +
+		",13700000082,,,\n" +
+		"周八,1370000008,,abc,\n" +
+		"吴九,13700000081,C081,,2999-01-01\n")
+
+	report := importReport(t, cookies, "customers.csv", csv, true)
+	if !report.DryRun || report.Total != 4 || report.Valid != 1 || report.Imported != 0 {
+		t.Errorf("dry run report = %+v", report)
+	}
+	want := map[int][]string{3: {"name"}, 4: {"cellphone", "balance"}, 5: {"cellphone", "card_no", "open_date"}}
+	got := make(map[int][]string)
+	for _, e := range report.Errors {
+		got[e.Row] = append(got[e.Row], e.Field)
+	}
+	for row, fields := range want {
+		if len(got[row]) != len(fields) {
+			t.Errorf("row %d errors = %v, want %v", row, got[row], fields)
+		}
+	}
+	if customerExists("13700000081") {
+		t.Fatal("dry run wrote customers")
+	}
+
+	// 不是 dry run 时，有错误的文件也不写入任何一行
+	report = importReport(t, cookies, "customers.csv", csv, false)
+	if report.Imported != 0 || customerExists("13700000081") {
+		t.Errorf("import with errors: imported=%d", report.Imported)
+	}
+
+	valid := []byte("name,cellphone,card_no,balance\n孙七,13700000081,C081,100.5\n周八,13700000083,,\n")
+	report = importReport(t, cookies, "customers.CSV", valid, false)
+	if report.Imported != 2 || report.OpeningTotal != "100.50" || len(report.Errors) != 0 {
+		t.Errorf("import report = %+v", report)
+	}
+	customer, err := testStore.GetCustomerByCellphone("13700000081")
+	if err != nil || customer.Name != "孙七" || customer.CustomerID != "C081" {
+		t.Errorf("imported customer = %+v, err=%v", customer, err)
+	}
+
+	// 与已有会员重复的文件再导入一次，其他合法行也不能写入
+	duplicate := []byte("name,cellphone\n郑九,13700000084\n孙七,13700000081\n")
+	report = importReport(t, cookies, "customers.csv", duplicate, false)
+	if report.Imported != 0 || len(report.Errors) != 1 || report.Errors[0].Row != 3 || customerExists("13700000084") {
+		t.Errorf("import duplicate report = %+v", report)
+	}
+
+	if resp := postImport(t, cookies, "customers.txt", valid, false); resp.Code != service.ErrInvalidImportFile.Code {
+		t.Errorf("import txt: code=%d", resp.Code)
+	}
+	if resp := postImport(t, cookies, "customers.csv", []byte("name,card_no\n孙七,C1\n"), false); resp.Code != service.ErrInvalidImportFile.Code {
+		t.Errorf("import without cellphone column: code=%d", resp.Code)
+	}
+}
+
+func TestImportCustomersXLSX(t *testing.T) {
+	cookies := operatorLogin(t)
+	cell := func(ref, text string) string {
+		return `<c r="` + ref + `" t="inlineStr"><is><t>` + text + `</t></is></c>`
+	}
+	xlsx := buildXLSX(t, `<row r="1">`+cell("A1", "姓名")+cell("B1", "手机号")+cell("D1", "期初余额")+`</row>`+
+		`<row r="3">`+cell("A3", "冯一")+cell("B3", "13700000091")+`<c r="D3"><v>20</v></c></row>`)
+
+	report := importReport(t, cookies, "customers.xlsx", xlsx, false)
+	if report.Total != 1 || report.Imported != 1 || report.OpeningTotal != "20.00" {
+		t.Fatalf("import xlsx report = %+v", report)
+	}
+	if !customerExists("13700000091") {
+		t.Error("xlsx customer not imported")
+	}
+	if resp := postImport(t, cookies, "customers.xlsx", []byte("not a zip"), false); resp.Code != service.ErrInvalidImportFile.Code {
+		t.Errorf("import broken xlsx: code=%d", resp.Code)
+	}
+}
+
+// TestImportCustomersAtomic 仓储层批量写入时有一条冲突，所有会员和期初余额都不写入
+func TestImportCustomersAtomic(t *testing.T) {
+	addCustomer(t, operatorLogin(t), "13700000095", "陈二", "")
+	imports := []*model.CustomerImport{
+		{Customer: &model.KroCustomer{Cellphone: "13700000096", Name: "新会员", CustomerID: "C096", OpenDate: time.Now()},
+			Opening: &model.KroAccount{AccountType: model.AccountTypeOpening, Amount: 1000, DealTime: time.Now()}},
+		{Customer: &model.KroCustomer{Cellphone: "13700000095", Name: "重复", CustomerID: "C095", OpenDate: time.Now()}},
+	}
+	if err := testStore.ImportCustomers(imports); err == nil {
+		t.Fatal("import conflicting batch succeeded")
+	}
+	if customerExists("13700000096") {
+		t.Error("conflicting batch wrote the first customer")
+	}
+}
+
+// listSettings 查询运行时配置，按 key 返回
+func listSettings(t *testing.T, cookies []*http.Cookie) map[string]*view.Setting {
+	_, resp := postForm(t, "/admin/settings/list", url.Values{}, cookies)
+	var settings []*view.Setting
+	if resp.Code != service.OK || json.Unmarshal(resp.Data, &settings) != nil {
+		t.Fatalf("list settings: code=%d, msg=%s", resp.Code, resp.Msg)
+	}
+	byKey := make(map[string]*view.Setting)
+	for _, setting := range settings {
+		byKey[setting.Key] = setting
+	}
+	return byKey
+}
+
+func TestSettings(t *testing.T) {
+	cookies := operatorLogin(t)
+	cooldown := listSettings(t, cookies)[service.SettingSmsCooldown]
+	if cooldown == nil || cooldown.Type != service.SettingTypeInt || cooldown.Default != "120" {
+		t.Fatalf("cooldown setting = %+v", cooldown)
+	}
+
+	for _, value := range []string{"10", "abc", "3601", "1.5"} {
+		form := url.Values{"key": {service.SettingSmsCooldown}, "value": {value}}
+		if _, resp := postForm(t, "/admin/settings/update", form, cookies); resp.Code != service.ErrInvalidSetting.Code {
+			t.Errorf("set cooldown to %q: code=%d", value, resp.Code)
+		}
+	}
+	if _, resp := postForm(t, "/admin/settings/update", url.Values{"key": {"no_such_key"}, "value": {"1"}}, cookies); resp.Code != service.ErrSettingNotFound.Code {
+		t.Errorf("set unknown key: code=%d", resp.Code)
+	}
+
+	// 60 秒前发送过验证码，默认 120 秒的冷却时间内不能再发，改为 30 秒后可以发送
+	const cell = "13700000099"
+	addCustomer(t, cookies, cell, "褚三", "")
+	if _, resp := postForm(t, "/cu/check_code", url.Values{"cell": {cell}}, nil); resp.Code != service.OK {
+		t.Fatalf("send check code: code=%d, msg=%s", resp.Code, resp.Msg)
+	}
+	msg, err := testStore.GetPhoneLatestSms(cell, model.SmsPurposeLogin)
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg.SendTime = time.Now().Add(-time.Minute)
+	if err := testStore.UpdateSmsMsg(msg); err != nil {
+		t.Fatal(err)
+	}
+	if _, resp := postForm(t, "/cu/check_code", url.Values{"cell": {cell}}, nil); resp.Code != service.ErrSmsTooFrequent.Code {
+		t.Errorf("resend with default cooldown: code=%d", resp.Code)
+	}
+	if _, resp := postForm(t, "/admin/settings/update", url.Values{"key": {service.SettingSmsCooldown}, "value": {"30"}}, cookies); resp.Code != service.OK {
+		t.Fatalf("set cooldown: code=%d, msg=%s", resp.Code, resp.Msg)
+	}
+	defer postForm(t, "/admin/settings/update", url.Values{"key": {service.SettingSmsCooldown}, "value": {"120"}}, cookies)
+	if value := listSettings(t, cookies)[service.SettingSmsCooldown].Value; value != "30" {
+		t.Errorf("cooldown after update = %s", value)
+	}
+	if _, resp := postForm(t, "/cu/check_code", url.Values{"cell": {cell}}, nil); resp.Code != service.OK {
+		t.Errorf("resend after cooldown lowered: code=%d, msg=%s", resp.Code, resp.Msg)
+	}
+
+	_, resp := postForm(t, "/admin/settings/history", url.Values{"key": {service.SettingSmsCooldown}}, cookies)
+	var history []*view.SettingHistory
+	if resp.Code != service.OK || json.Unmarshal(resp.Data, &history) != nil || len(history) == 0 {
+		t.Fatalf("setting history: code=%d, data=%s", resp.Code, resp.Data)
+	}
+	if latest := history[0]; latest.NewValue != "30" || latest.OldValue != "" {
+		t.Errorf("latest history = %+v", latest)
+	}
+}
@@ -0,0 +1,125 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"code.bean.com/flamingo/handler/view"
+)
+
+// 接口的登录要求，为空表示不需要登录
+const (
+	authCustomer = "customer"
+	authOperator = "operator"
+	authAdmin    = "admin"
+)
+
+// apiSpec 一个接口的文档，用于生成 /openapi.json
+type apiSpec struct {
+	Method  string
+	Path    string // gin 的路由格式，路径参数为 :name
+	Tag     string
+	Summary string
+	Auth    string
+	// Request 请求参数结构体，nil 表示没有参数
+	Request interface{}
+	// Response 成功时 data 的类型，nil 表示 data 为 null
+	Response interface{}
+	// Status 成功时的 http 状态码，默认 200
+	Status int
+	// RawResponse 不使用统一 json 返回格式的接口的返回说明，如页面、回调和跳转，为空表示使用统一格式
+	RawResponse string
+	// Upload 上传文件的 multipart 字段名，为空表示不上传文件
+	Upload string
+}
+
+const (
+	tagHealth   = "健康检查"
+	tagDocs     = "接口文档"
+	tagTemplate = "页面"
+	tagWechat   = "微信公众号"
+	tagCustomer = "会员"
+	tagOperator = "操作员"
+	tagCallback = "第三方回调"
+	tagAdmin    = "管理员"
+	tagAPIV1    = "API v1"
+)
+
+// success 返回 "success" 字符串的接口
+const success = "success"
+
+// apiSpecs 所有通过 Handler.Register 注册的接口，新增路由时需要在这里补充文档，openapi_test.go 会检查路由和文档是否一致
+var apiSpecs = []*apiSpec{
+	{Method: http.MethodGet, Path: "/healthz", Tag: tagHealth, Summary: "存活检查", RawResponse: "进程存活时返回 200"},
+	{Method: http.MethodGet, Path: "/readyz", Tag: tagHealth, Summary: "就绪检查", RawResponse: "配置和数据库正常时返回 200，否则返回 503"},
+	{Method: http.MethodGet, Path: "/metrics", Tag: tagHealth, Summary: "Prometheus 监控指标，配置 server.metrics_addr 时只在该地址提供", Auth: authAdmin, RawResponse: "Prometheus 文本格式"},
+
+	{Method: http.MethodGet, Path: "/openapi.json", Tag: tagDocs, Summary: "OpenAPI 3 接口文档", RawResponse: "OpenAPI 3 json 文档"},
+	{Method: http.MethodGet, Path: "/docs", Tag: tagDocs, Summary: "接口文档页面", RawResponse: "html 页面"},
+
+	{Method: http.MethodGet, Path: "/templates/home", Tag: tagTemplate, Summary: "操作员首页", RawResponse: "html 页面"},
+	{Method: http.MethodGet, Path: "/templates/login", Tag: tagTemplate, Summary: "操作员登录页", RawResponse: "html 页面"},
+	{Method: http.MethodGet, Path: "/templates/person", Tag: tagTemplate, Summary: "会员详情页", RawResponse: "html 页面"},
+	{Method: http.MethodGet, Path: "/templates/signin_user", Tag: tagTemplate, Summary: "新增会员页", RawResponse: "html 页面"},
+	{Method: http.MethodGet, Path: "/templates/charge", Tag: tagTemplate, Summary: "会员充值消费页", RawResponse: "html 页面"},
+	{Method: http.MethodGet, Path: "/templates/customer_home", Tag: tagTemplate, Summary: "会员首页", RawResponse: "html 页面"},
+	{Method: http.MethodGet, Path: "/templates/charge_code", Tag: tagTemplate, Summary: "会员付款码页", RawResponse: "html 页面"},
+	{Method: http.MethodGet, Path: "/templates/customer_login", Tag: tagTemplate, Summary: "会员登录页", RawResponse: "html 页面"},
+
+	{Method: http.MethodGet, Path: "/wx/wx_conn", Tag: tagWechat, Summary: "公众号服务器配置校验", RawResponse: "签名正确时原样返回 echostr"},
+	{Method: http.MethodPost, Path: "/wx/wx_conn", Tag: tagWechat, Summary: "接收公众号消息和事件", RawResponse: "xml 格式的被动回复，或 success"},
+	{Method: http.MethodGet, Path: "/wx/oauth", Tag: tagWechat, Summary: "跳转到微信网页授权", RawResponse: "302 跳转"},
+	{Method: http.MethodGet, Path: "/wx/oauth_callback", Tag: tagWechat, Summary: "微信网页授权回调", RawResponse: "302 跳转到会员首页或会员登录页"},
+
+	{Method: http.MethodPost, Path: "/cu/check_code", Tag: tagCustomer, Summary: "发送登录验证码", Request: view.CellReq{}, Response: success},
+	{Method: http.MethodPost, Path: "/cu/login", Tag: tagCustomer, Summary: "会员验证码登录", Request: view.CustomerLoginReq{}, Response: success},
+	{Method: http.MethodPost, Path: "/cu/cu_detail", Tag: tagCustomer, Summary: "会员详情", Auth: authCustomer, Response: view.CustomersInfo{}},
+	{Method: http.MethodPost, Path: "/cu/pay_code", Tag: tagCustomer, Summary: "生成消费确认码", Auth: authCustomer, Response: view.PayCode{}},
+	{Method: http.MethodPost, Path: "/cu/payment_token", Tag: tagCustomer, Summary: "生成付款码", Auth: authCustomer, Response: view.PaymentToken{}},
+	{Method: http.MethodPost, Path: "/cu/recharge_order", Tag: tagCustomer, Summary: "创建在线充值订单", Auth: authCustomer, Request: view.RechargeOrderReq{}, Response: view.RechargeOrder{}},
+	{Method: http.MethodPost, Path: "/cu/recharge_order_query", Tag: tagCustomer, Summary: "查询在线充值订单", Auth: authCustomer, Request: view.OrderNoReq{}, Response: view.RechargeOrder{}},
+	{Method: http.MethodPost, Path: "/cu/wx_unbind", Tag: tagCustomer, Summary: "解除微信绑定", Auth: authCustomer, Response: success},
+	{Method: http.MethodPost, Path: "/cu/notify_setting", Tag: tagCustomer, Summary: "余额变动通知设置", Auth: authCustomer, Request: view.NotifySettingReq{}, Response: success},
+	{Method: http.MethodPost, Path: "/cu/update_profile", Tag: tagCustomer, Summary: "修改本人资料", Auth: authCustomer, Request: view.UpdateProfileReq{}, Response: view.CustomersInfo{}},
+
+	{Method: http.MethodPost, Path: "/operator/login", Tag: tagOperator, Summary: "操作员登录", Request: view.OperatorLoginReq{}, Response: success},
+	{Method: http.MethodPost, Path: "/operator/info", Tag: tagOperator, Summary: "操作员姓名", Auth: authOperator, Response: ""},
+	{Method: http.MethodPost, Path: "/operator/add_customer", Tag: tagOperator, Summary: "新增会员", Auth: authOperator, Request: view.AddCustomerReq{}, Response: view.CustomersInfo{}},
+	{Method: http.MethodPost, Path: "/operator/query_customer", Tag: tagOperator, Summary: "查询会员详情", Auth: authOperator, Request: view.CellReq{}, Response: view.CustomersInfo{}},
+	{Method: http.MethodPost, Path: "/operator/search_customers", Tag: tagOperator, Summary: "搜索会员", Auth: authOperator, Request: view.SearchCustomersReq{}, Response: view.CustomerList{}},
+	{Method: http.MethodPost, Path: "/operator/update_customer", Tag: tagOperator, Summary: "修改会员资料", Auth: authOperator, Request: view.UpdateCustomerReq{}, Response: view.CustomersInfo{}},
+	{Method: http.MethodPost, Path: "/operator/customer_changes", Tag: tagOperator, Summary: "会员资料修改记录", Auth: authOperator, Request: view.CellReq{}, Response: []*view.CustomerChange{}},
+	{Method: http.MethodPost, Path: "/operator/operate_customer", Tag: tagOperator, Summary: "会员充值、消费和退款", Auth: authOperator, Request: view.OperateCustomerReq{}, Response: true},
+	{Method: http.MethodPost, Path: "/operator/pay_code", Tag: tagOperator, Summary: "给会员发送大额消费确认码", Auth: authOperator, Request: view.SendPayCodeReq{}, Response: success},
+	{Method: http.MethodPost, Path: "/operator/scan_pay_token", Tag: tagOperator, Summary: "扫描会员付款码", Auth: authOperator, Request: view.PaymentTokenReq{}, Response: view.PaymentAuth{}},
+	{Method: http.MethodPost, Path: "/operator/settle_report", Tag: tagOperator, Summary: "日结对账报表", Auth: authOperator, Request: view.SettleReportReq{}, Response: view.SettleReport{}},
+	{Method: http.MethodPost, Path: "/operator/sms_status", Tag: tagOperator, Summary: "会员短信发送记录", Auth: authOperator, Request: view.CellReq{}, Response: []*view.SmsRecord{}},
+
+	{Method: http.MethodPost, Path: "/sms/report", Tag: tagCallback, Summary: "短信送达状态回调，query 参数 token 须与 sms.report_token 一致", RawResponse: `短信服务商要求的 {"result":0,"errmsg":"OK"}`},
+	{Method: http.MethodPost, Path: "/pay/notify/:gateway", Tag: tagCallback, Summary: "支付结果异步通知", RawResponse: "支付渠道要求的应答格式"},
+	{Method: http.MethodPost, Path: "/pay/fake_complete", Tag: tagCallback, Summary: "模拟支付完成，仅在配置了 pay.fake_enabled 时注册", Request: view.OrderNoReq{}, Response: success},
+
+	{Method: http.MethodPost, Path: "/admin/wx_menu/push", Tag: tagAdmin, Summary: "推送公众号菜单", Auth: authAdmin, Response: success},
+	{Method: http.MethodPost, Path: "/admin/wx_menu/get", Tag: tagAdmin, Summary: "查询公众号菜单", Auth: authAdmin, Response: json.RawMessage{}},
+	{Method: http.MethodPost, Path: "/admin/wx_menu/delete", Tag: tagAdmin, Summary: "删除公众号菜单", Auth: authAdmin, Response: success},
+	{Method: http.MethodPost, Path: "/admin/wx_balance_reminder", Tag: tagAdmin, Summary: "发送余额提醒模板消息", Auth: authAdmin, Request: view.BalanceReminderReq{}, Response: success},
+	{Method: http.MethodPost, Path: "/admin/settings/list", Tag: tagAdmin, Summary: "查询运行时配置", Auth: authAdmin, Response: []*view.Setting{}},
+	{Method: http.MethodPost, Path: "/admin/settings/update", Tag: tagAdmin, Summary: "修改运行时配置", Auth: authAdmin, Request: view.UpdateSettingReq{}, Response: success},
+	{Method: http.MethodPost, Path: "/admin/settings/history", Tag: tagAdmin, Summary: "运行时配置修改记录", Auth: authAdmin, Request: view.SettingKeyReq{}, Response: []*view.SettingHistory{}},
+	{Method: http.MethodPost, Path: "/admin/customers/import", Tag: tagAdmin, Summary: "从 csv 或 xlsx 批量导入会员，有错误时不写入", Auth: authAdmin, Request: view.ImportCustomersReq{}, Response: view.ImportReport{}, Upload: "file"},
+
+	{Method: http.MethodPost, Path: "/api/v1/sessions", Tag: tagAPIV1, Summary: "操作员登录，返回 bearer token", Request: view.OperatorLoginReq{}, Response: view.Session{}, Status: http.StatusCreated},
+	{Method: http.MethodDelete, Path: "/api/v1/sessions/current", Tag: tagAPIV1, Summary: "操作员退出登录", Auth: authOperator, Status: http.StatusNoContent, RawResponse: "没有返回内容"},
+	{Method: http.MethodGet, Path: "/api/v1/operators/me", Tag: tagAPIV1, Summary: "当前登录的操作员", Auth: authOperator, Response: view.Session{}},
+	{Method: http.MethodGet, Path: "/api/v1/customers", Tag: tagAPIV1, Summary: "搜索会员", Auth: authOperator, Request: view.SearchCustomersReq{}, Response: view.CustomerList{}},
+	{Method: http.MethodPost, Path: "/api/v1/customers", Tag: tagAPIV1, Summary: "新增会员", Auth: authOperator, Request: view.AddCustomerReq{}, Response: view.CustomersInfo{}, Status: http.StatusCreated},
+	{Method: http.MethodGet, Path: "/api/v1/customers/:cell", Tag: tagAPIV1, Summary: "会员详情", Auth: authOperator, Request: view.CellReq{}, Response: view.CustomersInfo{}},
+	{Method: http.MethodPut, Path: "/api/v1/customers/:cell", Tag: tagAPIV1, Summary: "修改会员资料", Auth: authOperator, Request: view.CustomerProfileReq{}, Response: view.CustomersInfo{}},
+	{Method: http.MethodGet, Path: "/api/v1/customers/:cell/changes", Tag: tagAPIV1, Summary: "会员资料修改记录", Auth: authOperator, Request: view.CellReq{}, Response: []*view.CustomerChange{}},
+	{Method: http.MethodGet, Path: "/api/v1/customers/:cell/transactions", Tag: tagAPIV1, Summary: "会员账户流水", Auth: authOperator, Request: view.CellReq{}, Response: []*view.AccountInfo{}},
+	{Method: http.MethodPost, Path: "/api/v1/customers/:cell/transactions", Tag: tagAPIV1, Summary: "会员充值、消费和退款", Auth: authOperator, Request: view.TransactionReq{}, Response: view.CustomersInfo{}, Status: http.StatusCreated},
+	{Method: http.MethodPost, Path: "/api/v1/customers/:cell/pay_codes", Tag: tagAPIV1, Summary: "给会员发送大额消费确认码", Auth: authOperator, Request: view.PayCodeReq{}, Status: http.StatusAccepted},
+	{Method: http.MethodGet, Path: "/api/v1/customers/:cell/sms", Tag: tagAPIV1, Summary: "会员短信发送记录", Auth: authOperator, Request: view.CellReq{}, Response: []*view.SmsRecord{}},
+	{Method: http.MethodPost, Path: "/api/v1/payment_tokens/exchange", Tag: tagAPIV1, Summary: "扫描会员付款码", Auth: authOperator, Request: view.PaymentTokenReq{}, Response: view.PaymentAuth{}},
+	{Method: http.MethodGet, Path: "/api/v1/reports/settlement", Tag: tagAPIV1, Summary: "日结对账报表", Auth: authOperator, Request: view.SettleReportReq{}, Response: view.SettleReport{}},
+}
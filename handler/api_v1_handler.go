@@ -0,0 +1,304 @@
+package handler
+
+import (
+	"net/http"
+
+	"code.bean.com/flamingo/config"
+	"code.bean.com/flamingo/handler/view"
+	"code.bean.com/flamingo/metrics"
+	"code.bean.com/flamingo/service"
+	"code.bean.com/flamingo/util"
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+)
+
+// apiV1Prefix 版本化 json 接口的路径前缀
+const apiV1Prefix = "/api/v1"
+
+// APIV1Handler 面向移动端的 json 接口，按资源组织路由，与 /cu、/operator 下的旧接口共用 service 层
+type APIV1Handler struct{}
+
+// NewAPIV1Handler 实例化
+func NewAPIV1Handler() *APIV1Handler {
+	return &APIV1Handler{}
+}
+
+// Register 注册api
+func (handler *APIV1Handler) Register(e *gin.Engine) {
+	group := e.Group(apiV1Prefix)
+	apiV1Route(group, http.MethodPost, "/sessions", JSONStatusWrapper(handler.CreateSession))
+
+	auth := group.Group("", OperatorInfoMiddleware())
+	apiV1Route(auth, http.MethodDelete, "/sessions/current", JSONStatusWrapper(handler.DeleteSession))
+	apiV1Route(auth, http.MethodGet, "/operators/me", JSONStatusWrapper(handler.GetCurrentOperator))
+	apiV1Route(auth, http.MethodGet, "/customers", JSONStatusWrapper(handler.ListCustomers))
+	apiV1Route(auth, http.MethodPost, "/customers", JSONStatusWrapper(handler.CreateCustomer))
+	apiV1Route(auth, http.MethodGet, "/customers/:cell", JSONStatusWrapper(handler.GetCustomer))
+	apiV1Route(auth, http.MethodPut, "/customers/:cell", JSONStatusWrapper(handler.UpdateCustomer))
+	apiV1Route(auth, http.MethodGet, "/customers/:cell/changes", JSONStatusWrapper(handler.ListCustomerChanges))
+	apiV1Route(auth, http.MethodGet, "/customers/:cell/transactions", JSONStatusWrapper(handler.ListTransactions))
+	apiV1Route(auth, http.MethodPost, "/customers/:cell/transactions", JSONStatusWrapper(handler.CreateTransaction))
+	apiV1Route(auth, http.MethodPost, "/customers/:cell/pay_codes", JSONStatusWrapper(handler.CreatePayCode))
+	apiV1Route(auth, http.MethodGet, "/customers/:cell/sms", JSONStatusWrapper(handler.ListSms))
+	apiV1Route(auth, http.MethodPost, "/payment_tokens/exchange", JSONStatusWrapper(handler.ExchangePaymentToken))
+	apiV1Route(auth, http.MethodGet, "/reports/settlement", JSONStatusWrapper(handler.SettleReport))
+}
+
+// apiV1Route 注册路由，并把路由模板作为监控的 route label，避免会员手机号出现在 label 中
+func apiV1Route(group *gin.RouterGroup, method, path string, fn gin.HandlerFunc) {
+	route := apiV1Prefix + path
+	group.Handle(method, path, func(c *gin.Context) {
+		c.Set(metrics.RouteKey, route)
+	}, fn)
+}
+
+// bindJSONRequest 解析 /api/v1 的请求参数：GET 和 DELETE 从 query 中解析，其他方法只接受 json 请求体
+// fill 在校验前执行，用于写入路径参数
+func bindJSONRequest(c *gin.Context, req interface{}, fill func()) error {
+	var b binding.Binding = binding.JSON
+	if c.Request.Method == http.MethodGet || c.Request.Method == http.MethodDelete {
+		b = binding.Form
+	}
+	if err := c.ShouldBindWith(req, b); err != nil {
+		return service.ErrInvalidParam.WithDetail(err.Error())
+	}
+	if fill != nil {
+		fill()
+	}
+	return validateRequest(req)
+}
+
+// pathCell 校验路径中的会员手机号
+func pathCell(c *gin.Context) (string, error) {
+	req := view.CellReq{Cell: c.Param("cell")}
+	if err := validateRequest(&req); err != nil {
+		return "", err
+	}
+	return req.Cell, nil
+}
+
+// CreateSession 操作员登录，同时设置 cookie 并返回 bearer token
+func (handler *APIV1Handler) CreateSession(c *gin.Context) (int, interface{}, error) {
+	var req view.OperatorLoginReq
+	if err := bindJSONRequest(c, &req, nil); err != nil {
+		return 0, nil, err
+	}
+	operator, err := service.OperatorServiceInstance().OperatorLogin(req.Cell, req.Pwd)
+	if err == service.ErrOperatorDisabled {
+		return 0, nil, err
+	}
+	if err != nil {
+		return 0, nil, service.ErrWrongPassword
+	}
+	enbytes, err := util.AESEncrypt([]byte(operator.Cellphone))
+	if err != nil {
+		return 0, nil, err
+	}
+	token, expireAt, err := service.OperatorServiceInstance().CreateSession(operator)
+	if err != nil {
+		return 0, nil, err
+	}
+	setCookie(c, "operator_id", string(enbytes), config.ConfigInstance.Cookie.MaxAge, false)
+	return http.StatusCreated, &view.Session{
+		Token:    token,
+		ExpireAt: expireAt.Format("2006-01-02 15:04:05"),
+		Operator: &view.Operator{Cellphone: operator.Cellphone, Name: operator.Name},
+		Admin:    service.OperatorServiceInstance().IsAdmin(operator),
+	}, nil
+}
+
+// DeleteSession 操作员退出登录，使用 bearer token 时注销该 token
+func (handler *APIV1Handler) DeleteSession(c *gin.Context) (int, interface{}, error) {
+	if token, ok := bearerToken(c); ok {
+		if err := service.OperatorServiceInstance().DeleteSession(token); err != nil {
+			return 0, nil, err
+		}
+	}
+	setCookie(c, "operator_id", "", -1, false)
+	return http.StatusNoContent, nil, nil
+}
+
+// GetCurrentOperator 当前登录的操作员
+func (handler *APIV1Handler) GetCurrentOperator(c *gin.Context) (int, interface{}, error) {
+	op, err := OperatorInfo(c)
+	if err != nil {
+		return 0, nil, err
+	}
+	return http.StatusOK, &view.Session{
+		Operator: &view.Operator{Cellphone: op.Cellphone, Name: op.Name},
+		Admin:    service.OperatorServiceInstance().IsAdmin(op),
+	}, nil
+}
+
+// ListCustomers 分页搜索会员，条件通过 query 参数指定
+func (handler *APIV1Handler) ListCustomers(c *gin.Context) (int, interface{}, error) {
+	var req view.SearchCustomersReq
+	if err := bindJSONRequest(c, &req, nil); err != nil {
+		return 0, nil, err
+	}
+	list, err := service.CustomerServiceInstance().SearchCustomers(customerFilter(&req))
+	if err != nil {
+		return 0, nil, err
+	}
+	return http.StatusOK, list, nil
+}
+
+// CreateCustomer 新增会员，返回会员详情
+func (handler *APIV1Handler) CreateCustomer(c *gin.Context) (int, interface{}, error) {
+	var req view.AddCustomerReq
+	if err := bindJSONRequest(c, &req, nil); err != nil {
+		return 0, nil, err
+	}
+	if _, err := service.CustomerServiceInstance().CreateCustomer(req.Cell, req.Name); err != nil {
+		return 0, nil, err
+	}
+	info, err := service.CustomerServiceInstance().GetCustomerDetailInfo(req.Cell)
+	if err != nil {
+		return 0, nil, err
+	}
+	return http.StatusCreated, info, nil
+}
+
+// GetCustomer 会员详情，包含余额和账户流水
+func (handler *APIV1Handler) GetCustomer(c *gin.Context) (int, interface{}, error) {
+	cell, err := pathCell(c)
+	if err != nil {
+		return 0, nil, err
+	}
+	info, err := service.CustomerServiceInstance().GetCustomerDetailInfo(cell)
+	if err != nil {
+		return 0, nil, err
+	}
+	return http.StatusOK, info, nil
+}
+
+// UpdateCustomer 修改会员资料，返回最新的会员详情
+func (handler *APIV1Handler) UpdateCustomer(c *gin.Context) (int, interface{}, error) {
+	op, err := OperatorInfo(c)
+	if err != nil {
+		return 0, nil, err
+	}
+	var req view.CustomerProfileReq
+	if err := bindJSONRequest(c, &req, func() { req.Cell = c.Param("cell") }); err != nil {
+		return 0, nil, err
+	}
+	profile := &service.CustomerProfile{
+		Name:             req.Name,
+		Birthday:         req.Birthday,
+		Gender:           req.Gender,
+		Email:            req.Email,
+		Notes:            req.Notes,
+		Tags:             req.Tags,
+		MarketingConsent: req.MarketingConsent,
+	}
+	if err := service.CustomerServiceInstance().UpdateProfile(req.Cell, profile, op); err != nil {
+		return 0, nil, err
+	}
+	info, err := service.CustomerServiceInstance().GetCustomerDetailInfo(req.Cell)
+	if err != nil {
+		return 0, nil, err
+	}
+	return http.StatusOK, info, nil
+}
+
+// ListCustomerChanges 会员资料的修改记录
+func (handler *APIV1Handler) ListCustomerChanges(c *gin.Context) (int, interface{}, error) {
+	cell, err := pathCell(c)
+	if err != nil {
+		return 0, nil, err
+	}
+	changes, err := service.CustomerServiceInstance().GetProfileChanges(cell)
+	if err != nil {
+		return 0, nil, err
+	}
+	return http.StatusOK, changes, nil
+}
+
+// ListTransactions 会员的账户流水
+func (handler *APIV1Handler) ListTransactions(c *gin.Context) (int, interface{}, error) {
+	cell, err := pathCell(c)
+	if err != nil {
+		return 0, nil, err
+	}
+	info, err := service.CustomerServiceInstance().GetCustomerDetailInfo(cell)
+	if err != nil {
+		return 0, nil, err
+	}
+	return http.StatusOK, info.AccountsDetail, nil
+}
+
+// CreateTransaction 给会员记一笔充值、消费或退款，返回最新的会员详情
+func (handler *APIV1Handler) CreateTransaction(c *gin.Context) (int, interface{}, error) {
+	op, err := OperatorInfo(c)
+	if err != nil {
+		return 0, nil, err
+	}
+	var req view.TransactionReq
+	if err := bindJSONRequest(c, &req, func() { req.Cell = c.Param("cell") }); err != nil {
+		return 0, nil, err
+	}
+	payment := &service.RechargePayment{
+		Method:   req.PayMethod,
+		Ref:      req.PayRef,
+		Tendered: req.Tendered,
+	}
+	if _, err := service.CustomerServiceInstance().AddCustomerAccount(req.Cell, req.OperateType, req.Amount, req.Desc, req.Code, payment, op); err != nil {
+		return 0, nil, err
+	}
+	info, err := service.CustomerServiceInstance().GetCustomerDetailInfo(req.Cell)
+	if err != nil {
+		return 0, nil, err
+	}
+	return http.StatusCreated, info, nil
+}
+
+// CreatePayCode 给会员发送大额消费确认码
+func (handler *APIV1Handler) CreatePayCode(c *gin.Context) (int, interface{}, error) {
+	var req view.PayCodeReq
+	if err := bindJSONRequest(c, &req, func() { req.Cell = c.Param("cell") }); err != nil {
+		return 0, nil, err
+	}
+	if err := service.CustomerServiceInstance().SendPayCode(c.Request.Context(), req.Cell, req.Amount); err != nil {
+		return 0, nil, err
+	}
+	return http.StatusAccepted, nil, nil
+}
+
+// ListSms 会员最近的短信发送记录
+func (handler *APIV1Handler) ListSms(c *gin.Context) (int, interface{}, error) {
+	cell, err := pathCell(c)
+	if err != nil {
+		return 0, nil, err
+	}
+	records, err := service.SmsServiceInstance().GetPhoneSmsRecords(cell)
+	if err != nil {
+		return 0, nil, err
+	}
+	return http.StatusOK, records, nil
+}
+
+// ExchangePaymentToken 扫描会员付款码，换取会员信息和可消费金额
+func (handler *APIV1Handler) ExchangePaymentToken(c *gin.Context) (int, interface{}, error) {
+	var req view.PaymentTokenReq
+	if err := bindJSONRequest(c, &req, nil); err != nil {
+		return 0, nil, err
+	}
+	auth, err := service.CustomerServiceInstance().ExchangePaymentToken(req.Token)
+	if err != nil {
+		return 0, nil, err
+	}
+	return http.StatusOK, auth, nil
+}
+
+// SettleReport 日结对账报表，日期通过 query 参数 date 指定
+func (handler *APIV1Handler) SettleReport(c *gin.Context) (int, interface{}, error) {
+	var req view.SettleReportReq
+	if err := bindJSONRequest(c, &req, nil); err != nil {
+		return 0, nil, err
+	}
+	report, err := service.ReportServiceInstance().SettleReport(req.Date)
+	if err != nil {
+		return 0, nil, err
+	}
+	return http.StatusOK, report, nil
+}
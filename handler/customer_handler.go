@@ -2,6 +2,7 @@ package handler
 
 import (
 	"code.bean.com/flamingo/config"
+	"code.bean.com/flamingo/handler/view"
 	"code.bean.com/flamingo/service"
 	"code.bean.com/flamingo/util"
 	"code.byted.org/gopkg/logs"
@@ -19,14 +20,21 @@ func (handler *CustomersHandler) Register(e *gin.Engine) {
 	group.POST("/check_code", JSONWrapper(handler.SendCheckCode))
 	group.POST("login", JSONWrapper(handler.Login))
 	group.POST("/cu_detail", CustomersInfoMiddleware(), JSONWrapper(handler.GetCustomerInfo))
+	group.POST("/pay_code", CustomersInfoMiddleware(), JSONWrapper(handler.CreatePayCode))
+	group.POST("/payment_token", CustomersInfoMiddleware(), JSONWrapper(handler.CreatePaymentToken))
+	group.POST("/recharge_order", CustomersInfoMiddleware(), JSONWrapper(handler.CreateRechargeOrder))
+	group.POST("/recharge_order_query", CustomersInfoMiddleware(), JSONWrapper(handler.QueryRechargeOrder))
+	group.POST("/wx_unbind", CustomersInfoMiddleware(), JSONWrapper(handler.UnbindWechat))
+	group.POST("/notify_setting", CustomersInfoMiddleware(), JSONWrapper(handler.SetNotify))
+	group.POST("/update_profile", CustomersInfoMiddleware(), JSONWrapper(handler.UpdateProfile))
 }
 
 func (handler *CustomersHandler) SendCheckCode(c *gin.Context) (interface{}, error) {
-	phone := c.PostForm("cell")
-	if phone == "" {
-		return nil, service.NewError(401, "缺少必要参数")
+	var req view.CellReq
+	if err := bindRequest(c, &req); err != nil {
+		return nil, err
 	}
-	err := service.CustomerServiceInstance().SendCheckCode(phone)
+	err := service.CustomerServiceInstance().SendCheckCode(c.Request.Context(), req.Cell)
 	if err != nil {
 		return nil, err
 	}
@@ -34,28 +42,158 @@ func (handler *CustomersHandler) SendCheckCode(c *gin.Context) (interface{}, err
 }
 
 func (handler *CustomersHandler) Login(c *gin.Context) (interface{}, error) {
-	// phone := c.PostForm("cell")
-	// pwd := c.PostForm("pwd")
-	phone := c.PostForm("cell")
-	code := c.PostForm("code")
-	verify, err := service.CustomerServiceInstance().VerifyCheckCode(code, phone)
+	var req view.CustomerLoginReq
+	if err := bindRequest(c, &req); err != nil {
+		return nil, err
+	}
+	phone := req.Cell
+	verify, err := service.CustomerServiceInstance().VerifyCheckCode(req.Code, phone)
 	if err != nil {
 		return nil, err
 	}
 	if !verify {
 		return nil, service.ErrPasswordCheckCodeNotMatch
 	}
-	enbytes, _ := util.AESEncrypt([]byte(phone))
-	host, _ := config.ConfigJson.Get("host").String()
-	c.SetCookie("customer_id", string(enbytes), 3600*24, "/", host, false, false)
+	// 从微信网页授权跳转过来的用户，登录后绑定微信
+	if openID := wxOpenIDFromCookie(c); openID != "" {
+		if err := service.CustomerServiceInstance().BindWxOpenID(phone, openID); err != nil {
+			logs.Error("bind wechat openid error, err=%+v", err)
+		}
+		setCookie(c, "wx_openid", "", -1, true)
+	}
+	setCustomerCookie(c, phone)
 	return "success", nil
 }
 
+func (handler *CustomersHandler) UnbindWechat(c *gin.Context) (interface{}, error) {
+	customer, err := CustomerInfo(c)
+	if err != nil {
+		logs.Error("invalid user,err=%+v", err)
+		return nil, service.ErrorServiceInternalError
+	}
+	if err := service.CustomerServiceInstance().UnbindWxOpenID(customer); err != nil {
+		return nil, err
+	}
+	return "success", nil
+}
+
+func setCustomerCookie(c *gin.Context, phone string) {
+	enbytes, _ := util.AESEncrypt([]byte(phone))
+	setCookie(c, "customer_id", string(enbytes), config.ConfigInstance.Cookie.MaxAge, false)
+}
+
+func wxOpenIDFromCookie(c *gin.Context) string {
+	cookie, err := c.Cookie("wx_openid")
+	if err != nil || cookie == "" {
+		return ""
+	}
+	openID, err := util.AESDecrypt([]byte(cookie))
+	if err != nil {
+		return ""
+	}
+	return string(openID)
+}
+
 func (handler *CustomersHandler) GetCustomerInfo(c *gin.Context) (interface{}, error) {
 	customer, err := CustomerInfo(c)
 	if err != nil {
 		logs.Error("invalid user,err=%+v", err)
 		return nil, service.ErrorServiceInternalError
 	}
-	return service.CustomerServiceInstance().GetCustomerDetailInfo(customer.Cellphone)
+	return customerDetail(customer.Cellphone)
+}
+
+// customerDetail 会员本人看到的详情，不包含操作员填写的备注和标签
+func customerDetail(cellphone string) (*view.CustomersInfo, error) {
+	info, err := service.CustomerServiceInstance().GetCustomerDetailInfo(cellphone)
+	if err != nil {
+		return nil, err
+	}
+	info.Notes = ""
+	info.Tags = nil
+	return info, nil
+}
+
+// UpdateProfile 会员修改自己的资料，返回最新的会员详情
+func (handler *CustomersHandler) UpdateProfile(c *gin.Context) (interface{}, error) {
+	customer, err := CustomerInfo(c)
+	if err != nil {
+		logs.Error("invalid user,err=%+v", err)
+		return nil, service.ErrorServiceInternalError
+	}
+	var req view.UpdateProfileReq
+	if err := bindRequest(c, &req); err != nil {
+		return nil, err
+	}
+	profile := &service.CustomerProfile{
+		Name:             req.Name,
+		Birthday:         req.Birthday,
+		Gender:           req.Gender,
+		Email:            req.Email,
+		MarketingConsent: req.MarketingConsent == "1",
+	}
+	if err := service.CustomerServiceInstance().UpdateProfile(customer.Cellphone, profile, nil); err != nil {
+		return nil, err
+	}
+	return customerDetail(customer.Cellphone)
+}
+
+func (handler *CustomersHandler) SetNotify(c *gin.Context) (interface{}, error) {
+	customer, err := CustomerInfo(c)
+	if err != nil {
+		logs.Error("invalid user,err=%+v", err)
+		return nil, service.ErrorServiceInternalError
+	}
+	var req view.NotifySettingReq
+	if err := bindRequest(c, &req); err != nil {
+		return nil, err
+	}
+	if err := service.CustomerServiceInstance().SetNotify(customer, req.NotifyOff == "1"); err != nil {
+		return nil, err
+	}
+	return "success", nil
+}
+
+func (handler *CustomersHandler) CreatePayCode(c *gin.Context) (interface{}, error) {
+	customer, err := CustomerInfo(c)
+	if err != nil {
+		logs.Error("invalid user,err=%+v", err)
+		return nil, service.ErrorServiceInternalError
+	}
+	return service.CustomerServiceInstance().CreatePayCode(customer)
+}
+
+func (handler *CustomersHandler) CreatePaymentToken(c *gin.Context) (interface{}, error) {
+	customer, err := CustomerInfo(c)
+	if err != nil {
+		logs.Error("invalid user,err=%+v", err)
+		return nil, service.ErrorServiceInternalError
+	}
+	return service.CustomerServiceInstance().CreatePaymentToken(customer)
+}
+
+func (handler *CustomersHandler) CreateRechargeOrder(c *gin.Context) (interface{}, error) {
+	customer, err := CustomerInfo(c)
+	if err != nil {
+		logs.Error("invalid user,err=%+v", err)
+		return nil, service.ErrorServiceInternalError
+	}
+	var req view.RechargeOrderReq
+	if err := bindRequest(c, &req); err != nil {
+		return nil, err
+	}
+	return service.PayOrderServiceInstance().CreateRechargeOrder(customer, req.Amount, req.Gateway, req.TradeType, c.ClientIP())
+}
+
+func (handler *CustomersHandler) QueryRechargeOrder(c *gin.Context) (interface{}, error) {
+	customer, err := CustomerInfo(c)
+	if err != nil {
+		logs.Error("invalid user,err=%+v", err)
+		return nil, service.ErrorServiceInternalError
+	}
+	var req view.OrderNoReq
+	if err := bindRequest(c, &req); err != nil {
+		return nil, err
+	}
+	return service.PayOrderServiceInstance().QueryRechargeOrder(customer, req.OrderNo)
 }
@@ -0,0 +1,183 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+
+	"code.bean.com/flamingo/handler/view"
+	"code.bean.com/flamingo/model"
+	"code.bean.com/flamingo/service"
+)
+
+// customerLogin 发送登录验证码并用 fakeSmsServer 收到的验证码登录，返回会员登录 cookie
+func customerLogin(t *testing.T, cell string) []*http.Cookie {
+	if _, resp := postForm(t, "/cu/check_code", url.Values{"cell": {cell}}, nil); resp.Code != service.OK {
+		t.Fatalf("send check code %s: code=%d, msg=%s", cell, resp.Code, resp.Msg)
+	}
+	params := testSms.lastParams(cell)
+	if len(params) == 0 {
+		t.Fatalf("send check code %s: no sms received", cell)
+	}
+	rec, resp := postForm(t, "/cu/login", url.Values{"cell": {cell}, "code": {params[0]}}, nil)
+	if resp.Code != service.OK {
+		t.Fatalf("customer login %s: code=%d, msg=%s", cell, resp.Code, resp.Msg)
+	}
+	return rec.Result().Cookies()
+}
+
+// customerBalance 会员当前余额
+func customerBalance(t *testing.T, cookies []*http.Cookie) string {
+	_, resp := postForm(t, "/cu/cu_detail", url.Values{}, cookies)
+	var info view.CustomersInfo
+	if resp.Code != service.OK || json.Unmarshal(resp.Data, &info) != nil {
+		t.Fatalf("customer detail: code=%d, msg=%s", resp.Code, resp.Msg)
+	}
+	return info.CustomerRestAmount
+}
+
+func TestCustomerSmsLogin(t *testing.T) {
+	const cell = "13700000041"
+	cookies := operatorLogin(t)
+	addCustomer(t, cookies, cell, "周一", "")
+
+	if _, resp := postForm(t, "/cu/check_code", url.Values{"cell": {"13799999999"}}, nil); resp.Code != service.ErrIllegalPhoneNo.Code {
+		t.Errorf("check code for unknown customer: code=%d, want %d", resp.Code, service.ErrIllegalPhoneNo.Code)
+	}
+	if _, resp := postForm(t, "/cu/check_code", url.Values{"cell": {cell}}, nil); resp.Code != service.OK {
+		t.Fatalf("send check code: code=%d, msg=%s", resp.Code, resp.Msg)
+	}
+	params := testSms.lastParams(cell)
+	if len(params) != 1 || len(params[0]) == 0 {
+		t.Fatalf("check code sms params = %v", params)
+	}
+	rec, resp := postForm(t, "/cu/check_code", url.Values{"cell": {cell}}, nil)
+	if resp.Code != service.ErrSmsTooFrequent.Code || rec.Code != http.StatusTooManyRequests {
+		t.Errorf("resend within cooldown: status=%d, code=%d", rec.Code, resp.Code)
+	}
+
+	wrong := "0000"
+	if params[0] == wrong {
+		wrong = "1111"
+	}
+	if _, resp := postForm(t, "/cu/login", url.Values{"cell": {cell}, "code": {wrong}}, nil); resp.Code != service.ErrPasswordCheckCodeNotMatch.Code {
+		t.Errorf("login with wrong code: code=%d", resp.Code)
+	}
+	rec, resp = postForm(t, "/cu/login", url.Values{"cell": {cell}, "code": {params[0]}}, nil)
+	if resp.Code != service.OK {
+		t.Fatalf("login: code=%d, msg=%s", resp.Code, resp.Msg)
+	}
+	if balance := customerBalance(t, rec.Result().Cookies()); balance != "0.00" {
+		t.Errorf("balance after login = %s", balance)
+	}
+
+	_, resp = postForm(t, "/operator/sms_status", url.Values{"cell": {cell}}, cookies)
+	var records []*view.SmsRecord
+	if resp.Code != service.OK || json.Unmarshal(resp.Data, &records) != nil || len(records) != 1 {
+		t.Fatalf("sms status: code=%d, data=%s", resp.Code, resp.Data)
+	}
+	if records[0].Status != model.SmsStatusSent || records[0].Purpose != model.SmsPurposeLogin {
+		t.Errorf("sms record = %+v", records[0])
+	}
+}
+
+func TestPaymentToken(t *testing.T) {
+	const cell = "13700000051"
+	operator := operatorLogin(t)
+	addCustomer(t, operator, cell, "吴二", "100")
+	customer := customerLogin(t, cell)
+
+	_, resp := postForm(t, "/cu/payment_token", url.Values{}, customer)
+	var token view.PaymentToken
+	if resp.Code != service.OK || json.Unmarshal(resp.Data, &token) != nil || token.Token == "" {
+		t.Fatalf("create payment token: code=%d, data=%s", resp.Code, resp.Data)
+	}
+	if !strings.HasPrefix(token.QRCode, "data:image/png;base64,") || token.ExpireIn <= 0 {
+		t.Errorf("payment token qrcode=%.30s, expire_in=%d", token.QRCode, token.ExpireIn)
+	}
+
+	tampered := token.Token[:len(token.Token)-1] + "x"
+	if strings.HasSuffix(token.Token, "x") {
+		tampered = token.Token[:len(token.Token)-1] + "y"
+	}
+	if _, resp := postForm(t, "/operator/scan_pay_token", url.Values{"token": {tampered}}, operator); resp.Code != service.ErrPaymentTokenInvalid.Code {
+		t.Errorf("scan tampered token: code=%d", resp.Code)
+	}
+	_, resp = postForm(t, "/operator/scan_pay_token", url.Values{"token": {token.Token}}, operator)
+	var auth view.PaymentAuth
+	if resp.Code != service.OK || json.Unmarshal(resp.Data, &auth) != nil {
+		t.Fatalf("scan token: code=%d, msg=%s", resp.Code, resp.Msg)
+	}
+	if auth.Customer == nil || auth.Customer.CustomerCellphone != cell || auth.AuthCode == "" {
+		t.Errorf("payment auth = %+v", auth)
+	}
+	if _, resp := postForm(t, "/operator/scan_pay_token", url.Values{"token": {token.Token}}, operator); resp.Code != service.ErrPaymentTokenInvalid.Code {
+		t.Errorf("scan token twice: code=%d", resp.Code)
+	}
+
+	form := url.Values{"cell": {cell}, "operate_type": {model.AccountTypeCunsume}, "amount": {"30"}, "code": {auth.AuthCode}}
+	if _, resp := postForm(t, "/operator/operate_customer", form, operator); resp.Code != service.OK {
+		t.Errorf("consume with auth code: code=%d, msg=%s", resp.Code, resp.Msg)
+	}
+	if balance := customerBalance(t, customer); balance != "70.00" {
+		t.Errorf("balance after consume = %s, want 70.00", balance)
+	}
+}
+
+// postPayNotify 模拟支付渠道的异步通知，返回应答内容
+func postPayNotify(orderNo, tradeNo string, amount int, sign string) string {
+	form := url.Values{"order_no": {orderNo}, "trade_no": {tradeNo}, "amount": {strconv.Itoa(amount)}, "sign": {sign}}
+	req := httptest.NewRequest(http.MethodPost, "/pay/notify/fake", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rec := httptest.NewRecorder()
+	testRouter.ServeHTTP(rec, req)
+	return rec.Body.String()
+}
+
+func TestRechargeOrderNotify(t *testing.T) {
+	const cell = "13700000061"
+	addCustomer(t, operatorLogin(t), cell, "郑三", "")
+	customer := customerLogin(t, cell)
+
+	_, resp := postForm(t, "/cu/recharge_order", url.Values{"amount": {"100.10"}, "gateway": {"fake"}}, customer)
+	var order view.RechargeOrder
+	if resp.Code != service.OK || json.Unmarshal(resp.Data, &order) != nil || order.OrderNo == "" {
+		t.Fatalf("create recharge order: code=%d, msg=%s", resp.Code, resp.Msg)
+	}
+	if order.Status != model.PayOrderPending || order.Amount != "100.10" {
+		t.Errorf("recharge order = %+v", order)
+	}
+
+	gateway := &service.FakePayGateway{Secret: "test"}
+	const tradeNo = "T20261019"
+	if ack := postPayNotify(order.OrderNo, tradeNo, 10010, "bad"); ack != "fail" {
+		t.Errorf("notify with bad sign: ack=%s", ack)
+	}
+	if ack := postPayNotify(order.OrderNo, tradeNo, 1, gateway.SignNotify(order.OrderNo, tradeNo, 1)); ack != "fail" {
+		t.Errorf("notify with mismatched amount: ack=%s", ack)
+	}
+	if ack := postPayNotify("20261019000000unknown", tradeNo, 10010, gateway.SignNotify("20261019000000unknown", tradeNo, 10010)); ack != "fail" {
+		t.Errorf("notify for unknown order: ack=%s", ack)
+	}
+	if balance := customerBalance(t, customer); balance != "0.00" {
+		t.Fatalf("balance after rejected notifies = %s", balance)
+	}
+
+	sign := gateway.SignNotify(order.OrderNo, tradeNo, 10010)
+	for i := 0; i < 2; i++ {
+		if ack := postPayNotify(order.OrderNo, tradeNo, 10010, sign); ack != "success" {
+			t.Errorf("notify %d: ack=%s", i, ack)
+		}
+	}
+	if balance := customerBalance(t, customer); balance != "100.10" {
+		t.Errorf("balance after repeated notify = %s, want 100.10", balance)
+	}
+	_, resp = postForm(t, "/cu/recharge_order_query", url.Values{"order_no": {order.OrderNo}}, customer)
+	if resp.Code != service.OK || json.Unmarshal(resp.Data, &order) != nil || order.Status != model.PayOrderCredited {
+		t.Errorf("query recharge order: code=%d, data=%s", resp.Code, resp.Data)
+	}
+}
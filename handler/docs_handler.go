@@ -0,0 +1,31 @@
+package handler
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// DocsHandler 接口文档
+type DocsHandler struct{}
+
+// NewDocsHandler 实例化
+func NewDocsHandler() *DocsHandler {
+	return &DocsHandler{}
+}
+
+// Register 注册api
+func (handler *DocsHandler) Register(e *gin.Engine) {
+	e.GET("/openapi.json", handler.OpenAPI)
+	e.GET("/docs", handler.Docs)
+}
+
+// OpenAPI 返回 OpenAPI 3 文档
+func (handler *DocsHandler) OpenAPI(c *gin.Context) {
+	c.Data(http.StatusOK, "application/json; charset=utf-8", OpenAPIDocument())
+}
+
+// Docs 接口文档页面，页面加载 /openapi.json 后渲染
+func (handler *DocsHandler) Docs(c *gin.Context) {
+	c.HTML(http.StatusOK, "api_docs.html", gin.H{})
+}
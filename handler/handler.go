@@ -1,12 +1,13 @@
 package handler
 
 import (
-	"encoding/json"
-	"io/ioutil"
+	"context"
 	"net/http"
-	"time"
+	"sync"
 
+	"code.bean.com/flamingo/metrics"
 	"code.bean.com/flamingo/service"
+	"code.bean.com/flamingo/util"
 	"code.byted.org/gopkg/logs"
 	"github.com/gin-gonic/gin"
 )
@@ -17,89 +18,107 @@ type Handler interface {
 }
 
 var (
-	handlers      []Handler
-	configService *service.ConfigService
-)
-
-const (
-	// AKURL AccessToken url
-	AKURL = "https://api.weixin.qq.com/cgi-bin/token?grant_type=client_credential&appid=wxe3811b0b04d53337&secret=c383e3f539137e3da5591320e20e67ad"
+	handlers []Handler
 )
 
 // JSONHandlerFunc 返回json结果的处理函数
 type JSONHandlerFunc func(*gin.Context) (interface{}, error)
 
-// AccessTokenResp access token返回结构体
-type AccessTokenResp struct {
-	AccessToken string `json:"access_token"`
-	Expires     int    `json:"expires_in"`
-}
+// JSONStatusHandlerFunc 返回json结果和成功时的http状态码的处理函数
+type JSONStatusHandlerFunc func(*gin.Context) (int, interface{}, error)
 
 // Init 初始化Handler层
 func Init() {
-	configService = service.NewConfigService()
 	handlers = make([]Handler, 0)
-	handlers = append(handlers, NewTemplateHandler(), NewWXAccessHandler(), NewCustomerHandler(), NewOperatorHandler())
-	// go RefreshAccessToken()
+	metrics.RegisterLiability(service.ReportServiceInstance().Liability)
+	handlers = append(handlers, NewHealthHandler(), NewDocsHandler(), NewTemplateHandler(), NewWXAccessHandler(), NewCustomerHandler(), NewOperatorHandler(), NewSmsHandler(), NewPayHandler(), NewAdminHandler(), NewAPIV1Handler())
 }
 
-// RegisterHandler 注册所有Handler的api接口
-func RegisterHandler(e *gin.Engine) {
-	for _, h := range handlers {
-		h.Register(e)
+// StartWorkers 启动后台任务，ctx 取消后任务退出，返回的 WaitGroup 用于等待所有任务退出
+func StartWorkers(ctx context.Context) *sync.WaitGroup {
+	workers := []func(context.Context){
+		service.SmsServiceInstance().RunRetryQueue,
+		service.WxTokenServiceInstance().Run,
+		service.PayOrderServiceInstance().RunOrderJobs,
 	}
+	wg := &sync.WaitGroup{}
+	for _, worker := range workers {
+		wg.Add(1)
+		go func(run func(context.Context)) {
+			defer wg.Done()
+			run(ctx)
+		}(worker)
+	}
+	return wg
 }
 
-// RefreshAccessToken 微信access token
-func RefreshAccessToken() {
-	for {
-		req, err := http.NewRequest("GET", AKURL, nil)
-		if err != nil {
-			logs.Fatalf("get access token req error:%+v\n", err)
-		}
-		client := &http.Client{}
-		resp, err := client.Do(req)
-		if err != nil {
-			logs.Fatalf("get access token resp error:%+v\n", err)
-		}
-		body, err := ioutil.ReadAll(resp.Body)
-		if err != nil {
-			logs.Fatalf("read access token resp error:%+v\n", err)
-		}
-		logs.Info("refresh access token resp:%s", string(body))
-		var token AccessTokenResp
-		err = json.Unmarshal(body, &token)
-		if err != nil || token.AccessToken == "" {
-			continue
-		}
-		configService.UpdateAccessToken(token.AccessToken)
-		logs.Info("update access token:%s", token.AccessToken)
-		time.Sleep(7000 * time.Second)
+// RegisterHandler 注册所有Handler的api接口，新增的路由需要在 apiSpecs 中写文档，由 openapi_test.go 检查
+func RegisterHandler(e *gin.Engine) {
+	for _, h := range handlers {
+		h.Register(e)
 	}
-
+	e.NoRoute(func(c *gin.Context) {
+		c.Set(metrics.RouteKey, metrics.RouteUnmatched)
+	})
 }
 
 // JSONWrapper 将一个函数的返回结果用统一的json格式封装
 func JSONWrapper(fn JSONHandlerFunc) gin.HandlerFunc {
-	return func(c *gin.Context) {
-		logs.Debug("@@request is +%v", c.Request)
+	return JSONStatusWrapper(func(c *gin.Context) (int, interface{}, error) {
 		data, err := fn(c)
-		logs.Debug("@@data and err is +%v  %v", data, err)
+		return http.StatusOK, data, err
+	})
+}
+
+// JSONStatusWrapper 与 JSONWrapper 相同，成功时使用处理函数返回的http状态码
+func JSONStatusWrapper(fn JSONStatusHandlerFunc) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		status, data, err := fn(c)
 		if err != nil {
-			logs.Error("error: %v, path: %v, params: %v", err, c.Request.URL, c.Request.Form)
-			se, ok := err.(*service.Error)
-			if ok {
-				c.JSON(http.StatusOK, se)
-				return
-			}
-			e := service.ErrorWrap(service.StatusInternalServerError, err)
-			c.JSON(http.StatusInternalServerError, e)
+			logs.CtxError(c.Request.Context(), "error: %v, path: %v, params: %v", util.Redact(err.Error()), util.Redact(c.Request.URL.Path), util.RedactValues(c.Request.Form))
+			writeError(c, err)
 			return
 		}
+		c.Set(metrics.ErrorCodeKey, service.OK)
+		if status == http.StatusNoContent {
+			c.Status(status)
+			return
+		}
+		c.JSON(status, Response{Code: service.OK, Msg: "ok", Data: data})
+	}
+}
 
-		c.JSON(http.StatusOK, map[string]interface{}{
-			"code": 0,
-			"data": data,
-		})
+// Response 所有 json 接口统一的返回结构
+type Response struct {
+	Code      int         `json:"code"`
+	Msg       string      `json:"msg"`
+	Data      interface{} `json:"data"`
+	RequestID string      `json:"request_id,omitempty"`
+	// Errors 参数校验失败时每个字段的错误
+	Errors []*FieldError `json:"errors,omitempty"`
+}
+
+// writeError 按错误目录返回 http 状态码和 Accept-Language 对应语言的错误信息，未知错误作为服务内部错误返回
+func writeError(c *gin.Context, err error) {
+	lang := service.ParseLanguage(c.GetHeader("Accept-Language"))
+	var fields []*FieldError
+	se, ok := err.(*service.Error)
+	if ve, isValidation := err.(*ValidationError); isValidation {
+		se, fields = ve.catalogError(), ve.localize(lang)
+	} else if !ok {
+		se = service.AsError(err)
 	}
+	c.Set(metrics.ErrorCodeKey, se.Code)
+	c.JSON(se.HTTPStatus(), Response{
+		Code:      se.Code,
+		Msg:       se.Localize(lang),
+		RequestID: util.RequestID(c.Request.Context()),
+		Errors:    fields,
+	})
+}
+
+// abortWithError 中间件中返回错误并终止后续处理
+func abortWithError(c *gin.Context, err error) {
+	writeError(c, err)
+	c.Abort()
 }
@@ -0,0 +1,470 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+
+	"code.bean.com/flamingo/config"
+	"code.bean.com/flamingo/handler/view"
+	"code.bean.com/flamingo/metrics"
+	"code.bean.com/flamingo/model"
+	"code.bean.com/flamingo/service"
+	"code.bean.com/flamingo/util"
+	"code.byted.org/gopkg/logs"
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	testOperatorCell = "13800000000"
+	testOperatorPwd  = "secret"
+	testCustomerCell = "13900000000"
+)
+
+// 测试用的公众号配置，testWxAESKey 为 43 位的 EncodingAESKey
+const (
+	testWxToken  = "wxtoken"
+	testWxAppID  = "wx0000000000000000"
+	testWxAESKey = "abcdefghijklmnopqrstuvwxyz0123456789ABCDEFG"
+)
+
+var (
+	testRouter *gin.Engine
+	testStore  *model.MemoryStore
+	testSms    *fakeSmsServer
+	testLogs   *logs.MemoryProvider
+)
+
+// TestMain 使用内存数据注册所有接口，短信服务商由本地的 fakeSmsServer 模拟，不依赖数据库和配置文件
+func TestMain(m *testing.M) {
+	testLogs = logs.NewMemoryProvider()
+	logs.AddProvider(testLogs)
+	testSms = newFakeSmsServer()
+	config.ConfigInstance = &config.Config{
+		Env:            config.Dev,
+		Sms:            config.SmsConfig{AppID: "1400000000", AppKey: "key", PayCodeTplID: 1, APIBase: testSms.URL},
+		Wechat:         config.WechatConfig{Token: testWxToken, AppID: testWxAppID, AppSecret: "secret", EncodingAESKey: testWxAESKey},
+		Session:        config.SessionConfig{PaymentTokenSecret: "payment-secret"},
+		Cookie:         config.CookieConfig{MaxAge: 3600},
+		Pay:            config.PayConfig{FakeEnabled: true, FakeSecret: "test", MaxAmount: 5000, OrderTimeoutMinutes: 15},
+		AdminOperators: []string{testOperatorCell},
+	}
+	if err := util.InitAES("0123456789abcdef"); err != nil {
+		panic(err)
+	}
+	testStore = model.NewMemoryStore()
+	testStore.AddOperator(&model.KroOperator{Cellphone: testOperatorCell, Name: "测试操作员", Pwd: testOperatorPwd})
+	service.SetRepositories(model.MemoryRepositories(testStore))
+
+	gin.SetMode(gin.TestMode)
+	testRouter = gin.New()
+	Init()
+	RegisterHandler(testRouter)
+	code := m.Run()
+	testSms.Close()
+	os.Exit(code)
+}
+
+// fakeSmsServer 模拟短信服务商的发送接口，记录每个手机号最近一次收到的短信参数
+type fakeSmsServer struct {
+	*httptest.Server
+	mutex sync.Mutex
+	sent  map[string][]string
+}
+
+func newFakeSmsServer() *fakeSmsServer {
+	s := &fakeSmsServer{sent: make(map[string][]string)}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req service.CheckCodeReqTemplate
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Tel == nil {
+			w.Write([]byte(`{"result":1001,"errmsg":"bad request"}`))
+			return
+		}
+		s.mutex.Lock()
+		s.sent[req.Tel.Mobile] = req.Params
+		s.mutex.Unlock()
+		w.Write([]byte(`{"result":0,"errmsg":"OK","sid":"sid-` + req.Tel.Mobile + `"}`))
+	}))
+	return s
+}
+
+// lastParams 手机号最近一次收到的短信参数，验证码短信的第一个参数为验证码
+func (s *fakeSmsServer) lastParams(phone string) []string {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.sent[phone]
+}
+
+// testResponse 解析后的接口返回，Data 保留原始 json 按需解析
+type testResponse struct {
+	Code int             `json:"code"`
+	Msg  string          `json:"msg"`
+	Data json.RawMessage `json:"data"`
+}
+
+// postForm 以表单方式请求接口，返回 http 响应和解析后的返回结构
+func postForm(t *testing.T, path string, form url.Values, cookies []*http.Cookie) (*httptest.ResponseRecorder, *testResponse) {
+	req := httptest.NewRequest(http.MethodPost, path, strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	for _, cookie := range cookies {
+		req.AddCookie(cookie)
+	}
+	rec := httptest.NewRecorder()
+	testRouter.ServeHTTP(rec, req)
+	var resp testResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("POST %s: invalid json response %q: %v", path, rec.Body.String(), err)
+	}
+	return rec, &resp
+}
+
+// operatorLogin 操作员登录，返回登录 cookie
+func operatorLogin(t *testing.T) []*http.Cookie {
+	rec, resp := postForm(t, "/operator/login", url.Values{"cell": {testOperatorCell}, "pwd": {testOperatorPwd}}, nil)
+	if resp.Code != service.OK {
+		t.Fatalf("login: code=%d, msg=%s", resp.Code, resp.Msg)
+	}
+	cookies := rec.Result().Cookies()
+	if len(cookies) == 0 {
+		t.Fatal("login: no cookie set")
+	}
+	return cookies
+}
+
+func TestOperatorLogin(t *testing.T) {
+	rec, resp := postForm(t, "/operator/login", url.Values{"cell": {testOperatorCell}, "pwd": {"wrong"}}, nil)
+	if resp.Code != service.ErrWrongPassword.Code || rec.Code != service.ErrWrongPassword.Status {
+		t.Errorf("login with wrong password: status=%d, code=%d", rec.Code, resp.Code)
+	}
+
+	_, resp = postForm(t, "/operator/info", url.Values{}, nil)
+	if resp.Code != service.ErrUserNotLogin.Code {
+		t.Errorf("info without cookie: code=%d, want %d", resp.Code, service.ErrUserNotLogin.Code)
+	}
+	tampered := []*http.Cookie{{Name: "operator_id", Value: "abc"}}
+	_, resp = postForm(t, "/operator/info", url.Values{}, tampered)
+	if resp.Code != service.ErrUserNotLogin.Code {
+		t.Errorf("info with tampered cookie: code=%d, want %d", resp.Code, service.ErrUserNotLogin.Code)
+	}
+
+	_, resp = postForm(t, "/operator/info", url.Values{}, operatorLogin(t))
+	var name string
+	if resp.Code != service.OK || json.Unmarshal(resp.Data, &name) != nil || name != "测试操作员" {
+		t.Errorf("info after login: code=%d, data=%s", resp.Code, resp.Data)
+	}
+}
+
+func TestRechargeConsumeRefund(t *testing.T) {
+	cookies := operatorLogin(t)
+	_, resp := postForm(t, "/operator/add_customer", url.Values{"cell": {testCustomerCell}, "name": {"张三"}}, cookies)
+	if resp.Code != service.OK {
+		t.Fatalf("add customer: code=%d, msg=%s", resp.Code, resp.Msg)
+	}
+
+	steps := []struct {
+		name string
+		form url.Values
+		code int
+	}{
+		{"recharge", url.Values{"operate_type": {model.AccountTypeRecharge}, "amount": {"100.10"}, "pay_method": {model.PayMethodCash}}, service.OK},
+		{"recharge without pay method", url.Values{"operate_type": {model.AccountTypeRecharge}, "amount": {"10"}}, service.ErrInvalidPayMethod.Code},
+		{"consume", url.Values{"operate_type": {model.AccountTypeCunsume}, "amount": {"30.20"}}, service.OK},
+		{"consume in scientific notation", url.Values{"operate_type": {model.AccountTypeCunsume}, "amount": {"1e30"}}, service.ErrInvalidParam.Code},
+		{"consume hex float", url.Values{"operate_type": {model.AccountTypeCunsume}, "amount": {"0x1p70"}}, service.ErrInvalidParam.Code},
+		{"consume infinity", url.Values{"operate_type": {model.AccountTypeCunsume}, "amount": {"Inf"}}, service.ErrInvalidParam.Code},
+		{"consume more than balance", url.Values{"operate_type": {model.AccountTypeCunsume}, "amount": {"69.91"}}, service.ErrInsufficientBalance.Code},
+		{"refund", url.Values{"operate_type": {model.AcccountTypeRefund}, "amount": {"10.01"}}, service.OK},
+		{"consume all", url.Values{"operate_type": {model.AccountTypeCunsume}, "amount": {"79.91"}}, service.OK},
+	}
+	for _, step := range steps {
+		step.form.Set("cell", testCustomerCell)
+		_, resp := postForm(t, "/operator/operate_customer", step.form, cookies)
+		if resp.Code != step.code {
+			t.Errorf("%s: code=%d, want %d, msg=%s", step.name, resp.Code, step.code, resp.Msg)
+		}
+	}
+
+	_, resp = postForm(t, "/operator/query_customer", url.Values{"cell": {testCustomerCell}}, cookies)
+	var info struct {
+		RestAmount string `json:"rest_amount"`
+		Accounts   []struct {
+			Amount string `json:"amount"`
+		} `json:"account_detail"`
+	}
+	if resp.Code != service.OK || json.Unmarshal(resp.Data, &info) != nil {
+		t.Fatalf("query customer: code=%d, data=%s", resp.Code, resp.Data)
+	}
+	if info.RestAmount != "0.00" || len(info.Accounts) != 4 {
+		t.Errorf("query customer: rest=%s, accounts=%d, want 0.00 and 4", info.RestAmount, len(info.Accounts))
+	}
+}
+
+// TestPayMethodEnum 充值请求的付款方式枚举要与服务端接受的付款方式一致
+func TestPayMethodEnum(t *testing.T) {
+	want := strings.Join(model.OperatorPayMethods, "|")
+	for _, req := range []interface{}{view.OperateCustomerReq{}, view.TransactionReq{}} {
+		field, _ := reflect.TypeOf(req).FieldByName("PayMethod")
+		enum := ""
+		for _, rule := range ParseRules(field.Tag.Get(validateTag)) {
+			if rule.Name == "enum" {
+				enum = rule.Param
+			}
+		}
+		if enum != want {
+			t.Errorf("%T pay_method enum=%q, want %q", req, enum, want)
+		}
+	}
+}
+
+// addCustomer 新增会员，充值 recharge 元，recharge 为空时不充值
+func addCustomer(t *testing.T, cookies []*http.Cookie, cell, name, recharge string) {
+	_, resp := postForm(t, "/operator/add_customer", url.Values{"cell": {cell}, "name": {name}}, cookies)
+	if resp.Code != service.OK {
+		t.Fatalf("add customer %s: code=%d, msg=%s", cell, resp.Code, resp.Msg)
+	}
+	if recharge == "" {
+		return
+	}
+	form := url.Values{"cell": {cell}, "operate_type": {model.AccountTypeRecharge}, "amount": {recharge}, "pay_method": {model.PayMethodCash}}
+	if _, resp := postForm(t, "/operator/operate_customer", form, cookies); resp.Code != service.OK {
+		t.Fatalf("recharge %s: code=%d, msg=%s", cell, resp.Code, resp.Msg)
+	}
+}
+
+func TestSearchCustomersByBalance(t *testing.T) {
+	cookies := operatorLogin(t)
+	addCustomer(t, cookies, "13700000001", "李四", "50")
+	addCustomer(t, cookies, "13700000002", "王五", "")
+
+	cases := []struct {
+		form  url.Values
+		cells []string
+	}{
+		{url.Values{"max_balance": {"0"}}, []string{"13700000002"}},
+		{url.Values{"min_balance": {"0"}, "max_balance": {"50"}, "sort": {"balance"}}, []string{"13700000001", "13700000002"}},
+		{url.Values{"min_balance": {"0.01"}}, []string{"13700000001"}},
+	}
+	for _, c := range cases {
+		c.form.Set("q", "1370000000")
+		_, resp := postForm(t, "/operator/search_customers", c.form, cookies)
+		var list struct {
+			Customers []struct {
+				Cell string `json:"cellphone"`
+			} `json:"customers"`
+		}
+		if resp.Code != service.OK || json.Unmarshal(resp.Data, &list) != nil {
+			t.Errorf("search %v: code=%d, msg=%s", c.form, resp.Code, resp.Msg)
+			continue
+		}
+		cells := make([]string, 0, len(list.Customers))
+		for _, customer := range list.Customers {
+			cells = append(cells, customer.Cell)
+		}
+		if strings.Join(cells, ",") != strings.Join(c.cells, ",") {
+			t.Errorf("search %v: got %v, want %v", c.form, cells, c.cells)
+		}
+	}
+
+	_, resp := postForm(t, "/operator/search_customers", url.Values{"min_balance": {"-1"}}, cookies)
+	if resp.Code != service.ErrInvalidParam.Code {
+		t.Errorf("search with negative balance: code=%d, want %d", resp.Code, service.ErrInvalidParam.Code)
+	}
+}
+
+func TestSearchCustomersByPinyin(t *testing.T) {
+	cookies := operatorLogin(t)
+	addCustomer(t, cookies, "13700000011", "单晗", "")
+
+	for _, q := range []string{"单晗", "sh", "shanhan", "ShanHan"} {
+		_, resp := postForm(t, "/operator/search_customers", url.Values{"q": {q}}, cookies)
+		var list struct {
+			Customers []struct {
+				Cell string `json:"cellphone"`
+			} `json:"customers"`
+		}
+		if resp.Code != service.OK || json.Unmarshal(resp.Data, &list) != nil {
+			t.Errorf("search %q: code=%d, msg=%s", q, resp.Code, resp.Msg)
+			continue
+		}
+		if len(list.Customers) != 1 || list.Customers[0].Cell != "13700000011" {
+			t.Errorf("search %q: got %+v, want 13700000011", q, list.Customers)
+		}
+	}
+}
+
+// TestConcurrentConsume 并发消费时只有余额足够的请求成功
+func TestConcurrentConsume(t *testing.T) {
+	cookies := operatorLogin(t)
+	addCustomer(t, cookies, "13700000021", "赵六", "100")
+
+	var wg sync.WaitGroup
+	codes := make([]int, 10)
+	for i := range codes {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			form := url.Values{"cell": {"13700000021"}, "operate_type": {model.AccountTypeCunsume}, "amount": {"30"}}
+			_, resp := postForm(t, "/operator/operate_customer", form, cookies)
+			codes[i] = resp.Code
+		}(i)
+	}
+	wg.Wait()
+	succeeded := 0
+	for _, code := range codes {
+		if code == service.OK {
+			succeeded++
+		} else if code != service.ErrInsufficientBalance.Code {
+			t.Errorf("consume: code=%d", code)
+		}
+	}
+	if succeeded != 3 {
+		t.Errorf("concurrent consume: %d succeeded, want 3", succeeded)
+	}
+}
+
+// apiRequest 请求 /api/v1 接口，token 不为空时使用 bearer token
+func apiRequest(t *testing.T, method, path, body, token string) (*httptest.ResponseRecorder, *testResponse) {
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	rec := httptest.NewRecorder()
+	testRouter.ServeHTTP(rec, req)
+	var resp testResponse
+	if rec.Body.Len() > 0 {
+		if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+			t.Fatalf("%s %s: invalid json response %q: %v", method, path, rec.Body.String(), err)
+		}
+	}
+	return rec, &resp
+}
+
+func TestBearerSession(t *testing.T) {
+	_, resp := apiRequest(t, http.MethodPost, "/api/v1/sessions", `{"cell":"`+testOperatorCell+`","pwd":"`+testOperatorPwd+`"}`, "")
+	var session struct {
+		Token    string `json:"token"`
+		ExpireAt string `json:"expire_at"`
+	}
+	if resp.Code != service.OK || json.Unmarshal(resp.Data, &session) != nil || len(session.Token) != 64 || session.ExpireAt == "" {
+		t.Fatalf("create session: code=%d, data=%s", resp.Code, resp.Data)
+	}
+	_, resp2 := apiRequest(t, http.MethodPost, "/api/v1/sessions", `{"cell":"`+testOperatorCell+`","pwd":"`+testOperatorPwd+`"}`, "")
+	var other struct {
+		Token string `json:"token"`
+	}
+	if json.Unmarshal(resp2.Data, &other) != nil || other.Token == session.Token {
+		t.Errorf("second session reuses token %q", other.Token)
+	}
+
+	if rec, _ := apiRequest(t, http.MethodGet, "/api/v1/operators/me", "", session.Token); rec.Code != http.StatusOK {
+		t.Errorf("me with token: status=%d", rec.Code)
+	}
+	if rec, _ := apiRequest(t, http.MethodGet, "/api/v1/operators/me", "", "0123456789abcdef"); rec.Code != service.ErrUserNotLogin.Status {
+		t.Errorf("me with forged token: status=%d", rec.Code)
+	}
+	if rec, _ := apiRequest(t, http.MethodDelete, "/api/v1/sessions/current", "", session.Token); rec.Code != http.StatusNoContent {
+		t.Errorf("delete session: status=%d", rec.Code)
+	}
+	if rec, _ := apiRequest(t, http.MethodGet, "/api/v1/operators/me", "", session.Token); rec.Code != service.ErrUserNotLogin.Status {
+		t.Errorf("me with revoked token: status=%d", rec.Code)
+	}
+	if rec, _ := apiRequest(t, http.MethodGet, "/api/v1/operators/me", "", other.Token); rec.Code != http.StatusOK {
+		t.Errorf("me with other token: status=%d", rec.Code)
+	}
+}
+
+func TestMetricsRequiresAdmin(t *testing.T) {
+	if rec, _ := apiRequest(t, http.MethodGet, "/metrics", "", ""); rec.Code != service.ErrUserNotLogin.Status {
+		t.Errorf("metrics without login: status=%d", rec.Code)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/no/such/path", nil)
+	rec := httptest.NewRecorder()
+	var route interface{}
+	router := gin.New()
+	router.Use(func(c *gin.Context) {
+		c.Next()
+		route, _ = c.Get(metrics.RouteKey)
+	})
+	RegisterHandler(router)
+	router.ServeHTTP(rec, req)
+	if rec.Code != http.StatusNotFound || route != metrics.RouteUnmatched {
+		t.Errorf("unknown path: status=%d, route=%v", rec.Code, route)
+	}
+}
+
+func TestWechatOAuthState(t *testing.T) {
+	req := httptest.NewRequest(http.MethodGet, "/wx/oauth_callback?state=forged&code=abc", nil)
+	req.AddCookie(&http.Cookie{Name: "wx_state", Value: "expected"})
+	rec := httptest.NewRecorder()
+	testRouter.ServeHTTP(rec, req)
+	if rec.Code != http.StatusFound || rec.Header().Get("Location") != "/templates/customer_login" {
+		t.Errorf("callback with forged state: status=%d, location=%s", rec.Code, rec.Header().Get("Location"))
+	}
+	cleared := false
+	for _, cookie := range rec.Result().Cookies() {
+		if cookie.Name == "wx_state" && cookie.MaxAge < 0 {
+			cleared = true
+		}
+	}
+	if !cleared {
+		t.Error("wx_state cookie not cleared after callback")
+	}
+}
+
+// TestConcurrentProfileUpdate 并发修改资料时，每条修改记录的旧值都是上一次修改的新值
+func TestConcurrentProfileUpdate(t *testing.T) {
+	const cell = "13700000031"
+	addCustomer(t, operatorLogin(t), cell, "林一", "")
+	_, resp := apiRequest(t, http.MethodPost, "/api/v1/sessions", `{"cell":"`+testOperatorCell+`","pwd":"`+testOperatorPwd+`"}`, "")
+	var session struct {
+		Token string `json:"token"`
+	}
+	if json.Unmarshal(resp.Data, &session) != nil || session.Token == "" {
+		t.Fatalf("create session: code=%d, data=%s", resp.Code, resp.Data)
+	}
+
+	names := []string{"林二", "林三", "林四", "林五", "林六", "林七", "林八", "林九"}
+	var wg sync.WaitGroup
+	for _, name := range names {
+		wg.Add(1)
+		go func(name string) {
+			defer wg.Done()
+			if rec, _ := apiRequest(t, http.MethodPut, "/api/v1/customers/"+cell, `{"name":"`+name+`"}`, session.Token); rec.Code != http.StatusOK {
+				t.Errorf("update profile %s: status=%d", name, rec.Code)
+			}
+		}(name)
+	}
+	wg.Wait()
+
+	_, resp = apiRequest(t, http.MethodGet, "/api/v1/customers/"+cell+"/changes", "", session.Token)
+	var changes []*view.CustomerChange
+	if err := json.Unmarshal(resp.Data, &changes); err != nil {
+		t.Fatalf("changes: data=%s, err=%v", resp.Data, err)
+	}
+	var nameChanges []*view.CustomerChange
+	for _, change := range changes {
+		if change.Field == "name" {
+			nameChanges = append(nameChanges, change)
+		}
+	}
+	if len(nameChanges) != len(names) {
+		t.Fatalf("name changes = %d, want %d", len(nameChanges), len(names))
+	}
+	// 修改记录按时间倒序
+	for i := 0; i+1 < len(nameChanges); i++ {
+		if nameChanges[i].OldValue != nameChanges[i+1].NewValue {
+			t.Errorf("change %d old value %q, previous new value %q", i, nameChanges[i].OldValue, nameChanges[i+1].NewValue)
+		}
+	}
+	if last := nameChanges[len(nameChanges)-1]; last.OldValue != "林一" {
+		t.Errorf("first change old value %q, want 林一", last.OldValue)
+	}
+}
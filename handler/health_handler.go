@@ -0,0 +1,86 @@
+package handler
+
+import (
+	"context"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"code.bean.com/flamingo/config"
+	"code.bean.com/flamingo/model"
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const readyCheckTimeout = 2 * time.Second
+
+// draining 服务正在退出，readyz 返回不可用使负载均衡摘除流量
+var draining int32
+
+// SetDraining 标记服务开始退出
+func SetDraining() {
+	atomic.StoreInt32(&draining, 1)
+}
+
+// HealthHandler 存活、就绪检查和监控指标
+type HealthHandler struct{}
+
+// NewHealthHandler 实例化
+func NewHealthHandler() *HealthHandler {
+	return &HealthHandler{}
+}
+
+// Register 注册api，配置了 server.metrics_addr 时 /metrics 只在该地址提供，见 MetricsHandler
+func (handler *HealthHandler) Register(e *gin.Engine) {
+	e.GET("/healthz", handler.Healthz)
+	e.GET("/readyz", handler.Readyz)
+	if config.ConfigInstance.Server.MetricsAddr == "" {
+		e.GET("/metrics", OperatorInfoMiddleware(), AdminMiddleware(), gin.WrapH(prometheus.Handler()))
+	}
+}
+
+// MetricsHandler 单独监听 server.metrics_addr 时使用的 /metrics 处理函数，该地址不应对外开放
+func MetricsHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", prometheus.Handler())
+	return mux
+}
+
+// Healthz 进程存活即返回成功
+func (handler *HealthHandler) Healthz(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"status": "ok"})
+}
+
+// Readyz 检查配置和数据库，全部可用时才接收流量
+func (handler *HealthHandler) Readyz(c *gin.Context) {
+	checks := make(map[string]string)
+	ready := true
+	if atomic.LoadInt32(&draining) == 1 {
+		checks["server"] = "draining"
+		ready = false
+	} else {
+		checks["server"] = "ok"
+	}
+	if config.ConfigInstance == nil {
+		checks["config"] = "not loaded"
+		ready = false
+	} else if err := config.ConfigInstance.Validate(); err != nil {
+		checks["config"] = err.Error()
+		ready = false
+	} else {
+		checks["config"] = "ok"
+	}
+	ctx, cancel := context.WithTimeout(c.Request.Context(), readyCheckTimeout)
+	defer cancel()
+	if err := model.Ping(ctx); err != nil {
+		checks["db"] = err.Error()
+		ready = false
+	} else {
+		checks["db"] = "ok"
+	}
+	if !ready {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"status": "unavailable", "checks": checks})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"status": "ok", "checks": checks})
+}
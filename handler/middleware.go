@@ -1,13 +1,18 @@
 package handler
 
 import (
-	"net/http"
+	"strconv"
+	"strings"
+	"time"
 
+	"code.bean.com/flamingo/config"
+	"code.bean.com/flamingo/metrics"
 	"code.bean.com/flamingo/model"
 
 	"code.bean.com/flamingo/util"
 
 	"code.bean.com/flamingo/service"
+	"code.byted.org/gopkg/logs"
 	"github.com/gin-gonic/gin"
 )
 
@@ -16,17 +21,17 @@ func CustomersInfoMiddleware() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		cellPhone, err := c.Cookie("customer_id")
 		if err != nil || cellPhone == "" {
-			getErrorResponse(c, http.StatusUnauthorized, service.ErrUserNotLogin)
+			abortWithError(c, service.ErrUserNotLogin)
 			return
 		}
 		decriptBytes, err := util.AESDecrypt([]byte(cellPhone))
 		if err != nil {
-			getErrorResponse(c, http.StatusUnauthorized, service.ErrUserNotLogin)
+			abortWithError(c, service.ErrUserNotLogin)
 			return
 		}
-		customer, err := model.CustomerDaoInstance().GetCustomerByCellphone(string(decriptBytes))
+		customer, err := service.CustomerServiceInstance().GetCustomerByCellphone(string(decriptBytes))
 		if err != nil {
-			getErrorResponse(c, http.StatusUnauthorized, service.ErrUserNotLogin)
+			abortWithError(c, service.ErrUserNotLogin)
 			return
 		}
 		c.Set("customer", customer)
@@ -35,22 +40,16 @@ func CustomersInfoMiddleware() gin.HandlerFunc {
 	}
 }
 
-//OperatorInfoMiddleware 操作员信息解析
+//OperatorInfoMiddleware 操作员信息解析，支持 cookie 和 Authorization: Bearer 两种方式
 func OperatorInfoMiddleware() gin.HandlerFunc {
 	return func(c *gin.Context) {
-		operatorID, err := c.Cookie("operator_id")
-		if err != nil || operatorID == "" {
-			getErrorResponse(c, http.StatusUnauthorized, service.ErrUserNotLogin)
-			return
-		}
-		decriptBytes, err := util.AESDecrypt([]byte(operatorID))
+		operator, err := requestOperator(c)
 		if err != nil {
-			getErrorResponse(c, http.StatusUnauthorized, service.ErrUserNotLogin)
+			abortWithError(c, service.ErrUserNotLogin)
 			return
 		}
-		operator, err := model.GetOperatorByCellphone(string(decriptBytes))
-		if err != nil {
-			getErrorResponse(c, http.StatusUnauthorized, service.ErrUserNotLogin)
+		if operator.Disabled {
+			abortWithError(c, service.ErrOperatorDisabled)
 			return
 		}
 		c.Set("op", operator)
@@ -59,25 +58,63 @@ func OperatorInfoMiddleware() gin.HandlerFunc {
 	}
 }
 
-func getErrorResponse(c *gin.Context, httpStatus int, err *service.Error) {
-	c.JSON(httpStatus, gin.H{
-		"code": httpStatus,
-		"data": 0,
-		"msg":  err.Message(),
-	})
-	c.Abort()
+// requestOperator 根据 bearer token 或登录 cookie 获取操作员，bearer token 为服务端保存的会话，用于移动端
+func requestOperator(c *gin.Context) (*model.KroOperator, error) {
+	if token, ok := bearerToken(c); ok {
+		return service.OperatorServiceInstance().GetSessionOperator(token)
+	}
+	operatorID, err := c.Cookie("operator_id")
+	if err != nil || operatorID == "" {
+		return nil, service.ErrUserNotLogin
+	}
+	decriptBytes, err := util.AESDecrypt([]byte(operatorID))
+	if err != nil {
+		return nil, service.ErrUserNotLogin
+	}
+	return service.OperatorServiceInstance().GetOperatorByCellphone(string(decriptBytes))
+}
+
+// bearerToken 读取 Authorization: Bearer 请求头中的 token
+func bearerToken(c *gin.Context) (string, bool) {
+	auth := c.GetHeader("Authorization")
+	if !strings.HasPrefix(auth, "Bearer ") {
+		return "", false
+	}
+	return strings.TrimPrefix(auth, "Bearer "), true
+}
+
+//AdminMiddleware 管理员权限校验，需在 OperatorInfoMiddleware 之后使用
+func AdminMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		operator, err := OperatorInfo(c)
+		if err != nil {
+			abortWithError(c, service.ErrUserNotLogin)
+			return
+		}
+		if !service.OperatorServiceInstance().IsAdmin(operator) {
+			abortWithError(c, service.ErrForbidden)
+			return
+		}
+		c.Next()
+	}
+}
+
+// setCookie 按配置的域名和 secure 设置 cookie
+func setCookie(c *gin.Context, name, value string, maxAge int, httpOnly bool) {
+	cookie := config.ConfigInstance.Cookie
+	c.SetCookie(name, value, maxAge, "/", cookie.Domain, cookie.Secure, httpOnly)
 }
 
 // CustomerInfo 从请求中获取 customer 信息
 func CustomerInfo(c *gin.Context) (*model.KroCustomer, error) {
 	op, ok := c.Get("customer")
 	if !ok {
-		return nil, service.ErrUnauthorized
+		return nil, service.ErrUserNotLogin
 	}
 
 	customer, ok := op.(*model.KroCustomer)
 	if !ok {
-		return nil, service.ErrUnauthorized
+		return nil, service.ErrUserNotLogin
 	}
 	return customer, nil
 }
@@ -86,12 +123,46 @@ func CustomerInfo(c *gin.Context) (*model.KroCustomer, error) {
 func OperatorInfo(c *gin.Context) (*model.KroOperator, error) {
 	op, ok := c.Get("op")
 	if !ok {
-		return nil, service.ErrUnauthorized
+		return nil, service.ErrUserNotLogin
 	}
 
 	operator, ok := op.(*model.KroOperator)
 	if !ok {
-		return nil, service.ErrUnauthorized
+		return nil, service.ErrUserNotLogin
 	}
 	return operator, nil
 }
+
+// RequestIDMiddleware 使用上游传入的或新生成的请求 ID，写入 request 的 context 和响应 header
+func RequestIDMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id := c.GetHeader(util.RequestIDHeader)
+		if !util.ValidRequestID(id) {
+			id = util.NewRequestID()
+		}
+		c.Request = c.Request.WithContext(util.WithRequestID(c.Request.Context(), id))
+		c.Header(util.RequestIDHeader, id)
+		c.Next()
+	}
+}
+
+// AccessLogMiddleware 每个请求输出一行 key=value 格式的访问日志，手机号等敏感参数已隐藏
+func AccessLogMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		c.Next()
+		code := ""
+		if v, ok := c.Get(metrics.ErrorCodeKey); ok {
+			code = strconv.Itoa(v.(int))
+		}
+		actor := "-"
+		if operator, err := OperatorInfo(c); err == nil {
+			actor = "operator:" + util.MaskPhone(operator.Cellphone)
+		} else if customer, err := CustomerInfo(c); err == nil {
+			actor = "customer:" + util.MaskPhone(customer.Cellphone)
+		}
+		logs.CtxInfo(c.Request.Context(), "access method=%s path=%s query=%q status=%d code=%s latency_ms=%d ip=%s actor=%s",
+			c.Request.Method, util.Redact(c.Request.URL.Path), util.RedactValues(c.Request.URL.Query()), c.Writer.Status(), code,
+			time.Since(start).Nanoseconds()/int64(time.Millisecond), c.ClientIP(), actor)
+	}
+}
@@ -0,0 +1,356 @@
+package handler
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"code.bean.com/flamingo/service"
+)
+
+// openAPIVersion 接口文档的版本，接口有不兼容的修改时更新
+const openAPIVersion = "1.0.0"
+
+var (
+	openAPIOnce sync.Once
+	openAPIDoc  []byte
+)
+
+// OpenAPIDocument 根据 apiSpecs、请求结构体的 validate tag 和错误目录生成 OpenAPI 3 文档
+func OpenAPIDocument() []byte {
+	openAPIOnce.Do(func() {
+		doc, err := json.Marshal(newOpenAPIBuilder().build())
+		if err != nil {
+			panic("marshal openapi document error: " + err.Error())
+		}
+		openAPIDoc = doc
+	})
+	return openAPIDoc
+}
+
+type jsonObject map[string]interface{}
+
+type openAPIBuilder struct {
+	schemas jsonObject
+}
+
+func newOpenAPIBuilder() *openAPIBuilder {
+	return &openAPIBuilder{schemas: jsonObject{}}
+}
+
+func (b *openAPIBuilder) build() jsonObject {
+	paths := jsonObject{}
+	for _, spec := range apiSpecs {
+		path := openAPIPath(spec.Path)
+		item, ok := paths[path].(jsonObject)
+		if !ok {
+			item = jsonObject{}
+			paths[path] = item
+		}
+		item[strings.ToLower(spec.Method)] = b.operation(spec)
+	}
+	b.schemas["FieldError"] = b.structSchema(reflect.TypeOf(FieldError{}))
+	b.schemas["Error"] = jsonObject{
+		"type":     "object",
+		"required": []string{"code", "msg"},
+		"properties": jsonObject{
+			"code":       jsonObject{"type": "integer", "description": "错误码，见 x-error-codes"},
+			"msg":        jsonObject{"type": "string", "description": "按 Accept-Language 返回中文或英文错误信息"},
+			"data":       jsonObject{"nullable": true},
+			"request_id": jsonObject{"type": "string", "description": "请求 ID，与响应头 X-Request-ID 相同"},
+			"errors":     jsonObject{"type": "array", "items": schemaRef("FieldError"), "description": "参数校验失败时每个字段的错误"},
+		},
+	}
+	tags := make([]jsonObject, 0)
+	seen := make(map[string]bool)
+	for _, spec := range apiSpecs {
+		if !seen[spec.Tag] {
+			seen[spec.Tag] = true
+			tags = append(tags, jsonObject{"name": spec.Tag})
+		}
+	}
+	return jsonObject{
+		"openapi": "3.0.3",
+		"info": jsonObject{
+			"title":       "flamingo 会员系统接口",
+			"version":     openAPIVersion,
+			"description": openAPIDescription(),
+		},
+		"tags":  tags,
+		"paths": paths,
+		"components": jsonObject{
+			"schemas": b.schemas,
+			"responses": jsonObject{
+				"Error": jsonObject{
+					"description": "错误，http 状态码和错误码见 x-error-codes",
+					"content":     jsonObject{"application/json": jsonObject{"schema": schemaRef("Error")}},
+				},
+			},
+			"securitySchemes": jsonObject{
+				"operatorCookie": jsonObject{"type": "apiKey", "in": "cookie", "name": "operator_id"},
+				"operatorBearer": jsonObject{"type": "http", "scheme": "bearer", "description": "POST /api/v1/sessions 返回的 token"},
+				"customerCookie": jsonObject{"type": "apiKey", "in": "cookie", "name": "customer_id"},
+			},
+		},
+		"x-error-codes": errorCodes(),
+	}
+}
+
+func (b *openAPIBuilder) operation(spec *apiSpec) jsonObject {
+	op := jsonObject{
+		"tags":        []string{spec.Tag},
+		"summary":     spec.Summary,
+		"operationId": operationID(spec),
+	}
+	status := spec.Status
+	if status == 0 {
+		status = http.StatusOK
+	}
+	responses := jsonObject{}
+	if spec.RawResponse != "" {
+		responses[strconv.Itoa(status)] = jsonObject{"description": spec.RawResponse}
+	} else {
+		data := jsonObject{"nullable": true}
+		if spec.Response != nil {
+			data = b.schema(reflect.TypeOf(spec.Response))
+		}
+		responses[strconv.Itoa(status)] = jsonObject{
+			"description": "成功，code 为 0",
+			"content": jsonObject{"application/json": jsonObject{"schema": jsonObject{
+				"type":     "object",
+				"required": []string{"code", "msg", "data"},
+				"properties": jsonObject{
+					"code": jsonObject{"type": "integer", "enum": []int{service.OK}},
+					"msg":  jsonObject{"type": "string"},
+					"data": data,
+				},
+			}}},
+		}
+		responses["default"] = jsonObject{"$ref": "#/components/responses/Error"}
+	}
+	op["responses"] = responses
+
+	switch spec.Auth {
+	case authOperator, authAdmin:
+		op["security"] = []jsonObject{{"operatorCookie": []string{}}, {"operatorBearer": []string{}}}
+		if spec.Auth == authAdmin {
+			op["description"] = "需要管理员权限"
+		}
+	case authCustomer:
+		op["security"] = []jsonObject{{"customerCookie": []string{}}}
+	}
+
+	params, body := b.requestParams(spec)
+	if len(params) > 0 {
+		op["parameters"] = params
+	}
+	if spec.Upload != "" {
+		if body == nil {
+			body = jsonObject{"type": "object", "properties": jsonObject{}}
+		}
+		body["properties"].(jsonObject)[spec.Upload] = jsonObject{"type": "string", "format": "binary"}
+		body["required"] = append(stringSlice(body["required"]), spec.Upload)
+		op["requestBody"] = jsonObject{"required": true, "content": jsonObject{"multipart/form-data": jsonObject{"schema": body}}}
+	} else if body != nil {
+		content := jsonObject{"application/json": jsonObject{"schema": body}}
+		// 旧接口通过 bindRequest 解析，同时支持表单和 json
+		if !strings.HasPrefix(spec.Path, apiV1Prefix) {
+			content["application/x-www-form-urlencoded"] = jsonObject{"schema": body}
+		}
+		op["requestBody"] = jsonObject{"required": true, "content": content}
+	}
+	return op
+}
+
+// requestParams 路径参数按名字匹配请求结构体的字段，GET 和 DELETE 的其余字段作为 query 参数，其他方法作为请求体
+func (b *openAPIBuilder) requestParams(spec *apiSpec) ([]jsonObject, jsonObject) {
+	pathNames := make(map[string]bool)
+	for _, segment := range strings.Split(spec.Path, "/") {
+		if strings.HasPrefix(segment, ":") {
+			pathNames[segment[1:]] = true
+		}
+	}
+	var params []jsonObject
+	var body jsonObject
+	bodyProps, bodyRequired := jsonObject{}, []string{}
+	if spec.Request != nil {
+		t := reflect.TypeOf(spec.Request)
+		for i := 0; i < t.NumField(); i++ {
+			sf := t.Field(i)
+			name := FieldName(sf)
+			schema, required := b.fieldSchema(sf)
+			switch {
+			case pathNames[name]:
+				delete(pathNames, name)
+				params = append(params, jsonObject{"name": name, "in": "path", "required": true, "schema": schema})
+			case spec.Method == http.MethodGet || spec.Method == http.MethodDelete:
+				params = append(params, jsonObject{"name": name, "in": "query", "required": required, "schema": schema})
+			default:
+				bodyProps[name] = schema
+				if required {
+					bodyRequired = append(bodyRequired, name)
+				}
+			}
+		}
+		if len(bodyProps) > 0 {
+			body = jsonObject{"type": "object", "properties": bodyProps}
+			if len(bodyRequired) > 0 {
+				body["required"] = bodyRequired
+			}
+		}
+	}
+	names := make([]string, 0, len(pathNames))
+	for name := range pathNames {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		params = append(params, jsonObject{"name": name, "in": "path", "required": true, "schema": jsonObject{"type": "string"}})
+	}
+	return params, body
+}
+
+// fieldSchema 请求字段的 schema，由 validate 和 desc tag 生成
+func (b *openAPIBuilder) fieldSchema(sf reflect.StructField) (jsonObject, bool) {
+	schema := b.schema(sf.Type)
+	description := sf.Tag.Get("desc")
+	required := false
+	for _, rule := range ParseRules(sf.Tag.Get(validateTag)) {
+		switch rule.Name {
+		case "required":
+			required = true
+		case "phone":
+			schema["format"] = "phone"
+		case "enum":
+			schema["enum"] = strings.Split(rule.Param, "|")
+		case "minlen":
+			schema["minLength"], _ = strconv.Atoi(rule.Param)
+		case "maxlen":
+			schema["maxLength"], _ = strconv.Atoi(rule.Param)
+		case "amount", "decimal":
+			schema["pattern"] = amountRegexp.String()
+		case "min":
+			// 金额等数值以字符串传递，范围写在说明里
+			description += "，最小值 " + rule.Param
+		case "max":
+			description += "，最大值 " + rule.Param
+		case "date":
+			schema["format"] = "date"
+		case "email":
+			schema["format"] = "email"
+		}
+	}
+	if description != "" {
+		schema["description"] = description
+	}
+	return schema, required
+}
+
+// schema 返回类型的 schema，结构体登记到 components.schemas 中并返回引用
+func (b *openAPIBuilder) schema(t reflect.Type) jsonObject {
+	if t == reflect.TypeOf(json.RawMessage{}) {
+		return jsonObject{"type": "object", "description": "原样返回的 json"}
+	}
+	switch t.Kind() {
+	case reflect.Ptr:
+		return b.schema(t.Elem())
+	case reflect.String:
+		return jsonObject{"type": "string"}
+	case reflect.Bool:
+		return jsonObject{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return jsonObject{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return jsonObject{"type": "number"}
+	case reflect.Slice, reflect.Array:
+		return jsonObject{"type": "array", "items": b.schema(t.Elem())}
+	case reflect.Map:
+		return jsonObject{"type": "object", "additionalProperties": b.schema(t.Elem())}
+	case reflect.Struct:
+		name := t.Name()
+		if _, ok := b.schemas[name]; !ok {
+			// 先占位，避免递归引用时死循环
+			b.schemas[name] = jsonObject{}
+			b.schemas[name] = b.structSchema(t)
+		}
+		return schemaRef(name)
+	}
+	return jsonObject{}
+}
+
+func (b *openAPIBuilder) structSchema(t reflect.Type) jsonObject {
+	props := jsonObject{}
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		name := strings.Split(sf.Tag.Get("json"), ",")[0]
+		if name == "-" || sf.PkgPath != "" {
+			continue
+		}
+		if name == "" {
+			name = sf.Name
+		}
+		props[name] = b.schema(sf.Type)
+	}
+	return jsonObject{"type": "object", "properties": props}
+}
+
+func stringSlice(v interface{}) []string {
+	s, _ := v.([]string)
+	return s
+}
+
+func schemaRef(name string) jsonObject {
+	return jsonObject{"$ref": "#/components/schemas/" + name}
+}
+
+// openAPIPath 将 gin 的路径参数 :name 转换为 {name}
+func openAPIPath(path string) string {
+	segments := strings.Split(path, "/")
+	for i, segment := range segments {
+		if strings.HasPrefix(segment, ":") {
+			segments[i] = "{" + segment[1:] + "}"
+		}
+	}
+	return strings.Join(segments, "/")
+}
+
+// operationID 由方法和路径生成，如 post_operator_add_customer
+func operationID(spec *apiSpec) string {
+	replacer := strings.NewReplacer("/", "_", ":", "", ".", "_")
+	return strings.ToLower(spec.Method) + replacer.Replace(spec.Path)
+}
+
+func errorCodes() []jsonObject {
+	codes := make([]jsonObject, 0)
+	for _, e := range service.Errors() {
+		codes = append(codes, jsonObject{
+			"code":       e.Code,
+			"status":     e.HTTPStatus(),
+			"message":    e.Localize(service.LangZhCN),
+			"message_en": e.Localize(service.LangEn),
+		})
+	}
+	return codes
+}
+
+// openAPIDescription 文档首页的说明，包含错误码表
+func openAPIDescription() string {
+	lines := []string{
+		"所有 json 接口返回 `{code, msg, data}`，code 为 0 表示成功。",
+		"失败时返回错误码对应的 http 状态码，错误信息按 Accept-Language 返回中文或英文。",
+		"`/cu`、`/operator`、`/admin` 下的接口同时支持表单和 json 请求体，`/api/v1` 下的接口只接受 json。",
+		"",
+		"| 错误码 | HTTP 状态码 | 说明 |",
+		"| --- | --- | --- |",
+	}
+	for _, e := range service.Errors() {
+		lines = append(lines, fmt.Sprintf("| %d | %d | %s |", e.Code, e.HTTPStatus(), e.Message()))
+	}
+	return strings.Join(lines, "\n")
+}
@@ -0,0 +1,52 @@
+package handler
+
+import (
+	"encoding/json"
+	"sort"
+	"testing"
+)
+
+// TestAPISpecsCoverRoutes 每个注册的路由都要在 apiSpecs 中写文档，文档中也不能有已删除的路由
+func TestAPISpecsCoverRoutes(t *testing.T) {
+	routes := make(map[string]bool)
+	for _, r := range testRouter.Routes() {
+		routes[r.Method+" "+r.Path] = true
+	}
+	documented := make(map[string]bool, len(apiSpecs))
+	for _, spec := range apiSpecs {
+		documented[spec.Method+" "+spec.Path] = true
+	}
+
+	var missing, stale []string
+	for key := range routes {
+		if !documented[key] {
+			missing = append(missing, key)
+		}
+	}
+	for key := range documented {
+		if !routes[key] {
+			stale = append(stale, key)
+		}
+	}
+	sort.Strings(missing)
+	sort.Strings(stale)
+	if len(missing) > 0 {
+		t.Errorf("routes without api spec, add them to handler/api_spec.go: %v", missing)
+	}
+	if len(stale) > 0 {
+		t.Errorf("api specs without route: %v", stale)
+	}
+}
+
+func TestOpenAPIDocument(t *testing.T) {
+	var doc struct {
+		OpenAPI string                     `json:"openapi"`
+		Paths   map[string]json.RawMessage `json:"paths"`
+	}
+	if err := json.Unmarshal(OpenAPIDocument(), &doc); err != nil {
+		t.Fatalf("invalid openapi document: %v", err)
+	}
+	if doc.OpenAPI == "" || len(doc.Paths) == 0 {
+		t.Errorf("openapi document without version or paths: openapi=%q, paths=%d", doc.OpenAPI, len(doc.Paths))
+	}
+}
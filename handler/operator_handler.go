@@ -2,6 +2,7 @@ package handler
 
 import (
 	"code.bean.com/flamingo/config"
+	"code.bean.com/flamingo/handler/view"
 	"code.bean.com/flamingo/service"
 	"code.bean.com/flamingo/util"
 	"code.byted.org/gopkg/logs"
@@ -20,22 +21,30 @@ func (handler *OperatorHandler) Register(e *gin.Engine) {
 	group.POST("/info", OperatorInfoMiddleware(), JSONWrapper(handler.OperatorInfo))
 	group.POST("/add_customer", OperatorInfoMiddleware(), JSONWrapper(handler.AddNewCustomer))
 	group.POST("/query_customer", OperatorInfoMiddleware(), JSONWrapper(handler.GetCustomerInfo))
+	group.POST("/search_customers", OperatorInfoMiddleware(), JSONWrapper(handler.SearchCustomers))
+	group.POST("/update_customer", OperatorInfoMiddleware(), JSONWrapper(handler.UpdateCustomer))
+	group.POST("/customer_changes", OperatorInfoMiddleware(), JSONWrapper(handler.GetCustomerChanges))
 	group.POST("/operate_customer", OperatorInfoMiddleware(), JSONWrapper(handler.OperateCustomer))
+	group.POST("/pay_code", OperatorInfoMiddleware(), JSONWrapper(handler.SendPayCode))
+	group.POST("/scan_pay_token", OperatorInfoMiddleware(), JSONWrapper(handler.ScanPaymentToken))
+	group.POST("/settle_report", OperatorInfoMiddleware(), JSONWrapper(handler.SettleReport))
+	group.POST("/sms_status", OperatorInfoMiddleware(), JSONWrapper(handler.GetSmsStatus))
 }
 
 func (handler *OperatorHandler) Login(c *gin.Context) (interface{}, error) {
-	phone := c.PostForm("cell")
-	pwd := c.PostForm("pwd")
-	if phone == "" || pwd == "" {
-		return nil, service.NewError(401, "缺少必要参数")
+	var req view.OperatorLoginReq
+	if err := bindRequest(c, &req); err != nil {
+		return nil, err
+	}
+	operator, err := service.OperatorServiceInstance().OperatorLogin(req.Cell, req.Pwd)
+	if err == service.ErrOperatorDisabled {
+		return nil, err
 	}
-	operator, err := service.OperatorServiceInstance().OperatorLogin(phone, pwd)
 	if err != nil {
-		return nil, service.NewError(402, "密码错误")
+		return nil, service.ErrWrongPassword
 	}
 	enbytes, _ := util.AESEncrypt([]byte(operator.Cellphone))
-	host, _ := config.ConfigJson.Get("host").String()
-	c.SetCookie("operator_id", string(enbytes), 3600*24, "/", host, false, false)
+	setCookie(c, "operator_id", string(enbytes), config.ConfigInstance.Cookie.MaxAge, false)
 	return "success", nil
 }
 
@@ -49,17 +58,78 @@ func (handlers *OperatorHandler) OperatorInfo(c *gin.Context) (interface{}, erro
 }
 
 func (handler *OperatorHandler) GetCustomerInfo(c *gin.Context) (interface{}, error) {
-	phone := c.PostForm("cell")
-	if phone == "" {
-		return nil, service.NewError(401, "缺少必要参数")
+	var req view.CellReq
+	if err := bindRequest(c, &req); err != nil {
+		return nil, err
 	}
-	return service.CustomerServiceInstance().GetCustomerDetailInfo(phone)
+	return service.CustomerServiceInstance().GetCustomerDetailInfo(req.Cell)
 }
 
 func (handler *OperatorHandler) AddNewCustomer(c *gin.Context) (interface{}, error) {
-	phone := c.PostForm("cell")
-	name := c.PostForm("name")
-	return service.CustomerServiceInstance().CreateCustomer(phone, name)
+	var req view.AddCustomerReq
+	if err := bindRequest(c, &req); err != nil {
+		return nil, err
+	}
+	return service.CustomerServiceInstance().CreateCustomer(req.Cell, req.Name)
+}
+
+// SearchCustomers 按手机号、卡号、姓名或标签搜索会员，支持余额和最近消费时间的筛选
+func (handler *OperatorHandler) SearchCustomers(c *gin.Context) (interface{}, error) {
+	var req view.SearchCustomersReq
+	if err := bindRequest(c, &req); err != nil {
+		return nil, err
+	}
+	return service.CustomerServiceInstance().SearchCustomers(customerFilter(&req))
+}
+
+// customerFilter 将搜索请求转换为查询条件，默认按降序排列
+func customerFilter(req *view.SearchCustomersReq) *service.CustomerFilter {
+	return &service.CustomerFilter{
+		Keyword:      req.Keyword,
+		Tag:          req.Tag,
+		MinBalance:   req.MinBalance,
+		MaxBalance:   req.MaxBalance,
+		InactiveDays: req.InactiveDays,
+		Sort:         req.Sort,
+		Desc:         req.Order != "asc",
+		Page:         req.Page,
+		PageSize:     req.PageSize,
+	}
+}
+
+// UpdateCustomer 修改会员资料，返回最新的会员详情
+func (handler *OperatorHandler) UpdateCustomer(c *gin.Context) (interface{}, error) {
+	op, err := OperatorInfo(c)
+	if err != nil {
+		logs.Error("invalid user,err=%+v", err)
+		return nil, err
+	}
+	var req view.UpdateCustomerReq
+	if err := bindRequest(c, &req); err != nil {
+		return nil, err
+	}
+	profile := &service.CustomerProfile{
+		Name:             req.Name,
+		Birthday:         req.Birthday,
+		Gender:           req.Gender,
+		Email:            req.Email,
+		Notes:            req.Notes,
+		Tags:             req.Tags,
+		MarketingConsent: req.MarketingConsent == "1",
+	}
+	if err := service.CustomerServiceInstance().UpdateProfile(req.Cell, profile, op); err != nil {
+		return nil, err
+	}
+	return service.CustomerServiceInstance().GetCustomerDetailInfo(req.Cell)
+}
+
+// GetCustomerChanges 查询会员资料的修改记录
+func (handler *OperatorHandler) GetCustomerChanges(c *gin.Context) (interface{}, error) {
+	var req view.CellReq
+	if err := bindRequest(c, &req); err != nil {
+		return nil, err
+	}
+	return service.CustomerServiceInstance().GetProfileChanges(req.Cell)
 }
 
 func (handler *OperatorHandler) OperateCustomer(c *gin.Context) (interface{}, error) {
@@ -68,12 +138,50 @@ func (handler *OperatorHandler) OperateCustomer(c *gin.Context) (interface{}, er
 		logs.Error("invalid user,err=%+v", err)
 		return nil, err
 	}
-	phone := c.PostForm("cell")
-	oper := c.PostForm("operate_type")
-	money := c.PostForm("amount")
-	desc := c.PostForm("desc")
-	if phone == "" || oper == "" || money == "" {
-		return nil, service.NewError(401, "缺少必要参数")
+	var req view.OperateCustomerReq
+	if err := bindRequest(c, &req); err != nil {
+		return nil, err
+	}
+	payment := &service.RechargePayment{
+		Method:   req.PayMethod,
+		Ref:      req.PayRef,
+		Tendered: req.Tendered,
+	}
+	return service.CustomerServiceInstance().AddCustomerAccount(req.Cell, req.OperateType, req.Amount, req.Desc, req.Code, payment, op)
+}
+
+func (handler *OperatorHandler) SendPayCode(c *gin.Context) (interface{}, error) {
+	var req view.SendPayCodeReq
+	if err := bindRequest(c, &req); err != nil {
+		return nil, err
+	}
+	err := service.CustomerServiceInstance().SendPayCode(c.Request.Context(), req.Cell, req.Amount)
+	if err != nil {
+		return nil, err
+	}
+	return "success", nil
+}
+
+func (handler *OperatorHandler) GetSmsStatus(c *gin.Context) (interface{}, error) {
+	var req view.CellReq
+	if err := bindRequest(c, &req); err != nil {
+		return nil, err
+	}
+	return service.SmsServiceInstance().GetPhoneSmsRecords(req.Cell)
+}
+
+func (handler *OperatorHandler) ScanPaymentToken(c *gin.Context) (interface{}, error) {
+	var req view.PaymentTokenReq
+	if err := bindRequest(c, &req); err != nil {
+		return nil, err
+	}
+	return service.CustomerServiceInstance().ExchangePaymentToken(req.Token)
+}
+
+func (handler *OperatorHandler) SettleReport(c *gin.Context) (interface{}, error) {
+	var req view.SettleReportReq
+	if err := bindRequest(c, &req); err != nil {
+		return nil, err
 	}
-	return service.CustomerServiceInstance().AddCustomerAccount(phone, oper, money, desc, op)
+	return service.ReportServiceInstance().SettleReport(req.Date)
 }
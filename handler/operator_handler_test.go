@@ -0,0 +1,96 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"code.bean.com/flamingo/handler/view"
+	"code.bean.com/flamingo/service"
+	"code.byted.org/gopkg/logs"
+	"github.com/gin-gonic/gin"
+)
+
+// searchCells 查询会员列表，返回总数和当前页的手机号
+func searchCells(t *testing.T, cookies []*http.Cookie, form url.Values) (int, []string) {
+	_, resp := postForm(t, "/operator/search_customers", form, cookies)
+	var list view.CustomerList
+	if resp.Code != service.OK || json.Unmarshal(resp.Data, &list) != nil {
+		t.Fatalf("search %v: code=%d, msg=%s", form, resp.Code, resp.Msg)
+	}
+	cells := make([]string, 0, len(list.Customers))
+	for _, customer := range list.Customers {
+		cells = append(cells, customer.CustomerCellphone)
+	}
+	return list.Total, cells
+}
+
+func TestSearchCustomers(t *testing.T) {
+	cookies := operatorLogin(t)
+	for _, c := range []struct{ cell, name, tags string }{
+		{"13700000101", "卫一", "vip,老客"},
+		{"13700000102", "卫二", "vip"},
+		{"13700000103", "卫三", "vipx"},
+	} {
+		addCustomer(t, cookies, c.cell, c.name, "")
+		form := url.Values{"cell": {c.cell}, "name": {c.name}, "tags": {c.tags}}
+		if _, resp := postForm(t, "/operator/update_customer", form, cookies); resp.Code != service.OK {
+			t.Fatalf("update customer %s: code=%d, msg=%s", c.cell, resp.Code, resp.Msg)
+		}
+	}
+
+	cases := []struct {
+		form  url.Values
+		total int
+		cells []string
+	}{
+		{url.Values{"q": {"137000001"}}, 3, []string{"13700000103", "13700000102", "13700000101"}},
+		{url.Values{"q": {"卫二"}}, 1, []string{"13700000102"}},
+		{url.Values{"q": {"137000001"}, "tag": {"vip"}}, 2, []string{"13700000102", "13700000101"}},
+		{url.Values{"q": {"137000001"}, "tag": {"老客"}}, 1, []string{"13700000101"}},
+		{url.Values{"q": {"137000001"}, "page": {"2"}, "page_size": {"2"}}, 3, []string{"13700000101"}},
+		{url.Values{"q": {"137000001"}, "order": {"asc"}, "page_size": {"1"}}, 3, []string{"13700000101"}},
+		{url.Values{"q": {"137000001"}, "page": {"3"}, "page_size": {"2"}}, 3, []string{}},
+		// 通配符按普通字符匹配
+		{url.Values{"q": {"1370000010_"}}, 0, []string{}},
+		{url.Values{"q": {"%"}}, 0, []string{}},
+		{url.Values{"q": {"137000001"}, "tag": {"vip%"}}, 0, []string{}},
+	}
+	for _, c := range cases {
+		total, cells := searchCells(t, cookies, c.form)
+		if total != c.total || strings.Join(cells, ",") != strings.Join(c.cells, ",") {
+			t.Errorf("search %v: total=%d, cells=%v, want %d and %v", c.form, total, cells, c.total, c.cells)
+		}
+	}
+}
+
+// TestLogRedaction 访问日志和错误日志中不能出现完整的手机号、密码和 token
+func TestLogRedaction(t *testing.T) {
+	router := gin.New()
+	router.Use(AccessLogMiddleware())
+	RegisterHandler(router)
+
+	const cell, pwd, token = "13712345678", "pwd-6a1f", "token-9c2e"
+	form := url.Values{"cell": {cell}, "pwd": {pwd}}
+	req := httptest.NewRequest(http.MethodPost, "/operator/login?token="+token+"&phone="+cell, strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	if rec.Code == http.StatusOK {
+		t.Fatalf("login with unknown operator: status=%d", rec.Code)
+	}
+
+	logs.Flush()
+	output := testLogs.String()
+	if !strings.Contains(output, "path=/operator/login") || !strings.Contains(output, "137****5678") {
+		t.Fatalf("access log not captured: %s", output)
+	}
+	for _, secret := range []string{cell, pwd, token} {
+		if strings.Contains(output, secret) {
+			t.Errorf("log contains %q", secret)
+		}
+	}
+}
@@ -0,0 +1,45 @@
+package handler
+
+import (
+	"net/http"
+
+	"code.bean.com/flamingo/config"
+	"code.bean.com/flamingo/handler/view"
+	"code.bean.com/flamingo/service"
+	"github.com/gin-gonic/gin"
+)
+
+// PayHandler 支付渠道回调api
+type PayHandler struct{}
+
+// NewPayHandler 实例化
+func NewPayHandler() *PayHandler {
+	return &PayHandler{}
+}
+
+// Register 注册api
+func (handler *PayHandler) Register(e *gin.Engine) {
+	group := e.Group("/pay")
+	group.POST("/notify/:gateway", handler.Notify)
+	if config.ConfigInstance.Pay.FakeEnabled {
+		group.POST("/fake_complete", JSONWrapper(handler.FakeComplete))
+	}
+}
+
+// Notify 支付结果异步通知
+func (handler *PayHandler) Notify(c *gin.Context) {
+	contentType, body := service.PayOrderServiceInstance().HandleNotify(c.Param("gateway"), c.Request)
+	c.Data(http.StatusOK, contentType, []byte(body))
+}
+
+// FakeComplete 模拟支付完成，仅在配置了 pay.fake_enabled 时注册
+func (handler *PayHandler) FakeComplete(c *gin.Context) (interface{}, error) {
+	var req view.OrderNoReq
+	if err := bindRequest(c, &req); err != nil {
+		return nil, err
+	}
+	if err := service.PayOrderServiceInstance().FakeCompletePayOrder(req.OrderNo); err != nil {
+		return nil, err
+	}
+	return "success", nil
+}
@@ -0,0 +1,44 @@
+package handler
+
+import (
+	"crypto/subtle"
+	"net/http"
+
+	"code.bean.com/flamingo/config"
+	"code.bean.com/flamingo/service"
+	"code.byted.org/gopkg/logs"
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+)
+
+// SmsHandler 短信服务商回调api
+type SmsHandler struct{}
+
+// NewSmsHandler 实例化
+func NewSmsHandler() *SmsHandler {
+	return &SmsHandler{}
+}
+
+// Register 注册api
+func (handler *SmsHandler) Register(e *gin.Engine) {
+	group := e.Group("/sms")
+	group.POST("/report", handler.Report)
+}
+
+// Report 短信送达状态回调，服务商的回调不带签名，通过回调地址中的 token 校验来源
+func (handler *SmsHandler) Report(c *gin.Context) {
+	token := config.ConfigInstance.Sms.ReportToken
+	if token == "" || subtle.ConstantTimeCompare([]byte(c.Query("token")), []byte(token)) != 1 {
+		logs.Error("reject sms report with invalid token, ip=%s", c.ClientIP())
+		c.JSON(http.StatusForbidden, gin.H{"result": 1, "errmsg": "invalid token"})
+		return
+	}
+	reports := make([]*service.SmsReport, 0)
+	if err := c.ShouldBindWith(&reports, binding.JSON); err != nil {
+		logs.Error("bind sms report error, err=%+v", err)
+		c.JSON(http.StatusOK, gin.H{"result": 1, "errmsg": "invalid report"})
+		return
+	}
+	service.SmsServiceInstance().HandleReports(reports)
+	c.JSON(http.StatusOK, gin.H{"result": 0, "errmsg": "OK"})
+}
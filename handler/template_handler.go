@@ -41,6 +41,9 @@ func (handler *TemplateHandler) Register(e *gin.Engine) {
 	group.GET("/customer_home", func(c *gin.Context) {
 		c.HTML(http.StatusOK, "customer_person.html", gin.H{})
 	})
+	group.GET("/charge_code", func(c *gin.Context) {
+		c.HTML(http.StatusOK, "charge-code.html", gin.H{})
+	})
 	group.GET("/customer_login", func(c *gin.Context) {
 		c.HTML(http.StatusOK, "login_user.html", gin.H{})
 	})
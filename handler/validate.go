@@ -0,0 +1,254 @@
+package handler
+
+import (
+	"fmt"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"code.bean.com/flamingo/service"
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+)
+
+// 请求参数的校验规则，写在请求结构体字段的 validate tag 中，多个规则用逗号分隔
+// 除 required 外，字段为空时不校验
+//
+//	required      必填
+//	phone         手机号
+//	enum=A|B      取值只能是列出的值之一
+//	minlen/maxlen 字符串长度(字符数)
+//	amount        金额，正数且最多两位小数
+//	decimal       非负金额，可以为 0，用于筛选条件
+//	min/max       数值或金额的取值范围
+//	date          日期，格式为 2006-01-02
+//	email         邮箱地址
+const validateTag = "validate"
+
+var emailRegexp = regexp.MustCompile(`^[^@\s]+@[^@\s]+\.[^@\s]+$`)
+
+// Rule 一条校验规则
+type Rule struct {
+	Name  string
+	Param string
+}
+
+// FieldError 单个字段的校验错误
+type FieldError struct {
+	Field string `json:"field"`
+	Rule  string `json:"rule"`
+	Param string `json:"param,omitempty"`
+	Msg   string `json:"msg"`
+}
+
+// ValidationError 请求参数校验失败，包含所有不合法的字段
+type ValidationError struct {
+	Fields []*FieldError
+}
+
+func (e *ValidationError) Error() string {
+	names := make([]string, 0, len(e.Fields))
+	for _, f := range e.Fields {
+		names = append(names, f.Field+":"+f.Rule)
+	}
+	return "invalid params: " + strings.Join(names, ", ")
+}
+
+// catalogError 全部为缺少必填参数时返回 ErrMissParam，否则返回 ErrInvalidParam
+func (e *ValidationError) catalogError() *service.Error {
+	for _, f := range e.Fields {
+		if f.Rule != "required" {
+			return service.ErrInvalidParam
+		}
+	}
+	return service.ErrMissParam
+}
+
+// localize 生成指定语言的字段错误信息
+func (e *ValidationError) localize(lang string) []*FieldError {
+	fields := make([]*FieldError, 0, len(e.Fields))
+	for _, f := range e.Fields {
+		copied := *f
+		copied.Msg = ruleMessage(lang, f.Field, f.Rule, f.Param)
+		fields = append(fields, &copied)
+	}
+	return fields
+}
+
+// bindRequest 根据 Content-Type 从表单或 json 中解析请求参数并校验
+func bindRequest(c *gin.Context, req interface{}) error {
+	if err := c.ShouldBindWith(req, binding.Default(c.Request.Method, c.ContentType())); err != nil {
+		return service.ErrInvalidParam.WithDetail(err.Error())
+	}
+	return validateRequest(req)
+}
+
+// validateRequest 按 validate tag 校验请求结构体，返回 *ValidationError
+func validateRequest(req interface{}) error {
+	v := reflect.Indirect(reflect.ValueOf(req))
+	t := v.Type()
+	var fields []*FieldError
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		rules := ParseRules(sf.Tag.Get(validateTag))
+		if len(rules) == 0 {
+			continue
+		}
+		field := v.Field(i)
+		for _, rule := range rules {
+			if !checkRule(rule, field) {
+				fields = append(fields, &FieldError{Field: FieldName(sf), Rule: rule.Name, Param: rule.Param})
+				break
+			}
+		}
+	}
+	if len(fields) > 0 {
+		return &ValidationError{Fields: fields}
+	}
+	return nil
+}
+
+// ParseRules 解析 validate tag
+func ParseRules(tag string) []*Rule {
+	var rules []*Rule
+	for _, part := range strings.Split(tag, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		rule := &Rule{Name: part}
+		if idx := strings.Index(part, "="); idx >= 0 {
+			rule.Name, rule.Param = part[:idx], part[idx+1:]
+		}
+		rules = append(rules, rule)
+	}
+	return rules
+}
+
+// FieldName 请求参数名，优先使用 form tag，路径参数使用 uri tag
+func FieldName(sf reflect.StructField) string {
+	for _, key := range []string{"form", "json", "uri"} {
+		if name := strings.Split(sf.Tag.Get(key), ",")[0]; name != "" && name != "-" {
+			return name
+		}
+	}
+	return sf.Name
+}
+
+func checkRule(rule *Rule, field reflect.Value) bool {
+	if rule.Name == "required" {
+		return !isZero(field)
+	}
+	if isZero(field) {
+		return true
+	}
+	switch rule.Name {
+	case "phone":
+		return !service.IsInvalidPhoneNo(field.String())
+	case "enum":
+		value := fmt.Sprint(field.Interface())
+		for _, option := range strings.Split(rule.Param, "|") {
+			if value == option {
+				return true
+			}
+		}
+		return false
+	case "minlen":
+		n, _ := strconv.Atoi(rule.Param)
+		return utf8.RuneCountInString(field.String()) >= n
+	case "maxlen":
+		n, _ := strconv.Atoi(rule.Param)
+		return utf8.RuneCountInString(field.String()) <= n
+	case "amount":
+		return isAmount(field.String())
+	case "decimal":
+		return amountRegexp.MatchString(field.String())
+	case "min", "max":
+		value, ok := numberValue(field)
+		if !ok {
+			return false
+		}
+		limit, _ := strconv.ParseFloat(rule.Param, 64)
+		if rule.Name == "min" {
+			return value >= limit
+		}
+		return value <= limit
+	case "date":
+		_, err := time.Parse("2006-01-02", field.String())
+		return err == nil
+	case "email":
+		return emailRegexp.MatchString(field.String())
+	default:
+		panic("unknown validate rule " + rule.Name)
+	}
+}
+
+func isZero(field reflect.Value) bool {
+	return reflect.DeepEqual(field.Interface(), reflect.Zero(field.Type()).Interface())
+}
+
+// amountRegexp 金额格式，最多 9 位整数和 2 位小数，与 service 中转换金额的格式一致
+var amountRegexp = regexp.MustCompile(`^[0-9]{1,9}(\.[0-9]{1,2})?$`)
+
+// isAmount 大于 0 且最多两位小数的金额
+func isAmount(s string) bool {
+	if !amountRegexp.MatchString(s) {
+		return false
+	}
+	value, _ := strconv.ParseFloat(s, 64)
+	return value > 0
+}
+
+func numberValue(field reflect.Value) (float64, bool) {
+	switch field.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(field.Int()), true
+	case reflect.Float32, reflect.Float64:
+		return field.Float(), true
+	case reflect.String:
+		value, err := strconv.ParseFloat(field.String(), 64)
+		return value, err == nil
+	}
+	return 0, false
+}
+
+// ruleMessages 规则的错误信息模板，%[1]s 为参数名，%[2]s 为规则参数
+var ruleMessages = map[string]map[string]string{
+	service.LangZhCN: {
+		"required": "%[1]s 不能为空",
+		"phone":    "%[1]s 不是有效的手机号",
+		"enum":     "%[1]s 只能是 %[2]s 之一",
+		"minlen":   "%[1]s 长度不能少于 %[2]s 个字符",
+		"maxlen":   "%[1]s 长度不能超过 %[2]s 个字符",
+		"amount":   "%[1]s 必须是大于 0 且最多两位小数的金额",
+		"decimal":  "%[1]s 必须是不小于 0 且最多两位小数的金额",
+		"min":      "%[1]s 不能小于 %[2]s",
+		"max":      "%[1]s 不能大于 %[2]s",
+		"date":     "%[1]s 必须是 YYYY-MM-DD 格式的日期",
+		"email":    "%[1]s 不是有效的邮箱地址",
+	},
+	service.LangEn: {
+		"required": "%[1]s is required",
+		"phone":    "%[1]s is not a valid phone number",
+		"enum":     "%[1]s must be one of %[2]s",
+		"minlen":   "%[1]s must be at least %[2]s characters",
+		"maxlen":   "%[1]s must be at most %[2]s characters",
+		"amount":   "%[1]s must be a positive amount with at most two decimals",
+		"decimal":  "%[1]s must be a non-negative amount with at most two decimals",
+		"min":      "%[1]s must not be less than %[2]s",
+		"max":      "%[1]s must not be greater than %[2]s",
+		"date":     "%[1]s must be a date in YYYY-MM-DD format",
+		"email":    "%[1]s is not a valid email address",
+	},
+}
+
+func ruleMessage(lang, field, rule, param string) string {
+	format, ok := ruleMessages[lang][rule]
+	if !ok {
+		format = ruleMessages[service.LangZhCN][rule]
+	}
+	return fmt.Sprintf(format, field, strings.Replace(param, "|", ", ", -1))
+}
@@ -0,0 +1,29 @@
+package handler
+
+import "testing"
+
+func TestIsAmount(t *testing.T) {
+	cases := map[string]bool{
+		"1":            true,
+		"0.01":         true,
+		"100.1":        true,
+		"999999999.99": true,
+		"0":            false,
+		"0.00":         false,
+		"-1":           false,
+		"1.001":        false,
+		"1.":           false,
+		".5":           false,
+		"1e30":         false,
+		"0x1p70":       false,
+		"Inf":          false,
+		"NaN":          false,
+		"1000000000":   false,
+		" 1":           false,
+	}
+	for s, want := range cases {
+		if got := isAmount(s); got != want {
+			t.Errorf("isAmount(%q)=%v, want %v", s, got, want)
+		}
+	}
+}
@@ -1,11 +1,20 @@
 package view
 
+// CustomersInfo 会员详情，notes 和 tags 只对操作员展示
 type CustomersInfo struct {
 	AccountsDetail     []*AccountInfo `json:"account_detail"`
 	CustomerCellphone  string         `json:"cellphone"`
 	CustomerName       string         `json:"customer_name"`
 	CustomerRestAmount string         `json:"rest_amount"`
 	CustomerOpenDate   string         `json:"open_date"`
+	NotifyOff          bool           `json:"notify_off"`
+	WxBound            bool           `json:"wx_bound"`
+	Birthday           string         `json:"birthday"`
+	Gender             string         `json:"gender"`
+	Email              string         `json:"email"`
+	Notes              string         `json:"notes,omitempty"`
+	Tags               []string       `json:"tags,omitempty"`
+	MarketingConsent   bool           `json:"marketing_consent"`
 }
 
 type AccountInfo struct {
@@ -13,4 +22,34 @@ type AccountInfo struct {
 	AccountType   string `json:"type"`
 	AccountAmount string `json:"amount"`
 	OperatorName  string `json:"operator"`
+	PayMethod     string `json:"pay_method"`
+}
+
+// CustomerChange 会员资料的一条修改记录
+type CustomerChange struct {
+	Field      string `json:"field"`
+	OldValue   string `json:"old_value"`
+	NewValue   string `json:"new_value"`
+	Source     string `json:"source"`
+	Operator   string `json:"operator"`
+	ChangeTime string `json:"change_time"`
+}
+
+// CustomerList 分页的会员列表
+type CustomerList struct {
+	Total     int                `json:"total"`
+	Page      int                `json:"page"`
+	PageSize  int                `json:"page_size"`
+	Customers []*CustomerSummary `json:"customers"`
+}
+
+// CustomerSummary 会员列表中的一个会员，last_visit 为最近一次消费时间，没有消费过时为空
+type CustomerSummary struct {
+	CustomerCellphone  string   `json:"cellphone"`
+	CustomerName       string   `json:"customer_name"`
+	CardNo             string   `json:"card_no"`
+	CustomerOpenDate   string   `json:"open_date"`
+	LastVisit          string   `json:"last_visit"`
+	CustomerRestAmount string   `json:"rest_amount"`
+	Tags               []string `json:"tags"`
 }
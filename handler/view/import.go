@@ -0,0 +1,19 @@
+package view
+
+// ImportReport 批量导入会员的结果，校验有错误时不写入任何数据，写入时失败的行也记录在 Errors 中
+type ImportReport struct {
+	DryRun       bool              `json:"dry_run"`
+	Total        int               `json:"total"`
+	Valid        int               `json:"valid"`
+	Imported     int               `json:"imported"`
+	OpeningTotal string            `json:"opening_total"`
+	Errors       []*ImportRowError `json:"errors"`
+}
+
+// ImportRowError 导入文件中一行数据的错误，Row 为文件中的行号，从 1 开始，表头为第 1 行
+type ImportRowError struct {
+	Row   int    `json:"row"`
+	Field string `json:"field"`
+	Value string `json:"value"`
+	Msg   string `json:"msg"`
+}
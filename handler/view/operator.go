@@ -4,3 +4,11 @@ type Operator struct {
 	Cellphone string `json:"cellphone"`
 	Name      string `json:"name"`
 }
+
+// Session 操作员登录会话，Token 用于 Authorization: Bearer 请求头，ExpireAt 之后失效
+type Session struct {
+	Token    string    `json:"token,omitempty"`
+	ExpireAt string    `json:"expire_at,omitempty"`
+	Operator *Operator `json:"operator"`
+	Admin    bool      `json:"admin"`
+}
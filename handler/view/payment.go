@@ -0,0 +1,13 @@
+package view
+
+type PaymentToken struct {
+	Token    string `json:"token"`
+	ExpireIn int    `json:"expire_in"`
+	QRCode   string `json:"qrcode"` //付款码的二维码图片，data URI
+}
+
+type PaymentAuth struct {
+	Customer *CustomersInfo `json:"customer"`
+	AuthCode string         `json:"auth_code"`
+	ExpireAt string         `json:"expire_at"`
+}
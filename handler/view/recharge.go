@@ -0,0 +1,13 @@
+package view
+
+type RechargeOrder struct {
+	OrderNo    string `json:"order_no"`
+	Amount     string `json:"amount"`
+	Gateway    string `json:"gateway"`
+	TradeType  string `json:"trade_type"`
+	Status     string `json:"status"`
+	StatusDesc string `json:"status_desc"`
+	ExpireAt   string `json:"expire_at"`
+	Payload    string `json:"payload,omitempty"`
+	QRCode     string `json:"qrcode,omitempty"` //扫码支付的二维码图片，data URI
+}
@@ -0,0 +1,31 @@
+package view
+
+type SettleReport struct {
+	Date          string                `json:"date"`
+	RechargeCount int                   `json:"recharge_count"`
+	RechargeTotal string                `json:"recharge_total"`
+	ConsumeCount  int                   `json:"consume_count"`
+	ConsumeTotal  string                `json:"consume_total"`
+	RefundCount   int                   `json:"refund_count"`
+	RefundTotal   string                `json:"refund_total"`
+	PayMethods    []*PayMethodSummary   `json:"pay_methods"`
+	Recharges     []*SettleRechargeItem `json:"recharges"`
+}
+
+type PayMethodSummary struct {
+	PayMethod     string `json:"pay_method"`
+	PayMethodDesc string `json:"pay_method_desc"`
+	Count         int    `json:"count"`
+	Amount        string `json:"amount"`
+	Tendered      string `json:"tendered"`
+	Change        string `json:"change"`
+}
+
+type SettleRechargeItem struct {
+	DealTime  string `json:"deal_time"`
+	Operator  string `json:"operator"`
+	Amount    string `json:"amount"`
+	PayMethod string `json:"pay_method"`
+	PayRef    string `json:"pay_ref"`
+	Tendered  string `json:"tendered"`
+}
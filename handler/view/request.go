@@ -0,0 +1,157 @@
+package view
+
+// 接口的请求参数，支持表单和 json 两种格式，validate tag 为校验规则，规则说明见 handler/validate.go
+
+// CellReq 只需要会员手机号的请求
+type CellReq struct {
+	Cell string `form:"cell" json:"cell" validate:"required,phone" desc:"会员手机号"`
+}
+
+// OperatorLoginReq 操作员登录
+type OperatorLoginReq struct {
+	Cell string `form:"cell" json:"cell" validate:"required,maxlen=20" desc:"操作员手机号"`
+	Pwd  string `form:"pwd" json:"pwd" validate:"required,maxlen=64" desc:"密码"`
+}
+
+// AddCustomerReq 新增会员
+type AddCustomerReq struct {
+	Cell string `form:"cell" json:"cell" validate:"required,phone" desc:"会员手机号"`
+	Name string `form:"name" json:"name" validate:"required,maxlen=32" desc:"会员姓名"`
+}
+
+// UpdateCustomerReq 操作员修改会员资料，整体替换，为空的项会被清空
+type UpdateCustomerReq struct {
+	Cell             string `form:"cell" json:"cell" validate:"required,phone" desc:"会员手机号"`
+	Name             string `form:"name" json:"name" validate:"required,maxlen=32" desc:"会员姓名"`
+	Birthday         string `form:"birthday" json:"birthday" validate:"date" desc:"生日，如 1990-01-02"`
+	Gender           string `form:"gender" json:"gender" validate:"enum=M|F" desc:"性别，M 男，F 女"`
+	Email            string `form:"email" json:"email" validate:"email,maxlen=64" desc:"邮箱"`
+	Notes            string `form:"notes" json:"notes" validate:"maxlen=512" desc:"备注，会员本人不可见"`
+	Tags             string `form:"tags" json:"tags" validate:"maxlen=255" desc:"标签，多个用逗号分隔，会员本人不可见"`
+	MarketingConsent string `form:"marketing_consent" json:"marketing_consent" validate:"enum=0|1" desc:"1 表示同意接收营销信息"`
+}
+
+// UpdateProfileReq 会员修改自己的资料，整体替换，为空的项会被清空
+type UpdateProfileReq struct {
+	Name             string `form:"name" json:"name" validate:"required,maxlen=32" desc:"姓名"`
+	Birthday         string `form:"birthday" json:"birthday" validate:"date" desc:"生日，如 1990-01-02"`
+	Gender           string `form:"gender" json:"gender" validate:"enum=M|F" desc:"性别，M 男，F 女"`
+	Email            string `form:"email" json:"email" validate:"email,maxlen=64" desc:"邮箱"`
+	MarketingConsent string `form:"marketing_consent" json:"marketing_consent" validate:"enum=0|1" desc:"1 表示同意接收营销信息"`
+}
+
+// SearchCustomersReq 搜索会员，条件之间为且的关系
+type SearchCustomersReq struct {
+	Keyword      string `form:"q" json:"q" validate:"maxlen=32" desc:"手机号、卡号、姓名或姓名拼音首字母的一部分"`
+	Tag          string `form:"tag" json:"tag" validate:"maxlen=16" desc:"标签"`
+	MinBalance   string `form:"min_balance" json:"min_balance" validate:"decimal" desc:"余额下限(元)"`
+	MaxBalance   string `form:"max_balance" json:"max_balance" validate:"decimal" desc:"余额上限(元)"`
+	InactiveDays int    `form:"inactive_days" json:"inactive_days" validate:"min=1,max=3650" desc:"最近多少天没有消费"`
+	Sort         string `form:"sort" json:"sort" validate:"enum=open_date|last_visit|balance" desc:"排序字段，默认按开卡日期"`
+	Order        string `form:"order" json:"order" validate:"enum=asc|desc" desc:"排序方向，默认为 desc"`
+	Page         int    `form:"page" json:"page" validate:"min=1,max=100000" desc:"页码，从 1 开始"`
+	PageSize     int    `form:"page_size" json:"page_size" validate:"min=1,max=100" desc:"每页数量，默认 20"`
+}
+
+// OperateCustomerReq 会员充值、消费和退款
+type OperateCustomerReq struct {
+	Cell        string `form:"cell" json:"cell" validate:"required,phone" desc:"会员手机号"`
+	OperateType string `form:"operate_type" json:"operate_type" validate:"required,enum=RECHARGE|CONSUME|REFUND" desc:"操作类型"`
+	Amount      string `form:"amount" json:"amount" validate:"required,amount,max=100000" desc:"金额(元)"`
+	Desc        string `form:"desc" json:"desc" validate:"maxlen=255" desc:"备注"`
+	Code        string `form:"code" json:"code" validate:"maxlen=8" desc:"大额消费的会员确认码"`
+	PayMethod   string `form:"pay_method" json:"pay_method" validate:"enum=CASH|BANK_CARD|WECHAT|ALIPAY" desc:"充值的付款方式，与 model.OperatorPayMethods 一致"`
+	PayRef      string `form:"pay_ref" json:"pay_ref" validate:"maxlen=64" desc:"支付渠道的交易流水号"`
+	Tendered    string `form:"tendered" json:"tendered" validate:"amount,max=100000" desc:"实收金额(元)，为空时等于充值金额"`
+}
+
+// SendPayCodeReq 给会员发送大额消费确认码
+type SendPayCodeReq struct {
+	Cell   string `form:"cell" json:"cell" validate:"required,phone" desc:"会员手机号"`
+	Amount string `form:"amount" json:"amount" validate:"required,amount,max=100000" desc:"确认码允许的最大消费金额(元)"`
+}
+
+// PaymentTokenReq 扫描会员付款码
+type PaymentTokenReq struct {
+	Token string `form:"token" json:"token" validate:"required,maxlen=128" desc:"会员付款码"`
+}
+
+// SettleReportReq 日结对账报表
+type SettleReportReq struct {
+	Date string `form:"date" json:"date" validate:"required,date" desc:"日期，如 2018-01-02"`
+}
+
+// CustomerLoginReq 会员验证码登录
+type CustomerLoginReq struct {
+	Cell string `form:"cell" json:"cell" validate:"required,phone" desc:"会员手机号"`
+	Code string `form:"code" json:"code" validate:"required,maxlen=8" desc:"短信验证码"`
+}
+
+// NotifySettingReq 会员余额变动通知设置
+type NotifySettingReq struct {
+	NotifyOff string `form:"notify_off" json:"notify_off" validate:"enum=0|1" desc:"1 表示关闭通知"`
+}
+
+// RechargeOrderReq 创建在线充值订单
+type RechargeOrderReq struct {
+	Amount    string `form:"amount" json:"amount" validate:"required,amount" desc:"充值金额(元)"`
+	Gateway   string `form:"gateway" json:"gateway" validate:"enum=wechat|alipay|fake" desc:"支付渠道，为空时使用默认渠道"`
+	TradeType string `form:"trade_type" json:"trade_type" validate:"enum=native|jsapi" desc:"支付方式，默认为扫码支付"`
+}
+
+// OrderNoReq 按订单号查询或操作充值订单
+type OrderNoReq struct {
+	OrderNo string `form:"order_no" json:"order_no" validate:"required,maxlen=32" desc:"充值订单号"`
+}
+
+// BalanceReminderReq 给会员发送余额提醒
+type BalanceReminderReq struct {
+	Cell   string `form:"cell" json:"cell" validate:"required,phone" desc:"会员手机号"`
+	Remark string `form:"remark" json:"remark" validate:"maxlen=100" desc:"提醒内容"`
+}
+
+// ImportCustomersReq 批量导入会员，文件通过 multipart 的 file 字段上传
+type ImportCustomersReq struct {
+	DryRun string `form:"dry_run" json:"dry_run" validate:"enum=0|1" desc:"1 表示只校验不写入"`
+}
+
+// SettingKeyReq 指定运行时配置项
+type SettingKeyReq struct {
+	Key string `form:"key" json:"key" validate:"required,maxlen=64" desc:"配置项"`
+}
+
+// UpdateSettingReq 修改运行时配置
+type UpdateSettingReq struct {
+	Key   string `form:"key" json:"key" validate:"required,maxlen=64" desc:"配置项"`
+	Value string `form:"value" json:"value" validate:"required,maxlen=1024" desc:"配置值"`
+}
+
+// TransactionReq /api/v1 中给会员记一笔充值、消费或退款，会员手机号来自路径
+type TransactionReq struct {
+	Cell        string `json:"-" uri:"cell" validate:"required,phone" desc:"会员手机号"`
+	OperateType string `json:"operate_type" validate:"required,enum=RECHARGE|CONSUME|REFUND" desc:"操作类型"`
+	Amount      string `json:"amount" validate:"required,amount,max=100000" desc:"金额(元)"`
+	Desc        string `json:"desc" validate:"maxlen=255" desc:"备注"`
+	Code        string `json:"code" validate:"maxlen=8" desc:"大额消费的会员确认码"`
+	PayMethod   string `json:"pay_method" validate:"enum=CASH|BANK_CARD|WECHAT|ALIPAY" desc:"充值的付款方式，与 model.OperatorPayMethods 一致"`
+	PayRef      string `json:"pay_ref" validate:"maxlen=64" desc:"支付渠道的交易流水号"`
+	Tendered    string `json:"tendered" validate:"amount,max=100000" desc:"实收金额(元)，为空时等于充值金额"`
+}
+
+// PayCodeReq /api/v1 中给会员发送大额消费确认码，会员手机号来自路径
+type PayCodeReq struct {
+	Cell   string `json:"-" uri:"cell" validate:"required,phone" desc:"会员手机号"`
+	Amount string `json:"amount" validate:"required,amount,max=100000" desc:"确认码允许的最大消费金额(元)"`
+}
+
+// CustomerProfileReq /api/v1 中修改会员资料，会员手机号来自路径，整体替换，为空的项会被清空
+type CustomerProfileReq struct {
+	Cell             string `json:"-" uri:"cell" validate:"required,phone" desc:"会员手机号"`
+	Name             string `json:"name" validate:"required,maxlen=32" desc:"会员姓名"`
+	Birthday         string `json:"birthday" validate:"date" desc:"生日，如 1990-01-02"`
+	Gender           string `json:"gender" validate:"enum=M|F" desc:"性别，M 男，F 女"`
+	Email            string `json:"email" validate:"email,maxlen=64" desc:"邮箱"`
+	Notes            string `json:"notes" validate:"maxlen=512" desc:"备注，会员本人不可见"`
+	Tags             string `json:"tags" validate:"maxlen=255" desc:"标签，多个用逗号分隔，会员本人不可见"`
+	MarketingConsent bool   `json:"marketing_consent" desc:"是否同意接收营销信息"`
+}
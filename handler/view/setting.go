@@ -0,0 +1,17 @@
+package view
+
+type Setting struct {
+	Key     string `json:"key"`
+	Type    string `json:"type"`
+	Value   string `json:"value"`
+	Default string `json:"default"`
+	Desc    string `json:"desc"`
+}
+
+type SettingHistory struct {
+	Key        string `json:"key"`
+	OldValue   string `json:"old_value"`
+	NewValue   string `json:"new_value"`
+	Operator   string `json:"operator"`
+	ChangeTime string `json:"change_time"`
+}
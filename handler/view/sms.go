@@ -0,0 +1,16 @@
+package view
+
+type SmsRecord struct {
+	SendTime   string `json:"send_time"`
+	Purpose    string `json:"purpose"`
+	Status     string `json:"status"`
+	StatusDesc string `json:"status_desc"`
+	ErrMsg     string `json:"err_msg"`
+	RetryCount int    `json:"retry_count"`
+	ReportTime string `json:"report_time"`
+}
+
+type PayCode struct {
+	Code     string `json:"code"`
+	ExpireAt string `json:"expire_at"`
+}
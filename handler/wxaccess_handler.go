@@ -1,19 +1,20 @@
 package handler
 
 import (
-	"crypto/sha1"
-	"fmt"
-	"io"
-	"sort"
+	"encoding/xml"
+	"io/ioutil"
+	"net/http"
 	"strings"
 
+	"code.bean.com/flamingo/config"
+	"code.bean.com/flamingo/service"
+	"code.bean.com/flamingo/util"
 	"code.byted.org/gopkg/logs"
 	"github.com/gin-gonic/gin"
 )
 
-const (
-	Token = "hello2018"
-)
+// wxMessageMaxBytes 微信推送消息的最大长度，正常的消息和事件远小于该值
+const wxMessageMaxBytes = 64 << 10
 
 // WXAccessHandler 微信通用认证api
 type WXAccessHandler struct{}
@@ -27,24 +28,114 @@ func NewWXAccessHandler() *WXAccessHandler {
 func (handler *WXAccessHandler) Register(e *gin.Engine) {
 	group := e.Group("/wx")
 	group.GET("/wx_conn", handler.FirstConn)
+	group.POST("/wx_conn", handler.ReceiveMessage)
+	group.GET("/oauth", handler.OAuth)
+	group.GET("/oauth_callback", handler.OAuthCallback)
+}
+
+// OAuth 跳转到微信网页授权
+func (handler *WXAccessHandler) OAuth(c *gin.Context) {
+	// state 用于防止 CSRF，必须不可预测
+	conf := config.ConfigInstance
+	redirectURI := conf.Wechat.OAuthRedirect
+	if redirectURI == "" {
+		if conf.Server.BaseURL == "" {
+			logs.Error("wechat oauth redirect not configured, set wechat.oauth_redirect or server.base_url")
+			c.Redirect(http.StatusFound, "/templates/customer_login")
+			return
+		}
+		redirectURI = strings.TrimRight(conf.Server.BaseURL, "/") + "/wx/oauth_callback"
+	}
+	state := util.RandomHex(16)
+	setCookie(c, "wx_state", state, 600, true)
+	c.Redirect(http.StatusFound, service.WechatServiceInstance().OAuthURL(redirectURI, state))
+}
+
+// OAuthCallback 微信网页授权回调，已绑定会员卡的用户直接登录，未绑定的用户短信验证登录后绑定
+func (handler *WXAccessHandler) OAuthCallback(c *gin.Context) {
+	state, err := c.Cookie("wx_state")
+	// state 只能使用一次
+	setCookie(c, "wx_state", "", -1, true)
+	if err != nil || state == "" || state != c.Query("state") {
+		logs.Error("invalid wechat oauth state")
+		c.Redirect(http.StatusFound, "/templates/customer_login")
+		return
+	}
+	openID, err := service.WechatServiceInstance().ExchangeOAuthCode(c.Query("code"))
+	if err != nil {
+		logs.Error("wechat oauth error, err=%+v", err)
+		c.Redirect(http.StatusFound, "/templates/customer_login")
+		return
+	}
+	customer, err := service.CustomerServiceInstance().GetCustomerByOpenID(openID)
+	if err != nil {
+		enbytes, _ := util.AESEncrypt([]byte(openID))
+		setCookie(c, "wx_openid", string(enbytes), 600, true)
+		c.Redirect(http.StatusFound, "/templates/customer_login")
+		return
+	}
+	setCustomerCookie(c, customer.Cellphone)
+	c.Redirect(http.StatusFound, "/templates/customer_home")
 }
 
 // FirstConn 首次连接
 func (handler *WXAccessHandler) FirstConn(c *gin.Context) {
-	// 注意下面将gin.H参数传入index.tmpl中!也就是使用的是index.tmpl模板
 	sign, ts, nonce := c.Query("signature"), c.Query("timestamp"), c.Query("nonce")
-	mySign := makeSignature(ts, nonce)
-	if mySign == sign {
-		echostr := c.Query("echostr")
-		logs.Info("ECHO_STR:%s", echostr)
-		c.Writer.Write([]byte(echostr))
+	if !service.WechatServiceInstance().CheckSignature(sign, ts, nonce) {
+		logs.Error("invalid wechat signature, path: %v", c.Request.URL.Path)
+		c.String(http.StatusForbidden, "invalid signature")
+		return
 	}
+	c.String(http.StatusOK, c.Query("echostr"))
 }
 
-func makeSignature(timestamp, nonce string) string {
-	s1 := []string{Token, timestamp, nonce}
-	sort.Strings(s1)
-	s := sha1.New()
-	io.WriteString(s, strings.Join(s1, ""))
-	return fmt.Sprintf("%x", s.Sum(nil))
+// ReceiveMessage 接收微信推送的消息和事件，明文模式和安全模式都支持
+func (handler *WXAccessHandler) ReceiveMessage(c *gin.Context) {
+	wechatService := service.WechatServiceInstance()
+	sign, ts, nonce := c.Query("signature"), c.Query("timestamp"), c.Query("nonce")
+	if !wechatService.CheckSignature(sign, ts, nonce) {
+		logs.Error("invalid wechat signature, path: %v", c.Request.URL.Path)
+		c.String(http.StatusForbidden, "invalid signature")
+		return
+	}
+	body, err := ioutil.ReadAll(http.MaxBytesReader(c.Writer, c.Request.Body, wxMessageMaxBytes))
+	if err != nil {
+		c.String(http.StatusBadRequest, "")
+		return
+	}
+	var msg *service.WxMessage
+	if err := xml.Unmarshal(body, &msg); err != nil {
+		logs.Error("unmarshal wechat message error, err=%+v", err)
+		c.String(http.StatusBadRequest, "")
+		return
+	}
+	encrypted := c.Query("encrypt_type") == "aes"
+	if encrypted {
+		msg, err = wechatService.DecryptMessage(msg, c.Query("msg_signature"), ts, nonce)
+		if err != nil {
+			logs.Error("decrypt wechat message error, err=%+v", err)
+			c.String(http.StatusForbidden, "")
+			return
+		}
+	}
+	reply, err := wechatService.Router.Dispatch(msg)
+	if err != nil {
+		logs.Error("handle wechat message error, type=%s, event=%s, err=%+v", msg.MsgType, msg.Event, err)
+	}
+	// 不需要回复时返回 success，微信服务器不会重试
+	if err != nil || reply == nil {
+		c.String(http.StatusOK, "success")
+		return
+	}
+	if !encrypted {
+		c.XML(http.StatusOK, reply)
+		return
+	}
+	encryptedReply, err := wechatService.EncryptReply(reply, ts, nonce)
+	if err != nil {
+		logs.Error("encrypt wechat reply error, err=%+v", err)
+		c.String(http.StatusOK, "success")
+		return
+	}
+	c.XML(http.StatusOK, encryptedReply)
 }
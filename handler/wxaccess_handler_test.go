@@ -0,0 +1,112 @@
+package handler
+
+import (
+	"encoding/xml"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"code.bean.com/flamingo/service"
+	"code.bean.com/flamingo/util"
+)
+
+// postWxMessage 以微信服务器的身份推送消息，query 中带上 signature、timestamp 和 nonce
+func postWxMessage(query url.Values, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, "/wx/wx_conn?"+query.Encode(), strings.NewReader(body))
+	req.Header.Set("Content-Type", "text/xml")
+	rec := httptest.NewRecorder()
+	testRouter.ServeHTTP(rec, req)
+	return rec
+}
+
+func wxQuery(timestamp, nonce string) url.Values {
+	return url.Values{"signature": {util.WXSignature(testWxToken, timestamp, nonce)}, "timestamp": {timestamp}, "nonce": {nonce}}
+}
+
+func TestWechatBalanceMessage(t *testing.T) {
+	const cell, openID = "13700000071", "o_test_balance"
+	addCustomer(t, operatorLogin(t), cell, "冯四", "88.8")
+	if err := service.CustomerServiceInstance().BindWxOpenID(cell, openID); err != nil {
+		t.Fatalf("bind openid: %v", err)
+	}
+	ts, nonce := strconv.FormatInt(time.Now().Unix(), 10), "nonce"
+	msg := `<xml><ToUserName>gh_test</ToUserName><FromUserName>` + openID + `</FromUserName><CreateTime>1</CreateTime><MsgType>text</MsgType><Content>余额</Content></xml>`
+
+	rec := postWxMessage(wxQuery(ts, nonce), msg)
+	var reply struct {
+		ToUserName string `xml:"ToUserName"`
+		Content    string `xml:"Content"`
+	}
+	if rec.Code != http.StatusOK || xml.Unmarshal(rec.Body.Bytes(), &reply) != nil {
+		t.Fatalf("plain message: status=%d, body=%s", rec.Code, rec.Body.String())
+	}
+	if reply.ToUserName != openID || !strings.Contains(reply.Content, "88.80") {
+		t.Errorf("plain reply = %+v", reply)
+	}
+
+	stale := strconv.FormatInt(time.Now().Add(-10*time.Minute).Unix(), 10)
+	if rec := postWxMessage(wxQuery(stale, nonce), msg); rec.Code != http.StatusForbidden {
+		t.Errorf("message with stale timestamp: status=%d", rec.Code)
+	}
+	forged := wxQuery(ts, nonce)
+	forged.Set("signature", util.WXSignature("other", ts, nonce))
+	if rec := postWxMessage(forged, msg); rec.Code != http.StatusForbidden {
+		t.Errorf("message with forged signature: status=%d", rec.Code)
+	}
+	large := strings.Replace(msg, "余额", strings.Repeat("a", wxMessageMaxBytes), 1)
+	if rec := postWxMessage(wxQuery(ts, nonce), large); rec.Code != http.StatusBadRequest {
+		t.Errorf("oversized message: status=%d", rec.Code)
+	}
+}
+
+func TestWechatEncryptedMessage(t *testing.T) {
+	const cell, openID = "13700000072", "o_test_encrypted"
+	addCustomer(t, operatorLogin(t), cell, "陈五", "12")
+	if err := service.CustomerServiceInstance().BindWxOpenID(cell, openID); err != nil {
+		t.Fatalf("bind openid: %v", err)
+	}
+	key, err := util.WXAESKey(testWxAESKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	plain := `<xml><ToUserName>gh_test</ToUserName><FromUserName>` + openID + `</FromUserName><CreateTime>1</CreateTime><MsgType>text</MsgType><Content>余额</Content></xml>`
+	encrypted, err := util.WXEncrypt(key, testWxAppID, []byte(plain))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts, nonce := strconv.FormatInt(time.Now().Unix(), 10), "nonce2"
+	body := `<xml><ToUserName>gh_test</ToUserName><Encrypt><![CDATA[` + encrypted + `]]></Encrypt></xml>`
+	query := wxQuery(ts, nonce)
+	query.Set("encrypt_type", "aes")
+	query.Set("msg_signature", util.WXSignature(testWxToken, ts, nonce, encrypted))
+
+	rec := postWxMessage(query, body)
+	var reply service.WxEncryptedReply
+	if rec.Code != http.StatusOK || xml.Unmarshal(rec.Body.Bytes(), &reply) != nil {
+		t.Fatalf("encrypted message: status=%d, body=%s", rec.Code, rec.Body.String())
+	}
+	if reply.MsgSignature.Text != util.WXSignature(testWxToken, reply.TimeStamp, reply.Nonce.Text, reply.Encrypt.Text) {
+		t.Errorf("reply msg_signature does not match")
+	}
+	decrypted, appID, err := util.WXDecrypt(key, reply.Encrypt.Text)
+	if err != nil || appID != testWxAppID {
+		t.Fatalf("decrypt reply: appid=%s, err=%v", appID, err)
+	}
+	if !strings.Contains(string(decrypted), "12.00") || !strings.Contains(string(decrypted), openID) {
+		t.Errorf("decrypted reply = %s", decrypted)
+	}
+
+	query.Set("msg_signature", util.WXSignature(testWxToken, ts, nonce, "tampered"))
+	if rec := postWxMessage(query, body); rec.Code != http.StatusForbidden {
+		t.Errorf("encrypted message with wrong msg_signature: status=%d", rec.Code)
+	}
+	other, _ := util.WXEncrypt(key, "wx_other_app", []byte(plain))
+	query.Set("msg_signature", util.WXSignature(testWxToken, ts, nonce, other))
+	if rec := postWxMessage(query, strings.Replace(body, encrypted, other, 1)); rec.Code != http.StatusForbidden {
+		t.Errorf("message for another appid: status=%d", rec.Code)
+	}
+}
@@ -1,9 +1,21 @@
 package main
 
 import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
 	"code.bean.com/flamingo/config"
 	"code.bean.com/flamingo/handler"
+	"code.bean.com/flamingo/metrics"
 	"code.bean.com/flamingo/model"
+	"code.bean.com/flamingo/util"
 	"code.byted.org/gin/ginex"
 	"code.byted.org/gopkg/logs"
 	"github.com/gin-gonic/gin"
@@ -11,13 +23,54 @@ import (
 
 var (
 	e *ginex.Engine
+
+	configFile  = flag.String("config", envOr("FLAMINGO_CONFIG", "./conf/flamingo.json"), "config file path")
+	secretsFile = flag.String("secrets", os.Getenv("FLAMINGO_SECRETS_FILE"), "secrets file path, overrides the config file")
 )
 
 func main() {
-	router := gin.Default()
-	config.Init("./conf/flamingo.json")
-	model.Init()
-	logs.Info("init model finished")
+	flag.Usage = usage
+	flag.Parse()
+	name, args := "serve", flag.Args()
+	if len(args) > 0 {
+		name, args = args[0], args[1:]
+	}
+	cmd := findCommand(name)
+	if cmd == nil {
+		fmt.Fprintf(os.Stderr, "unknown command: %s\n", name)
+		usage()
+		os.Exit(2)
+	}
+	if err := config.Init(*configFile, *secretsFile); err != nil {
+		log.Fatalf("init config error: %v", err)
+	}
+	if config.ConfigInstance.Session.AESKey == "" {
+		logs.Warn("session.aes_key not configured, use a random key, login cookies will be invalid after restart")
+	}
+	if err := util.InitAES(config.ConfigInstance.Session.AESKey); err != nil {
+		log.Fatalf("init aes key error: %v", err)
+	}
+	if cmd.needDB {
+		if err := model.Init(); err != nil {
+			log.Fatalf("init model error: %v", err)
+		}
+		logs.Info("init model finished")
+	}
+	err := cmd.run(args)
+	logs.Flush()
+	if err != nil {
+		log.Fatalf("%s error: %v", name, err)
+	}
+}
+
+// runServe 启动 http 服务
+func runServe(args []string) error {
+	if err := checkMigrations(); err != nil {
+		return err
+	}
+	// 不使用 gin 默认的 Logger，其日志中包含未隐藏手机号的 query 参数
+	router := gin.New()
+	router.Use(gin.Recovery(), handler.RequestIDMiddleware(), handler.AccessLogMiddleware(), metrics.Middleware())
 	handler.Init()
 	router.Static("/templates/css", "templates/css")
 	router.Static("/templates/js", "templates/js")
@@ -51,5 +104,80 @@ func main() {
 	// 	i = i + 1
 
 	// })
-	router.Run(":8002")
+	serve(router)
+	return nil
+}
+
+// serve 启动 http 服务，收到 SIGTERM/SIGINT 后停止接收新请求，等待处理中的请求和后台任务结束后退出
+func serve(router http.Handler) {
+	conf := config.ConfigInstance.Server
+	server := &http.Server{
+		Addr:         conf.Addr,
+		Handler:      router,
+		ReadTimeout:  time.Duration(conf.ReadTimeout) * time.Second,
+		WriteTimeout: time.Duration(conf.WriteTimeout) * time.Second,
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	workers := handler.StartWorkers(ctx)
+
+	errCh := make(chan error, 2)
+	go func() {
+		logs.Info("listen on %s", conf.Addr)
+		errCh <- server.ListenAndServe()
+	}()
+	var metricsServer *http.Server
+	if conf.MetricsAddr != "" {
+		metricsServer = &http.Server{Addr: conf.MetricsAddr, Handler: handler.MetricsHandler()}
+		go func() {
+			logs.Info("metrics listen on %s", conf.MetricsAddr)
+			errCh <- metricsServer.ListenAndServe()
+		}()
+	}
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGTERM, syscall.SIGINT)
+	select {
+	case err := <-errCh:
+		cancel()
+		log.Fatalf("listen error: %v", err)
+	case sig := <-signals:
+		logs.Info("receive signal %v, shutting down", sig)
+	}
+
+	handler.SetDraining()
+	if conf.DrainSeconds > 0 {
+		// 等待负载均衡通过 /readyz 发现实例下线、不再转发新请求
+		logs.Info("draining for %ds", conf.DrainSeconds)
+		time.Sleep(time.Duration(conf.DrainSeconds) * time.Second)
+	}
+	shutdownCtx, shutdownCancel := context.WithTimeout(context.Background(), time.Duration(conf.ShutdownTimeout)*time.Second)
+	defer shutdownCancel()
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		logs.Error("shutdown http server error: %v", err)
+	}
+	if metricsServer != nil {
+		metricsServer.Shutdown(shutdownCtx)
+	}
+	cancel()
+	done := make(chan struct{})
+	go func() {
+		workers.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-shutdownCtx.Done():
+		logs.Error("background workers not stopped before shutdown timeout")
+	}
+	if err := model.Close(); err != nil {
+		logs.Error("close db error: %v", err)
+	}
+	logs.Info("server stopped")
+	logs.Flush()
+}
+
+func envOr(name, value string) string {
+	if v := os.Getenv(name); v != "" {
+		return v
+	}
+	return value
 }
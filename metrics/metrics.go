@@ -0,0 +1,129 @@
+package metrics
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const namespace = "flamingo"
+
+// ErrorCodeKey JSONWrapper 将业务错误码写入 gin.Context 的 key，成功时为 0
+const ErrorCodeKey = "metrics_error_code"
+
+// RouteKey 带路径参数的路由将路由模板写入 gin.Context 的 key，避免参数值出现在 label 中
+const RouteKey = "metrics_route"
+
+// 登录结果
+const (
+	LoginSuccess = "success"
+	LoginFailure = "failure"
+)
+
+var (
+	httpDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "http_request_duration_seconds",
+		Help:      "HTTP request latency by route, status and business error code.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"route", "method", "status", "code"})
+
+	dbDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "db_query_duration_seconds",
+		Help:      "Database query latency by operation and table.",
+		Buckets:   []float64{.001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5},
+	}, []string{"operation", "table"})
+
+	accountCount = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "account_total",
+		Help:      "Number of ledger entries by account type.",
+	}, []string{"type"})
+
+	accountAmount = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "account_amount_yuan_total",
+		Help:      "Ledger amount in yuan by account type.",
+	}, []string{"type"})
+
+	smsCount = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "sms_send_total",
+		Help:      "SMS send attempts by purpose and result status.",
+	}, []string{"purpose", "status"})
+
+	loginCount = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "login_total",
+		Help:      "Login attempts by role and result.",
+	}, []string{"role", "result"})
+)
+
+func init() {
+	prometheus.MustRegister(httpDuration, dbDuration, accountCount, accountAmount, smsCount, loginCount)
+}
+
+// RegisterLiability 注册储值余额总额(元)的 gauge，fn 在每次采集时调用，需自行缓存
+func RegisterLiability(fn func() float64) {
+	prometheus.MustRegister(prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "stored_value_liability_yuan",
+		Help:      "Total outstanding stored-value balance of all customers in yuan.",
+	}, fn))
+}
+
+// Middleware 记录每个请求的耗时、http 状态码和业务错误码
+func Middleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		c.Next()
+		status := c.Writer.Status()
+		code := ""
+		if v, ok := c.Get(ErrorCodeKey); ok {
+			code = strconv.Itoa(v.(int))
+		}
+		route := routeLabel(c.Request.URL.Path)
+		if v, ok := c.Get(RouteKey); ok {
+			route = v.(string)
+		}
+		httpDuration.WithLabelValues(route, c.Request.Method, strconv.Itoa(status), code).
+			Observe(time.Since(start).Seconds())
+	}
+}
+
+// RouteUnmatched 没有匹配到路由的请求使用的 route label，由 NoRoute 处理函数写入 RouteKey
+const RouteUnmatched = "unmatched"
+
+// routeLabel 未设置 RouteKey 的路由没有路径参数，直接使用 path；静态文件合并，避免 label 无限增长
+// 已匹配的路由即使返回 404 也使用自己的 label
+func routeLabel(path string) string {
+	if strings.HasPrefix(path, "/templates/") {
+		return "/templates/*"
+	}
+	return path
+}
+
+// ObserveDB 记录一次数据库操作的耗时
+func ObserveDB(operation, table string, d time.Duration) {
+	dbDuration.WithLabelValues(operation, table).Observe(d.Seconds())
+}
+
+// ObserveAccount 记录一笔入账的流水，amount 单位为分
+func ObserveAccount(accountType string, amount int) {
+	accountCount.WithLabelValues(accountType).Inc()
+	accountAmount.WithLabelValues(accountType).Add(float64(amount) / 100)
+}
+
+// ObserveSms 记录一次短信发送结果
+func ObserveSms(purpose, status string) {
+	smsCount.WithLabelValues(purpose, status).Inc()
+}
+
+// ObserveLogin 记录一次登录结果，role 为 customer 或 operator
+func ObserveLogin(role, result string) {
+	loginCount.WithLabelValues(role, result).Inc()
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"code.bean.com/flamingo/config"
+	"code.bean.com/flamingo/model"
+	"code.byted.org/gopkg/logs"
+)
+
+// runMigrate 执行 migrate 命令：
+//
+//	migrate up [-to version]  执行未执行的 migration
+//	migrate down [-steps n]   回滚最近的 n 个 migration
+//	migrate status            查看 migration 执行状态
+func runMigrate(args []string) error {
+	action := "up"
+	if len(args) > 0 {
+		action, args = args[0], args[1:]
+	}
+	fs := flag.NewFlagSet("migrate "+action, flag.ContinueOnError)
+	to := fs.Int("to", -1, "migrate up to this version, default to the latest")
+	steps := fs.Int("steps", 1, "number of migrations to roll back")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	switch action {
+	case "up":
+		return model.Migrate(*to)
+	case "down":
+		return model.Rollback(*steps)
+	case "status":
+		states, err := model.MigrationStatus()
+		if err != nil {
+			return err
+		}
+		for _, state := range states {
+			appliedAt := "pending"
+			if state.Applied {
+				appliedAt = state.AppliedAt.Format("2006-01-02 15:04:05")
+			}
+			fmt.Fprintf(os.Stdout, "%4d  %-32s  %s\n", state.Version, state.Name, appliedAt)
+		}
+		return nil
+	default:
+		return fmt.Errorf("unknown migrate action: %s", action)
+	}
+}
+
+// checkMigrations 启动时检查 migration，开启 auto_migrate 时自动执行
+func checkMigrations() error {
+	if config.ConfigInstance.DB.AutoMigrate {
+		return model.Migrate(-1)
+	}
+	pending, err := model.PendingMigrations()
+	if err != nil {
+		return err
+	}
+	if pending > 0 {
+		logs.Warn("%d migrations pending, run `flamingo migrate up` to apply", pending)
+	}
+	return nil
+}
@@ -2,6 +2,10 @@ package model
 
 import (
 	"sync"
+	"time"
+
+	"code.byted.org/gopkg/logs"
+	"github.com/jinzhu/gorm"
 )
 
 var (
@@ -17,6 +21,20 @@ type ConfigItem struct {
 	Value string `gorm:"column:value_field"`
 }
 
+// ConfigItemHistory 配置项修改记录
+type ConfigItemHistory struct {
+	ID         int       `gorm:"column:id"`
+	Key        string    `gorm:"column:key_field"`
+	OldValue   string    `gorm:"column:old_value"`
+	NewValue   string    `gorm:"column:new_value"`
+	Operator   string    `gorm:"column:operator"`
+	ChangeTime time.Time `gorm:"column:change_time"`
+}
+
+func (ConfigItemHistory) TableName() string {
+	return "config_item_history"
+}
+
 func ConfigItemDaoInstance() *ConfigItemDao {
 	configItemDaoOnce.Do(func() {
 		configItemDao = &ConfigItemDao{}
@@ -33,7 +51,93 @@ func (dao *ConfigItemDao) GetConfigItem(key string) (string, error) {
 	return item.Value, nil
 }
 
-func (dao *ConfigItemDao) UpdateConfigItem(key, value string) {
+// UpdateConfigItem 更新配置项，配置项不存在时新建
+func (dao *ConfigItemDao) UpdateConfigItem(key, value string) error {
+	var item ConfigItem
+	db := MSDB.Model(&item).Where("key_field = ?", key).Update("value_field", value)
+	if db.Error != nil {
+		logs.Error("update config item error, key=%s, err=%+v", key, db.Error)
+		return db.Error
+	}
+	if db.RowsAffected > 0 {
+		return nil
+	}
+	err := MSDB.Where("key_field = ?", key).First(&item).Error
+	if err == nil {
+		// 值未变化时 RowsAffected 也为 0
+		return nil
+	}
+	if err != gorm.ErrRecordNotFound {
+		logs.Error("get config item error, key=%s, err=%+v", key, err)
+		return err
+	}
+	err = MSDB.Create(&ConfigItem{Key: key, Value: value}).Error
+	if err != nil {
+		logs.Error("create config item error, key=%s, err=%+v", key, err)
+	}
+	return err
+}
+
+// GetConfigItems 批量查询配置项，不存在的 key 不会出现在结果中
+func (dao *ConfigItemDao) GetConfigItems(keys []string) (map[string]string, error) {
+	items := make([]*ConfigItem, 0)
+	err := MSDB.Where("key_field in (?)", keys).Find(&items).Error
+	if err != nil {
+		logs.Error("get config items error, err=%+v", err)
+		return nil, err
+	}
+	values := make(map[string]string, len(items))
+	for _, item := range items {
+		values[item.Key] = item.Value
+	}
+	return values, nil
+}
+
+// SetConfigItemWithHistory 更新或新建配置项并记录修改历史，值未变化时不记录
+func (dao *ConfigItemDao) SetConfigItemWithHistory(key, value, operator string) error {
+	tx := MSDB.Begin()
 	var item ConfigItem
-	MSDB.Model(&item).Where("key_field = ?", key).Update("value_field", value)
+	err := tx.Set("gorm:query_option", "FOR UPDATE").Where("key_field = ?", key).First(&item).Error
+	if err != nil && err != gorm.ErrRecordNotFound {
+		tx.Rollback()
+		logs.Error("get config item error, key=%s, err=%+v", key, err)
+		return err
+	}
+	if err == nil && item.Value == value {
+		tx.Rollback()
+		return nil
+	}
+	oldValue := item.Value
+	if err == gorm.ErrRecordNotFound {
+		err = tx.Create(&ConfigItem{Key: key, Value: value}).Error
+	} else {
+		err = tx.Model(&item).Update("value_field", value).Error
+	}
+	if err != nil {
+		tx.Rollback()
+		logs.Error("save config item error, key=%s, err=%+v", key, err)
+		return err
+	}
+	history := &ConfigItemHistory{Key: key, OldValue: oldValue, NewValue: value, Operator: operator, ChangeTime: time.Now()}
+	if err := tx.Create(history).Error; err != nil {
+		tx.Rollback()
+		logs.Error("create config item history error, key=%s, err=%+v", key, err)
+		return err
+	}
+	return tx.Commit().Error
+}
+
+// GetConfigItemHistory 查询配置项最近的修改记录
+func (dao *ConfigItemDao) GetConfigItemHistory(key string, limit int) ([]*ConfigItemHistory, error) {
+	histories := make([]*ConfigItemHistory, 0)
+	err := MSDB.Where("key_field = ?", key).Order("change_time desc").Limit(limit).Find(&histories).Error
+	if err != nil {
+		logs.Error("get config item history error, key=%s, err=%+v", key, err)
+	}
+	return histories, err
+}
+
+// WithConfigLock 持有 key 对应的 mysql 命名锁执行 fn，fn 中应重新读取配置项
+func (dao *ConfigItemDao) WithConfigLock(key string, fn func() error) error {
+	return withNamedLock("flamingo_config_"+key, 10, fn)
 }
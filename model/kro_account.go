@@ -1,6 +1,7 @@
 package model
 
 import (
+	"fmt"
 	"sync"
 	"time"
 
@@ -11,8 +12,31 @@ const (
 	AccountTypeRecharge = "RECHARGE" //充值
 	AccountTypeCunsume  = "CONSUME"  //消费
 	AcccountTypeRefund  = "REFUND"   //退款
+	AccountTypeOpening  = "OPENING"  //期初余额，从旧系统导入会员时记入
 )
 
+// 充值的付款方式
+const (
+	PayMethodCash     = "CASH"      //现金
+	PayMethodBankCard = "BANK_CARD" //银行卡
+	PayMethodWechat   = "WECHAT"    //微信
+	PayMethodAlipay   = "ALIPAY"    //支付宝
+	PayMethodOther    = "OTHER"     //其他，如测试环境的模拟支付
+)
+
+// OperatorPayMethods 操作员充值时可以选择的付款方式，OTHER 只用于在线充值订单
+var OperatorPayMethods = []string{PayMethodCash, PayMethodBankCard, PayMethodWechat, PayMethodAlipay}
+
+// IsOperatorPayMethod 是否为操作员充值时可以选择的付款方式
+func IsOperatorPayMethod(method string) bool {
+	for _, m := range OperatorPayMethods {
+		if m == method {
+			return true
+		}
+	}
+	return false
+}
+
 type KroAccount struct {
 	CustomerID  int       `gorm:"column:customer_id"`
 	AccountType string    `gorm:"column:account_type"`
@@ -21,6 +45,9 @@ type KroAccount struct {
 	Desc        string    `gorm:"column:desc"`
 	OpCell      string    `gorm:"column:operator"`
 	Operator    string    `gorm:"column:operator_name"`
+	PayMethod   string    `gorm:"column:pay_method"`
+	PayRef      string    `gorm:"column:pay_ref"`
+	Tendered    int       `gorm:"column:tendered"`
 }
 
 type KroAccountDao struct{}
@@ -52,3 +79,29 @@ func (dao *KroAccountDao) GetCustomerAccounts(customer *KroCustomer) ([]*KroAcco
 	}
 	return accounts, err
 }
+
+// WithAccountLock 持有会员对应的 mysql 命名锁执行 fn，fn 中应重新读取流水计算余额
+func (dao *KroAccountDao) WithAccountLock(customer *KroCustomer, fn func() error) error {
+	return withNamedLock(fmt.Sprintf("flamingo_account_%d", customer.ID), 10, fn)
+}
+
+// GetAccountsByDealTime 查询 [start, end) 时间段内的所有流水
+func (dao *KroAccountDao) GetAccountsByDealTime(start, end time.Time) ([]*KroAccount, error) {
+	accounts := make([]*KroAccount, 0)
+	err := MSDB.Where("deal_time>=? AND deal_time<?", start, end).Order("id").Find(&accounts).Error
+	if err != nil {
+		logs.Error("get accounts by deal time error, err=%+v", err)
+	}
+	return accounts, err
+}
+
+// GetTotalBalance 所有会员的余额总和，单位为分
+func (dao *KroAccountDao) GetTotalBalance() (int, error) {
+	var total int
+	err := MSDB.Raw("SELECT COALESCE(SUM(CASE WHEN account_type IN (?, ?, ?) THEN amount ELSE -amount END), 0) FROM kro_accounts",
+		AccountTypeRecharge, AcccountTypeRefund, AccountTypeOpening).Row().Scan(&total)
+	if err != nil {
+		logs.Error("get total balance error, err=%+v", err)
+	}
+	return total, err
+}
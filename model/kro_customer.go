@@ -2,6 +2,7 @@ package model
 
 import (
 	"errors"
+	"strings"
 	"sync"
 	"time"
 
@@ -9,14 +10,79 @@ import (
 	"github.com/jinzhu/gorm"
 )
 
+// 会员性别，空表示未填写
+const (
+	GenderMale   = "M"
+	GenderFemale = "F"
+)
+
+// 会员资料修改的来源
+const (
+	ChangeSourceOperator = "operator" //操作员修改
+	ChangeSourceCustomer = "customer" //会员本人修改
+)
+
 type KroCustomer struct {
+	ID               int        `gorm:"column:id"`
+	CustomerID       string     `gorm:"column:card_no"`
+	Name             string     `gorm:"column:name"`
+	Cellphone        string     `gorm:"column:cellphone"`
+	OpenDate         time.Time  `gorm:"column:open_date"`
+	WxOpenID         string     `gorm:"column:wx_openid"`
+	NotifyOff        bool       `gorm:"column:notify_off"`
+	PayCounter       int64      `gorm:"column:pay_counter"`
+	Birthday         *time.Time `gorm:"column:birthday"`
+	Gender           string     `gorm:"column:gender"`
+	Email            string     `gorm:"column:email"`
+	Notes            string     `gorm:"column:notes"`
+	Tags             string     `gorm:"column:tags"` //逗号分隔的标签
+	MarketingConsent bool       `gorm:"column:marketing_consent"`
+	NameInitials     string     `gorm:"column:name_initials"` //姓名的拼音首字母，用于搜索，见 util.PinyinInitials
+	NamePinyin       string     `gorm:"column:name_pinyin"`   //姓名的全拼，用于搜索，见 util.Pinyin
+}
+
+// 会员列表的排序字段
+const (
+	CustomerSortOpenDate  = "open_date"  //开卡日期
+	CustomerSortLastVisit = "last_visit" //最近一次消费时间
+	CustomerSortBalance   = "balance"    //余额
+)
+
+// CustomerQuery 会员列表的查询条件，零值表示不限
+type CustomerQuery struct {
+	Keyword       string     // 手机号、卡号、姓名或姓名拼音首字母的一部分
+	Tag           string     // 包含该标签
+	MinBalance    *int       // 余额下限(分)，包含
+	MaxBalance    *int       // 余额上限(分)，包含
+	InactiveSince *time.Time // 该时间之后没有消费过，包括从未消费的会员
+	Sort          string     // 排序字段，默认按开卡日期
+	Desc          bool
+	Offset        int
+	Limit         int
+}
+
+// CustomerRow 会员列表中的一行，包含余额(分)和最近一次消费时间
+type CustomerRow struct {
+	KroCustomer
+	Balance   int        `gorm:"column:balance"`
+	LastVisit *time.Time `gorm:"column:last_visit"`
+}
+
+// KroCustomerChange 会员资料的修改记录，每个修改的字段一条
+type KroCustomerChange struct {
 	ID         int       `gorm:"column:id"`
-	CustomerID string    `gorm:"column:card_no"`
-	Name       string    `gorm:"column:name"`
-	Cellphone  string    `gorm:"column:cellphone"`
-	OpenDate   time.Time `gorm:"column:open_date"`
+	CustomerID int       `gorm:"column:customer_id"`
+	Field      string    `gorm:"column:field"`
+	OldValue   string    `gorm:"column:old_value"`
+	NewValue   string    `gorm:"column:new_value"`
+	Source     string    `gorm:"column:source"`
+	Operator   string    `gorm:"column:operator"` //操作员手机号，会员本人修改时为空
+	ChangeTime time.Time `gorm:"column:change_time"`
 }
 
+// ProfileUpdateFunc 根据会员当前的资料计算修改后的资料和每个字段的修改记录
+type ProfileUpdateFunc func(current *KroCustomer) (*KroCustomer, []*KroCustomerChange, error)
+
 type KroCustomerDao struct{}
 
 var kroCustomerDao *KroCustomerDao
@@ -54,3 +120,209 @@ func (dao *KroCustomerDao) GetCustomerByCellphone(cellphone string) (*KroCustome
 	}
 	return &customer, err
 }
+
+// UpdateCustomerNotify 更新用户是否接收账户变动通知
+func (dao *KroCustomerDao) UpdateCustomerNotify(customer *KroCustomer, notifyOff bool) error {
+	err := MSDB.Model(customer).Where("id=?", customer.ID).Update("notify_off", notifyOff).Error
+	if err != nil {
+		logs.Error("update customer notify error, err=%+v", err)
+	}
+	return err
+}
+
+// UpdateCustomerProfile 在一个事务中锁定会员当前的资料，由 update 计算修改后的资料和修改历史并保存
+// update 返回错误时原样返回，没有修改历史时不保存
+func (dao *KroCustomerDao) UpdateCustomerProfile(customerID int, update ProfileUpdateFunc) error {
+	tx := MSDB.Begin()
+	var current KroCustomer
+	if err := tx.Set("gorm:query_option", "FOR UPDATE").Where("id=?", customerID).First(&current).Error; err != nil {
+		tx.Rollback()
+		logs.Error("get customer for update error, id=%d, err=%+v", customerID, err)
+		return err
+	}
+	customer, changes, err := update(&current)
+	if err != nil || len(changes) == 0 {
+		tx.Rollback()
+		return err
+	}
+	err = tx.Model(&KroCustomer{}).Where("id=?", customer.ID).Updates(map[string]interface{}{
+		"name":              customer.Name,
+		"birthday":          customer.Birthday,
+		"gender":            customer.Gender,
+		"email":             customer.Email,
+		"notes":             customer.Notes,
+		"tags":              customer.Tags,
+		"marketing_consent": customer.MarketingConsent,
+		"name_initials":     customer.NameInitials,
+		"name_pinyin":       customer.NamePinyin,
+	}).Error
+	if err != nil {
+		tx.Rollback()
+		logs.Error("update customer profile error, err=%+v", err)
+		return err
+	}
+	for _, change := range changes {
+		if err := tx.Create(change).Error; err != nil {
+			tx.Rollback()
+			logs.Error("create customer change error, err=%+v", err)
+			return err
+		}
+	}
+	return tx.Commit().Error
+}
+
+// GetCustomerChanges 查询会员资料最近的修改记录
+func (dao *KroCustomerDao) GetCustomerChanges(customerID, limit int) ([]*KroCustomerChange, error) {
+	changes := make([]*KroCustomerChange, 0)
+	err := MSDB.Where("customer_id=?", customerID).Order("id desc").Limit(limit).Find(&changes).Error
+	if err != nil {
+		logs.Error("get customer changes error, err=%+v", err)
+	}
+	return changes, err
+}
+
+// likeEscaper 转义 LIKE 中的通配符
+var likeEscaper = strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`)
+
+// SearchCustomers 按条件分页查询会员，返回当前页和符合条件的总数
+// 余额和最近一次消费时间由账户流水汇总，与 GetTotalBalance 的计算方式一致
+func (dao *KroCustomerDao) SearchCustomers(query *CustomerQuery) ([]*CustomerRow, int, error) {
+	db := MSDB.Table("kro_customers c").Joins(`LEFT JOIN (SELECT customer_id,
+	SUM(CASE WHEN account_type IN (?) THEN amount ELSE -amount END) AS balance,
+	MAX(CASE WHEN account_type = ? THEN deal_time END) AS last_visit
+	FROM kro_accounts GROUP BY customer_id) s ON s.customer_id = c.id`,
+		[]string{AccountTypeRecharge, AcccountTypeRefund, AccountTypeOpening}, AccountTypeCunsume)
+	if query.Keyword != "" {
+		like := "%" + likeEscaper.Replace(query.Keyword) + "%"
+		db = db.Where("c.cellphone LIKE ? OR c.card_no LIKE ? OR c.name LIKE ? OR c.name_initials LIKE ? OR c.name_pinyin LIKE ?",
+			like, like, like, strings.ToLower(like), strings.ToLower(like))
+	}
+	if query.Tag != "" {
+		db = db.Where("CONCAT(',', c.tags, ',') LIKE ?", "%,"+likeEscaper.Replace(query.Tag)+",%")
+	}
+	if query.MinBalance != nil {
+		db = db.Where("COALESCE(s.balance, 0) >= ?", *query.MinBalance)
+	}
+	if query.MaxBalance != nil {
+		db = db.Where("COALESCE(s.balance, 0) <= ?", *query.MaxBalance)
+	}
+	if query.InactiveSince != nil {
+		db = db.Where("s.last_visit IS NULL OR s.last_visit < ?", *query.InactiveSince)
+	}
+	var total int
+	if err := db.Count(&total).Error; err != nil {
+		logs.Error("count customers error, err=%+v", err)
+		return nil, 0, err
+	}
+	order := map[string]string{
+		CustomerSortLastVisit: "s.last_visit",
+		CustomerSortBalance:   "COALESCE(s.balance, 0)",
+	}[query.Sort]
+	if order == "" {
+		order = "c.open_date"
+	}
+	direction := " ASC"
+	if query.Desc {
+		direction = " DESC"
+	}
+	rows := make([]*CustomerRow, 0)
+	err := db.Select("c.*, COALESCE(s.balance, 0) AS balance, s.last_visit AS last_visit").
+		Order(order + direction + ", c.id" + direction).Offset(query.Offset).Limit(query.Limit).Scan(&rows).Error
+	if err != nil {
+		logs.Error("search customers error, err=%+v", err)
+	}
+	return rows, total, err
+}
+
+// GetCustomerByCardNo 根据会员卡号查询
+func (dao *KroCustomerDao) GetCustomerByCardNo(cardNo string) (*KroCustomer, error) {
+	var customer KroCustomer
+	err := MSDB.Where("card_no=?", cardNo).First(&customer).Error
+	if err != nil && err != gorm.ErrRecordNotFound {
+		logs.Error("get customer error, err=%+v", err)
+	}
+	return &customer, err
+}
+
+// CustomerImport 导入的一个会员，Opening 为期初余额流水，没有期初余额时为空
+type CustomerImport struct {
+	Customer *KroCustomer
+	Opening  *KroAccount
+}
+
+// ImportCustomers 在一个事务中写入所有导入的会员和期初余额
+func (dao *KroCustomerDao) ImportCustomers(imports []*CustomerImport) error {
+	tx := MSDB.Begin()
+	for _, item := range imports {
+		if err := tx.Create(item.Customer).Error; err != nil {
+			tx.Rollback()
+			logs.Error("import customer error, err=%+v", err)
+			return err
+		}
+		if item.Opening != nil {
+			item.Opening.CustomerID = item.Customer.ID
+			if err := tx.Create(item.Opening).Error; err != nil {
+				tx.Rollback()
+				logs.Error("import customer opening account error, err=%+v", err)
+				return err
+			}
+		}
+	}
+	return tx.Commit().Error
+}
+
+// ListCustomers 按 id 升序分页查询会员，afterID 为上一页最后一个会员的 id
+func (dao *KroCustomerDao) ListCustomers(afterID, limit int) ([]*KroCustomer, error) {
+	customers := make([]*KroCustomer, 0)
+	err := MSDB.Where("id>?", afterID).Order("id").Limit(limit).Find(&customers).Error
+	if err != nil {
+		logs.Error("list customers error, err=%+v", err)
+	}
+	return customers, err
+}
+
+func (dao *KroCustomerDao) GetCustomerByID(id int) (*KroCustomer, error) {
+	var customer KroCustomer
+	err := MSDB.Where("id=?", id).First(&customer).Error
+	if err != nil && err != gorm.ErrRecordNotFound {
+		logs.Error("get customer error, err=%+v", err)
+	}
+	return &customer, err
+}
+
+// UsePayCounter 使用一个付款码时间窗口，每个窗口只能使用一次，返回是否使用成功
+func (dao *KroCustomerDao) UsePayCounter(customer *KroCustomer, counter int64) (bool, error) {
+	db := MSDB.Model(&KroCustomer{}).Where("id=? AND pay_counter<?", customer.ID, counter).Update("pay_counter", counter)
+	if db.Error != nil {
+		logs.Error("update customer pay counter error, err=%+v", db.Error)
+		return false, db.Error
+	}
+	return db.RowsAffected == 1, nil
+}
+
+func (dao *KroCustomerDao) GetCustomerByOpenID(openID string) (*KroCustomer, error) {
+	var customer KroCustomer
+	err := MSDB.Where("wx_openid=?", openID).First(&customer).Error
+	if err != nil && err != gorm.ErrRecordNotFound {
+		logs.Error("get customer error, err=%+v", err)
+	}
+	return &customer, err
+}
+
+// UpdateCustomerOpenID 绑定或解绑(openID 为空)用户的微信 openid，一个 openid 只能绑定一个用户
+func (dao *KroCustomerDao) UpdateCustomerOpenID(customer *KroCustomer, openID string) error {
+	tx := MSDB.Begin()
+	if openID != "" {
+		if err := tx.Model(&KroCustomer{}).Where("wx_openid=? AND id<>?", openID, customer.ID).Update("wx_openid", "").Error; err != nil {
+			tx.Rollback()
+			logs.Error("unbind customer openid error, err=%+v", err)
+			return err
+		}
+	}
+	if err := tx.Model(customer).Where("id=?", customer.ID).Update("wx_openid", openID).Error; err != nil {
+		tx.Rollback()
+		logs.Error("update customer openid error, err=%+v", err)
+		return err
+	}
+	return tx.Commit().Error
+}
@@ -0,0 +1,720 @@
+package model
+
+import (
+	"errors"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+// MemoryStore 内存实现的数据访问接口，行为与 gorm 实现保持一致，数据不持久化
+type MemoryStore struct {
+	mutex     sync.Mutex
+	itemLock  sync.Mutex // WithConfigLock 使用，fn 中会调用其他方法，不能与 mutex 共用
+	acctLock  sync.Mutex // WithAccountLock 使用，所有会员共用一把锁
+	nextID    int
+	customers []*KroCustomer
+	changes   []*KroCustomerChange
+	accounts  []*KroAccount
+	smsMsgs   []*SmsMsg
+	items     map[string]string
+	histories []*ConfigItemHistory
+	operators []*KroOperator
+	payOrders []*PayOrder
+	sessions  []*OperatorSession
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{items: make(map[string]string)}
+}
+
+func (m *MemoryStore) genID() int {
+	m.nextID++
+	return m.nextID
+}
+
+// AddOperator 添加操作员
+func (m *MemoryStore) AddOperator(operator *KroOperator) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	operator.ID = m.genID()
+	copied := *operator
+	m.operators = append(m.operators, &copied)
+}
+
+// ---- CustomerRepository ----
+
+func (m *MemoryStore) CreateCustomer(customer *KroCustomer) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if err := m.checkCustomerUnique(customer); err != nil {
+		return err
+	}
+	m.addCustomer(customer)
+	return nil
+}
+
+// checkCustomerUnique 与 cellphone、card_no 的唯一索引一致，调用方需持有 mutex
+func (m *MemoryStore) checkCustomerUnique(customer *KroCustomer) error {
+	for _, c := range m.customers {
+		if c.Cellphone == customer.Cellphone {
+			return errors.New("user exist")
+		}
+		if c.CustomerID == customer.CustomerID {
+			return errors.New("card no exist")
+		}
+	}
+	return nil
+}
+
+func (m *MemoryStore) addCustomer(customer *KroCustomer) {
+	customer.ID = m.genID()
+	copied := *customer
+	m.customers = append(m.customers, &copied)
+}
+
+func (m *MemoryStore) findCustomer(match func(c *KroCustomer) bool) (*KroCustomer, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	for _, c := range m.customers {
+		if match(c) {
+			copied := *c
+			return &copied, nil
+		}
+	}
+	return &KroCustomer{}, gorm.ErrRecordNotFound
+}
+
+func (m *MemoryStore) GetCustomerByCellphone(cellphone string) (*KroCustomer, error) {
+	return m.findCustomer(func(c *KroCustomer) bool { return c.Cellphone == cellphone })
+}
+
+func (m *MemoryStore) GetCustomerByID(id int) (*KroCustomer, error) {
+	return m.findCustomer(func(c *KroCustomer) bool { return c.ID == id })
+}
+
+func (m *MemoryStore) GetCustomerByOpenID(openID string) (*KroCustomer, error) {
+	return m.findCustomer(func(c *KroCustomer) bool { return c.WxOpenID == openID })
+}
+
+func (m *MemoryStore) GetCustomerByCardNo(cardNo string) (*KroCustomer, error) {
+	return m.findCustomer(func(c *KroCustomer) bool { return c.CustomerID == cardNo })
+}
+
+func (m *MemoryStore) ImportCustomers(imports []*CustomerImport) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	cells, cardNos := make(map[string]bool), make(map[string]bool)
+	for _, item := range imports {
+		if err := m.checkCustomerUnique(item.Customer); err != nil {
+			return err
+		}
+		if cells[item.Customer.Cellphone] || cardNos[item.Customer.CustomerID] {
+			return errors.New("duplicate customer in import")
+		}
+		cells[item.Customer.Cellphone], cardNos[item.Customer.CustomerID] = true, true
+	}
+	for _, item := range imports {
+		m.addCustomer(item.Customer)
+		if item.Opening != nil {
+			item.Opening.CustomerID = item.Customer.ID
+			copied := *item.Opening
+			m.accounts = append(m.accounts, &copied)
+		}
+	}
+	return nil
+}
+
+func (m *MemoryStore) ListCustomers(afterID, limit int) ([]*KroCustomer, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	customers := make([]*KroCustomer, 0)
+	for _, c := range m.customers {
+		if c.ID > afterID && len(customers) < limit {
+			copied := *c
+			customers = append(customers, &copied)
+		}
+	}
+	return customers, nil
+}
+
+func (m *MemoryStore) SearchCustomers(query *CustomerQuery) ([]*CustomerRow, int, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	stats := make(map[int]*CustomerRow)
+	for _, a := range m.accounts {
+		row, ok := stats[a.CustomerID]
+		if !ok {
+			row = &CustomerRow{}
+			stats[a.CustomerID] = row
+		}
+		if a.AccountType == AccountTypeRecharge || a.AccountType == AcccountTypeRefund || a.AccountType == AccountTypeOpening {
+			row.Balance += a.Amount
+		} else {
+			row.Balance -= a.Amount
+		}
+		if a.AccountType == AccountTypeCunsume && (row.LastVisit == nil || a.DealTime.After(*row.LastVisit)) {
+			dealTime := a.DealTime
+			row.LastVisit = &dealTime
+		}
+	}
+	keyword := strings.ToLower(query.Keyword)
+	rows := make([]*CustomerRow, 0)
+	for _, c := range m.customers {
+		row := &CustomerRow{KroCustomer: *c}
+		if s, ok := stats[c.ID]; ok {
+			row.Balance, row.LastVisit = s.Balance, s.LastVisit
+		}
+		switch {
+		case keyword != "" && !strings.Contains(c.Cellphone, keyword) && !strings.Contains(strings.ToLower(c.CustomerID), keyword) &&
+			!strings.Contains(strings.ToLower(c.Name), keyword) && !strings.Contains(c.NameInitials, keyword) && !strings.Contains(c.NamePinyin, keyword):
+		case query.Tag != "" && !strings.Contains(","+c.Tags+",", ","+query.Tag+","):
+		case query.MinBalance != nil && row.Balance < *query.MinBalance:
+		case query.MaxBalance != nil && row.Balance > *query.MaxBalance:
+		case query.InactiveSince != nil && row.LastVisit != nil && !row.LastVisit.Before(*query.InactiveSince):
+		default:
+			rows = append(rows, row)
+		}
+	}
+	less := func(i, j int) bool {
+		a, b := rows[i], rows[j]
+		switch query.Sort {
+		case CustomerSortLastVisit:
+			// 与 mysql 一致，没有消费过的会员排在升序的最前面
+			if (a.LastVisit == nil) != (b.LastVisit == nil) {
+				return a.LastVisit == nil
+			}
+			if a.LastVisit != nil && !a.LastVisit.Equal(*b.LastVisit) {
+				return a.LastVisit.Before(*b.LastVisit)
+			}
+		case CustomerSortBalance:
+			if a.Balance != b.Balance {
+				return a.Balance < b.Balance
+			}
+		default:
+			if !a.OpenDate.Equal(b.OpenDate) {
+				return a.OpenDate.Before(b.OpenDate)
+			}
+		}
+		return a.ID < b.ID
+	}
+	sort.SliceStable(rows, func(i, j int) bool {
+		if query.Desc {
+			return less(j, i)
+		}
+		return less(i, j)
+	})
+	total := len(rows)
+	if query.Offset >= len(rows) {
+		return make([]*CustomerRow, 0), total, nil
+	}
+	rows = rows[query.Offset:]
+	if query.Limit > 0 && len(rows) > query.Limit {
+		rows = rows[:query.Limit]
+	}
+	return rows, total, nil
+}
+
+func (m *MemoryStore) UpdateCustomerNotify(customer *KroCustomer, notifyOff bool) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	for _, c := range m.customers {
+		if c.ID == customer.ID {
+			c.NotifyOff = notifyOff
+		}
+	}
+	customer.NotifyOff = notifyOff
+	return nil
+}
+
+func (m *MemoryStore) UpdateCustomerOpenID(customer *KroCustomer, openID string) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	for _, c := range m.customers {
+		if c.ID == customer.ID {
+			c.WxOpenID = openID
+		} else if openID != "" && c.WxOpenID == openID {
+			c.WxOpenID = ""
+		}
+	}
+	customer.WxOpenID = openID
+	return nil
+}
+
+func (m *MemoryStore) UpdateCustomerProfile(customerID int, update ProfileUpdateFunc) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	var c *KroCustomer
+	for _, candidate := range m.customers {
+		if candidate.ID == customerID {
+			c = candidate
+		}
+	}
+	if c == nil {
+		return gorm.ErrRecordNotFound
+	}
+	current := *c
+	customer, changes, err := update(&current)
+	if err != nil || len(changes) == 0 {
+		return err
+	}
+	c.Name = customer.Name
+	c.Birthday = customer.Birthday
+	c.Gender = customer.Gender
+	c.Email = customer.Email
+	c.Notes = customer.Notes
+	c.Tags = customer.Tags
+	c.MarketingConsent = customer.MarketingConsent
+	c.NameInitials = customer.NameInitials
+	c.NamePinyin = customer.NamePinyin
+	for _, change := range changes {
+		change.ID = m.genID()
+		copied := *change
+		m.changes = append(m.changes, &copied)
+	}
+	return nil
+}
+
+func (m *MemoryStore) GetCustomerChanges(customerID, limit int) ([]*KroCustomerChange, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	changes := make([]*KroCustomerChange, 0)
+	for i := len(m.changes) - 1; i >= 0 && len(changes) < limit; i-- {
+		if m.changes[i].CustomerID == customerID {
+			copied := *m.changes[i]
+			changes = append(changes, &copied)
+		}
+	}
+	return changes, nil
+}
+
+func (m *MemoryStore) UsePayCounter(customer *KroCustomer, counter int64) (bool, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	for _, c := range m.customers {
+		if c.ID == customer.ID && c.PayCounter < counter {
+			c.PayCounter = counter
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// ---- AccountRepository ----
+
+func (m *MemoryStore) CreateNewAccount(account *KroAccount) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	copied := *account
+	m.accounts = append(m.accounts, &copied)
+	return nil
+}
+
+func (m *MemoryStore) GetCustomerAccounts(customer *KroCustomer) ([]*KroAccount, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	accounts := make([]*KroAccount, 0)
+	for i := len(m.accounts) - 1; i >= 0; i-- {
+		if m.accounts[i].CustomerID == customer.ID {
+			copied := *m.accounts[i]
+			accounts = append(accounts, &copied)
+		}
+	}
+	return accounts, nil
+}
+
+func (m *MemoryStore) GetAccountsByDealTime(start, end time.Time) ([]*KroAccount, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	accounts := make([]*KroAccount, 0)
+	for _, a := range m.accounts {
+		if !a.DealTime.Before(start) && a.DealTime.Before(end) {
+			copied := *a
+			accounts = append(accounts, &copied)
+		}
+	}
+	return accounts, nil
+}
+
+func (m *MemoryStore) GetTotalBalance() (int, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	total := 0
+	for _, a := range m.accounts {
+		if a.AccountType == AccountTypeRecharge || a.AccountType == AcccountTypeRefund || a.AccountType == AccountTypeOpening {
+			total += a.Amount
+		} else {
+			total -= a.Amount
+		}
+	}
+	return total, nil
+}
+
+func (m *MemoryStore) WithAccountLock(customer *KroCustomer, fn func() error) error {
+	m.acctLock.Lock()
+	defer m.acctLock.Unlock()
+	return fn()
+}
+
+// ---- SmsMsgRepository ----
+
+func (m *MemoryStore) AddSmsMsg(smsMsg *SmsMsg) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	smsMsg.ID = m.genID()
+	copied := *smsMsg
+	m.smsMsgs = append(m.smsMsgs, &copied)
+	return nil
+}
+
+func (m *MemoryStore) UpdateSmsMsg(smsMsg *SmsMsg) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	for i, msg := range m.smsMsgs {
+		if msg.ID == smsMsg.ID {
+			copied := *smsMsg
+			m.smsMsgs[i] = &copied
+			return nil
+		}
+	}
+	return gorm.ErrRecordNotFound
+}
+
+func (m *MemoryStore) MarkSmsUsed(smsMsg *SmsMsg) (bool, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	for _, msg := range m.smsMsgs {
+		if msg.ID == smsMsg.ID && !msg.Used {
+			msg.Used = true
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (m *MemoryStore) ClaimSmsMsg(smsMsg *SmsMsg) (bool, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	for _, msg := range m.smsMsgs {
+		if msg.ID == smsMsg.ID && msg.Status == SmsStatusQueued {
+			msg.Status = SmsStatusSending
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// findLatestSms 按 id 倒序查找第一条匹配的短信
+func (m *MemoryStore) findLatestSms(match func(msg *SmsMsg) bool) (*SmsMsg, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	for i := len(m.smsMsgs) - 1; i >= 0; i-- {
+		if match(m.smsMsgs[i]) {
+			copied := *m.smsMsgs[i]
+			return &copied, nil
+		}
+	}
+	return &SmsMsg{}, gorm.ErrRecordNotFound
+}
+
+func (m *MemoryStore) GetPhoneLatestSms(phone, purpose string) (*SmsMsg, error) {
+	return m.findLatestSms(func(msg *SmsMsg) bool { return msg.Cellphone == phone && msg.Purpose == purpose })
+}
+
+func (m *MemoryStore) GetSmsByMsgID(msgID string) (*SmsMsg, error) {
+	return m.findLatestSms(func(msg *SmsMsg) bool { return msg.MsgID == msgID })
+}
+
+func (m *MemoryStore) GetPhoneSmsList(phone string, limit int) ([]*SmsMsg, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	msgs := make([]*SmsMsg, 0)
+	for i := len(m.smsMsgs) - 1; i >= 0 && len(msgs) < limit; i-- {
+		if m.smsMsgs[i].Cellphone == phone {
+			copied := *m.smsMsgs[i]
+			msgs = append(msgs, &copied)
+		}
+	}
+	return msgs, nil
+}
+
+func (m *MemoryStore) GetRetrySmsList(since, now time.Time) ([]*SmsMsg, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	msgs := make([]*SmsMsg, 0)
+	for _, msg := range m.smsMsgs {
+		retry := msg.RetryCount > 0 && msg.SendTime.After(since)
+		due := msg.SendAfter != nil && !msg.SendAfter.After(now)
+		if msg.Status == SmsStatusQueued && (retry || due) {
+			copied := *msg
+			msgs = append(msgs, &copied)
+		}
+	}
+	return msgs, nil
+}
+
+// ---- ConfigItemRepository ----
+
+func (m *MemoryStore) GetConfigItem(key string) (string, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	value, ok := m.items[key]
+	if !ok {
+		return "", gorm.ErrRecordNotFound
+	}
+	return value, nil
+}
+
+func (m *MemoryStore) GetConfigItems(keys []string) (map[string]string, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	values := make(map[string]string)
+	for _, key := range keys {
+		if value, ok := m.items[key]; ok {
+			values[key] = value
+		}
+	}
+	return values, nil
+}
+
+func (m *MemoryStore) UpdateConfigItem(key, value string) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.items[key] = value
+	return nil
+}
+
+func (m *MemoryStore) SetConfigItemWithHistory(key, value, operator string) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	oldValue, ok := m.items[key]
+	if ok && oldValue == value {
+		return nil
+	}
+	m.items[key] = value
+	m.histories = append(m.histories, &ConfigItemHistory{
+		ID:         m.genID(),
+		Key:        key,
+		OldValue:   oldValue,
+		NewValue:   value,
+		Operator:   operator,
+		ChangeTime: time.Now(),
+	})
+	return nil
+}
+
+func (m *MemoryStore) GetConfigItemHistory(key string, limit int) ([]*ConfigItemHistory, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	histories := make([]*ConfigItemHistory, 0)
+	for i := len(m.histories) - 1; i >= 0 && len(histories) < limit; i-- {
+		if m.histories[i].Key == key {
+			copied := *m.histories[i]
+			histories = append(histories, &copied)
+		}
+	}
+	return histories, nil
+}
+
+func (m *MemoryStore) WithConfigLock(key string, fn func() error) error {
+	m.itemLock.Lock()
+	defer m.itemLock.Unlock()
+	return fn()
+}
+
+// ---- OperatorRepository ----
+
+func (m *MemoryStore) findOperator(match func(op *KroOperator) bool) (*KroOperator, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	for _, op := range m.operators {
+		if match(op) {
+			copied := *op
+			return &copied, nil
+		}
+	}
+	return nil, gorm.ErrRecordNotFound
+}
+
+func (m *MemoryStore) CheckOperatorByPwd(cellphone, pwd string) (*KroOperator, error) {
+	return m.findOperator(func(op *KroOperator) bool { return op.Cellphone == cellphone && op.Pwd == pwd })
+}
+
+func (m *MemoryStore) GetOperatorByCellphone(cellphone string) (*KroOperator, error) {
+	return m.findOperator(func(op *KroOperator) bool { return op.Cellphone == cellphone })
+}
+
+func (m *MemoryStore) CreateOperator(operator *KroOperator) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	for _, op := range m.operators {
+		if op.Cellphone == operator.Cellphone {
+			return ErrOperatorExist
+		}
+	}
+	operator.ID = m.genID()
+	copied := *operator
+	m.operators = append(m.operators, &copied)
+	return nil
+}
+
+func (m *MemoryStore) updateOperator(operator *KroOperator, update func(op *KroOperator)) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	for _, op := range m.operators {
+		if op.ID == operator.ID {
+			update(op)
+		}
+	}
+	update(operator)
+	return nil
+}
+
+func (m *MemoryStore) UpdateOperatorPwd(operator *KroOperator, pwd string) error {
+	return m.updateOperator(operator, func(op *KroOperator) { op.Pwd = pwd })
+}
+
+func (m *MemoryStore) UpdateOperatorDisabled(operator *KroOperator, disabled bool) error {
+	return m.updateOperator(operator, func(op *KroOperator) { op.Disabled = disabled })
+}
+
+func (m *MemoryStore) CreateOperatorSession(session *OperatorSession) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	session.ID = m.genID()
+	copied := *session
+	m.sessions = append(m.sessions, &copied)
+	return nil
+}
+
+func (m *MemoryStore) GetOperatorSession(tokenHash string) (*OperatorSession, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	for _, session := range m.sessions {
+		if session.TokenHash == tokenHash {
+			copied := *session
+			return &copied, nil
+		}
+	}
+	return &OperatorSession{}, gorm.ErrRecordNotFound
+}
+
+func (m *MemoryStore) deleteSessions(match func(session *OperatorSession) bool) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	kept := m.sessions[:0]
+	for _, session := range m.sessions {
+		if !match(session) {
+			kept = append(kept, session)
+		}
+	}
+	m.sessions = kept
+}
+
+func (m *MemoryStore) DeleteOperatorSession(tokenHash string) error {
+	m.deleteSessions(func(session *OperatorSession) bool { return session.TokenHash == tokenHash })
+	return nil
+}
+
+func (m *MemoryStore) DeleteOperatorSessions(cellphone string) error {
+	now := time.Now()
+	m.deleteSessions(func(session *OperatorSession) bool {
+		return session.OpCell == cellphone || session.ExpireTime.Before(now)
+	})
+	return nil
+}
+
+// ---- PayOrderRepository ----
+
+func (m *MemoryStore) CreatePayOrder(order *PayOrder) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	for _, o := range m.payOrders {
+		if o.OrderNo == order.OrderNo {
+			return errors.New("duplicate order no")
+		}
+	}
+	order.ID = m.genID()
+	copied := *order
+	m.payOrders = append(m.payOrders, &copied)
+	return nil
+}
+
+func (m *MemoryStore) getPayOrder(orderNo string) *PayOrder {
+	for _, o := range m.payOrders {
+		if o.OrderNo == orderNo {
+			return o
+		}
+	}
+	return nil
+}
+
+func (m *MemoryStore) GetPayOrder(orderNo string) (*PayOrder, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	o := m.getPayOrder(orderNo)
+	if o == nil {
+		return &PayOrder{}, gorm.ErrRecordNotFound
+	}
+	copied := *o
+	return &copied, nil
+}
+
+func (m *MemoryStore) MarkPaid(order *PayOrder, tradeNo string, paidTime time.Time) (bool, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	o := m.getPayOrder(order.OrderNo)
+	if o == nil || (o.Status != PayOrderPending && o.Status != PayOrderClosed) {
+		return false, nil
+	}
+	o.Status = PayOrderPaid
+	o.TradeNo = tradeNo
+	o.PaidTime = &paidTime
+	return true, nil
+}
+
+func (m *MemoryStore) MarkClosed(order *PayOrder) (bool, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	o := m.getPayOrder(order.OrderNo)
+	if o == nil || o.Status != PayOrderPending {
+		return false, nil
+	}
+	o.Status = PayOrderClosed
+	return true, nil
+}
+
+func (m *MemoryStore) CreditPayOrder(order *PayOrder, account *KroAccount) (bool, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	o := m.getPayOrder(order.OrderNo)
+	if o == nil || o.Status != PayOrderPaid {
+		return false, nil
+	}
+	creditTime := account.DealTime
+	o.Status = PayOrderCredited
+	o.CreditTime = &creditTime
+	copied := *account
+	m.accounts = append(m.accounts, &copied)
+	return true, nil
+}
+
+func (m *MemoryStore) GetPayOrdersByStatus(status string, before time.Time, limit int) ([]*PayOrder, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	orders := make([]*PayOrder, 0)
+	for _, o := range m.payOrders {
+		if o.Status == status && o.CreateTime.Before(before) {
+			copied := *o
+			orders = append(orders, &copied)
+		}
+	}
+	sort.Slice(orders, func(i, j int) bool { return orders[i].ID < orders[j].ID })
+	if len(orders) > limit {
+		orders = orders[:limit]
+	}
+	return orders, nil
+}
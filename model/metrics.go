@@ -0,0 +1,43 @@
+package model
+
+import (
+	"time"
+
+	"code.bean.com/flamingo/metrics"
+	"github.com/jinzhu/gorm"
+)
+
+const metricsStartKey = "metrics:start_time"
+
+// registerMetricsCallbacks 通过 gorm 回调记录每次数据库操作的耗时，Exec 执行的语句不经过回调
+func registerMetricsCallbacks(db *gorm.DB) {
+	callback := db.Callback()
+	callback.Create().Before("gorm:create").Register("metrics:before_create", beforeQuery)
+	callback.Create().After("gorm:create").Register("metrics:after_create", afterQuery("create"))
+	callback.Query().Before("gorm:query").Register("metrics:before_query", beforeQuery)
+	callback.Query().After("gorm:query").Register("metrics:after_query", afterQuery("query"))
+	callback.Update().Before("gorm:update").Register("metrics:before_update", beforeQuery)
+	callback.Update().After("gorm:update").Register("metrics:after_update", afterQuery("update"))
+	callback.Delete().Before("gorm:delete").Register("metrics:before_delete", beforeQuery)
+	callback.Delete().After("gorm:delete").Register("metrics:after_delete", afterQuery("delete"))
+	callback.RowQuery().Before("gorm:row_query").Register("metrics:before_row_query", beforeQuery)
+	callback.RowQuery().After("gorm:row_query").Register("metrics:after_row_query", afterQuery("row_query"))
+}
+
+func beforeQuery(scope *gorm.Scope) {
+	scope.InstanceSet(metricsStartKey, time.Now())
+}
+
+func afterQuery(operation string) func(scope *gorm.Scope) {
+	return func(scope *gorm.Scope) {
+		v, ok := scope.InstanceGet(metricsStartKey)
+		if !ok {
+			return
+		}
+		table := "raw"
+		if scope.Value != nil {
+			table = scope.TableName()
+		}
+		metrics.ObserveDB(operation, table, time.Since(v.(time.Time)))
+	}
+}
@@ -0,0 +1,283 @@
+package model
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"code.byted.org/gopkg/logs"
+	"github.com/jinzhu/gorm"
+)
+
+const migrateLockName = "flamingo_migrate"
+
+// Migration 一次数据库结构变更，Version 递增且不能修改已发布的 migration
+type Migration struct {
+	Version int
+	Name    string
+	Up      func(db *gorm.DB) error
+	Down    func(db *gorm.DB) error
+}
+
+// SchemaMigration 已执行的 migration 记录
+type SchemaMigration struct {
+	Version   int       `gorm:"column:version"`
+	Name      string    `gorm:"column:name"`
+	AppliedAt time.Time `gorm:"column:applied_at"`
+}
+
+func (SchemaMigration) TableName() string {
+	return "schema_migrations"
+}
+
+// MigrationState migration 的执行状态
+type MigrationState struct {
+	Version   int
+	Name      string
+	Applied   bool
+	AppliedAt time.Time
+}
+
+// SQLMigration 用 sql 语句定义 migration，多条语句用分号分隔
+func SQLMigration(version int, name, up, down string) *Migration {
+	return &Migration{
+		Version: version,
+		Name:    name,
+		Up:      func(db *gorm.DB) error { return execSQL(db, up) },
+		Down:    func(db *gorm.DB) error { return execSQL(db, down) },
+	}
+}
+
+func execSQL(db *gorm.DB, sql string) error {
+	for _, stmt := range strings.Split(sql, ";") {
+		if strings.TrimSpace(stmt) == "" {
+			continue
+		}
+		if err := db.Exec(stmt).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Migrate 执行版本不大于 target 的未执行 migration，target 小于 0 时执行全部
+func Migrate(target int) error {
+	return withMigrateLock(func() error {
+		applied, err := appliedMigrations()
+		if err != nil {
+			return err
+		}
+		for _, m := range sortedMigrations() {
+			if _, ok := applied[m.Version]; ok || (target >= 0 && m.Version > target) {
+				continue
+			}
+			logs.Info("apply migration %d %s", m.Version, m.Name)
+			// mysql 的 DDL 会隐式提交，无法放在事务中，失败时需要人工检查
+			if err := m.Up(MSDB); err != nil {
+				return fmt.Errorf("apply migration %d %s error: %v", m.Version, m.Name, err)
+			}
+			record := &SchemaMigration{Version: m.Version, Name: m.Name, AppliedAt: time.Now()}
+			if err := MSDB.Create(record).Error; err != nil {
+				return fmt.Errorf("record migration %d error: %v", m.Version, err)
+			}
+		}
+		return nil
+	})
+}
+
+// Rollback 按版本倒序回滚最近执行的 steps 个 migration
+func Rollback(steps int) error {
+	return withMigrateLock(func() error {
+		applied, err := appliedMigrations()
+		if err != nil {
+			return err
+		}
+		migrations := sortedMigrations()
+		for i := len(migrations) - 1; i >= 0 && steps > 0; i-- {
+			m := migrations[i]
+			if _, ok := applied[m.Version]; !ok {
+				continue
+			}
+			if m.Down == nil {
+				return fmt.Errorf("migration %d %s can not be rolled back", m.Version, m.Name)
+			}
+			logs.Info("rollback migration %d %s", m.Version, m.Name)
+			if err := m.Down(MSDB); err != nil {
+				return fmt.Errorf("rollback migration %d %s error: %v", m.Version, m.Name, err)
+			}
+			if err := MSDB.Where("version=?", m.Version).Delete(&SchemaMigration{}).Error; err != nil {
+				return fmt.Errorf("delete migration record %d error: %v", m.Version, err)
+			}
+			steps--
+		}
+		return nil
+	})
+}
+
+// MigrationStatus 所有 migration 的执行状态
+func MigrationStatus() ([]*MigrationState, error) {
+	if err := createMigrationsTable(); err != nil {
+		return nil, err
+	}
+	applied, err := appliedMigrations()
+	if err != nil {
+		return nil, err
+	}
+	states := make([]*MigrationState, 0, len(migrations))
+	for _, m := range sortedMigrations() {
+		state := &MigrationState{Version: m.Version, Name: m.Name}
+		if record, ok := applied[m.Version]; ok {
+			state.Applied = true
+			state.AppliedAt = record.AppliedAt
+		}
+		states = append(states, state)
+	}
+	return states, nil
+}
+
+// PendingMigrations 未执行的 migration 数量
+func PendingMigrations() (int, error) {
+	states, err := MigrationStatus()
+	if err != nil {
+		return 0, err
+	}
+	pending := 0
+	for _, state := range states {
+		if !state.Applied {
+			pending++
+		}
+	}
+	return pending, nil
+}
+
+func sortedMigrations() []*Migration {
+	sorted := make([]*Migration, len(migrations))
+	copy(sorted, migrations)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Version < sorted[j].Version })
+	return sorted
+}
+
+func appliedMigrations() (map[int]*SchemaMigration, error) {
+	records := make([]*SchemaMigration, 0)
+	if err := MSDB.Find(&records).Error; err != nil {
+		return nil, err
+	}
+	applied := make(map[int]*SchemaMigration, len(records))
+	for _, r := range records {
+		applied[r.Version] = r
+	}
+	return applied, nil
+}
+
+func createMigrationsTable() error {
+	return MSDB.Exec(`CREATE TABLE IF NOT EXISTS schema_migrations (
+		version INT NOT NULL PRIMARY KEY,
+		name VARCHAR(128) NOT NULL,
+		applied_at DATETIME NOT NULL
+	) ENGINE=InnoDB DEFAULT CHARSET=utf8`).Error
+}
+
+// withMigrateLock 使用 mysql 命名锁避免多个实例同时执行 migration
+func withMigrateLock(fn func() error) error {
+	if MSDB == nil {
+		return errors.New("database not initialized")
+	}
+	if err := createMigrationsTable(); err != nil {
+		return err
+	}
+	err := withNamedLock(migrateLockName, 30, fn)
+	if err == errLockTimeout {
+		return errors.New("another migration is running")
+	}
+	return err
+}
+
+var errLockTimeout = errors.New("get lock timeout")
+
+// withNamedLock 持有 mysql 命名锁执行 fn，等待超过 timeout 秒返回 errLockTimeout
+// 锁和连接绑定，需要单独占用一个连接
+func withNamedLock(name string, timeout int, fn func() error) error {
+	ctx := context.Background()
+	conn, err := MSDB.DB().Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	var locked int
+	if err := conn.QueryRowContext(ctx, "SELECT GET_LOCK(?, ?)", name, timeout).Scan(&locked); err != nil {
+		return err
+	}
+	if locked != 1 {
+		return errLockTimeout
+	}
+	defer conn.ExecContext(ctx, "SELECT RELEASE_LOCK(?)", name)
+	return fn()
+}
+
+// columnExists 用于兼容手工建表的库，列已存在时跳过
+func columnExists(db *gorm.DB, table, column string) (bool, error) {
+	var n int
+	err := db.Raw("SELECT COUNT(*) FROM information_schema.columns WHERE table_schema=DATABASE() AND table_name=? AND column_name=?",
+		table, column).Row().Scan(&n)
+	return n > 0, err
+}
+
+func indexExists(db *gorm.DB, table, index string) (bool, error) {
+	var n int
+	err := db.Raw("SELECT COUNT(*) FROM information_schema.statistics WHERE table_schema=DATABASE() AND table_name=? AND index_name=?",
+		table, index).Row().Scan(&n)
+	return n > 0, err
+}
+
+// addColumns 添加不存在的列，columns 中每一项为 [列名, 列定义]
+func addColumns(db *gorm.DB, table string, columns [][2]string) error {
+	for _, col := range columns {
+		exists, err := columnExists(db, table, col[0])
+		if err != nil {
+			return err
+		}
+		if exists {
+			continue
+		}
+		if err := db.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN `%s` %s", table, col[0], col[1])).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func dropColumns(db *gorm.DB, table string, columns ...string) error {
+	for _, col := range columns {
+		exists, err := columnExists(db, table, col)
+		if err != nil {
+			return err
+		}
+		if !exists {
+			continue
+		}
+		if err := db.Exec(fmt.Sprintf("ALTER TABLE %s DROP COLUMN `%s`", table, col)).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// addIndex 创建不存在的索引，definition 如 "UNIQUE INDEX uk_cellphone (cellphone)"
+func addIndex(db *gorm.DB, table, index, definition string) error {
+	exists, err := indexExists(db, table, index)
+	if err != nil || exists {
+		return err
+	}
+	return db.Exec(fmt.Sprintf("ALTER TABLE %s ADD %s", table, definition)).Error
+}
+
+func dropIndex(db *gorm.DB, table, index string) error {
+	exists, err := indexExists(db, table, index)
+	if err != nil || !exists {
+		return err
+	}
+	return db.Exec(fmt.Sprintf("ALTER TABLE %s DROP INDEX %s", table, index)).Error
+}
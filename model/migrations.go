@@ -0,0 +1,362 @@
+package model
+
+import (
+	"fmt"
+
+	"code.bean.com/flamingo/util"
+	"github.com/jinzhu/gorm"
+)
+
+// migrations 所有的数据库结构变更，表名为 gorm 默认的复数形式
+// 早期的表是手工创建的，前几个 migration 只补充缺少的表、列和索引，可以在已有的库上执行
+var migrations = []*Migration{
+	{
+		Version: 1,
+		Name:    "create_base_tables",
+		Up: func(db *gorm.DB) error {
+			err := execSQL(db, `
+CREATE TABLE IF NOT EXISTS kro_customers (
+	id INT NOT NULL AUTO_INCREMENT PRIMARY KEY,
+	card_no VARCHAR(32) NOT NULL DEFAULT '',
+	name VARCHAR(64) NOT NULL DEFAULT '',
+	cellphone VARCHAR(20) NOT NULL,
+	open_date DATETIME NULL
+) ENGINE=InnoDB DEFAULT CHARSET=utf8;
+CREATE TABLE IF NOT EXISTS kro_accounts (
+	id INT NOT NULL AUTO_INCREMENT PRIMARY KEY,
+	customer_id INT NOT NULL,
+	account_type VARCHAR(16) NOT NULL,
+	amount INT NOT NULL,
+	deal_time DATETIME NOT NULL,
+	`+"`desc`"+` VARCHAR(255) NOT NULL DEFAULT '',
+	operator VARCHAR(20) NOT NULL DEFAULT '',
+	operator_name VARCHAR(64) NOT NULL DEFAULT ''
+) ENGINE=InnoDB DEFAULT CHARSET=utf8;
+CREATE TABLE IF NOT EXISTS kro_operators (
+	id INT NOT NULL AUTO_INCREMENT PRIMARY KEY,
+	cellphone VARCHAR(20) NOT NULL,
+	name VARCHAR(64) NOT NULL DEFAULT '',
+	pwd VARCHAR(128) NOT NULL DEFAULT ''
+) ENGINE=InnoDB DEFAULT CHARSET=utf8;
+CREATE TABLE IF NOT EXISTS sms_msgs (
+	id INT NOT NULL AUTO_INCREMENT PRIMARY KEY,
+	customer_id INT NOT NULL DEFAULT 0,
+	cellphone VARCHAR(20) NOT NULL,
+	code VARCHAR(16) NOT NULL DEFAULT '',
+	send_time DATETIME NOT NULL
+) ENGINE=InnoDB DEFAULT CHARSET=utf8;
+CREATE TABLE IF NOT EXISTS config_items (
+	id INT NOT NULL AUTO_INCREMENT PRIMARY KEY,
+	key_field VARCHAR(64) NOT NULL,
+	value_field TEXT
+) ENGINE=InnoDB DEFAULT CHARSET=utf8`)
+			if err != nil {
+				return err
+			}
+			indexes := [][3]string{
+				{"kro_customers", "uk_cellphone", "UNIQUE INDEX uk_cellphone (cellphone)"},
+				{"kro_accounts", "idx_customer_id", "INDEX idx_customer_id (customer_id)"},
+				{"kro_operators", "uk_cellphone", "UNIQUE INDEX uk_cellphone (cellphone)"},
+				{"sms_msgs", "idx_cellphone", "INDEX idx_cellphone (cellphone)"},
+				{"sms_msgs", "idx_customer_id", "INDEX idx_customer_id (customer_id)"},
+				{"config_items", "uk_key_field", "UNIQUE INDEX uk_key_field (key_field)"},
+			}
+			for _, idx := range indexes {
+				if err := addIndex(db, idx[0], idx[1], idx[2]); err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+		Down: func(db *gorm.DB) error {
+			return execSQL(db, "DROP TABLE IF EXISTS config_items; DROP TABLE IF EXISTS sms_msgs; DROP TABLE IF EXISTS kro_operators; DROP TABLE IF EXISTS kro_accounts; DROP TABLE IF EXISTS kro_customers")
+		},
+	},
+	{
+		Version: 2,
+		Name:    "sms_delivery_status",
+		Up: func(db *gorm.DB) error {
+			err := addColumns(db, "sms_msgs", [][2]string{
+				{"purpose", "VARCHAR(16) NOT NULL DEFAULT 'login'"},
+				{"tpl_id", "INT NOT NULL DEFAULT 0"},
+				{"params", "VARCHAR(512) NOT NULL DEFAULT ''"},
+				{"amount", "INT NOT NULL DEFAULT 0"},
+				{"used", "TINYINT(1) NOT NULL DEFAULT 0"},
+				{"msg_id", "VARCHAR(64) NOT NULL DEFAULT ''"},
+				{"status", "VARCHAR(16) NOT NULL DEFAULT 'sent'"},
+				{"err_msg", "VARCHAR(255) NOT NULL DEFAULT ''"},
+				{"retry_count", "INT NOT NULL DEFAULT 0"},
+				{"report_time", "DATETIME NULL"},
+			})
+			if err != nil {
+				return err
+			}
+			if err := addIndex(db, "sms_msgs", "idx_msg_id", "INDEX idx_msg_id (msg_id)"); err != nil {
+				return err
+			}
+			return addIndex(db, "sms_msgs", "idx_status_send_time", "INDEX idx_status_send_time (status, send_time)")
+		},
+		Down: func(db *gorm.DB) error {
+			if err := dropIndex(db, "sms_msgs", "idx_msg_id"); err != nil {
+				return err
+			}
+			if err := dropIndex(db, "sms_msgs", "idx_status_send_time"); err != nil {
+				return err
+			}
+			return dropColumns(db, "sms_msgs", "purpose", "tpl_id", "params", "amount", "used", "msg_id", "status", "err_msg", "retry_count", "report_time")
+		},
+	},
+	{
+		Version: 3,
+		Name:    "customer_wechat_and_notify",
+		Up: func(db *gorm.DB) error {
+			err := addColumns(db, "kro_customers", [][2]string{
+				{"wx_openid", "VARCHAR(64) NOT NULL DEFAULT ''"},
+				{"notify_off", "TINYINT(1) NOT NULL DEFAULT 0"},
+				{"pay_counter", "BIGINT NOT NULL DEFAULT 0"},
+			})
+			if err != nil {
+				return err
+			}
+			// 未绑定的用户 wx_openid 为空，唯一性由 UpdateCustomerOpenID 保证
+			return addIndex(db, "kro_customers", "idx_wx_openid", "INDEX idx_wx_openid (wx_openid)")
+		},
+		Down: func(db *gorm.DB) error {
+			if err := dropIndex(db, "kro_customers", "idx_wx_openid"); err != nil {
+				return err
+			}
+			return dropColumns(db, "kro_customers", "wx_openid", "notify_off", "pay_counter")
+		},
+	},
+	{
+		Version: 4,
+		Name:    "account_pay_method",
+		Up: func(db *gorm.DB) error {
+			err := addColumns(db, "kro_accounts", [][2]string{
+				{"pay_method", "VARCHAR(16) NOT NULL DEFAULT ''"},
+				{"pay_ref", "VARCHAR(64) NOT NULL DEFAULT ''"},
+				{"tendered", "INT NOT NULL DEFAULT 0"},
+			})
+			if err != nil {
+				return err
+			}
+			return addIndex(db, "kro_accounts", "idx_deal_time", "INDEX idx_deal_time (deal_time)")
+		},
+		Down: func(db *gorm.DB) error {
+			if err := dropIndex(db, "kro_accounts", "idx_deal_time"); err != nil {
+				return err
+			}
+			return dropColumns(db, "kro_accounts", "pay_method", "pay_ref", "tendered")
+		},
+	},
+	SQLMigration(5, "create_pay_orders", `
+CREATE TABLE IF NOT EXISTS pay_orders (
+	id INT NOT NULL AUTO_INCREMENT PRIMARY KEY,
+	order_no VARCHAR(32) NOT NULL,
+	customer_id INT NOT NULL,
+	amount INT NOT NULL,
+	gateway VARCHAR(16) NOT NULL,
+	trade_type VARCHAR(16) NOT NULL,
+	status VARCHAR(16) NOT NULL,
+	trade_no VARCHAR(64) NOT NULL DEFAULT '',
+	create_time DATETIME NOT NULL,
+	expire_time DATETIME NOT NULL,
+	paid_time DATETIME NULL,
+	credit_time DATETIME NULL,
+	UNIQUE INDEX uk_order_no (order_no),
+	INDEX idx_customer_id (customer_id),
+	INDEX idx_status_create_time (status, create_time)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8`,
+		"DROP TABLE IF EXISTS pay_orders"),
+	SQLMigration(6, "create_config_item_history", `
+CREATE TABLE IF NOT EXISTS config_item_history (
+	id INT NOT NULL AUTO_INCREMENT PRIMARY KEY,
+	key_field VARCHAR(64) NOT NULL,
+	old_value TEXT,
+	new_value TEXT,
+	operator VARCHAR(20) NOT NULL DEFAULT '',
+	change_time DATETIME NOT NULL,
+	INDEX idx_key_field_change_time (key_field, change_time)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8`,
+		"DROP TABLE IF EXISTS config_item_history"),
+	{
+		Version: 7,
+		Name:    "operator_disabled",
+		Up: func(db *gorm.DB) error {
+			return addColumns(db, "kro_operators", [][2]string{
+				{"disabled", "TINYINT(1) NOT NULL DEFAULT 0"},
+			})
+		},
+		Down: func(db *gorm.DB) error {
+			return dropColumns(db, "kro_operators", "disabled")
+		},
+	},
+	{
+		Version: 8,
+		Name:    "customer_profile",
+		Up: func(db *gorm.DB) error {
+			err := addColumns(db, "kro_customers", [][2]string{
+				{"birthday", "DATE NULL"},
+				{"gender", "VARCHAR(8) NOT NULL DEFAULT ''"},
+				{"email", "VARCHAR(64) NOT NULL DEFAULT ''"},
+				{"notes", "VARCHAR(512) NOT NULL DEFAULT ''"},
+				{"tags", "VARCHAR(255) NOT NULL DEFAULT ''"},
+				{"marketing_consent", "TINYINT(1) NOT NULL DEFAULT 0"},
+			})
+			if err != nil {
+				return err
+			}
+			return execSQL(db, `
+CREATE TABLE IF NOT EXISTS kro_customer_changes (
+	id INT NOT NULL AUTO_INCREMENT PRIMARY KEY,
+	customer_id INT NOT NULL,
+	field VARCHAR(32) NOT NULL,
+	old_value VARCHAR(512) NOT NULL DEFAULT '',
+	new_value VARCHAR(512) NOT NULL DEFAULT '',
+	source VARCHAR(16) NOT NULL,
+	operator VARCHAR(20) NOT NULL DEFAULT '',
+	change_time DATETIME NOT NULL,
+	INDEX idx_customer_id (customer_id)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8`)
+		},
+		Down: func(db *gorm.DB) error {
+			if err := execSQL(db, "DROP TABLE IF EXISTS kro_customer_changes"); err != nil {
+				return err
+			}
+			return dropColumns(db, "kro_customers", "birthday", "gender", "email", "notes", "tags", "marketing_consent")
+		},
+	},
+	{
+		Version: 9,
+		Name:    "customer_search",
+		Up: func(db *gorm.DB) error {
+			err := addColumns(db, "kro_customers", [][2]string{
+				{"name_initials", "VARCHAR(64) NOT NULL DEFAULT ''"},
+			})
+			if err != nil {
+				return err
+			}
+			if err := addIndex(db, "kro_customers", "idx_open_date", "INDEX idx_open_date (open_date)"); err != nil {
+				return err
+			}
+			return backfillNameInitials(db)
+		},
+		Down: func(db *gorm.DB) error {
+			if err := dropIndex(db, "kro_customers", "idx_open_date"); err != nil {
+				return err
+			}
+			return dropColumns(db, "kro_customers", "name_initials")
+		},
+	},
+	{
+		// 姓名中有拼音表之外的汉字时不再生成不完整的首字母，重新生成已有会员的首字母
+		Version: 10,
+		Name:    "recompute_name_initials",
+		Up:      backfillNameInitials,
+		Down:    func(db *gorm.DB) error { return nil },
+	},
+	{
+		// 拼音表扩充到全部常用汉字并区分姓氏读音，增加全拼用于搜索，重新生成已有会员的首字母和全拼
+		Version: 11,
+		Name:    "customer_name_pinyin",
+		Up: func(db *gorm.DB) error {
+			err := addColumns(db, "kro_customers", [][2]string{
+				{"name_pinyin", "VARCHAR(255) NOT NULL DEFAULT ''"},
+			})
+			if err != nil {
+				return err
+			}
+			return backfillNamePinyin(db)
+		},
+		Down: func(db *gorm.DB) error {
+			return dropColumns(db, "kro_customers", "name_pinyin")
+		},
+	},
+	{
+		Version: 12,
+		Name:    "sms_send_after",
+		Up: func(db *gorm.DB) error {
+			err := addColumns(db, "sms_msgs", [][2]string{
+				{"send_after", "DATETIME NULL"},
+			})
+			if err != nil {
+				return err
+			}
+			return addIndex(db, "sms_msgs", "idx_status_send_after", "INDEX idx_status_send_after (status, send_after)")
+		},
+		Down: func(db *gorm.DB) error {
+			if err := dropIndex(db, "sms_msgs", "idx_status_send_after"); err != nil {
+				return err
+			}
+			return dropColumns(db, "sms_msgs", "send_after")
+		},
+	},
+	{
+		// 已有重复卡号时迁移失败，需要先手工修正重复的卡号
+		Version: 13,
+		Name:    "customer_card_no_unique",
+		Up: func(db *gorm.DB) error {
+			var dup struct {
+				CardNo string
+				N      int
+			}
+			err := db.Raw("SELECT card_no, COUNT(*) AS n FROM kro_customers GROUP BY card_no HAVING n > 1 LIMIT 1").Scan(&dup).Error
+			if err != nil && err != gorm.ErrRecordNotFound {
+				return err
+			}
+			if dup.N > 1 {
+				return fmt.Errorf("duplicate card_no %q in kro_customers, fix it before adding unique index", dup.CardNo)
+			}
+			return addIndex(db, "kro_customers", "uk_card_no", "UNIQUE INDEX uk_card_no (card_no)")
+		},
+		Down: func(db *gorm.DB) error {
+			return dropIndex(db, "kro_customers", "uk_card_no")
+		},
+	},
+	SQLMigration(14, "create_operator_sessions", `
+CREATE TABLE IF NOT EXISTS operator_sessions (
+	id INT NOT NULL AUTO_INCREMENT PRIMARY KEY,
+	token_hash CHAR(64) NOT NULL,
+	op_cell VARCHAR(20) NOT NULL,
+	create_time DATETIME NOT NULL,
+	expire_time DATETIME NOT NULL,
+	UNIQUE INDEX uk_token_hash (token_hash),
+	INDEX idx_op_cell (op_cell)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8`,
+		"DROP TABLE IF EXISTS operator_sessions"),
+}
+
+// backfillNameInitials 为已有会员生成姓名的拼音首字母
+func backfillNameInitials(db *gorm.DB) error {
+	return backfillCustomerNames(db, func(name string) map[string]interface{} {
+		return map[string]interface{}{"name_initials": util.PinyinInitials(name)}
+	})
+}
+
+// backfillNamePinyin 为已有会员生成姓名的拼音首字母和全拼
+func backfillNamePinyin(db *gorm.DB) error {
+	return backfillCustomerNames(db, func(name string) map[string]interface{} {
+		return map[string]interface{}{"name_initials": util.PinyinInitials(name), "name_pinyin": util.Pinyin(name)}
+	})
+}
+
+// backfillCustomerNames 按 id 分页遍历已有会员，用 fields 根据姓名计算出的字段更新会员
+func backfillCustomerNames(db *gorm.DB, fields func(name string) map[string]interface{}) error {
+	const pageSize = 500
+	afterID := 0
+	for {
+		customers := make([]*KroCustomer, 0)
+		if err := db.Select("id, name").Where("id>?", afterID).Order("id").Limit(pageSize).Find(&customers).Error; err != nil {
+			return err
+		}
+		for _, c := range customers {
+			if err := db.Model(&KroCustomer{}).Where("id=?", c.ID).Updates(fields(c.Name)).Error; err != nil {
+				return err
+			}
+			afterID = c.ID
+		}
+		if len(customers) < pageSize {
+			return nil
+		}
+	}
+}
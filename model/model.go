@@ -1,6 +1,8 @@
 package model
 
 import (
+	"context"
+	"errors"
 	"fmt"
 
 	"code.bean.com/flamingo/config"
@@ -18,17 +20,31 @@ func Init() error {
 }
 
 func initMYSQL() error {
-	username, _ := config.ConfigJson.Get("flamingo_db").Get("username").String()
-	pwd, _ := config.ConfigJson.Get("flamingo_db").Get("password").String()
-	host, _ := config.ConfigJson.Get("flamingo_db").Get("host").String()
-	database, _ := config.ConfigJson.Get("flamingo_db").Get("database").String()
-	dbCon := fmt.Sprintf("%s:%s@tcp(%s)/%s?charset=utf8&parseTime=True&loc=Local", username, pwd, host, database)
-	db, err := gorm.Open("mysql", dbCon)
+	db := config.ConfigInstance.DB
+	dbCon := fmt.Sprintf("%s:%s@tcp(%s)/%s?charset=utf8&parseTime=True&loc=Local", db.Username, db.Password, db.Host, db.Database)
+	conn, err := gorm.Open("mysql", dbCon)
 	if err != nil {
 		logs.Info("init mysql error:%+v", err)
 		return err
 	}
-	MSDB = db
+	registerMetricsCallbacks(conn)
+	MSDB = conn
 	logs.Info("connect mysql success!!")
 	return nil
 }
+
+// Ping 检查数据库连接是否可用
+func Ping(ctx context.Context) error {
+	if MSDB == nil {
+		return errors.New("database not initialized")
+	}
+	return MSDB.DB().PingContext(ctx)
+}
+
+// Close 关闭数据库连接，在服务退出时调用
+func Close() error {
+	if MSDB == nil {
+		return nil
+	}
+	return MSDB.Close()
+}
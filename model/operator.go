@@ -1,17 +1,38 @@
 package model
 
 import (
+	"errors"
+	"sync"
+
 	"code.byted.org/gopkg/logs"
+	"github.com/jinzhu/gorm"
 )
 
+// ErrOperatorExist 操作员手机号已存在
+var ErrOperatorExist = errors.New("operator exist")
+
 type KroOperator struct {
 	ID        int    `gorm:"column:id" json:"-"`
 	Cellphone string `gorm:"column:cellphone" json:"cellphone"`
 	Name      string `gorm:"column:name" json:"name"`
 	Pwd       string `gorm:"column:pwd" json:"-"`
+	Disabled  bool   `gorm:"column:disabled" json:"disabled"`
+}
+
+type OperatorDao struct{}
+
+var operatorDao *OperatorDao
+var operatorDaoOnce sync.Once
+
+func OperatorDaoInstance() *OperatorDao {
+	operatorDaoOnce.Do(
+		func() {
+			operatorDao = &OperatorDao{}
+		})
+	return operatorDao
 }
 
-func CheckOperatorByPwd(cellphone, pwd string) (*KroOperator, error) {
+func (dao *OperatorDao) CheckOperatorByPwd(cellphone, pwd string) (*KroOperator, error) {
 	var operator KroOperator
 	err := MSDB.Where("cellphone=? AND pwd=?", cellphone, pwd).First(&operator).Error
 	if err != nil {
@@ -22,7 +43,7 @@ func CheckOperatorByPwd(cellphone, pwd string) (*KroOperator, error) {
 	return &operator, nil
 }
 
-func GetOperatorByCellphone(cellphone string) (*KroOperator, error) {
+func (dao *OperatorDao) GetOperatorByCellphone(cellphone string) (*KroOperator, error) {
 	var operator KroOperator
 	err := MSDB.Where("cellphone=?", cellphone).First(&operator).Error
 	if err != nil {
@@ -32,3 +53,38 @@ func GetOperatorByCellphone(cellphone string) (*KroOperator, error) {
 
 	return &operator, nil
 }
+
+// CreateOperator 新增操作员，手机号已存在时返回 ErrOperatorExist
+func (dao *OperatorDao) CreateOperator(operator *KroOperator) error {
+	err := MSDB.Where("cellphone=?", operator.Cellphone).First(&KroOperator{}).Error
+	if err != nil && err != gorm.ErrRecordNotFound {
+		logs.Error("get operator error, err=%+v", err)
+		return err
+	}
+	if err == nil {
+		return ErrOperatorExist
+	}
+	err = MSDB.Create(operator).Error
+	if err != nil {
+		logs.Error("create operator error, err=%+v", err)
+	}
+	return err
+}
+
+// UpdateOperatorPwd 修改操作员密码
+func (dao *OperatorDao) UpdateOperatorPwd(operator *KroOperator, pwd string) error {
+	err := MSDB.Model(operator).Where("id=?", operator.ID).Update("pwd", pwd).Error
+	if err != nil {
+		logs.Error("update operator pwd error, err=%+v", err)
+	}
+	return err
+}
+
+// UpdateOperatorDisabled 停用或启用操作员
+func (dao *OperatorDao) UpdateOperatorDisabled(operator *KroOperator, disabled bool) error {
+	err := MSDB.Model(operator).Where("id=?", operator.ID).Update("disabled", disabled).Error
+	if err != nil {
+		logs.Error("update operator disabled error, err=%+v", err)
+	}
+	return err
+}
@@ -0,0 +1,50 @@
+package model
+
+import (
+	"time"
+
+	"code.byted.org/gopkg/logs"
+)
+
+// OperatorSession 操作员的 bearer token 会话，只保存 token 的 sha256，数据库泄露时不能直接用来登录
+type OperatorSession struct {
+	ID         int       `gorm:"column:id"`
+	TokenHash  string    `gorm:"column:token_hash"`
+	OpCell     string    `gorm:"column:op_cell"`
+	CreateTime time.Time `gorm:"column:create_time"`
+	ExpireTime time.Time `gorm:"column:expire_time"`
+}
+
+// CreateOperatorSession 保存新的会话
+func (dao *OperatorDao) CreateOperatorSession(session *OperatorSession) error {
+	err := MSDB.Create(session).Error
+	if err != nil {
+		logs.Error("create operator session error, err=%+v", err)
+	}
+	return err
+}
+
+// GetOperatorSession 根据 token 的哈希查询会话，不判断是否过期
+func (dao *OperatorDao) GetOperatorSession(tokenHash string) (*OperatorSession, error) {
+	var session OperatorSession
+	err := MSDB.Where("token_hash=?", tokenHash).First(&session).Error
+	return &session, err
+}
+
+// DeleteOperatorSession 删除会话，会话不存在时不报错
+func (dao *OperatorDao) DeleteOperatorSession(tokenHash string) error {
+	err := MSDB.Where("token_hash=?", tokenHash).Delete(&OperatorSession{}).Error
+	if err != nil {
+		logs.Error("delete operator session error, err=%+v", err)
+	}
+	return err
+}
+
+// DeleteOperatorSessions 删除操作员的所有会话，以及所有已过期的会话
+func (dao *OperatorDao) DeleteOperatorSessions(cellphone string) error {
+	err := MSDB.Where("op_cell=? OR expire_time<?", cellphone, time.Now()).Delete(&OperatorSession{}).Error
+	if err != nil {
+		logs.Error("delete operator sessions error, err=%+v", err)
+	}
+	return err
+}
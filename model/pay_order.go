@@ -0,0 +1,116 @@
+package model
+
+import (
+	"sync"
+	"time"
+
+	"code.byted.org/gopkg/logs"
+)
+
+// 支付订单状态
+const (
+	PayOrderPending  = "pending"  //待支付
+	PayOrderPaid     = "paid"     //已支付，待入账
+	PayOrderCredited = "credited" //已入账
+	PayOrderClosed   = "closed"   //超时关闭
+)
+
+// PayOrder 会员在线充值订单
+type PayOrder struct {
+	ID         int        `gorm:"column:id"`
+	OrderNo    string     `gorm:"column:order_no"`
+	CustomerID int        `gorm:"column:customer_id"`
+	Amount     int        `gorm:"column:amount"`
+	Gateway    string     `gorm:"column:gateway"`
+	TradeType  string     `gorm:"column:trade_type"`
+	Status     string     `gorm:"column:status"`
+	TradeNo    string     `gorm:"column:trade_no"`
+	CreateTime time.Time  `gorm:"column:create_time"`
+	ExpireTime time.Time  `gorm:"column:expire_time"`
+	PaidTime   *time.Time `gorm:"column:paid_time"`
+	CreditTime *time.Time `gorm:"column:credit_time"`
+}
+
+type PayOrderDao struct{}
+
+var payOrderDao *PayOrderDao
+var payOrderDaoOnce sync.Once
+
+func PayOrderDaoInstance() *PayOrderDao {
+	payOrderDaoOnce.Do(
+		func() {
+			payOrderDao = &PayOrderDao{}
+		})
+	return payOrderDao
+}
+
+func (dao *PayOrderDao) CreatePayOrder(order *PayOrder) error {
+	err := MSDB.Create(order).Error
+	if err != nil {
+		logs.Error("create pay order error, err=%+v", err)
+	}
+	return err
+}
+
+func (dao *PayOrderDao) GetPayOrder(orderNo string) (*PayOrder, error) {
+	var order PayOrder
+	err := MSDB.Where("order_no=?", orderNo).First(&order).Error
+	return &order, err
+}
+
+// MarkPaid 将待支付或已关闭的订单标记为已支付，返回是否由本次调用标记成功
+func (dao *PayOrderDao) MarkPaid(order *PayOrder, tradeNo string, paidTime time.Time) (bool, error) {
+	db := MSDB.Model(&PayOrder{}).Where("order_no=? AND status IN (?)", order.OrderNo, []string{PayOrderPending, PayOrderClosed}).
+		Updates(map[string]interface{}{"status": PayOrderPaid, "trade_no": tradeNo, "paid_time": paidTime})
+	if db.Error != nil {
+		logs.Error("mark pay order paid error, err=%+v", db.Error)
+		return false, db.Error
+	}
+	return db.RowsAffected == 1, nil
+}
+
+// MarkClosed 关闭未支付的订单，返回是否由本次调用关闭成功
+func (dao *PayOrderDao) MarkClosed(order *PayOrder) (bool, error) {
+	db := MSDB.Model(&PayOrder{}).Where("order_no=? AND status=?", order.OrderNo, PayOrderPending).Update("status", PayOrderClosed)
+	if db.Error != nil {
+		logs.Error("mark pay order closed error, err=%+v", db.Error)
+		return false, db.Error
+	}
+	return db.RowsAffected == 1, nil
+}
+
+// CreditPayOrder 在同一事务中将已支付订单标记为已入账并写入充值流水，保证每个订单只入账一次
+func (dao *PayOrderDao) CreditPayOrder(order *PayOrder, account *KroAccount) (bool, error) {
+	tx := MSDB.Begin()
+	db := tx.Model(&PayOrder{}).Where("order_no=? AND status=?", order.OrderNo, PayOrderPaid).
+		Updates(map[string]interface{}{"status": PayOrderCredited, "credit_time": account.DealTime})
+	if db.Error != nil {
+		tx.Rollback()
+		logs.Error("mark pay order credited error, err=%+v", db.Error)
+		return false, db.Error
+	}
+	if db.RowsAffected != 1 {
+		tx.Rollback()
+		return false, nil
+	}
+	if err := tx.Create(account).Error; err != nil {
+		tx.Rollback()
+		logs.Error("create pay order account error, err=%+v", err)
+		return false, err
+	}
+	if err := tx.Commit().Error; err != nil {
+		logs.Error("commit pay order credit error, err=%+v", err)
+		return false, err
+	}
+	return true, nil
+}
+
+// GetPayOrdersByStatus 查询某状态下创建时间早于 before 的订单
+func (dao *PayOrderDao) GetPayOrdersByStatus(status string, before time.Time, limit int) ([]*PayOrder, error) {
+	orders := make([]*PayOrder, 0)
+	err := MSDB.Where("status=? AND create_time<?", status, before).Order("id").Limit(limit).Find(&orders).Error
+	if err != nil {
+		logs.Error("get pay orders error, err=%+v", err)
+	}
+	return orders, err
+}
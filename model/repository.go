@@ -0,0 +1,116 @@
+package model
+
+import (
+	"time"
+)
+
+// 数据访问接口，gorm 实现为各 Dao，内存实现为 MemoryStore
+// 查询不到记录时统一返回 gorm.ErrRecordNotFound
+
+// CustomerRepository 会员数据
+type CustomerRepository interface {
+	CreateCustomer(customer *KroCustomer) error
+	GetCustomerByCellphone(cellphone string) (*KroCustomer, error)
+	GetCustomerByID(id int) (*KroCustomer, error)
+	GetCustomerByOpenID(openID string) (*KroCustomer, error)
+	GetCustomerByCardNo(cardNo string) (*KroCustomer, error)
+	ListCustomers(afterID, limit int) ([]*KroCustomer, error)
+	SearchCustomers(query *CustomerQuery) ([]*CustomerRow, int, error)
+	// ImportCustomers 在一个事务中写入所有导入的会员和期初余额，任何一条失败时都不写入
+	ImportCustomers(imports []*CustomerImport) error
+	UpdateCustomerNotify(customer *KroCustomer, notifyOff bool) error
+	UpdateCustomerOpenID(customer *KroCustomer, openID string) error
+	UpdateCustomerProfile(customerID int, update ProfileUpdateFunc) error
+	GetCustomerChanges(customerID, limit int) ([]*KroCustomerChange, error)
+	UsePayCounter(customer *KroCustomer, counter int64) (bool, error)
+}
+
+// AccountRepository 会员账户流水
+type AccountRepository interface {
+	CreateNewAccount(account *KroAccount) error
+	GetCustomerAccounts(customer *KroCustomer) ([]*KroAccount, error)
+	GetAccountsByDealTime(start, end time.Time) ([]*KroAccount, error)
+	GetTotalBalance() (int, error)
+	// WithAccountLock 在多个实例间互斥地执行同一会员的 fn，用于先校验余额再记账的操作
+	WithAccountLock(customer *KroCustomer, fn func() error) error
+}
+
+// SmsMsgRepository 短信记录
+type SmsMsgRepository interface {
+	AddSmsMsg(smsMsg *SmsMsg) error
+	UpdateSmsMsg(smsMsg *SmsMsg) error
+	MarkSmsUsed(smsMsg *SmsMsg) (bool, error)
+	ClaimSmsMsg(smsMsg *SmsMsg) (bool, error)
+	GetPhoneLatestSms(phone, purpose string) (*SmsMsg, error)
+	GetSmsByMsgID(msgID string) (*SmsMsg, error)
+	GetPhoneSmsList(phone string, limit int) ([]*SmsMsg, error)
+	GetRetrySmsList(since, now time.Time) ([]*SmsMsg, error)
+}
+
+// ConfigItemRepository 配置项
+type ConfigItemRepository interface {
+	GetConfigItem(key string) (string, error)
+	GetConfigItems(keys []string) (map[string]string, error)
+	UpdateConfigItem(key, value string) error
+	SetConfigItemWithHistory(key, value, operator string) error
+	GetConfigItemHistory(key string, limit int) ([]*ConfigItemHistory, error)
+	// WithConfigLock 在多个实例间互斥地执行 fn，用于修改前需要重新读取的配置项
+	WithConfigLock(key string, fn func() error) error
+}
+
+// OperatorRepository 操作员
+type OperatorRepository interface {
+	CheckOperatorByPwd(cellphone, pwd string) (*KroOperator, error)
+	GetOperatorByCellphone(cellphone string) (*KroOperator, error)
+	CreateOperator(operator *KroOperator) error
+	UpdateOperatorPwd(operator *KroOperator, pwd string) error
+	UpdateOperatorDisabled(operator *KroOperator, disabled bool) error
+	CreateOperatorSession(session *OperatorSession) error
+	GetOperatorSession(tokenHash string) (*OperatorSession, error)
+	DeleteOperatorSession(tokenHash string) error
+	DeleteOperatorSessions(cellphone string) error
+}
+
+// PayOrderRepository 在线充值订单
+type PayOrderRepository interface {
+	CreatePayOrder(order *PayOrder) error
+	GetPayOrder(orderNo string) (*PayOrder, error)
+	MarkPaid(order *PayOrder, tradeNo string, paidTime time.Time) (bool, error)
+	MarkClosed(order *PayOrder) (bool, error)
+	CreditPayOrder(order *PayOrder, account *KroAccount) (bool, error)
+	GetPayOrdersByStatus(status string, before time.Time, limit int) ([]*PayOrder, error)
+}
+
+// Repositories 所有数据访问接口的集合
+type Repositories struct {
+	Customers   CustomerRepository
+	Accounts    AccountRepository
+	SmsMsgs     SmsMsgRepository
+	ConfigItems ConfigItemRepository
+	Operators   OperatorRepository
+	PayOrders   PayOrderRepository
+}
+
+// GormRepositories 使用 MSDB 的实现
+func GormRepositories() *Repositories {
+	return &Repositories{
+		Customers:   CustomerDaoInstance(),
+		Accounts:    KroAccountDaoInstance(),
+		SmsMsgs:     SmsMsgDaoInstance(),
+		ConfigItems: ConfigItemDaoInstance(),
+		Operators:   OperatorDaoInstance(),
+		PayOrders:   PayOrderDaoInstance(),
+	}
+}
+
+// MemoryRepositories 使用同一个 MemoryStore 的实现，用于本地开发和测试
+func MemoryRepositories(store *MemoryStore) *Repositories {
+	return &Repositories{
+		Customers:   store,
+		Accounts:    store,
+		SmsMsgs:     store,
+		ConfigItems: store,
+		Operators:   store,
+		PayOrders:   store,
+	}
+}
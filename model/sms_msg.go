@@ -7,11 +7,40 @@ import (
 	"code.byted.org/gopkg/logs"
 )
 
+// 短信发送状态
+const (
+	SmsStatusQueued    = "queued"    //待发送
+	SmsStatusSending   = "sending"   //已被重试队列领取，正在发送
+	SmsStatusSent      = "sent"      //已提交给短信服务商
+	SmsStatusFailed    = "failed"    //发送失败
+	SmsStatusDelivered = "delivered" //用户已收到
+	SmsStatusDisplayed = "displayed" //未发送短信，在用户页面展示
+)
+
+// 短信用途
+const (
+	SmsPurposeLogin  = "login"  //登录验证码
+	SmsPurposeNotify = "notify" //账户变动通知
+	SmsPurposePay    = "pay"    //大额消费确认码
+)
+
 type SmsMsg struct {
-	CustomerID int       `gorm:"column:customer_id"`
-	Cellphone  string    `gorm:"column:cellphone"`
-	Code       string    `gorm:"column:code"`
-	SendTime   time.Time `gorm:"column:send_time"`
+	ID         int        `gorm:"column:id"`
+	CustomerID int        `gorm:"column:customer_id"`
+	Cellphone  string     `gorm:"column:cellphone"`
+	Code       string     `gorm:"column:code"`
+	Purpose    string     `gorm:"column:purpose"`
+	TplID      int        `gorm:"column:tpl_id"`
+	Params     string     `gorm:"column:params"`
+	Amount     int        `gorm:"column:amount"`
+	Used       bool       `gorm:"column:used"`
+	SendTime   time.Time  `gorm:"column:send_time"`
+	MsgID      string     `gorm:"column:msg_id"`
+	Status     string     `gorm:"column:status"`
+	ErrMsg     string     `gorm:"column:err_msg"`
+	RetryCount int        `gorm:"column:retry_count"`
+	ReportTime *time.Time `gorm:"column:report_time"`
+	SendAfter  *time.Time `gorm:"column:send_after"` //免打扰时段内的通知推迟到该时间后由重试队列发送
 }
 
 type SmsMsgDao struct{}
@@ -27,9 +56,9 @@ func SmsMsgDaoInstance() *SmsMsgDao {
 	return smsMsgDao
 }
 
-func (dao *SmsMsgDao) GetPhoneLatestSms(phone string) (*SmsMsg, error) {
+func (dao *SmsMsgDao) GetPhoneLatestSms(phone, purpose string) (*SmsMsg, error) {
 	var msg SmsMsg
-	err := MSDB.Where("cellphone=?", phone).Order("id desc").First(&msg).Error
+	err := MSDB.Where("cellphone=? AND purpose=?", phone, purpose).Order("id desc").First(&msg).Error
 	return &msg, err
 }
 
@@ -40,3 +69,59 @@ func (dao *SmsMsgDao) AddSmsMsg(smsMsg *SmsMsg) error {
 	}
 	return err
 }
+
+// UpdateSmsMsg 更新短信的发送状态
+func (dao *SmsMsgDao) UpdateSmsMsg(smsMsg *SmsMsg) error {
+	err := MSDB.Save(smsMsg).Error
+	if err != nil {
+		logs.Error("update smsMsg error, err=%+v", err)
+	}
+	return err
+}
+
+// MarkSmsUsed 将验证码标记为已使用，返回是否由本次调用标记成功
+func (dao *SmsMsgDao) MarkSmsUsed(smsMsg *SmsMsg) (bool, error) {
+	db := MSDB.Model(&SmsMsg{}).Where("id=? AND used=?", smsMsg.ID, false).Update("used", true)
+	if db.Error != nil {
+		logs.Error("mark smsMsg used error, err=%+v", db.Error)
+		return false, db.Error
+	}
+	return db.RowsAffected == 1, nil
+}
+
+// ClaimSmsMsg 将等待发送的短信标记为发送中，返回是否由本次调用领取成功，多实例同时运行重试队列时避免重复发送
+func (dao *SmsMsgDao) ClaimSmsMsg(smsMsg *SmsMsg) (bool, error) {
+	db := MSDB.Model(&SmsMsg{}).Where("id=? AND status=?", smsMsg.ID, SmsStatusQueued).Update("status", SmsStatusSending)
+	if db.Error != nil {
+		logs.Error("claim smsMsg error, err=%+v", db.Error)
+		return false, db.Error
+	}
+	return db.RowsAffected == 1, nil
+}
+
+// GetSmsByMsgID 根据短信服务商返回的消息ID查询短信
+func (dao *SmsMsgDao) GetSmsByMsgID(msgID string) (*SmsMsg, error) {
+	var msg SmsMsg
+	err := MSDB.Where("msg_id=?", msgID).First(&msg).Error
+	return &msg, err
+}
+
+// GetPhoneSmsList 查询手机号最近的短信记录
+func (dao *SmsMsgDao) GetPhoneSmsList(phone string, limit int) ([]*SmsMsg, error) {
+	msgs := make([]*SmsMsg, 0)
+	err := MSDB.Where("cellphone=?", phone).Order("id desc").Limit(limit).Find(&msgs).Error
+	if err != nil {
+		logs.Error("get phone sms list error, err=%+v", err)
+	}
+	return msgs, err
+}
+
+// GetRetrySmsList 查询 since 之后发送失败、等待重试的短信，以及推迟发送的时间已经到了的短信
+func (dao *SmsMsgDao) GetRetrySmsList(since, now time.Time) ([]*SmsMsg, error) {
+	msgs := make([]*SmsMsg, 0)
+	err := MSDB.Where("status=? AND ((retry_count>0 AND send_time>?) OR send_after<=?)", SmsStatusQueued, since, now).Order("id").Find(&msgs).Error
+	if err != nil {
+		logs.Error("get retry sms list error, err=%+v", err)
+	}
+	return msgs, err
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"os"
+
+	"code.bean.com/flamingo/service"
+)
+
+// runOperator 执行 operator 命令：
+//
+//	operator create -cell 手机号 -name 姓名 [-pwd 密码]  新增操作员，不指定密码时生成随机密码
+//	operator disable -cell 手机号                        停用操作员
+//	operator enable -cell 手机号                         启用操作员
+//	operator reset-password -cell 手机号 [-pwd 密码]     重置密码，不指定密码时生成随机密码
+func runOperator(args []string) error {
+	action, args, err := subcommand(args)
+	if err != nil {
+		return err
+	}
+	fs := flag.NewFlagSet("operator "+action, flag.ContinueOnError)
+	cell := fs.String("cell", "", "operator cellphone")
+	name := fs.String("name", "", "operator name")
+	pwd := fs.String("pwd", "", "password, generate a random one if empty")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if err := requireFlags(fs, "cell"); err != nil {
+		return err
+	}
+	operatorService := service.OperatorServiceInstance()
+	switch action {
+	case "create":
+		if err := requireFlags(fs, "name"); err != nil {
+			return err
+		}
+		password := passwordOrRandom(*pwd)
+		if _, err := operatorService.CreateOperator(*cell, *name, password); err != nil {
+			return err
+		}
+		printPassword(*cell, *pwd, password)
+		return nil
+	case "disable", "enable":
+		if err := operatorService.SetDisabled(*cell, action == "disable"); err != nil {
+			return err
+		}
+		fmt.Fprintf(os.Stdout, "operator %s %sd\n", *cell, action)
+		return nil
+	case "reset-password":
+		password := passwordOrRandom(*pwd)
+		if err := operatorService.ResetPassword(*cell, password); err != nil {
+			return err
+		}
+		printPassword(*cell, *pwd, password)
+		return nil
+	default:
+		return fmt.Errorf("unknown operator action: %s", action)
+	}
+}
+
+func passwordOrRandom(pwd string) string {
+	if pwd != "" {
+		return pwd
+	}
+	buf := make([]byte, 6)
+	if _, err := rand.Read(buf); err != nil {
+		panic("generate random password error: " + err.Error())
+	}
+	return hex.EncodeToString(buf)
+}
+
+// printPassword 只在生成随机密码时输出密码
+func printPassword(cell, given, password string) {
+	if given != "" {
+		fmt.Fprintf(os.Stdout, "operator %s saved\n", cell)
+		return
+	}
+	fmt.Fprintf(os.Stdout, "operator %s saved, password: %s\n", cell, password)
+}
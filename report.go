@@ -0,0 +1,52 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"code.bean.com/flamingo/service"
+)
+
+// runReport 执行 report 命令：
+//
+//	report settle -date 2006-01-02 [-json]  日结对账报表，默认输出表格
+func runReport(args []string) error {
+	action, args, err := subcommand(args)
+	if err != nil {
+		return err
+	}
+	fs := flag.NewFlagSet("report "+action, flag.ContinueOnError)
+	date := fs.String("date", "", "report date, such as 2018-01-02")
+	asJSON := fs.Bool("json", false, "print the report as json")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if action != "settle" {
+		return fmt.Errorf("unknown report action: %s", action)
+	}
+	if err := requireFlags(fs, "date"); err != nil {
+		return err
+	}
+	report, err := service.ReportServiceInstance().SettleReport(*date)
+	if err != nil {
+		return fmt.Errorf("%s", errorMessage(err))
+	}
+	if *asJSON {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(report)
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(w, "日期\t%s\n", report.Date)
+	fmt.Fprintf(w, "充值\t%d 笔\t%s 元\n", report.RechargeCount, report.RechargeTotal)
+	fmt.Fprintf(w, "消费\t%d 笔\t%s 元\n", report.ConsumeCount, report.ConsumeTotal)
+	fmt.Fprintf(w, "退款\t%d 笔\t%s 元\n", report.RefundCount, report.RefundTotal)
+	fmt.Fprintf(w, "\n付款方式\t笔数\t充值金额\t实收\t找零\n")
+	for _, m := range report.PayMethods {
+		fmt.Fprintf(w, "%s\t%d\t%s\t%s\t%s\n", m.PayMethodDesc, m.Count, m.Amount, m.Tendered, m.Change)
+	}
+	return w.Flush()
+}
@@ -1,22 +1,25 @@
 package service
 
 import (
+	"strconv"
+	"time"
+
 	"code.bean.com/flamingo/model"
 	"code.byted.org/gopkg/logs"
 )
 
 type ConfigService struct {
-	configItemDao *model.ConfigItemDao
+	configItems model.ConfigItemRepository
 }
 
-func NewConfigService() *ConfigService {
+func NewConfigService(items model.ConfigItemRepository) *ConfigService {
 	return &ConfigService{
-		configItemDao: model.ConfigItemDaoInstance(),
+		configItems: items,
 	}
 }
 
 func (service *ConfigService) GetAccessToken() string {
-	token, err := service.configItemDao.GetConfigItem("access_token")
+	token, err := service.configItems.GetConfigItem("access_token")
 	if err != nil {
 		logs.Error("get access token error:%+v", err)
 		return ""
@@ -24,6 +27,27 @@ func (service *ConfigService) GetAccessToken() string {
 	return token
 }
 
-func (service *ConfigService) UpdateAccessToken(value string) {
-	service.configItemDao.UpdateConfigItem("access_token", value)
+// GetAccessTokenExpire 获取共享的 access token 的过期时间
+func (service *ConfigService) GetAccessTokenExpire() time.Time {
+	value, err := service.configItems.GetConfigItem("access_token_expire")
+	if err != nil {
+		return time.Time{}
+	}
+	expire, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return time.Time{}
+	}
+	return time.Unix(expire, 0)
+}
+
+// WithAccessTokenLock 在多个实例间互斥地执行 fn，用于刷新 access token
+func (service *ConfigService) WithAccessTokenLock(fn func() error) error {
+	return service.configItems.WithConfigLock("access_token", fn)
+}
+
+func (service *ConfigService) UpdateAccessToken(value string, expireAt time.Time) error {
+	if err := service.configItems.UpdateConfigItem("access_token", value); err != nil {
+		return err
+	}
+	return service.configItems.UpdateConfigItem("access_token_expire", strconv.FormatInt(expireAt.Unix(), 10))
 }
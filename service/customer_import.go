@@ -0,0 +1,223 @@
+package service
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"code.bean.com/flamingo/handler/view"
+	"code.bean.com/flamingo/metrics"
+	"code.bean.com/flamingo/model"
+	"code.bean.com/flamingo/util"
+	"code.byted.org/gopkg/logs"
+	"github.com/jinzhu/gorm"
+)
+
+const (
+	// maxImportRows 单个导入文件的最大数据行数
+	maxImportRows = 5000
+	// maxOpeningBalance 期初余额上限，单位为分
+	maxOpeningBalance = 1000000 * 100
+	// importOperatorName 命令行导入时流水中记录的操作员
+	importOperatorName = "导入"
+)
+
+// importColumns 导入文件支持的表头，值为字段名
+var importColumns = map[string]string{
+	"name":            "name",
+	"姓名":              "name",
+	"cellphone":       "cellphone",
+	"phone":           "cellphone",
+	"手机号":             "cellphone",
+	"card_no":         "card_no",
+	"卡号":              "card_no",
+	"会员卡号":            "card_no",
+	"balance":         "balance",
+	"opening_balance": "balance",
+	"余额":              "balance",
+	"期初余额":            "balance",
+	"open_date":       "open_date",
+	"开卡日期":            "open_date",
+}
+
+// importDateLayouts 开卡日期支持的格式，xlsx 中的日期单元格为天数，见 parseOpenDate
+var importDateLayouts = []string{"2006-01-02", "2006/01/02", "2006-01-02 15:04:05", "2006/01/02 15:04:05", "20060102"}
+
+// importRow 导入文件中的一行
+type importRow struct {
+	row      int
+	name     string
+	cell     string
+	cardNo   string
+	balance  int
+	openDate time.Time
+}
+
+// ImportCustomers 从 csv 或 xlsx 文件批量导入会员和期初余额
+// 先校验所有行，包括与已有会员和文件中其他行的手机号、卡号重复；有任何错误或 dryRun 时不写入数据，只返回逐行的错误报告
+// 校验通过后所有行在一个事务中写入，写入失败时返回错误，不会只导入一部分
+// operator 为空表示通过命令行导入
+func (s *CustomerService) ImportCustomers(filename string, data []byte, dryRun bool, operator *model.KroOperator) (*view.ImportReport, error) {
+	table, err := util.ReadTable(filename, data, maxImportRows+1)
+	if err != nil {
+		return nil, ErrInvalidImportFile.WithDetail(err.Error())
+	}
+	if len(table) == 0 {
+		return nil, ErrInvalidImportFile.WithDetail("empty file")
+	}
+	columns := make(map[string]int)
+	for i, title := range table[0] {
+		if field, ok := importColumns[strings.ToLower(strings.TrimSpace(title))]; ok {
+			columns[field] = i
+		}
+	}
+	for _, field := range []string{"name", "cellphone"} {
+		if _, ok := columns[field]; !ok {
+			return nil, ErrInvalidImportFile.WithDetail("missing column " + field)
+		}
+	}
+
+	report := &view.ImportReport{DryRun: dryRun, Errors: make([]*view.ImportRowError, 0)}
+	rows := make([]*importRow, 0, len(table)-1)
+	cells, cardNos := make(map[string]int), make(map[string]int)
+	openingTotal := 0
+	for i, record := range table[1:] {
+		value := func(field string) string {
+			if idx, ok := columns[field]; ok && idx < len(record) {
+				return strings.TrimSpace(record[idx])
+			}
+			return ""
+		}
+		if strings.TrimSpace(strings.Join(record, "")) == "" {
+			continue
+		}
+		report.Total++
+		row := &importRow{row: i + 2, name: value("name"), cell: value("cellphone"), cardNo: value("card_no")}
+		addError := func(field, value, msg string) {
+			report.Errors = append(report.Errors, &view.ImportRowError{Row: row.row, Field: field, Value: value, Msg: msg})
+		}
+		errCount := len(report.Errors)
+
+		switch {
+		case row.name == "":
+			addError("name", row.name, "姓名不能为空")
+		case utf8.RuneCountInString(row.name) > 32:
+			addError("name", row.name, "姓名不能超过 32 个字符")
+		}
+		if IsInvalidPhoneNo(row.cell) {
+			addError("cellphone", row.cell, ErrIllegalPhoneNo.Message())
+		} else if first, ok := cells[row.cell]; ok {
+			addError("cellphone", row.cell, fmt.Sprintf("手机号与第 %d 行重复", first))
+		} else if exist, err := customerExists(s.customers.GetCustomerByCellphone(row.cell)); err != nil {
+			return nil, err
+		} else if exist {
+			addError("cellphone", row.cell, ErrorUserAlreadyExist.Message())
+		} else {
+			cells[row.cell] = row.row
+		}
+		if row.cardNo != "" {
+			if len(row.cardNo) > 32 {
+				addError("card_no", row.cardNo, "卡号不能超过 32 个字符")
+			} else if first, ok := cardNos[row.cardNo]; ok {
+				addError("card_no", row.cardNo, fmt.Sprintf("卡号与第 %d 行重复", first))
+			} else if exist, err := customerExists(s.customers.GetCustomerByCardNo(row.cardNo)); err != nil {
+				return nil, err
+			} else if exist {
+				addError("card_no", row.cardNo, "卡号已存在")
+			} else {
+				cardNos[row.cardNo] = row.row
+			}
+		}
+		if balance := value("balance"); balance != "" {
+			cent, err := parseYuan(balance)
+			if err != nil || cent > maxOpeningBalance {
+				addError("balance", balance, "期初余额必须是 0 到 1000000 之间的金额")
+			} else {
+				row.balance = cent
+			}
+		}
+		row.openDate = time.Now()
+		if openDate := value("open_date"); openDate != "" {
+			t, ok := parseOpenDate(openDate)
+			if !ok || t.After(time.Now()) {
+				addError("open_date", openDate, "开卡日期格式有误，应为 2006-01-02 且不能晚于今天")
+			} else {
+				row.openDate = t
+			}
+		}
+		if len(report.Errors) == errCount {
+			report.Valid++
+			openingTotal += row.balance
+			rows = append(rows, row)
+		}
+	}
+	report.OpeningTotal = formatAmount(openingTotal)
+	if dryRun || len(report.Errors) > 0 {
+		return report, nil
+	}
+
+	opName, opCell := importOperatorName, ""
+	if operator != nil {
+		opName, opCell = operator.Name, operator.Cellphone
+	}
+	now := time.Now()
+	imports := make([]*model.CustomerImport, 0, len(rows))
+	for _, row := range rows {
+		customer := &model.KroCustomer{CustomerID: row.cardNo, Name: row.name, Cellphone: row.cell, OpenDate: row.openDate, NameInitials: util.PinyinInitials(row.name), NamePinyin: util.Pinyin(row.name)}
+		if customer.CustomerID == "" {
+			customer.CustomerID = newCardNo()
+		}
+		var opening *model.KroAccount
+		if row.balance > 0 {
+			opening = &model.KroAccount{
+				AccountType: model.AccountTypeOpening,
+				Amount:      row.balance,
+				DealTime:    now,
+				Desc:        "期初余额导入",
+				OpCell:      opCell,
+				Operator:    opName,
+			}
+		}
+		imports = append(imports, &model.CustomerImport{Customer: customer, Opening: opening})
+	}
+	// 所有行在一个事务中写入，失败时整个文件都没有导入，修正后可以重新导入
+	if err := s.customers.ImportCustomers(imports); err != nil {
+		logs.Error("import customers error, err=%+v", err)
+		return nil, ErrorServiceInternalError
+	}
+	for _, item := range imports {
+		if item.Opening != nil {
+			metrics.ObserveAccount(item.Opening.AccountType, item.Opening.Amount)
+		}
+	}
+	report.Imported = len(imports)
+	return report, nil
+}
+
+// customerExists 将查询会员的结果转换为是否存在，查询失败时返回 ErrorServiceInternalError
+func customerExists(customer *model.KroCustomer, err error) (bool, error) {
+	if err == gorm.ErrRecordNotFound {
+		return false, nil
+	}
+	if err != nil {
+		logs.Error("get customer error, err=%+v", err)
+		return false, ErrorServiceInternalError
+	}
+	return customer.ID > 0, nil
+}
+
+// parseOpenDate 解析开卡日期，纯数字且不是 20060102 格式时按 xlsx 的日期序号处理
+func parseOpenDate(value string) (time.Time, bool) {
+	for _, layout := range importDateLayouts {
+		if t, err := time.ParseInLocation(layout, value, time.Local); err == nil {
+			return t, true
+		}
+	}
+	if serial, err := strconv.ParseFloat(value, 64); err == nil && serial > 0 && serial < 100000 {
+		base := time.Date(1899, 12, 30, 0, 0, 0, 0, time.Local)
+		return base.AddDate(0, 0, int(serial)), true
+	}
+	return time.Time{}, false
+}
@@ -0,0 +1,199 @@
+package service
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"code.bean.com/flamingo/handler/view"
+	"code.bean.com/flamingo/model"
+	"code.bean.com/flamingo/util"
+	"code.byted.org/gopkg/logs"
+	"github.com/jinzhu/gorm"
+)
+
+const (
+	// maxCustomerTags 每个会员最多的标签数
+	maxCustomerTags = 10
+	// maxTagLength 单个标签的最大字符数
+	maxTagLength = 16
+	// customerChangesLimit 查询会员资料修改记录的条数
+	customerChangesLimit = 50
+)
+
+// CustomerProfile 会员可修改的资料，修改时整体替换，空值表示清空该项
+type CustomerProfile struct {
+	Name             string
+	Birthday         string // 生日，格式为 2006-01-02
+	Gender           string // model.GenderMale 或 model.GenderFemale
+	Email            string
+	Notes            string // 备注，只有操作员可以修改
+	Tags             string // 逗号分隔的标签，只有操作员可以修改
+	MarketingConsent bool
+}
+
+// UpdateProfile 修改会员资料并记录每个变化的字段，operator 为空表示会员本人修改，此时保留原有的备注和标签
+// 修改基于事务中锁定的当前资料，并发修改不会互相覆盖对方的修改记录
+func (s *CustomerService) UpdateProfile(cellphone string, profile *CustomerProfile, operator *model.KroOperator) error {
+	customer, err := s.customers.GetCustomerByCellphone(cellphone)
+	if err == gorm.ErrRecordNotFound {
+		return ErrorUserNotFound
+	}
+	if err != nil {
+		return ErrorServiceInternalError
+	}
+	source, opCell := model.ChangeSourceCustomer, ""
+	if operator != nil {
+		source, opCell = model.ChangeSourceOperator, operator.Cellphone
+	}
+	var changed int
+	err = s.customers.UpdateCustomerProfile(customer.ID, func(current *model.KroCustomer) (*model.KroCustomer, []*model.KroCustomerChange, error) {
+		updated, err := applyProfile(current, profile, operator != nil)
+		if err != nil {
+			return nil, nil, err
+		}
+		now := time.Now()
+		oldFields, newFields := profileFields(current), profileFields(updated)
+		changes := make([]*model.KroCustomerChange, 0)
+		for i := range oldFields {
+			if oldFields[i][1] == newFields[i][1] {
+				continue
+			}
+			changes = append(changes, &model.KroCustomerChange{
+				CustomerID: current.ID,
+				Field:      oldFields[i][0],
+				OldValue:   oldFields[i][1],
+				NewValue:   newFields[i][1],
+				Source:     source,
+				Operator:   opCell,
+				ChangeTime: now,
+			})
+		}
+		changed = len(changes)
+		return updated, changes, nil
+	})
+	if e, ok := err.(*Error); ok {
+		return e
+	}
+	if err != nil {
+		return ErrorServiceInternalError
+	}
+	if changed > 0 {
+		logs.Info("customer profile changed, cell=%s, fields=%d, source=%s, operator=%s", util.MaskPhone(cellphone), changed, source, util.MaskPhone(opCell))
+	}
+	return nil
+}
+
+// applyProfile 校验资料并返回修改后的会员，byOperator 为 false 时保留原有的备注和标签
+func applyProfile(current *model.KroCustomer, profile *CustomerProfile, byOperator bool) (*model.KroCustomer, error) {
+	updated := *current
+	updated.Name = strings.TrimSpace(profile.Name)
+	if updated.Name == "" || utf8.RuneCountInString(updated.Name) > 32 {
+		return nil, ErrInvalidProfile.WithDetail("name")
+	}
+	updated.NameInitials = util.PinyinInitials(updated.Name)
+	updated.NamePinyin = util.Pinyin(updated.Name)
+	updated.Birthday = nil
+	if profile.Birthday != "" {
+		birthday, err := time.ParseInLocation("2006-01-02", profile.Birthday, time.Local)
+		if err != nil || birthday.After(time.Now()) {
+			return nil, ErrInvalidProfile.WithDetail("birthday")
+		}
+		updated.Birthday = &birthday
+	}
+	switch profile.Gender {
+	case "", model.GenderMale, model.GenderFemale:
+		updated.Gender = profile.Gender
+	default:
+		return nil, ErrInvalidProfile.WithDetail("gender")
+	}
+	updated.Email = strings.ToLower(strings.TrimSpace(profile.Email))
+	updated.MarketingConsent = profile.MarketingConsent
+	if byOperator {
+		tags, err := normalizeTags(profile.Tags)
+		if err != nil {
+			return nil, ErrInvalidProfile.WithDetail(err.Error())
+		}
+		updated.Notes = strings.TrimSpace(profile.Notes)
+		updated.Tags = tags
+	}
+	return &updated, nil
+}
+
+// GetProfileChanges 会员资料最近的修改记录
+func (s *CustomerService) GetProfileChanges(cellphone string) ([]*view.CustomerChange, error) {
+	customer, err := s.customers.GetCustomerByCellphone(cellphone)
+	if err == gorm.ErrRecordNotFound {
+		return nil, ErrorUserNotFound
+	}
+	if err != nil {
+		return nil, ErrorServiceInternalError
+	}
+	changes, err := s.customers.GetCustomerChanges(customer.ID, customerChangesLimit)
+	if err != nil {
+		return nil, ErrorServiceInternalError
+	}
+	result := make([]*view.CustomerChange, 0, len(changes))
+	for _, change := range changes {
+		result = append(result, &view.CustomerChange{
+			Field:      change.Field,
+			OldValue:   change.OldValue,
+			NewValue:   change.NewValue,
+			Source:     change.Source,
+			Operator:   change.Operator,
+			ChangeTime: change.ChangeTime.Format("2006-01-02 15:04:05"),
+		})
+	}
+	return result, nil
+}
+
+// profileFields 可修改的资料字段及其文本值，用于比较和记录修改
+func profileFields(customer *model.KroCustomer) [][2]string {
+	return [][2]string{
+		{"name", customer.Name},
+		{"birthday", formatBirthday(customer.Birthday)},
+		{"gender", customer.Gender},
+		{"email", customer.Email},
+		{"notes", customer.Notes},
+		{"tags", customer.Tags},
+		{"marketing_consent", strconv.FormatBool(customer.MarketingConsent)},
+	}
+}
+
+func formatBirthday(birthday *time.Time) string {
+	if birthday == nil {
+		return ""
+	}
+	return birthday.Format("2006-01-02")
+}
+
+// normalizeTags 整理逗号分隔的标签，支持中文逗号，去掉空白和重复的标签
+func normalizeTags(tags string) (string, error) {
+	result := splitTags(strings.Replace(tags, "，", ",", -1))
+	if len(result) > maxCustomerTags {
+		return "", fmt.Errorf("at most %d tags", maxCustomerTags)
+	}
+	for _, tag := range result {
+		if utf8.RuneCountInString(tag) > maxTagLength {
+			return "", fmt.Errorf("tag %q is longer than %d characters", tag, maxTagLength)
+		}
+	}
+	return strings.Join(result, ","), nil
+}
+
+// splitTags 拆分逗号分隔的标签
+func splitTags(tags string) []string {
+	result := make([]string, 0)
+	seen := make(map[string]bool)
+	for _, tag := range strings.Split(tags, ",") {
+		tag = strings.TrimSpace(tag)
+		if tag == "" || seen[tag] {
+			continue
+		}
+		seen[tag] = true
+		result = append(result, tag)
+	}
+	return result
+}
@@ -0,0 +1,88 @@
+package service
+
+import (
+	"strings"
+	"time"
+
+	"code.bean.com/flamingo/handler/view"
+	"code.bean.com/flamingo/model"
+)
+
+// defaultPageSize 会员列表默认的每页数量
+const defaultPageSize = 20
+
+// CustomerFilter 会员列表的筛选和排序条件，零值表示不限
+type CustomerFilter struct {
+	Keyword      string // 手机号、卡号、姓名或姓名拼音首字母的一部分
+	Tag          string
+	MinBalance   string // 余额下限(元)
+	MaxBalance   string // 余额上限(元)
+	InactiveDays int    // 最近多少天没有消费
+	Sort         string // model.CustomerSortOpenDate、CustomerSortLastVisit 或 CustomerSortBalance，默认按开卡日期
+	Desc         bool
+	Page         int // 页码，从 1 开始
+	PageSize     int
+}
+
+// SearchCustomers 按条件分页查询会员
+func (s *CustomerService) SearchCustomers(filter *CustomerFilter) (*view.CustomerList, error) {
+	query := &model.CustomerQuery{
+		Keyword: strings.TrimSpace(filter.Keyword),
+		Tag:     strings.TrimSpace(filter.Tag),
+		Sort:    filter.Sort,
+		Desc:    filter.Desc,
+	}
+	if filter.MinBalance != "" {
+		cent, err := parseYuan(filter.MinBalance)
+		if err != nil {
+			return nil, ErrInvalidParam.WithDetail("min_balance")
+		}
+		query.MinBalance = &cent
+	}
+	if filter.MaxBalance != "" {
+		cent, err := parseYuan(filter.MaxBalance)
+		if err != nil {
+			return nil, ErrInvalidParam.WithDetail("max_balance")
+		}
+		query.MaxBalance = &cent
+	}
+	if filter.InactiveDays > 0 {
+		since := time.Now().AddDate(0, 0, -filter.InactiveDays)
+		query.InactiveSince = &since
+	}
+	page, pageSize := filter.Page, filter.PageSize
+	if page < 1 {
+		page = 1
+	}
+	if pageSize < 1 {
+		pageSize = defaultPageSize
+	}
+	query.Offset, query.Limit = (page-1)*pageSize, pageSize
+
+	rows, total, err := s.customers.SearchCustomers(query)
+	if err != nil {
+		return nil, ErrorServiceInternalError
+	}
+	list := &view.CustomerList{
+		Total:     total,
+		Page:      page,
+		PageSize:  pageSize,
+		Customers: make([]*view.CustomerSummary, 0, len(rows)),
+	}
+	for _, row := range rows {
+		lastVisit := ""
+		if row.LastVisit != nil {
+			lastVisit = row.LastVisit.Format("2006-01-02 15:04:05")
+		}
+		list.Customers = append(list.Customers, &view.CustomerSummary{
+			CustomerCellphone:  row.Cellphone,
+			CustomerName:       row.Name,
+			CardNo:             row.CustomerID,
+			CustomerOpenDate:   row.OpenDate.Format("2006-01-02 15:04:05"),
+			LastVisit:          lastVisit,
+			CustomerRestAmount: formatAmount(row.Balance),
+			Tags:               splitTags(row.Tags),
+		})
+	}
+	return list, nil
+}
@@ -2,63 +2,56 @@ package service
 
 import (
 	"context"
-	"crypto/sha256"
 	"fmt"
 	"math/rand"
 	"regexp"
-	"strconv"
 	"sync"
 	"time"
 
 	"code.byted.org/gopkg/logs"
 	"github.com/jinzhu/gorm"
 
-	"code.bean.com/flamingo/config"
+	"code.bean.com/flamingo/metrics"
 	"code.bean.com/flamingo/model"
 	"code.bean.com/flamingo/util"
 
 	"code.bean.com/flamingo/handler/view"
 )
 
-type CustomerService struct{}
+type CustomerService struct {
+	customers model.CustomerRepository
+	accounts  model.AccountRepository
+	smsMsgs   model.SmsMsgRepository
+	settings  *SettingsService
+	sms       *SmsService
+	notifier  *NotifyService
+}
 
 var customerService *CustomerService
 var customerServiceOnce sync.Once
 
-var appID string
-var appKey string
-
-var smsURL = "https://yun.tim.qq.com/v5/tlssmssvr/sendsms?sdkappid=%s&random=%s"
-var sigTpl = "appkey=%s&random=%s&time=%d&mobile=%s"
-
-type CheckCodeReqTemplate struct {
-	Params    []string `json:"params"`
-	Sig       string   `json:"sig"`
-	Tel       *TelInfo `json:"tel"`
-	TimeStamp int64    `json:"time"`
-	TplID     int      `json:"tpl_id"`
-}
-type CheckCodeResponse struct {
-	Result int    `json:"result"`
-	ErrMsg string `json:"errmsg"`
-}
-type TelInfo struct {
-	Mobile     string `json:"mobile"`
-	NationCode string `json:"nationcode"`
-}
-
 func CustomerServiceInstance() *CustomerService {
 	customerServiceOnce.Do(
 		func() {
-			customerService = &CustomerService{}
-			appID, _ = config.ConfigJson.Get("app_id").String()
-			appKey, _ = config.ConfigJson.Get("app_key").String()
+			customerService = NewCustomerService(repos(), SettingsServiceInstance(), SmsServiceInstance(), NotifyServiceInstance())
 		})
 	return customerService
 }
 
+// NewCustomerService 使用指定的数据访问实现和依赖的服务创建 CustomerService
+func NewCustomerService(r *model.Repositories, settings *SettingsService, sms *SmsService, notifier *NotifyService) *CustomerService {
+	return &CustomerService{
+		customers: r.Customers,
+		accounts:  r.Accounts,
+		smsMsgs:   r.SmsMsgs,
+		settings:  settings,
+		sms:       sms,
+		notifier:  notifier,
+	}
+}
+
 func (s *CustomerService) GetCustomerDetailInfo(cellphone string) (*view.CustomersInfo, error) {
-	customer, err := model.CustomerDaoInstance().GetCustomerByCellphone(cellphone)
+	customer, err := s.customers.GetCustomerByCellphone(cellphone)
 	if err == gorm.ErrRecordNotFound {
 		return nil, ErrorUserNotFound
 	}
@@ -66,14 +59,13 @@ func (s *CustomerService) GetCustomerDetailInfo(cellphone string) (*view.Custome
 		logs.Error("get customer info failed,err=%+v", err)
 		return nil, err
 	}
-	accounts, err := model.KroAccountDaoInstance().GetCustomerAccounts(customer)
+	accounts, err := s.accounts.GetCustomerAccounts(customer)
 	if err != nil && err != gorm.ErrRecordNotFound {
 		logs.Error("get customer accounts error, err=%+v", err)
 		return nil, err
 	}
 	logs.Info("Accounts:%d", len(accounts))
 	accountInfos := make([]*view.AccountInfo, 0)
-	restAmount := 0
 	for _, account := range accounts {
 		amount := fmt.Sprintf("%.2f", float64(account.Amount)/100.00)
 		dealTime := account.DealTime.Format("2006-01-02 15:04:05")
@@ -83,31 +75,33 @@ func (s *CustomerService) GetCustomerDetailInfo(cellphone string) (*view.Custome
 			AccountTime:   dealTime,
 			AccountType:   accountType,
 			OperatorName:  account.Operator,
+			PayMethod:     GetPayMethod(account.PayMethod),
 		})
-		if account.AccountType == model.AccountTypeRecharge || account.AccountType == model.AcccountTypeRefund {
-			logs.Info("amount:%d", account.Amount)
-			restAmount = restAmount + account.Amount
-		} else {
-			logs.Info("ccamount:%d", account.Amount)
-			restAmount = restAmount - account.Amount
-		}
 	}
-	rest := fmt.Sprintf("%.2f", float64(restAmount)/100.00)
+	rest := fmt.Sprintf("%.2f", float64(calcRestAmount(accounts))/100.00)
 	return &view.CustomersInfo{
 		CustomerCellphone:  customer.Cellphone,
 		CustomerName:       customer.Name,
 		CustomerRestAmount: rest,
 		CustomerOpenDate:   customer.OpenDate.Format("2006-01-02 15:04:05"),
+		NotifyOff:          customer.NotifyOff,
+		WxBound:            customer.WxOpenID != "",
 		AccountsDetail:     accountInfos,
+		Birthday:           formatBirthday(customer.Birthday),
+		Gender:             customer.Gender,
+		Email:              customer.Email,
+		Notes:              customer.Notes,
+		Tags:               splitTags(customer.Tags),
+		MarketingConsent:   customer.MarketingConsent,
 	}, nil
 }
 
 func (s *CustomerService) CreateCustomer(phone, name string) (*view.CustomersInfo, error) {
 	if IsInvalidPhoneNo(phone) {
-		logs.Error("invalid phone no:%s", phone)
+		logs.Error("invalid phone no:%s", util.MaskPhone(phone))
 		return nil, ErrIllegalPhoneNo
 	}
-	customer, err := model.CustomerDaoInstance().GetCustomerByCellphone(phone)
+	customer, err := s.customers.GetCustomerByCellphone(phone)
 	if err != nil && err != gorm.ErrRecordNotFound {
 		logs.Error("get customer info failed,err=%+v", err)
 		return nil, err
@@ -115,9 +109,8 @@ func (s *CustomerService) CreateCustomer(phone, name string) (*view.CustomersInf
 	if customer.ID > 0 {
 		return nil, ErrorUserAlreadyExist
 	}
-	customerID := IDGenerator()
-	customer = &model.KroCustomer{CustomerID: customerID, Name: name, Cellphone: phone, OpenDate: time.Now()}
-	err = model.CustomerDaoInstance().CreateCustomer(customer)
+	customer = &model.KroCustomer{CustomerID: newCardNo(), Name: name, Cellphone: phone, OpenDate: time.Now(), NameInitials: util.PinyinInitials(name), NamePinyin: util.Pinyin(name)}
+	err = s.customers.CreateCustomer(customer)
 	if err != nil {
 		logs.Error("create new customer error, err=%+v", err)
 		return nil, err
@@ -128,96 +121,378 @@ func (s *CustomerService) CreateCustomer(phone, name string) (*view.CustomersInf
 	}, nil
 }
 
-func (s *CustomerService) AddCustomerAccount(phone, operate, amount, desc string, operator *model.KroOperator) (bool, error) {
-	customer, err := model.CustomerDaoInstance().GetCustomerByCellphone(phone)
+// RechargePayment 操作员充值时记录的付款信息
+type RechargePayment struct {
+	Method   string // 付款方式
+	Ref      string // 支付渠道的交易流水号，现金付款时可为空
+	Tendered string // 实收金额(元)，为空时等于充值金额
+}
+
+func (s *CustomerService) AddCustomerAccount(phone, operate, amount, desc, code string, payment *RechargePayment, operator *model.KroOperator) (bool, error) {
+	customer, err := s.customers.GetCustomerByCellphone(phone)
+	if err == gorm.ErrRecordNotFound {
+		return false, ErrorUserNotFound
+	}
 	if err != nil {
 		logs.Error("get customer info failed,err=%+v", err)
 		return false, err
 	}
-	fAmount, err := strconv.ParseFloat(amount, 10)
+	cent, err := yuanToCent(amount)
 	if err != nil {
 		logs.Error("parse amount to num error,err=%+v", err)
 		return false, err
 	}
-	if operate == model.AccountTypeCunsume {
-		detailInfo, _ := s.GetCustomerDetailInfo(phone)
-		restAmount, _ := strconv.ParseFloat(detailInfo.CustomerRestAmount, 10)
-		if fAmount > restAmount {
-			return false, NewError(401, "买单金额超出账户余额")
+	account := &model.KroAccount{CustomerID: customer.ID, AccountType: operate, Amount: cent, DealTime: time.Now(), Desc: desc, OpCell: operator.Cellphone, Operator: operator.Name}
+	if operate == model.AccountTypeRecharge {
+		if err := fillRechargePayment(account, payment); err != nil {
+			return false, err
 		}
 	}
-	err = model.KroAccountDaoInstance().CreateNewAccount(&model.KroAccount{CustomerID: customer.ID, AccountType: operate, Amount: int(fAmount * 100), DealTime: time.Now(), Desc: desc, OpCell: operator.Cellphone, Operator: operator.Name})
+	if operate == model.AccountTypeCunsume {
+		// 校验余额和记账在同一把锁内完成，避免并发消费同时通过余额校验
+		err = s.accounts.WithAccountLock(customer, func() error {
+			if err := s.checkConsume(customer, code, cent); err != nil {
+				return err
+			}
+			return s.createAccount(account)
+		})
+	} else {
+		err = s.createAccount(account)
+	}
 	if err != nil {
-		logs.Error("create new account item error,err=%+v", err)
 		return false, err
 	}
+	metrics.ObserveAccount(account.AccountType, account.Amount)
+	s.notifyAccount(customer, account)
 	return true, nil
 }
 
-func (s *CustomerService) SendCheckCode(phone string) error {
+// checkConsume 校验消费金额不超过余额，超过阈值的消费需要会员确认码
+func (s *CustomerService) checkConsume(customer *model.KroCustomer, code string, cent int) error {
+	accounts, err := s.accounts.GetCustomerAccounts(customer)
+	if err != nil && err != gorm.ErrRecordNotFound {
+		logs.Error("get customer accounts error, err=%+v", err)
+		return ErrorServiceInternalError
+	}
+	if cent > calcRestAmount(accounts) {
+		return ErrInsufficientBalance
+	}
+	// 超过阈值(元)的消费需要会员确认码，阈值不大于0时不校验
+	threshold := floatToCent(s.settings.Float(SettingConsumeConfirmThreshold))
+	if threshold > 0 && cent > threshold {
+		if code == "" {
+			return ErrConsumeNeedConfirm
+		}
+		return s.verifyPayCode(customer.Cellphone, code, cent)
+	}
+	return nil
+}
+
+// createAccount 记录账户流水
+func (s *CustomerService) createAccount(account *model.KroAccount) error {
+	if err := s.accounts.CreateNewAccount(account); err != nil {
+		logs.Error("create new account item error,err=%+v", err)
+		return err
+	}
+	return nil
+}
+
+// fillRechargePayment 校验充值的付款信息并写入流水
+func fillRechargePayment(account *model.KroAccount, payment *RechargePayment) error {
+	if payment == nil {
+		return ErrInvalidPayMethod
+	}
+	if !model.IsOperatorPayMethod(payment.Method) {
+		return ErrInvalidPayMethod
+	}
+	if len(payment.Ref) > 64 || (payment.Method != model.PayMethodCash && payment.Ref == "") {
+		return ErrMissPayRef
+	}
+	tendered := account.Amount
+	if payment.Tendered != "" {
+		cent, err := yuanToCent(payment.Tendered)
+		if err != nil {
+			return ErrInvalidTendered
+		}
+		tendered = cent
+	}
+	// 只有现金可以找零，其他渠道实收金额必须与充值金额一致
+	if tendered < account.Amount || (payment.Method != model.PayMethodCash && tendered != account.Amount) {
+		return ErrInvalidTendered
+	}
+	account.PayMethod = payment.Method
+	account.PayRef = payment.Ref
+	account.Tendered = tendered
+	return nil
+}
+
+// notifyAccount 通知用户账户变动及变动后的余额
+func (s *CustomerService) notifyAccount(customer *model.KroCustomer, account *model.KroAccount) {
+	accounts, err := s.accounts.GetCustomerAccounts(customer)
+	if err != nil {
+		logs.Error("get customer accounts error, err=%+v", err)
+		return
+	}
+	s.notifier.NotifyAccount(customer, account, calcRestAmount(accounts))
+}
+
+// GetCustomerByCellphone 根据手机号获取会员
+func (s *CustomerService) GetCustomerByCellphone(cellphone string) (*model.KroCustomer, error) {
+	return s.customers.GetCustomerByCellphone(cellphone)
+}
+
+// listPageSize 遍历会员时每次查询的数量
+const listPageSize = 500
+
+// EachCustomer 按 id 顺序遍历所有会员及其余额(分)，fn 返回错误时停止遍历并返回该错误
+func (s *CustomerService) EachCustomer(fn func(customer *model.KroCustomer, balance int) error) error {
+	afterID := 0
+	for {
+		customers, err := s.customers.ListCustomers(afterID, listPageSize)
+		if err != nil {
+			return err
+		}
+		for _, customer := range customers {
+			accounts, err := s.accounts.GetCustomerAccounts(customer)
+			if err != nil && err != gorm.ErrRecordNotFound {
+				return err
+			}
+			if err := fn(customer, calcRestAmount(accounts)); err != nil {
+				return err
+			}
+			afterID = customer.ID
+		}
+		if len(customers) < listPageSize {
+			return nil
+		}
+	}
+}
+
+// GetCustomerByOpenID 根据绑定的微信 openid 查询用户，未绑定时返回 ErrorUserNotFound
+func (s *CustomerService) GetCustomerByOpenID(openID string) (*model.KroCustomer, error) {
+	customer, err := s.customers.GetCustomerByOpenID(openID)
+	if err == gorm.ErrRecordNotFound {
+		return nil, ErrorUserNotFound
+	}
+	if err != nil {
+		return nil, ErrorServiceInternalError
+	}
+	return customer, nil
+}
+
+// BindWxOpenID 绑定微信 openid，该 openid 之前绑定的用户会被解绑
+func (s *CustomerService) BindWxOpenID(phone, openID string) error {
+	customer, err := s.customers.GetCustomerByCellphone(phone)
+	if err == gorm.ErrRecordNotFound {
+		return ErrorUserNotFound
+	}
+	if err != nil {
+		return ErrorServiceInternalError
+	}
+	if customer.WxOpenID == openID {
+		return nil
+	}
+	if err := s.customers.UpdateCustomerOpenID(customer, openID); err != nil {
+		return ErrorServiceInternalError
+	}
+	return nil
+}
+
+// UnbindWxOpenID 解绑微信
+func (s *CustomerService) UnbindWxOpenID(customer *model.KroCustomer) error {
+	if err := s.customers.UpdateCustomerOpenID(customer, ""); err != nil {
+		return ErrorServiceInternalError
+	}
+	return nil
+}
+
+// SetNotify 设置用户是否接收账户变动通知
+func (s *CustomerService) SetNotify(customer *model.KroCustomer, notifyOff bool) error {
+	err := s.customers.UpdateCustomerNotify(customer, notifyOff)
+	if err != nil {
+		return ErrorServiceInternalError
+	}
+	return nil
+}
+
+func (s *CustomerService) SendCheckCode(ctx context.Context, phone string) error {
 	if IsInvalidPhoneNo(phone) {
-		logs.Error("invalid phone no:%s", phone)
+		logs.Error("invalid phone no:%s", util.MaskPhone(phone))
 		return ErrIllegalPhoneNo
 	}
-	customer, err := model.CustomerDaoInstance().GetCustomerByCellphone(phone)
+	customer, err := s.customers.GetCustomerByCellphone(phone)
 	if err == gorm.ErrRecordNotFound {
 		logs.Error("customer not found")
 		return ErrIllegalPhoneNo
 	}
-	msg, err := model.SmsMsgDaoInstance().GetPhoneLatestSms(phone)
-	if err != nil && err != gorm.ErrRecordNotFound {
-		logs.Error("get phone latest")
+	if err != nil {
+		logs.Error("get customer error, err=%+v", err)
 		return ErrorServiceInternalError
 	}
-	if err == nil {
-		if msg.SendTime.Add(2 * time.Minute).After(time.Now()) {
-			return ErrIllegalDataAccess
-		}
+	if err := s.checkSmsCooldown(phone, model.SmsPurposeLogin); err != nil {
+		return err
+	}
+	return s.sms.SendCode(ctx, customer.ID, phone, CreateCaptcha())
+}
+
+// SendPayCode 给会员发送大额消费确认码，确认码只能用于不超过 amount 的消费
+func (s *CustomerService) SendPayCode(ctx context.Context, phone, amount string) error {
+	if IsInvalidPhoneNo(phone) {
+		logs.Error("invalid phone no:%s", util.MaskPhone(phone))
+		return ErrIllegalPhoneNo
+	}
+	customer, err := s.customers.GetCustomerByCellphone(phone)
+	if err == gorm.ErrRecordNotFound {
+		return ErrorUserNotFound
 	}
-	code, err := sendSMSMsg(phone)
 	if err != nil {
 		return ErrorServiceInternalError
 	}
-	return model.SmsMsgDaoInstance().AddSmsMsg(&model.SmsMsg{CustomerID: customer.ID, Cellphone: phone, Code: code, SendTime: time.Now()})
+	cent, err := yuanToCent(amount)
+	if err != nil || cent <= 0 {
+		return ErrInvalidParam
+	}
+	if err := s.checkSmsCooldown(phone, model.SmsPurposePay); err != nil {
+		return err
+	}
+	return s.sms.SendPayCode(ctx, customer.ID, phone, CreateCaptcha(), cent)
+}
+
+// CreatePayCode 生成一个在会员页面展示的消费确认码
+func (s *CustomerService) CreatePayCode(customer *model.KroCustomer) (*view.PayCode, error) {
+	code := CreateCaptcha()
+	msg, err := s.sms.AddDisplayedCode(customer.ID, customer.Cellphone, code)
+	if err != nil {
+		return nil, err
+	}
+	return &view.PayCode{
+		Code:     code,
+		ExpireAt: msg.SendTime.Add(smsCodeValidPeriod).Format("2006-01-02 15:04:05"),
+	}, nil
+}
+
+// verifyPayCode 校验并消耗会员最近一次的消费确认码，校验失败的确认码同样作废
+func (s *CustomerService) verifyPayCode(phone, code string, amount int) error {
+	msg, err := s.smsMsgs.GetPhoneLatestSms(phone, model.SmsPurposePay)
+	if err == gorm.ErrRecordNotFound {
+		return ErrPayCodeNotMatch
+	}
+	if err != nil {
+		return ErrorServiceInternalError
+	}
+	if msg.Used || msg.SendTime.Add(smsCodeValidPeriod).Before(time.Now()) {
+		return ErrPayCodeNotMatch
+	}
+	ok, err := s.smsMsgs.MarkSmsUsed(msg)
+	if err != nil {
+		return ErrorServiceInternalError
+	}
+	if !ok || msg.Code != code || (msg.Amount > 0 && amount > msg.Amount) {
+		return ErrPayCodeNotMatch
+	}
+	return nil
+}
+
+// checkSmsCooldown 同一用途的短信在冷却时间内只能发送一次
+func (s *CustomerService) checkSmsCooldown(phone, purpose string) error {
+	msg, err := s.smsMsgs.GetPhoneLatestSms(phone, purpose)
+	if err != nil && err != gorm.ErrRecordNotFound {
+		logs.Error("get phone latest sms error, err=%+v", err)
+		return ErrorServiceInternalError
+	}
+	if err == nil && msg.Status != model.SmsStatusFailed && msg.Status != model.SmsStatusDisplayed {
+		cooldown := time.Duration(s.settings.Int(SettingSmsCooldown)) * time.Second
+		if msg.SendTime.Add(cooldown).After(time.Now()) {
+			return ErrSmsTooFrequent
+		}
+	}
+	return nil
 }
 
 func (s *CustomerService) VerifyCheckCode(code, phone string) (bool, error) {
+	ok, err := s.verifyCheckCode(code, phone)
+	if ok {
+		metrics.ObserveLogin("customer", metrics.LoginSuccess)
+	} else {
+		metrics.ObserveLogin("customer", metrics.LoginFailure)
+	}
+	return ok, err
+}
+
+func (s *CustomerService) verifyCheckCode(code, phone string) (bool, error) {
 	if IsInvalidPhoneNo(phone) {
-		logs.Error("invalid phone no:%s", phone)
+		logs.Error("invalid phone no:%s", util.MaskPhone(phone))
 		return false, ErrIllegalPhoneNo
 	}
-	_, err := model.CustomerDaoInstance().GetCustomerByCellphone(phone)
+	_, err := s.customers.GetCustomerByCellphone(phone)
 	if err == gorm.ErrRecordNotFound {
 		return false, ErrIllegalPhoneNo
 	}
-	msg, err := model.SmsMsgDaoInstance().GetPhoneLatestSms(phone)
+	msg, err := s.smsMsgs.GetPhoneLatestSms(phone, model.SmsPurposeLogin)
 	if err != nil {
 		return false, ErrorServiceInternalError
 	}
-	if msg.SendTime.Add(3 * time.Minute).Before(time.Now()) {
+	if msg.SendTime.Add(smsCodeValidPeriod).Before(time.Now()) {
 		return false, nil
 	}
 	return msg.Code == code, nil
 }
 
+// calcRestAmount 根据账户流水计算余额，单位为分
+func calcRestAmount(accounts []*model.KroAccount) int {
+	restAmount := 0
+	for _, account := range accounts {
+		if account.AccountType == model.AccountTypeRecharge || account.AccountType == model.AcccountTypeRefund || account.AccountType == model.AccountTypeOpening {
+			restAmount = restAmount + account.Amount
+		} else {
+			restAmount = restAmount - account.Amount
+		}
+	}
+	return restAmount
+}
+
 func IsInvalidPhoneNo(phone string) bool {
 	reg := `^1([38][0-9]|14[57]|5[^4])\d{8}$`
 	rgx := regexp.MustCompile(reg)
 	return !rgx.MatchString(phone)
 }
 
+// newCardNo 生成会员卡号：14 位开卡时间加 8 位随机数字，同一秒开卡的会员卡号也不会重复
+func newCardNo() string {
+	return IDGenerator() + util.RandomDigits(8)
+}
+
 func IDGenerator() string {
 	buf := make([]byte, 0, 64)
 	buf = time.Now().AppendFormat(buf, "20060102150405")
 	return string(buf)
 }
 
+func GetPayMethod(payMethod string) string {
+	switch payMethod {
+	case model.PayMethodCash:
+		return "现金"
+	case model.PayMethodBankCard:
+		return "银行卡"
+	case model.PayMethodWechat:
+		return "微信"
+	case model.PayMethodAlipay:
+		return "支付宝"
+	case "":
+		return ""
+	default:
+		return "其他"
+	}
+}
+
 func GetAccountType(accountType string) string {
 	switch accountType {
 	case model.AccountTypeCunsume:
 		return "消费"
 	case model.AccountTypeRecharge:
 		return "充值"
+	case model.AccountTypeOpening:
+		return "期初余额"
 	default:
 		return "退款"
 	}
@@ -227,30 +502,3 @@ func GetAccountType(accountType string) string {
 func CreateCaptcha() string {
 	return fmt.Sprintf("%04v", rand.New(rand.NewSource(time.Now().UnixNano())).Int31n(10000))
 }
-
-func sendSMSMsg(phone string) (string, error) {
-	code := CreateCaptcha()
-	telInfo := &TelInfo{Mobile: phone, NationCode: "86"}
-	params := []string{code}
-
-	timeStamp := time.Now().Unix()
-	url := fmt.Sprintf(smsURL, appID, code)
-
-	sigInput := fmt.Sprintf(sigTpl, appKey, code, timeStamp, phone)
-	logs.Info("input%s", sigInput)
-	h := sha256.New()
-	h.Write([]byte(sigInput))
-	bs := h.Sum(nil)
-	sig := fmt.Sprintf("%x", bs)
-	reqInfo := CheckCodeReqTemplate{Params: params, Sig: sig, Tel: telInfo, TimeStamp: timeStamp, TplID: 253094}
-	var resp CheckCodeResponse
-	logs.Info("send sms req:%+v", reqInfo)
-	err := util.PostWithObjResponse(context.Background(), url, reqInfo, &resp)
-	if err != nil {
-		return "", err
-	}
-	if resp.Result == 0 {
-		return code, nil
-	}
-	return "", ErrorServiceInternalError
-}
@@ -3,67 +3,95 @@ package service
 import (
 	"encoding/json"
 	"net/http"
+	"sort"
+	"strconv"
+	"strings"
 )
 
-// 错误码列表
+// OK 成功
+const OK = 0
+
+// 支持的响应语言，通过 Accept-Language 选择，默认中文
 const (
-	OK                          = 0 // 成功
-	StatusMissParam             = 1 //缺少必要参数
-	StatusInvalidParam          = 2 //参数值错误
-	StatusEncryptionError       = 3 //加密错误
-	StatusInternalServerError   = 4 //系统内部错误
-	StatusDeviceAntiFraudExpire = 5 // 设备反欺诈信息过期
-	StatusUserAlreadyExist      = 6 // 用户信息以存在
-	StatusInvalidFourElements   = 7 //用户四要素非法
-	StatusUserLoanReqError      = 8 //用户借款提交失败 （合作方）
-	StatusUserRepayError        = 9 // 用户还款提交失败（合作方）
+	LangZhCN = "zh-CN"
+	LangEn   = "en"
 )
 
-var errorMsgs map[int]string
-
-// InitError 错误消息初始化
-func InitError() {
-	errorMsgs = make(map[int]string)
-	//TODO 补充具体的错误消息
-	errorMsgs[StatusMissParam] = "缺少必要参数"
-	errorMsgs[StatusInvalidParam] = "参数值不合法"
-	errorMsgs[StatusEncryptionError] = "加密结果错误"
-	errorMsgs[StatusInternalServerError] = "服务器开小差啦"
-	errorMsgs[StatusDeviceAntiFraudExpire] = "设备反欺诈信息过期"
-	errorMsgs[StatusUserAlreadyExist] = "用户信息已存在"
-	errorMsgs[StatusInvalidFourElements] = "非法的四要素值"
+// Error 自定义错误类型，Code 为对外稳定的错误码，Status 为对应的 http 状态码
+type Error struct {
+	Code   int
+	Status int
+	Msg    string
+	detail string
 }
 
-// Error 自定义错误类型
-type Error struct {
-	Code int
-	Msg  string
-	err  error
+// catalog 所有错误码，错误码不能修改或复用
+var catalog = make(map[int]*Error)
+
+// translations 非中文的错误消息
+var translations = map[string]map[int]string{
+	LangEn: make(map[int]string),
+}
+
+// defineError 在错误目录中登记一个错误码
+func defineError(code, status int, zh, en string) *Error {
+	if _, ok := catalog[code]; ok {
+		panic("duplicate error code " + strconv.Itoa(code))
+	}
+	e := &Error{Code: code, Status: status, Msg: zh}
+	catalog[code] = e
+	translations[LangEn][code] = en
+	return e
+}
+
+// Errors 错误目录，按错误码排序
+func Errors() []*Error {
+	errs := make([]*Error, 0, len(catalog))
+	for _, e := range catalog {
+		errs = append(errs, e)
+	}
+	sort.Slice(errs, func(i, j int) bool { return errs[i].Code < errs[j].Code })
+	return errs
 }
 
-// Message 返回错误信息
+// Message 返回中文错误信息
 func (e *Error) Message() string {
-	if len(e.Msg) > 0 {
-		return e.Msg
+	return e.Localize(LangZhCN)
+}
+
+// Localize 返回指定语言的错误信息，没有翻译时返回中文
+func (e *Error) Localize(lang string) string {
+	msg := e.Msg
+	if m, ok := translations[lang][e.Code]; ok {
+		msg = m
 	}
-	//TODO 根据code返回对于错误消息
-	msg, ok := errorMsgs[e.Code]
-	if ok {
-		e.Msg = msg
+	if e.detail != "" {
+		msg += ": " + e.detail
 	}
-	return ""
+	return msg
+}
+
+// WithDetail 返回附带详细说明的同一错误码的错误，说明会拼接在错误信息后面
+func (e *Error) WithDetail(detail string) *Error {
+	copied := *e
+	copied.detail = detail
+	return &copied
+}
+
+// HTTPStatus 错误对应的 http 状态码
+func (e *Error) HTTPStatus() int {
+	if e.Status == 0 {
+		return http.StatusInternalServerError
+	}
+	return e.Status
 }
 
 // MarshalJSON error to json
 func (e *Error) MarshalJSON() ([]byte, error) {
-	m := map[string]interface{}{
+	return json.Marshal(map[string]interface{}{
 		"code": e.Code,
 		"msg":  e.Message(),
-	}
-	if e.err != nil {
-		m["detail"] = e.err.Error()
-	}
-	return json.Marshal(m)
+	})
 }
 
 // Error 返回错误信息
@@ -72,39 +100,88 @@ func (e *Error) Error() string {
 	return string(buf)
 }
 
-// NewError 自定义一个错误
-func NewError(code int, msg string) *Error {
-	return &Error{
-		Code: code,
-		Msg:  msg,
+// AsError 将任意错误转换为目录中的错误，未知错误作为服务内部错误
+func AsError(err error) *Error {
+	if se, ok := err.(*Error); ok {
+		return se
 	}
+	return ErrorServiceInternalError
 }
 
-// ErrorWrap 创建一个错误
-func ErrorWrap(code int, err error) *Error {
-	return &Error{
-		Code: code,
-		err:  err,
+// ParseLanguage 根据 Accept-Language 选择响应语言
+func ParseLanguage(acceptLanguage string) string {
+	lang, best := LangZhCN, -1.0
+	for _, part := range strings.Split(acceptLanguage, ",") {
+		fields := strings.Split(strings.TrimSpace(part), ";")
+		tag := strings.ToLower(strings.TrimSpace(fields[0]))
+		q := 1.0
+		for _, f := range fields[1:] {
+			if f = strings.TrimSpace(f); strings.HasPrefix(f, "q=") {
+				if v, err := strconv.ParseFloat(f[2:], 64); err == nil {
+					q = v
+				}
+			}
+		}
+		var candidate string
+		switch {
+		case strings.HasPrefix(tag, "zh"):
+			candidate = LangZhCN
+		case strings.HasPrefix(tag, "en"):
+			candidate = LangEn
+		default:
+			continue
+		}
+		if q > best {
+			lang, best = candidate, q
+		}
 	}
+	return lang
 }
 
+// 错误码按业务分段：41xx 请求参数和权限，42xx 密码和验证码，43xx 会员，44xx 订单，45xx 微信公众号，46xx 操作员，50xx 服务内部错误
 var (
-	ErrUnauthorized = NewError(http.StatusUnauthorized, "Unauthorized")
-
-	ErrMissParam         = NewError(4100, "缺少参数")
-	ErrInvalidParam      = NewError(4101, "参数无效")
-	ErrMissingData       = NewError(4102, "数据丢失")
-	ErrUserNotLogin      = NewError(4103, "用户未登录")
-	ErrIllegalDataAccess = NewError(4104, "非法数据访问")
-	ErrIllegalPhoneNo    = NewError(4105, "手机号输入有误")
+	ErrMissParam          = defineError(4100, http.StatusBadRequest, "缺少参数", "missing parameter")
+	ErrInvalidParam       = defineError(4101, http.StatusBadRequest, "参数无效", "invalid parameter")
+	ErrMissingData        = defineError(4102, http.StatusBadRequest, "数据丢失", "missing data")
+	ErrUserNotLogin       = defineError(4103, http.StatusUnauthorized, "用户未登录", "not logged in")
+	ErrIllegalDataAccess  = defineError(4104, http.StatusForbidden, "非法数据访问", "illegal data access")
+	ErrIllegalPhoneNo     = defineError(4105, http.StatusBadRequest, "手机号输入有误", "invalid phone number")
+	ErrConsumeNeedConfirm = defineError(4106, http.StatusBadRequest, "消费金额较大，需要会员确认码", "a confirmation code from the customer is required for this amount")
+	ErrInvalidPayMethod   = defineError(4107, http.StatusBadRequest, "付款方式无效", "invalid payment method")
+	ErrMissPayRef         = defineError(4108, http.StatusBadRequest, "缺少支付流水号", "missing payment reference")
+	ErrInvalidTendered    = defineError(4109, http.StatusBadRequest, "实收金额有误", "invalid tendered amount")
+	ErrInvalidSetting     = defineError(4110, http.StatusBadRequest, "配置值不合法", "invalid setting value")
+	ErrSettingNotFound    = defineError(4111, http.StatusNotFound, "配置项不存在", "setting not found")
+	ErrForbidden          = defineError(4112, http.StatusForbidden, "没有操作权限", "permission denied")
 
 	// 密码相关 42xx 开头
-	ErrWrongPassword             = NewError(4200, "密码错误")
-	ErrPasswordAlreadySet        = NewError(4201, "已设置过密码")
-	ErrPasswordCheckCodeNotMatch = NewError(4202, "验证码错误")
+	ErrWrongPassword             = defineError(4200, http.StatusUnauthorized, "密码错误", "wrong password")
+	ErrPasswordAlreadySet        = defineError(4201, http.StatusConflict, "已设置过密码", "password already set")
+	ErrPasswordCheckCodeNotMatch = defineError(4202, http.StatusBadRequest, "验证码错误", "wrong verification code")
+	ErrPayCodeNotMatch           = defineError(4203, http.StatusBadRequest, "确认码错误或已失效", "confirmation code is wrong or expired")
+	ErrPaymentTokenInvalid       = defineError(4204, http.StatusBadRequest, "付款码无效或已使用", "payment code is invalid or already used")
+	ErrSmsTooFrequent            = defineError(4205, http.StatusTooManyRequests, "请稍后再试", "please try again later")
+
+	ErrorUserNotFound      = defineError(4301, http.StatusNotFound, "用户信息不存在", "customer not found")
+	ErrorUserAlreadyExist  = defineError(4302, http.StatusConflict, "用户信息已存在", "customer already exists")
+	ErrInsufficientBalance = defineError(4303, http.StatusBadRequest, "买单金额超出账户余额", "amount exceeds the account balance")
+	ErrInvalidImportFile   = defineError(4304, http.StatusBadRequest, "导入文件格式有误", "invalid import file")
+	ErrInvalidProfile      = defineError(4305, http.StatusBadRequest, "会员资料有误", "invalid customer profile")
+
+	ErrPayOrderNotFound = defineError(4401, http.StatusNotFound, "充值订单不存在", "recharge order not found")
+
+	// 微信公众号相关 45xx 开头
+	ErrWxMenuNotConfigured     = defineError(4501, http.StatusConflict, "未配置公众号菜单", "wechat menu is not configured")
+	ErrWxTemplateNotConfigured = defineError(4502, http.StatusConflict, "未配置该模板消息", "wechat template message is not configured")
+	ErrWxNotBound              = defineError(4503, http.StatusConflict, "会员未绑定微信", "customer has not bound wechat")
 
-	ErrorUserNotFound     = NewError(4301, "用户信息不存在")
-	ErrorUserAlreadyExist = NewError(4302, "用户信息已存在")
+	ErrOperatorNotFound     = defineError(4601, http.StatusNotFound, "操作员不存在", "operator not found")
+	ErrOperatorAlreadyExist = defineError(4602, http.StatusConflict, "操作员已存在", "operator already exists")
+	ErrOperatorDisabled     = defineError(4603, http.StatusForbidden, "操作员已停用", "operator is disabled")
+	ErrWeakPassword         = defineError(4604, http.StatusBadRequest, "密码长度不能少于 6 位", "password must be at least 6 characters")
 
-	ErrorServiceInternalError = NewError(5001, "服务异常，请稍后再试")
+	ErrorServiceInternalError = defineError(5001, http.StatusInternalServerError, "服务异常，请稍后再试", "internal error, please try again later")
+	ErrSmsSendFailed          = defineError(5002, http.StatusBadGateway, "短信发送失败，请稍后再试", "failed to send sms, please try again later")
+	ErrPayGatewayError        = defineError(5003, http.StatusBadGateway, "支付渠道异常，请稍后再试", "payment gateway error, please try again later")
+	ErrWxAPIError             = defineError(5004, http.StatusBadGateway, "微信接口异常，请稍后再试", "wechat api error, please try again later")
 )
@@ -0,0 +1,257 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"code.byted.org/gopkg/logs"
+
+	"code.bean.com/flamingo/model"
+)
+
+// 通知渠道
+const (
+	NotifyChannelSms    = "sms"
+	NotifyChannelWechat = "wechat"
+	NotifyChannelLog    = "log"
+)
+
+// BalanceNotice 账户变动通知内容
+type BalanceNotice struct {
+	Customer    *model.KroCustomer
+	AccountType string
+	Amount      int
+	Balance     int
+	StoreName   string
+	DealTime    time.Time
+}
+
+// NotifyChannel 通知发送渠道
+type NotifyChannel interface {
+	// Available 渠道是否可以给该用户发送通知
+	Available(customer *model.KroCustomer) bool
+	Send(notice *BalanceNotice) error
+}
+
+// DeferrableChannel 可以持久化通知、在指定时间之后再发送的渠道，用于免打扰时段
+type DeferrableChannel interface {
+	Defer(notice *BalanceNotice, sendAfter time.Time) error
+}
+
+// SmsNotifyChannel 短信通知
+type SmsNotifyChannel struct {
+	Sms   *SmsService
+	TplID int
+}
+
+func (ch *SmsNotifyChannel) Available(customer *model.KroCustomer) bool {
+	return ch.TplID > 0 && customer.Cellphone != ""
+}
+
+func (ch *SmsNotifyChannel) Send(notice *BalanceNotice) error {
+	return ch.Sms.SendNotice(context.Background(), notice.Customer.ID, notice.Customer.Cellphone, ch.TplID, smsNoticeParams(notice))
+}
+
+func (ch *SmsNotifyChannel) Defer(notice *BalanceNotice, sendAfter time.Time) error {
+	return ch.Sms.QueueNotice(notice.Customer.ID, notice.Customer.Cellphone, ch.TplID, smsNoticeParams(notice), sendAfter)
+}
+
+func smsNoticeParams(notice *BalanceNotice) []string {
+	return []string{
+		GetAccountType(notice.AccountType),
+		formatAmount(notice.Amount),
+		notice.StoreName,
+		formatAmount(notice.Balance),
+	}
+}
+
+// WechatNotifyChannel 微信模板消息通知，用户需已绑定公众号
+type WechatNotifyChannel struct {
+	Client *WxAPIClient
+}
+
+func (ch *WechatNotifyChannel) Available(customer *model.KroCustomer) bool {
+	return customer.WxOpenID != "" && (ch.Client.HasTemplate(WxTemplateRecharge) || ch.Client.HasTemplate(WxTemplateConsume))
+}
+
+func (ch *WechatNotifyChannel) Send(notice *BalanceNotice) error {
+	var tpl WxTemplate
+	switch notice.AccountType {
+	case model.AccountTypeCunsume:
+		tpl = &ConsumeTemplate{
+			OpenID:    notice.Customer.WxOpenID,
+			StoreName: notice.StoreName,
+			Amount:    notice.Amount,
+			Balance:   notice.Balance,
+			DealTime:  notice.DealTime,
+		}
+	default:
+		recharge := &RechargeTemplate{
+			OpenID:    notice.Customer.WxOpenID,
+			StoreName: notice.StoreName,
+			Amount:    notice.Amount,
+			Balance:   notice.Balance,
+			DealTime:  notice.DealTime,
+		}
+		if notice.AccountType != model.AccountTypeRecharge {
+			recharge.Title = fmt.Sprintf("您的会员卡在%s发生一笔%s", notice.StoreName, GetAccountType(notice.AccountType))
+		}
+		tpl = recharge
+	}
+	return ch.Client.SendTemplate(context.Background(), tpl)
+}
+
+// LogNotifyChannel 只打印日志，用于开发环境
+type LogNotifyChannel struct{}
+
+func (ch *LogNotifyChannel) Available(customer *model.KroCustomer) bool {
+	return true
+}
+
+func (ch *LogNotifyChannel) Send(notice *BalanceNotice) error {
+	logs.Info("balance notice, customer=%d, type=%s, amount=%s, store=%s, balance=%s", notice.Customer.ID,
+		notice.AccountType, formatAmount(notice.Amount), notice.StoreName, formatAmount(notice.Balance))
+	return nil
+}
+
+func (ch *LogNotifyChannel) Defer(notice *BalanceNotice, sendAfter time.Time) error {
+	logs.Info("balance notice deferred, customer=%d, send_after=%s", notice.Customer.ID, sendAfter.Format("2006-01-02 15:04:05"))
+	return ch.Send(notice)
+}
+
+// NotifyService 账户变动通知
+type NotifyService struct {
+	channels   []NotifyChannel
+	storeName  string
+	quietStart int // 免打扰开始时间，当天的第几分钟
+	quietEnd   int // 免打扰结束时间，当天的第几分钟
+}
+
+var notifyService *NotifyService
+var notifyServiceOnce sync.Once
+
+func NotifyServiceInstance() *NotifyService {
+	notifyServiceOnce.Do(
+		func() {
+			notifyService = newNotifyService()
+		})
+	return notifyService
+}
+
+func newNotifyService() *NotifyService {
+	conf := currentConfig().Notify
+	s := &NotifyService{
+		channels:   make([]NotifyChannel, 0),
+		storeName:  currentConfig().StoreName,
+		quietStart: parseClock(conf.QuietStart),
+		quietEnd:   parseClock(conf.QuietEnd),
+	}
+
+	defaultChannels := []string{NotifyChannelLog}
+	if currentConfig().Product() {
+		defaultChannels = []string{NotifyChannelWechat, NotifyChannelSms}
+	}
+	names := conf.Channels
+	if len(names) == 0 {
+		names = defaultChannels
+	}
+	for _, name := range names {
+		switch name {
+		case NotifyChannelSms:
+			s.channels = append(s.channels, &SmsNotifyChannel{Sms: SmsServiceInstance(), TplID: conf.SmsTplID})
+		case NotifyChannelWechat:
+			s.channels = append(s.channels, &WechatNotifyChannel{Client: WxAPIClientInstance()})
+		case NotifyChannelLog:
+			s.channels = append(s.channels, &LogNotifyChannel{})
+		default:
+			logs.Error("unknown notify channel:%s", name)
+		}
+	}
+	return s
+}
+
+// NotifyAccount 发送账户变动通知，免打扰时段内的通知持久化后推迟到免打扰结束后发送
+func (s *NotifyService) NotifyAccount(customer *model.KroCustomer, account *model.KroAccount, balance int) {
+	if customer.NotifyOff {
+		return
+	}
+	notice := &BalanceNotice{
+		Customer:    customer,
+		AccountType: account.AccountType,
+		Amount:      account.Amount,
+		Balance:     balance,
+		StoreName:   s.storeName,
+		DealTime:    account.DealTime,
+	}
+	now := time.Now()
+	if delay := s.quietDelay(now); delay > 0 {
+		s.deferNotice(notice, now.Add(delay))
+		return
+	}
+	go s.send(notice)
+}
+
+func (s *NotifyService) send(notice *BalanceNotice) {
+	for _, ch := range s.channels {
+		if !ch.Available(notice.Customer) {
+			continue
+		}
+		err := ch.Send(notice)
+		if err == nil {
+			return
+		}
+		logs.Error("send balance notice error, customer=%d, err=%+v", notice.Customer.ID, err)
+	}
+}
+
+// deferNotice 由第一个可以推迟发送的渠道保存通知，微信模板消息不能推迟发送，免打扰时段内跳过
+func (s *NotifyService) deferNotice(notice *BalanceNotice, sendAfter time.Time) {
+	for _, ch := range s.channels {
+		deferrable, ok := ch.(DeferrableChannel)
+		if !ok || !ch.Available(notice.Customer) {
+			continue
+		}
+		err := deferrable.Defer(notice, sendAfter)
+		if err == nil {
+			return
+		}
+		logs.Error("defer balance notice error, customer=%d, err=%+v", notice.Customer.ID, err)
+	}
+}
+
+// quietDelay 当前处于免打扰时段时，返回距离免打扰结束的时长
+func (s *NotifyService) quietDelay(now time.Time) time.Duration {
+	if s.quietStart < 0 || s.quietEnd < 0 || s.quietStart == s.quietEnd {
+		return 0
+	}
+	minute := now.Hour()*60 + now.Minute()
+	var inQuiet bool
+	if s.quietStart < s.quietEnd {
+		inQuiet = minute >= s.quietStart && minute < s.quietEnd
+	} else {
+		inQuiet = minute >= s.quietStart || minute < s.quietEnd
+	}
+	if !inQuiet {
+		return 0
+	}
+	end := time.Date(now.Year(), now.Month(), now.Day(), s.quietEnd/60, s.quietEnd%60, 0, 0, now.Location())
+	if !end.After(now) {
+		end = end.AddDate(0, 0, 1)
+	}
+	return end.Sub(now)
+}
+
+// parseClock 将 "22:30" 格式的时间转换为当天的第几分钟，格式错误返回 -1
+func parseClock(clock string) int {
+	t, err := time.Parse("15:04", clock)
+	if err != nil {
+		return -1
+	}
+	return t.Hour()*60 + t.Minute()
+}
+
+func formatAmount(amount int) string {
+	return fmt.Sprintf("%.2f", float64(amount)/100.00)
+}
@@ -1,24 +1,175 @@
 package service
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"sync"
+	"time"
+	"unicode/utf8"
 
+	"code.bean.com/flamingo/metrics"
 	"code.bean.com/flamingo/model"
+	"code.bean.com/flamingo/util"
+	"code.byted.org/gopkg/logs"
+	"github.com/jinzhu/gorm"
 )
 
-type OperatorSerivce struct{}
+// defaultSessionTTL 未配置 cookie.max_age 时 bearer token 的有效期
+const defaultSessionTTL = 24 * time.Hour
+
+type OperatorSerivce struct {
+	operators  model.OperatorRepository
+	admins     map[string]bool // 管理员手机号
+	sessionTTL time.Duration   // bearer token 有效期
+}
 
 var operatorService *OperatorSerivce
 var operatorOnce sync.Once
 
 func OperatorServiceInstance() *OperatorSerivce {
 	operatorOnce.Do(func() {
-		operatorService = &OperatorSerivce{}
+		ttl := time.Duration(currentConfig().Cookie.MaxAge) * time.Second
+		operatorService = NewOperatorService(repos().Operators, currentConfig().AdminOperators, ttl)
 	})
 	return operatorService
 }
 
+// NewOperatorService 使用指定的数据访问实现创建 OperatorSerivce，admins 为管理员手机号，sessionTTL 不大于 0 时使用默认有效期
+func NewOperatorService(operators model.OperatorRepository, admins []string, sessionTTL time.Duration) *OperatorSerivce {
+	if sessionTTL <= 0 {
+		sessionTTL = defaultSessionTTL
+	}
+	s := &OperatorSerivce{operators: operators, admins: make(map[string]bool), sessionTTL: sessionTTL}
+	for _, cell := range admins {
+		s.admins[cell] = true
+	}
+	return s
+}
+
 func (s *OperatorSerivce) OperatorLogin(cell, pwd string) (*model.KroOperator, error) {
-	operator, err := model.CheckOperatorByPwd(cell, pwd)
+	operator, err := s.operators.CheckOperatorByPwd(cell, pwd)
+	if err == nil && operator.Disabled {
+		err = ErrOperatorDisabled
+	}
+	if err != nil {
+		metrics.ObserveLogin("operator", metrics.LoginFailure)
+	} else {
+		metrics.ObserveLogin("operator", metrics.LoginSuccess)
+	}
+	return operator, err
+}
+
+// GetOperatorByCellphone 根据手机号获取操作员
+func (s *OperatorSerivce) GetOperatorByCellphone(cell string) (*model.KroOperator, error) {
+	return s.operators.GetOperatorByCellphone(cell)
+}
+
+// IsAdmin 操作员是否为管理员，管理员通过配置 admin_operators 指定
+func (s *OperatorSerivce) IsAdmin(operator *model.KroOperator) bool {
+	return s.admins[operator.Cellphone]
+}
+
+// minPasswordLen 操作员密码的最小长度
+const minPasswordLen = 6
+
+// CreateOperator 新增操作员
+func (s *OperatorSerivce) CreateOperator(cell, name, pwd string) (*model.KroOperator, error) {
+	if IsInvalidPhoneNo(cell) {
+		return nil, ErrIllegalPhoneNo
+	}
+	if utf8.RuneCountInString(pwd) < minPasswordLen {
+		return nil, ErrWeakPassword
+	}
+	operator := &model.KroOperator{Cellphone: cell, Name: name, Pwd: pwd}
+	err := s.operators.CreateOperator(operator)
+	if err == model.ErrOperatorExist {
+		return nil, ErrOperatorAlreadyExist
+	}
+	if err != nil {
+		return nil, err
+	}
+	return operator, nil
+}
+
+// ResetPassword 重置操作员密码
+func (s *OperatorSerivce) ResetPassword(cell, pwd string) error {
+	if utf8.RuneCountInString(pwd) < minPasswordLen {
+		return ErrWeakPassword
+	}
+	operator, err := s.getOperator(cell)
+	if err != nil {
+		return err
+	}
+	if err := s.operators.UpdateOperatorPwd(operator, pwd); err != nil {
+		return err
+	}
+	return s.operators.DeleteOperatorSessions(operator.Cellphone)
+}
+
+// SetDisabled 停用或启用操作员，停用后不能登录，已登录的 cookie 和 token 也会失效
+func (s *OperatorSerivce) SetDisabled(cell string, disabled bool) error {
+	operator, err := s.getOperator(cell)
+	if err != nil {
+		return err
+	}
+	if err := s.operators.UpdateOperatorDisabled(operator, disabled); err != nil {
+		return err
+	}
+	if disabled {
+		return s.operators.DeleteOperatorSessions(operator.Cellphone)
+	}
+	return nil
+}
+
+// CreateSession 为操作员生成随机的 bearer token，数据库中只保存 token 的哈希，返回 token 和过期时间
+func (s *OperatorSerivce) CreateSession(operator *model.KroOperator) (string, time.Time, error) {
+	token := util.RandomHex(32)
+	now := time.Now()
+	session := &model.OperatorSession{TokenHash: sessionTokenHash(token), OpCell: operator.Cellphone, CreateTime: now, ExpireTime: now.Add(s.sessionTTL)}
+	if err := s.operators.CreateOperatorSession(session); err != nil {
+		return "", time.Time{}, ErrorServiceInternalError
+	}
+	return token, session.ExpireTime, nil
+}
+
+// GetSessionOperator 根据 bearer token 获取操作员，token 不存在或已过期时返回 ErrUserNotLogin
+func (s *OperatorSerivce) GetSessionOperator(token string) (*model.KroOperator, error) {
+	session, err := s.operators.GetOperatorSession(sessionTokenHash(token))
+	if err == gorm.ErrRecordNotFound {
+		return nil, ErrUserNotLogin
+	}
+	if err != nil {
+		logs.Error("get operator session error, err=%+v", err)
+		return nil, ErrorServiceInternalError
+	}
+	if !session.ExpireTime.After(time.Now()) {
+		s.operators.DeleteOperatorSession(session.TokenHash)
+		return nil, ErrUserNotLogin
+	}
+	operator, err := s.operators.GetOperatorByCellphone(session.OpCell)
+	if err == gorm.ErrRecordNotFound {
+		return nil, ErrUserNotLogin
+	}
+	return operator, err
+}
+
+// DeleteSession 注销 bearer token，token 不存在时不报错
+func (s *OperatorSerivce) DeleteSession(token string) error {
+	if err := s.operators.DeleteOperatorSession(sessionTokenHash(token)); err != nil {
+		return ErrorServiceInternalError
+	}
+	return nil
+}
+
+func sessionTokenHash(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+func (s *OperatorSerivce) getOperator(cell string) (*model.KroOperator, error) {
+	operator, err := s.operators.GetOperatorByCellphone(cell)
+	if err == gorm.ErrRecordNotFound {
+		return nil, ErrOperatorNotFound
+	}
 	return operator, err
 }
@@ -0,0 +1,27 @@
+package service
+
+import (
+	"testing"
+
+	"code.bean.com/flamingo/model"
+)
+
+func TestCreateOperator(t *testing.T) {
+	s := NewOperatorService(model.NewMemoryStore(), nil, 0)
+	cases := []struct {
+		cell string
+		pwd  string
+		err  error
+	}{
+		{"1380000000", "secret", ErrIllegalPhoneNo},
+		{"13800000000x", "secret", ErrIllegalPhoneNo},
+		{"13800000001", "12345", ErrWeakPassword},
+		{"13800000001", "secret", nil},
+		{"13800000001", "secret", ErrOperatorAlreadyExist},
+	}
+	for _, c := range cases {
+		if _, err := s.CreateOperator(c.cell, "操作员", c.pwd); err != c.err {
+			t.Errorf("CreateOperator(%q, %q) error = %v, want %v", c.cell, c.pwd, err, c.err)
+		}
+	}
+}
@@ -0,0 +1,256 @@
+package service
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"code.bean.com/flamingo/model"
+	"code.bean.com/flamingo/util"
+)
+
+var alipayGatewayURL = "https://openapi.alipay.com/gateway.do"
+
+// AlipayGateway 支付宝当面付(RSA2 签名)
+type AlipayGateway struct {
+	AppID      string
+	Subject    string // 订单标题
+	privateKey *rsa.PrivateKey
+	publicKey  *rsa.PublicKey // 支付宝公钥
+}
+
+type alipayTradeResp struct {
+	Code        string `json:"code"`
+	Msg         string `json:"msg"`
+	SubCode     string `json:"sub_code"`
+	SubMsg      string `json:"sub_msg"`
+	OutTradeNo  string `json:"out_trade_no"`
+	TradeNo     string `json:"trade_no"`
+	QRCode      string `json:"qr_code"`
+	TradeStatus string `json:"trade_status"`
+	TotalAmount string `json:"total_amount"`
+	SendPayDate string `json:"send_pay_date"`
+}
+
+// NewAlipayGateway privateKey 为应用私钥，publicKey 为支付宝公钥，均支持 PEM 或不带头尾的 base64
+func NewAlipayGateway(appID, subject, privateKey, publicKey string) (*AlipayGateway, error) {
+	g := &AlipayGateway{AppID: appID, Subject: subject}
+	der, err := decodeKey(privateKey)
+	if err != nil {
+		return nil, err
+	}
+	if key, err := x509.ParsePKCS1PrivateKey(der); err == nil {
+		g.privateKey = key
+	} else {
+		key, err := x509.ParsePKCS8PrivateKey(der)
+		if err != nil {
+			return nil, err
+		}
+		rsaKey, ok := key.(*rsa.PrivateKey)
+		if !ok {
+			return nil, errors.New("alipay private key is not rsa")
+		}
+		g.privateKey = rsaKey
+	}
+	der, err = decodeKey(publicKey)
+	if err != nil {
+		return nil, err
+	}
+	key, err := x509.ParsePKIXPublicKey(der)
+	if err != nil {
+		return nil, err
+	}
+	rsaKey, ok := key.(*rsa.PublicKey)
+	if !ok {
+		return nil, errors.New("alipay public key is not rsa")
+	}
+	g.publicKey = rsaKey
+	return g, nil
+}
+
+func (g *AlipayGateway) Prepay(ctx context.Context, params *PrepayParams) (string, error) {
+	if params.Order.TradeType != PayTradeNative {
+		return "", fmt.Errorf("alipay does not support trade type %s", params.Order.TradeType)
+	}
+	biz := map[string]string{
+		"out_trade_no": params.Order.OrderNo,
+		"total_amount": formatAmount(params.Order.Amount),
+		"subject":      g.Subject,
+		"time_expire":  params.Order.ExpireTime.Format("2006-01-02 15:04:05"),
+	}
+	resp, err := g.call(ctx, "alipay.trade.precreate", biz, params.NotifyURL)
+	if err != nil {
+		return "", err
+	}
+	return resp.QRCode, nil
+}
+
+func (g *AlipayGateway) ParseNotify(r *http.Request) (*PayResult, error) {
+	if err := r.ParseForm(); err != nil {
+		return nil, err
+	}
+	params := make(map[string]string)
+	for k := range r.PostForm {
+		params[k] = r.PostForm.Get(k)
+	}
+	if params["app_id"] != g.AppID || !g.verify(alipaySignContent(params, "sign", "sign_type"), params["sign"]) {
+		return nil, ErrPayNotifyInvalid
+	}
+	result := &PayResult{OrderNo: params["out_trade_no"], TradeNo: params["trade_no"]}
+	result.Paid = params["trade_status"] == "TRADE_SUCCESS" || params["trade_status"] == "TRADE_FINISHED"
+	amount, err := yuanToCent(params["total_amount"])
+	if err != nil {
+		return nil, ErrPayNotifyInvalid
+	}
+	result.Amount = amount
+	result.PaidTime = parseAlipayTime(params["gmt_payment"])
+	return result, nil
+}
+
+func (g *AlipayGateway) NotifyAck(success bool) (string, string) {
+	if success {
+		return "text/plain", "success"
+	}
+	return "text/plain", "failure"
+}
+
+func (g *AlipayGateway) Query(ctx context.Context, order *model.PayOrder) (*PayResult, error) {
+	resp, err := g.call(ctx, "alipay.trade.query", map[string]string{"out_trade_no": order.OrderNo}, "")
+	if err != nil {
+		return nil, err
+	}
+	result := &PayResult{OrderNo: resp.OutTradeNo, TradeNo: resp.TradeNo}
+	result.Paid = resp.TradeStatus == "TRADE_SUCCESS" || resp.TradeStatus == "TRADE_FINISHED"
+	result.Amount, _ = yuanToCent(resp.TotalAmount)
+	result.PaidTime = parseAlipayTime(resp.SendPayDate)
+	return result, nil
+}
+
+func (g *AlipayGateway) Close(ctx context.Context, order *model.PayOrder) error {
+	_, err := g.call(ctx, "alipay.trade.close", map[string]string{"out_trade_no": order.OrderNo}, "")
+	return err
+}
+
+// call 调用支付宝接口，校验返回内容的签名
+func (g *AlipayGateway) call(ctx context.Context, method string, biz map[string]string, notifyURL string) (*alipayTradeResp, error) {
+	bizContent, err := json.Marshal(biz)
+	if err != nil {
+		return nil, err
+	}
+	params := map[string]string{
+		"app_id":      g.AppID,
+		"method":      method,
+		"format":      "JSON",
+		"charset":     "utf-8",
+		"sign_type":   "RSA2",
+		"timestamp":   time.Now().Format("2006-01-02 15:04:05"),
+		"version":     "1.0",
+		"notify_url":  notifyURL,
+		"biz_content": string(bizContent),
+	}
+	sign, err := g.sign(alipaySignContent(params, "sign"))
+	if err != nil {
+		return nil, err
+	}
+	values := url.Values{}
+	for k, v := range params {
+		if v != "" {
+			values.Set(k, v)
+		}
+	}
+	values.Set("sign", sign)
+	body, err := util.PostRaw(ctx, alipayGatewayURL, "application/x-www-form-urlencoded;charset=utf-8", []byte(values.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	var raw map[string]json.RawMessage
+	if err := json.Unmarshal(body, &raw); err != nil {
+		return nil, err
+	}
+	node := raw[strings.Replace(method, ".", "_", -1)+"_response"]
+	var respSign string
+	json.Unmarshal(raw["sign"], &respSign)
+	if !g.verify(string(node), respSign) {
+		return nil, fmt.Errorf("alipay response sign mismatch, method=%s", method)
+	}
+	var resp alipayTradeResp
+	if err := json.Unmarshal(node, &resp); err != nil {
+		return nil, err
+	}
+	// 用户未扫码时查询返回交易不存在，视为未支付
+	if resp.Code != "10000" && resp.SubCode != "ACQ.TRADE_NOT_EXIST" {
+		return nil, fmt.Errorf("alipay error, code=%s, sub_code=%s, sub_msg=%s", resp.Code, resp.SubCode, resp.SubMsg)
+	}
+	return &resp, nil
+}
+
+func (g *AlipayGateway) sign(content string) (string, error) {
+	hashed := sha256.Sum256([]byte(content))
+	sig, err := rsa.SignPKCS1v15(rand.Reader, g.privateKey, crypto.SHA256, hashed[:])
+	if err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(sig), nil
+}
+
+func (g *AlipayGateway) verify(content, sign string) bool {
+	sig, err := base64.StdEncoding.DecodeString(sign)
+	if err != nil || content == "" {
+		return false
+	}
+	hashed := sha256.Sum256([]byte(content))
+	return rsa.VerifyPKCS1v15(g.publicKey, crypto.SHA256, hashed[:], sig) == nil
+}
+
+// alipaySignContent 待签名内容：去掉 excludes 和空值后按 key 排序拼接
+func alipaySignContent(params map[string]string, excludes ...string) string {
+	keys := make([]string, 0, len(params))
+	for k, v := range params {
+		if v == "" {
+			continue
+		}
+		excluded := false
+		for _, e := range excludes {
+			if k == e {
+				excluded = true
+			}
+		}
+		if !excluded {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	pairs := make([]string, 0, len(keys))
+	for _, k := range keys {
+		pairs = append(pairs, k+"="+params[k])
+	}
+	return strings.Join(pairs, "&")
+}
+
+func parseAlipayTime(value string) time.Time {
+	t, err := time.ParseInLocation("2006-01-02 15:04:05", value, time.Local)
+	if err != nil {
+		return time.Now()
+	}
+	return t
+}
+
+func decodeKey(key string) ([]byte, error) {
+	if block, _ := pem.Decode([]byte(key)); block != nil {
+		return block.Bytes, nil
+	}
+	return base64.StdEncoding.DecodeString(key)
+}
@@ -0,0 +1,122 @@
+package service
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestAlipaySignContent(t *testing.T) {
+	// 支付宝开放平台文档自行实现签名一节的公共参数示例
+	params := map[string]string{
+		"app_id":      "2014072300007148",
+		"method":      "alipay.mobile.public.menu.add",
+		"charset":     "GBK",
+		"sign_type":   "RSA2",
+		"timestamp":   "2014-07-24 03:07:50",
+		"biz_content": `{"button":[{"actionParam":"ZFB_HFCZ","actionType":"out","name":"话费充值"}]}`,
+		"sign":        "xxx",
+		"version":     "1.0",
+		"notify_url":  "",
+	}
+	want := `app_id=2014072300007148&biz_content={"button":[{"actionParam":"ZFB_HFCZ","actionType":"out","name":"话费充值"}]}` +
+		`&charset=GBK&method=alipay.mobile.public.menu.add&sign_type=RSA2&timestamp=2014-07-24 03:07:50&version=1.0`
+	if got := alipaySignContent(params, "sign"); got != want {
+		t.Errorf("alipaySignContent excluding sign:\n got %s\nwant %s", got, want)
+	}
+	// 异步通知验签时 sign 和 sign_type 都不参与
+	want = strings.Replace(want, "&sign_type=RSA2", "", 1)
+	if got := alipaySignContent(params, "sign", "sign_type"); got != want {
+		t.Errorf("alipaySignContent excluding sign and sign_type:\n got %s\nwant %s", got, want)
+	}
+}
+
+// testAlipayGateway 应用私钥和支付宝公钥使用同一对测试密钥，便于用 sign 生成通知的签名
+func testAlipayGateway(t *testing.T) *AlipayGateway {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pub, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	g, err := NewAlipayGateway("2014072300007148", "会员储值", base64.StdEncoding.EncodeToString(x509.MarshalPKCS1PrivateKey(key)),
+		base64.StdEncoding.EncodeToString(pub))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return g
+}
+
+func TestAlipayVerify(t *testing.T) {
+	g := testAlipayGateway(t)
+	content := "app_id=2014072300007148&out_trade_no=20261019120000abcd&total_amount=100.10"
+	sign, err := g.sign(content)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cases := []struct {
+		name    string
+		content string
+		sign    string
+		ok      bool
+	}{
+		{"valid", content, sign, true},
+		{"tampered content", strings.Replace(content, "100.10", "1.00", 1), sign, false},
+		{"tampered sign", content, "A" + sign[1:], false},
+		{"not base64", content, "!!!", false},
+		{"empty content", "", sign, false},
+	}
+	for _, c := range cases {
+		if ok := g.verify(c.content, c.sign); ok != c.ok {
+			t.Errorf("%s: verify=%v, want %v", c.name, ok, c.ok)
+		}
+	}
+}
+
+func TestAlipayParseNotify(t *testing.T) {
+	g := testAlipayGateway(t)
+	notify := func(mutate func(params map[string]string)) url.Values {
+		params := map[string]string{
+			"app_id": g.AppID, "out_trade_no": "20261019120000abcd", "trade_no": "2026101922001", "trade_status": "TRADE_SUCCESS",
+			"total_amount": "100.10", "gmt_payment": "2026-10-19 12:01:02", "sign_type": "RSA2",
+		}
+		params["sign"], _ = g.sign(alipaySignContent(params, "sign", "sign_type"))
+		if mutate != nil {
+			mutate(params)
+		}
+		form := url.Values{}
+		for k, v := range params {
+			form.Set(k, v)
+		}
+		return form
+	}
+	cases := []struct {
+		name string
+		form url.Values
+		ok   bool
+	}{
+		{"valid", notify(nil), true},
+		{"tampered amount", notify(func(p map[string]string) { p["total_amount"] = "1.00" }), false},
+		{"other app", notify(func(p map[string]string) { p["app_id"] = "2014072300007149" }), false},
+		{"missing sign", notify(func(p map[string]string) { delete(p, "sign") }), false},
+	}
+	for _, c := range cases {
+		req := httptest.NewRequest("POST", "/pay/notify/alipay", strings.NewReader(c.form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		result, err := g.ParseNotify(req)
+		if (err == nil) != c.ok {
+			t.Errorf("%s: err=%v, want ok=%v", c.name, err, c.ok)
+			continue
+		}
+		if c.ok && (result.OrderNo != "20261019120000abcd" || result.Amount != 10010 || !result.Paid) {
+			t.Errorf("%s: result=%+v", c.name, result)
+		}
+	}
+}
@@ -0,0 +1,139 @@
+package service
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"regexp"
+	"strconv"
+	"time"
+
+	"code.bean.com/flamingo/model"
+)
+
+// 支付渠道
+const (
+	PayGatewayWechat = "wechat"
+	PayGatewayAlipay = "alipay"
+	PayGatewayFake   = "fake"
+)
+
+// 支付方式
+const (
+	PayTradeNative = "native" //扫码支付
+	PayTradeJSAPI  = "jsapi"  //微信内网页支付
+)
+
+// ErrPayNotifyInvalid 异步通知签名或内容错误
+var ErrPayNotifyInvalid = errors.New("invalid pay notify")
+
+// PayResult 支付网关返回的支付结果
+type PayResult struct {
+	OrderNo  string
+	TradeNo  string
+	Amount   int
+	Paid     bool
+	PaidTime time.Time
+}
+
+// PrepayParams 下单参数
+type PrepayParams struct {
+	Order     *model.PayOrder
+	OpenID    string // JSAPI 支付时的付款人 openid
+	ClientIP  string
+	NotifyURL string
+}
+
+// PayGateway 支付网关
+type PayGateway interface {
+	// Prepay 下单，扫码支付返回二维码链接，JSAPI 支付返回调起支付的 json 参数
+	Prepay(ctx context.Context, params *PrepayParams) (string, error)
+	// ParseNotify 校验签名并解析异步通知
+	ParseNotify(r *http.Request) (*PayResult, error)
+	// NotifyAck 异步通知的应答内容
+	NotifyAck(success bool) (contentType string, body string)
+	// Query 主动查询订单支付结果
+	Query(ctx context.Context, order *model.PayOrder) (*PayResult, error)
+	// Close 关闭未支付的订单
+	Close(ctx context.Context, order *model.PayOrder) error
+}
+
+// FakePayGateway 本地测试用的支付网关，通过 /pay/fake_complete 模拟用户完成支付
+type FakePayGateway struct {
+	Secret string
+}
+
+func (g *FakePayGateway) Prepay(ctx context.Context, params *PrepayParams) (string, error) {
+	return fmt.Sprintf("fake://pay?order_no=%s&amount=%d", params.Order.OrderNo, params.Order.Amount), nil
+}
+
+// SignNotify 生成模拟异步通知的签名
+func (g *FakePayGateway) SignNotify(orderNo, tradeNo string, amount int) string {
+	mac := hmac.New(sha256.New, []byte(g.Secret))
+	fmt.Fprintf(mac, "%s|%s|%d", orderNo, tradeNo, amount)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func (g *FakePayGateway) ParseNotify(r *http.Request) (*PayResult, error) {
+	orderNo, tradeNo := r.PostFormValue("order_no"), r.PostFormValue("trade_no")
+	amount, err := strconv.Atoi(r.PostFormValue("amount"))
+	if err != nil {
+		return nil, ErrPayNotifyInvalid
+	}
+	if !hmac.Equal([]byte(g.SignNotify(orderNo, tradeNo, amount)), []byte(r.PostFormValue("sign"))) {
+		return nil, ErrPayNotifyInvalid
+	}
+	return &PayResult{OrderNo: orderNo, TradeNo: tradeNo, Amount: amount, Paid: true, PaidTime: time.Now()}, nil
+}
+
+func (g *FakePayGateway) NotifyAck(success bool) (string, string) {
+	if success {
+		return "text/plain", "success"
+	}
+	return "text/plain", "fail"
+}
+
+func (g *FakePayGateway) Query(ctx context.Context, order *model.PayOrder) (*PayResult, error) {
+	return &PayResult{OrderNo: order.OrderNo}, nil
+}
+
+func (g *FakePayGateway) Close(ctx context.Context, order *model.PayOrder) error {
+	return nil
+}
+
+// amountRegexp 以元为单位的金额，最多 9 位整数和 2 位小数，不接受科学计数法、十六进制、Inf 和 NaN
+var amountRegexp = regexp.MustCompile(`^([0-9]{1,9})(\.([0-9]{1,2}))?$`)
+
+// yuanToCent 将 "10.00" 格式的记账金额转换为分，金额必须大于 0
+// 充值、消费、退款、确认码和在线支付等写入流水的金额都应通过它转换
+func yuanToCent(amount string) (int, error) {
+	cent, err := parseYuan(amount)
+	if err != nil {
+		return 0, err
+	}
+	if cent <= 0 {
+		return 0, fmt.Errorf("amount %q must be positive", amount)
+	}
+	return cent, nil
+}
+
+// parseYuan 将 "10.00" 格式的金额精确转换为分，允许 0，用于余额筛选和期初余额
+func parseYuan(amount string) (int, error) {
+	m := amountRegexp.FindStringSubmatch(amount)
+	if m == nil {
+		return 0, fmt.Errorf("invalid amount %q", amount)
+	}
+	yuan, _ := strconv.Atoi(m[1])
+	cent, _ := strconv.Atoi((m[3] + "00")[:2])
+	return yuan*100 + cent, nil
+}
+
+// floatToCent 将以元为单位的配置金额四舍五入为分
+func floatToCent(yuan float64) int {
+	return int(math.Round(yuan * 100))
+}
@@ -0,0 +1,72 @@
+package service
+
+import (
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestYuanToCent(t *testing.T) {
+	cases := []struct {
+		amount string
+		cent   int
+		ok     bool
+	}{
+		{"1", 100, true},
+		{"0.1", 10, true},
+		{"0.29", 29, true},
+		{"100.10", 10010, true},
+		{"999999999.99", 99999999999, true},
+		{"0", 0, false},
+		{"0.00", 0, false},
+		{"-1", 0, false},
+		{"1.005", 0, false},
+		{"1e30", 0, false},
+		{"0x1p70", 0, false},
+		{"Inf", 0, false},
+		{"NaN", 0, false},
+		{"1000000000", 0, false},
+		{"", 0, false},
+	}
+	for _, c := range cases {
+		cent, err := yuanToCent(c.amount)
+		if (err == nil) != c.ok || cent != c.cent {
+			t.Errorf("yuanToCent(%q)=%d, %v, want %d, ok=%v", c.amount, cent, err, c.cent, c.ok)
+		}
+	}
+	if cent, err := parseYuan("0"); err != nil || cent != 0 {
+		t.Errorf("parseYuan(0)=%d, %v, want 0", cent, err)
+	}
+}
+
+func TestFakePayGatewayNotify(t *testing.T) {
+	g := &FakePayGateway{Secret: "test"}
+	// HMAC-SHA256(key="test", "20261019120000abcd|T0001|10010")
+	const sign = "8eb2dcea03e345f0dbadf2c17715da4ac43bb0e4230696669423f740acd29f6c"
+	if got := g.SignNotify("20261019120000abcd", "T0001", 10010); got != sign {
+		t.Errorf("SignNotify=%s, want %s", got, sign)
+	}
+	cases := []struct {
+		name string
+		form url.Values
+		ok   bool
+	}{
+		{"valid", url.Values{"order_no": {"20261019120000abcd"}, "trade_no": {"T0001"}, "amount": {"10010"}, "sign": {sign}}, true},
+		{"tampered amount", url.Values{"order_no": {"20261019120000abcd"}, "trade_no": {"T0001"}, "amount": {"1"}, "sign": {sign}}, false},
+		{"uppercase sign", url.Values{"order_no": {"20261019120000abcd"}, "trade_no": {"T0001"}, "amount": {"10010"}, "sign": {strings.ToUpper(sign)}}, false},
+		{"missing sign", url.Values{"order_no": {"20261019120000abcd"}, "trade_no": {"T0001"}, "amount": {"10010"}}, false},
+	}
+	for _, c := range cases {
+		req := httptest.NewRequest("POST", "/pay/notify/fake", strings.NewReader(c.form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		result, err := g.ParseNotify(req)
+		if (err == nil) != c.ok {
+			t.Errorf("%s: err=%v, want ok=%v", c.name, err, c.ok)
+			continue
+		}
+		if c.ok && (result.OrderNo != "20261019120000abcd" || result.Amount != 10010) {
+			t.Errorf("%s: result=%+v", c.name, result)
+		}
+	}
+}
@@ -0,0 +1,347 @@
+package service
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+
+	"code.byted.org/gopkg/logs"
+	"github.com/jinzhu/gorm"
+
+	"code.bean.com/flamingo/handler/view"
+	"code.bean.com/flamingo/metrics"
+	"code.bean.com/flamingo/model"
+	"code.bean.com/flamingo/util"
+)
+
+const (
+	payOrderJobInterval = time.Minute
+	payOrderOperator    = "在线充值"
+)
+
+// PayOrderService 会员在线充值
+type PayOrderService struct {
+	payOrders  model.PayOrderRepository
+	customers  model.CustomerRepository
+	gateways   map[string]PayGateway
+	gateway    string // 默认使用的支付渠道
+	notifyBase string // 异步通知地址前缀，如 https://example.com
+	timeout    time.Duration
+	maxAmount  int
+}
+
+var payOrderService *PayOrderService
+var payOrderServiceOnce sync.Once
+
+func PayOrderServiceInstance() *PayOrderService {
+	payOrderServiceOnce.Do(
+		func() {
+			payOrderService = newPayOrderService()
+		})
+	return payOrderService
+}
+
+func newPayOrderService() *PayOrderService {
+	conf := currentConfig().Pay
+	s := &PayOrderService{
+		payOrders:  repos().PayOrders,
+		customers:  repos().Customers,
+		gateways:   make(map[string]PayGateway),
+		gateway:    conf.Gateway,
+		notifyBase: conf.NotifyURLBase,
+		timeout:    time.Duration(conf.OrderTimeoutMinutes) * time.Minute,
+		maxAmount:  floatToCent(conf.MaxAmount),
+	}
+	subject := currentConfig().StoreName + "会员卡充值"
+
+	if wx := conf.Wechat; wx.MchID != "" {
+		s.gateways[PayGatewayWechat] = &WechatPayGateway{
+			AppID:  wx.AppID,
+			MchID:  wx.MchID,
+			APIKey: wx.APIKey,
+			Body:   subject,
+		}
+	}
+	if ali := conf.Alipay; ali.AppID != "" {
+		g, err := NewAlipayGateway(ali.AppID, subject, ali.PrivateKey, ali.AlipayPublicKey)
+		if err != nil {
+			logs.Error("init alipay gateway error, err=%+v", err)
+		} else {
+			s.gateways[PayGatewayAlipay] = g
+		}
+	}
+	if conf.FakeEnabled {
+		s.gateways[PayGatewayFake] = &FakePayGateway{Secret: conf.FakeSecret}
+		if s.gateway == "" {
+			s.gateway = PayGatewayFake
+		}
+	}
+	return s
+}
+
+// CreateRechargeOrder 创建充值订单并向支付渠道下单
+func (s *PayOrderService) CreateRechargeOrder(customer *model.KroCustomer, amount, gatewayName, tradeType, clientIP string) (*view.RechargeOrder, error) {
+	if gatewayName == "" {
+		gatewayName = s.gateway
+	}
+	if tradeType == "" {
+		tradeType = PayTradeNative
+	}
+	gateway, ok := s.gateways[gatewayName]
+	if !ok || (tradeType != PayTradeNative && tradeType != PayTradeJSAPI) {
+		return nil, ErrInvalidParam
+	}
+	if tradeType == PayTradeJSAPI && customer.WxOpenID == "" {
+		return nil, ErrInvalidParam
+	}
+	cent, err := yuanToCent(amount)
+	if err != nil || cent > s.maxAmount {
+		return nil, ErrInvalidParam
+	}
+	now := time.Now()
+	order := &model.PayOrder{
+		OrderNo:    IDGenerator() + util.RandomHex(8), // 14 位时间加 16 位随机数，不超过支付渠道限制的 32 位
+		CustomerID: customer.ID,
+		Amount:     cent,
+		Gateway:    gatewayName,
+		TradeType:  tradeType,
+		Status:     model.PayOrderPending,
+		CreateTime: now,
+		ExpireTime: now.Add(s.timeout),
+	}
+	if err := s.payOrders.CreatePayOrder(order); err != nil {
+		return nil, ErrorServiceInternalError
+	}
+	payload, err := gateway.Prepay(context.Background(), &PrepayParams{
+		Order:     order,
+		OpenID:    customer.WxOpenID,
+		ClientIP:  clientIP,
+		NotifyURL: s.notifyBase + "/pay/notify/" + gatewayName,
+	})
+	if err != nil {
+		logs.Error("prepay error, order=%s, err=%+v", order.OrderNo, err)
+		s.payOrders.MarkClosed(order)
+		return nil, ErrPayGatewayError
+	}
+	info := payOrderInfo(order)
+	info.Payload = payload
+	if tradeType == PayTradeNative {
+		if info.QRCode, err = util.QRCodeDataURI(payload, qrCodeSize); err != nil {
+			logs.Error("encode pay order qrcode error, order=%s, err=%+v", order.OrderNo, err)
+			return nil, ErrorServiceInternalError
+		}
+	}
+	return info, nil
+}
+
+// QueryRechargeOrder 查询会员的充值订单，未支付的订单会向支付渠道确认
+func (s *PayOrderService) QueryRechargeOrder(customer *model.KroCustomer, orderNo string) (*view.RechargeOrder, error) {
+	order, err := s.payOrders.GetPayOrder(orderNo)
+	if err == gorm.ErrRecordNotFound || (err == nil && order.CustomerID != customer.ID) {
+		return nil, ErrPayOrderNotFound
+	}
+	if err != nil {
+		return nil, ErrorServiceInternalError
+	}
+	if order.Status == model.PayOrderPending || order.Status == model.PayOrderPaid {
+		s.sync(context.Background(), order)
+		if order, err = s.payOrders.GetPayOrder(orderNo); err != nil {
+			return nil, ErrorServiceInternalError
+		}
+	}
+	return payOrderInfo(order), nil
+}
+
+// HandleNotify 处理支付渠道的异步通知，返回应答内容
+func (s *PayOrderService) HandleNotify(gatewayName string, r *http.Request) (string, string) {
+	gateway, ok := s.gateways[gatewayName]
+	if !ok {
+		return "text/plain", "unknown gateway"
+	}
+	result, err := gateway.ParseNotify(r)
+	if err != nil {
+		logs.Error("parse pay notify error, gateway=%s, err=%+v", gatewayName, err)
+		return gateway.NotifyAck(false)
+	}
+	order, err := s.payOrders.GetPayOrder(result.OrderNo)
+	if err != nil || order.Gateway != gatewayName {
+		logs.Error("get pay order error, order=%s, err=%+v", result.OrderNo, err)
+		return gateway.NotifyAck(false)
+	}
+	if err := s.handleResult(order, result); err != nil {
+		return gateway.NotifyAck(false)
+	}
+	return gateway.NotifyAck(true)
+}
+
+// RunOrderJobs 后台关闭超时未支付的订单，补偿已支付未入账的订单，ctx 取消时退出
+func (s *PayOrderService) RunOrderJobs(ctx context.Context) {
+	ticker := time.NewTicker(payOrderJobInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		now := time.Now()
+		if orders, err := s.payOrders.GetPayOrdersByStatus(model.PayOrderPending, now.Add(-s.timeout), 100); err == nil {
+			for _, order := range orders {
+				s.closeExpired(ctx, order)
+			}
+		}
+		if orders, err := s.payOrders.GetPayOrdersByStatus(model.PayOrderPaid, now.Add(-payOrderJobInterval), 100); err == nil {
+			for _, order := range orders {
+				s.credit(order)
+			}
+		}
+	}
+}
+
+// sync 向支付渠道查询订单状态，已支付的订单入账
+func (s *PayOrderService) sync(ctx context.Context, order *model.PayOrder) {
+	if order.Status == model.PayOrderPaid {
+		s.credit(order)
+		return
+	}
+	gateway, ok := s.gateways[order.Gateway]
+	if !ok {
+		return
+	}
+	result, err := gateway.Query(ctx, order)
+	if err != nil {
+		logs.Error("query pay order error, order=%s, err=%+v", order.OrderNo, err)
+		return
+	}
+	s.handleResult(order, result)
+}
+
+func (s *PayOrderService) closeExpired(ctx context.Context, order *model.PayOrder) {
+	gateway, ok := s.gateways[order.Gateway]
+	if !ok {
+		s.payOrders.MarkClosed(order)
+		return
+	}
+	// 关单前再确认一次，避免错过已支付的订单
+	result, err := gateway.Query(ctx, order)
+	if err != nil {
+		logs.Error("query expired pay order error, order=%s, err=%+v", order.OrderNo, err)
+		return
+	}
+	if result.Paid {
+		s.handleResult(order, result)
+		return
+	}
+	if err := gateway.Close(ctx, order); err != nil {
+		logs.Error("close pay order error, order=%s, err=%+v", order.OrderNo, err)
+		return
+	}
+	s.payOrders.MarkClosed(order)
+}
+
+func (s *PayOrderService) handleResult(order *model.PayOrder, result *PayResult) error {
+	if !result.Paid {
+		return nil
+	}
+	if result.Amount != order.Amount {
+		logs.Error("pay amount mismatch, order=%s, expect=%d, paid=%d", order.OrderNo, order.Amount, result.Amount)
+		return ErrPayNotifyInvalid
+	}
+	if _, err := s.payOrders.MarkPaid(order, result.TradeNo, result.PaidTime); err != nil {
+		return err
+	}
+	if order.TradeNo == "" {
+		order.TradeNo = result.TradeNo
+	}
+	return s.credit(order)
+}
+
+// credit 已支付订单入账，订单状态保证同一订单只写入一条充值流水
+func (s *PayOrderService) credit(order *model.PayOrder) error {
+	account := &model.KroAccount{
+		CustomerID:  order.CustomerID,
+		AccountType: model.AccountTypeRecharge,
+		Amount:      order.Amount,
+		DealTime:    time.Now(),
+		Desc:        "订单号" + order.OrderNo,
+		Operator:    payOrderOperator,
+		PayMethod:   payOrderMethod(order.Gateway),
+		PayRef:      order.TradeNo,
+		Tendered:    order.Amount,
+	}
+	ok, err := s.payOrders.CreditPayOrder(order, account)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return nil
+	}
+	logs.Info("pay order credited, order=%s, amount=%d", order.OrderNo, order.Amount)
+	metrics.ObserveAccount(account.AccountType, account.Amount)
+	if customer, err := s.customers.GetCustomerByID(order.CustomerID); err == nil {
+		CustomerServiceInstance().notifyAccount(customer, account)
+	}
+	return nil
+}
+
+func payOrderMethod(gateway string) string {
+	switch gateway {
+	case PayGatewayWechat:
+		return model.PayMethodWechat
+	case PayGatewayAlipay:
+		return model.PayMethodAlipay
+	default:
+		return model.PayMethodOther
+	}
+}
+
+func payOrderInfo(order *model.PayOrder) *view.RechargeOrder {
+	return &view.RechargeOrder{
+		OrderNo:    order.OrderNo,
+		Amount:     formatAmount(order.Amount),
+		Gateway:    order.Gateway,
+		TradeType:  order.TradeType,
+		Status:     order.Status,
+		StatusDesc: GetPayOrderStatus(order.Status),
+		ExpireAt:   order.ExpireTime.Format("2006-01-02 15:04:05"),
+	}
+}
+
+func GetPayOrderStatus(status string) string {
+	switch status {
+	case model.PayOrderPending:
+		return "待支付"
+	case model.PayOrderPaid:
+		return "已支付"
+	case model.PayOrderCredited:
+		return "已到账"
+	default:
+		return "已关闭"
+	}
+}
+
+// FakeCompletePayOrder 模拟用户支付完成，按支付渠道的异步通知流程处理，仅用于本地测试
+func (s *PayOrderService) FakeCompletePayOrder(orderNo string) error {
+	gateway, ok := s.gateways[PayGatewayFake].(*FakePayGateway)
+	if !ok {
+		return ErrIllegalDataAccess
+	}
+	order, err := s.payOrders.GetPayOrder(orderNo)
+	if err != nil || order.Gateway != PayGatewayFake {
+		return ErrPayOrderNotFound
+	}
+	tradeNo := "FAKE" + order.OrderNo
+	form := url.Values{}
+	form.Set("order_no", order.OrderNo)
+	form.Set("trade_no", tradeNo)
+	form.Set("amount", strconv.Itoa(order.Amount))
+	form.Set("sign", gateway.SignNotify(order.OrderNo, tradeNo, order.Amount))
+	_, ack := s.HandleNotify(PayGatewayFake, &http.Request{Method: "POST", PostForm: form})
+	if ack != "success" {
+		return ErrPayGatewayError
+	}
+	return nil
+}
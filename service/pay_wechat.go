@@ -0,0 +1,204 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/md5"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"code.bean.com/flamingo/model"
+	"code.bean.com/flamingo/util"
+)
+
+var (
+	wxPayUnifiedOrderURL = "https://api.mch.weixin.qq.com/pay/unifiedorder"
+	wxPayOrderQueryURL   = "https://api.mch.weixin.qq.com/pay/orderquery"
+	wxPayCloseOrderURL   = "https://api.mch.weixin.qq.com/pay/closeorder"
+)
+
+// WechatPayGateway 微信支付(v2 接口，MD5 签名)
+type WechatPayGateway struct {
+	AppID  string
+	MchID  string
+	APIKey string
+	Body   string // 商品描述
+}
+
+func (g *WechatPayGateway) Prepay(ctx context.Context, params *PrepayParams) (string, error) {
+	order := params.Order
+	req := map[string]string{
+		"body":             g.Body,
+		"out_trade_no":     order.OrderNo,
+		"total_fee":        strconv.Itoa(order.Amount),
+		"spbill_create_ip": params.ClientIP,
+		"notify_url":       params.NotifyURL,
+		"time_expire":      order.ExpireTime.Format("20060102150405"),
+	}
+	if order.TradeType == PayTradeJSAPI {
+		req["trade_type"] = "JSAPI"
+		req["openid"] = params.OpenID
+	} else {
+		req["trade_type"] = "NATIVE"
+		req["product_id"] = order.OrderNo
+	}
+	resp, err := g.call(ctx, wxPayUnifiedOrderURL, req)
+	if err != nil {
+		return "", err
+	}
+	if order.TradeType != PayTradeJSAPI {
+		return resp["code_url"], nil
+	}
+	jsParams := map[string]string{
+		"appId":     g.AppID,
+		"timeStamp": strconv.FormatInt(time.Now().Unix(), 10),
+		"nonceStr":  CreateCaptcha() + CreateCaptcha(),
+		"package":   "prepay_id=" + resp["prepay_id"],
+		"signType":  "MD5",
+	}
+	jsParams["paySign"] = g.sign(jsParams)
+	buf, err := json.Marshal(jsParams)
+	return string(buf), err
+}
+
+func (g *WechatPayGateway) ParseNotify(r *http.Request) (*PayResult, error) {
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return nil, err
+	}
+	params, err := parseWxPayXML(body)
+	if err != nil {
+		return nil, err
+	}
+	if params["return_code"] != "SUCCESS" || !g.verify(params) {
+		return nil, ErrPayNotifyInvalid
+	}
+	return g.result(params), nil
+}
+
+func (g *WechatPayGateway) NotifyAck(success bool) (string, string) {
+	if success {
+		return "text/xml", "<xml><return_code><![CDATA[SUCCESS]]></return_code><return_msg><![CDATA[OK]]></return_msg></xml>"
+	}
+	return "text/xml", "<xml><return_code><![CDATA[FAIL]]></return_code><return_msg><![CDATA[FAIL]]></return_msg></xml>"
+}
+
+func (g *WechatPayGateway) Query(ctx context.Context, order *model.PayOrder) (*PayResult, error) {
+	resp, err := g.call(ctx, wxPayOrderQueryURL, map[string]string{"out_trade_no": order.OrderNo})
+	if err != nil {
+		return nil, err
+	}
+	return g.result(resp), nil
+}
+
+func (g *WechatPayGateway) Close(ctx context.Context, order *model.PayOrder) error {
+	_, err := g.call(ctx, wxPayCloseOrderURL, map[string]string{"out_trade_no": order.OrderNo})
+	return err
+}
+
+func (g *WechatPayGateway) result(params map[string]string) *PayResult {
+	result := &PayResult{OrderNo: params["out_trade_no"], TradeNo: params["transaction_id"]}
+	result.Amount, _ = strconv.Atoi(params["total_fee"])
+	// 异步通知没有 trade_state 字段，result_code 为 SUCCESS 即支付成功
+	tradeState, ok := params["trade_state"]
+	result.Paid = params["result_code"] == "SUCCESS" && (!ok || tradeState == "SUCCESS")
+	paidTime, err := time.ParseInLocation("20060102150405", params["time_end"], time.Local)
+	if err != nil {
+		paidTime = time.Now()
+	}
+	result.PaidTime = paidTime
+	return result
+}
+
+// call 调用微信支付接口，校验返回的签名和业务结果
+func (g *WechatPayGateway) call(ctx context.Context, url string, req map[string]string) (map[string]string, error) {
+	req["appid"] = g.AppID
+	req["mch_id"] = g.MchID
+	req["nonce_str"] = CreateCaptcha() + CreateCaptcha()
+	req["sign"] = g.sign(req)
+	body, err := util.PostRaw(ctx, url, "text/xml", wxPayXML(req))
+	if err != nil {
+		return nil, err
+	}
+	resp, err := parseWxPayXML(body)
+	if err != nil {
+		return nil, err
+	}
+	if resp["return_code"] != "SUCCESS" {
+		return nil, fmt.Errorf("wechat pay error, return_msg=%s", resp["return_msg"])
+	}
+	if !g.verify(resp) {
+		return nil, fmt.Errorf("wechat pay response sign mismatch")
+	}
+	if resp["result_code"] != "SUCCESS" && resp["err_code"] != "ORDERNOTEXIST" {
+		return nil, fmt.Errorf("wechat pay error, err_code=%s, err_code_des=%s", resp["err_code"], resp["err_code_des"])
+	}
+	return resp, nil
+}
+
+// sign 微信支付 MD5 签名：非空参数按 key 排序拼接后加上 API 密钥
+func (g *WechatPayGateway) sign(params map[string]string) string {
+	keys := make([]string, 0, len(params))
+	for k, v := range params {
+		if k == "sign" || v == "" {
+			continue
+		}
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var buf bytes.Buffer
+	for _, k := range keys {
+		buf.WriteString(k + "=" + params[k] + "&")
+	}
+	buf.WriteString("key=" + g.APIKey)
+	return strings.ToUpper(fmt.Sprintf("%x", md5.Sum(buf.Bytes())))
+}
+
+// verify 以常量时间比较签名，避免通过响应时间逐字节猜出签名
+func (g *WechatPayGateway) verify(params map[string]string) bool {
+	return hmac.Equal([]byte(params["sign"]), []byte(g.sign(params)))
+}
+
+func wxPayXML(params map[string]string) []byte {
+	var buf bytes.Buffer
+	buf.WriteString("<xml>")
+	for k, v := range params {
+		buf.WriteString("<" + k + "><![CDATA[" + v + "]]></" + k + ">")
+	}
+	buf.WriteString("</xml>")
+	return buf.Bytes()
+}
+
+func parseWxPayXML(data []byte) (map[string]string, error) {
+	params := make(map[string]string)
+	decoder := xml.NewDecoder(bytes.NewReader(data))
+	var key string
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			return params, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		switch t := token.(type) {
+		case xml.StartElement:
+			key = t.Name.Local
+		case xml.CharData:
+			if key != "" && key != "xml" {
+				params[key] += string(t)
+			}
+		case xml.EndElement:
+			key = ""
+		}
+	}
+}
@@ -0,0 +1,67 @@
+package service
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// wxPayDocKey 微信支付文档签名算法示例中的 API 密钥
+const wxPayDocKey = "192006250b4c09247ec02edce69f6a2d"
+
+func TestWechatPaySign(t *testing.T) {
+	g := &WechatPayGateway{APIKey: wxPayDocKey}
+	cases := []struct {
+		params map[string]string
+		sign   string
+	}{
+		// 微信支付文档的签名示例
+		{map[string]string{"appid": "wxd930ea5d5a258f4f", "mch_id": "10000100", "device_info": "1000", "body": "test", "nonce_str": "ibuaiVcKdpRxkhJA"},
+			"9A0A8659F005D6984697E2CA0A9CF3B7"},
+		// 空值和 sign 字段不参与签名
+		{map[string]string{"appid": "wxd930ea5d5a258f4f", "mch_id": "10000100", "device_info": "1000", "body": "test", "nonce_str": "ibuaiVcKdpRxkhJA",
+			"attach": "", "sign": "WRONG"}, "9A0A8659F005D6984697E2CA0A9CF3B7"},
+	}
+	for _, c := range cases {
+		if sign := g.sign(c.params); sign != c.sign {
+			t.Errorf("sign(%v)=%s, want %s", c.params, sign, c.sign)
+		}
+	}
+}
+
+func TestWechatPayParseNotify(t *testing.T) {
+	g := &WechatPayGateway{AppID: "wxd930ea5d5a258f4f", MchID: "10000100", APIKey: wxPayDocKey}
+	notify := func(mutate func(params map[string]string)) map[string]string {
+		params := map[string]string{
+			"appid": g.AppID, "mch_id": g.MchID, "nonce_str": "ibuaiVcKdpRxkhJA", "return_code": "SUCCESS", "result_code": "SUCCESS",
+			"out_trade_no": "20261019120000abcd", "transaction_id": "4200000001", "total_fee": "10010", "time_end": "20261019120102",
+		}
+		params["sign"] = g.sign(params)
+		if mutate != nil {
+			mutate(params)
+		}
+		return params
+	}
+	cases := []struct {
+		name   string
+		params map[string]string
+		ok     bool
+	}{
+		{"valid", notify(nil), true},
+		{"lowercase sign", notify(func(p map[string]string) { p["sign"] = strings.ToLower(p["sign"]) }), false},
+		{"tampered amount", notify(func(p map[string]string) { p["total_fee"] = "1" }), false},
+		{"missing sign", notify(func(p map[string]string) { delete(p, "sign") }), false},
+		{"return fail", notify(func(p map[string]string) { p["return_code"] = "FAIL"; p["sign"] = g.sign(p) }), false},
+	}
+	for _, c := range cases {
+		req := httptest.NewRequest("POST", "/pay/notify/wechat", strings.NewReader(string(wxPayXML(c.params))))
+		result, err := g.ParseNotify(req)
+		if (err == nil) != c.ok {
+			t.Errorf("%s: err=%v, want ok=%v", c.name, err, c.ok)
+			continue
+		}
+		if c.ok && (result.OrderNo != "20261019120000abcd" || result.TradeNo != "4200000001" || result.Amount != 10010 || !result.Paid) {
+			t.Errorf("%s: result=%+v", c.name, result)
+		}
+	}
+}
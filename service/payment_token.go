@@ -0,0 +1,112 @@
+package service
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"code.byted.org/gopkg/logs"
+	"github.com/jinzhu/gorm"
+
+	"code.bean.com/flamingo/handler/view"
+	"code.bean.com/flamingo/model"
+	"code.bean.com/flamingo/util"
+)
+
+const (
+	paymentTokenStep   = 30 // 付款码刷新周期，单位秒
+	paymentTokenWindow = 1  // 允许使用的历史周期数，容忍扫码时刚好刷新
+	paymentTokenSigLen = 16
+	qrCodeSize         = 200 // 页面展示的二维码边长，单位像素
+)
+
+// CreatePaymentToken 生成会员当前周期的付款码
+func (s *CustomerService) CreatePaymentToken(customer *model.KroCustomer) (*view.PaymentToken, error) {
+	secret := paymentTokenSecret()
+	if secret == "" {
+		logs.Error("payment_token_secret not configured")
+		return nil, ErrorServiceInternalError
+	}
+	now := time.Now().Unix()
+	counter := now / paymentTokenStep
+	token := signPaymentToken(secret, customer.ID, counter)
+	qrcode, err := util.QRCodeDataURI(token, qrCodeSize)
+	if err != nil {
+		logs.Error("encode payment token qrcode error, err=%+v", err)
+		return nil, ErrorServiceInternalError
+	}
+	return &view.PaymentToken{
+		Token:    token,
+		ExpireIn: int(paymentTokenStep - now%paymentTokenStep),
+		QRCode:   qrcode,
+	}, nil
+}
+
+// ExchangePaymentToken 校验会员出示的付款码，返回会员信息和一次性的消费确认码
+func (s *CustomerService) ExchangePaymentToken(token string) (*view.PaymentAuth, error) {
+	secret := paymentTokenSecret()
+	if secret == "" {
+		logs.Error("payment_token_secret not configured")
+		return nil, ErrorServiceInternalError
+	}
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, ErrPaymentTokenInvalid
+	}
+	customerID, err1 := strconv.Atoi(parts[0])
+	counter, err2 := strconv.ParseInt(parts[1], 10, 64)
+	if err1 != nil || err2 != nil {
+		return nil, ErrPaymentTokenInvalid
+	}
+	if !hmac.Equal([]byte(signPaymentToken(secret, customerID, counter)), []byte(token)) {
+		return nil, ErrPaymentTokenInvalid
+	}
+	current := time.Now().Unix() / paymentTokenStep
+	if counter > current || counter < current-paymentTokenWindow {
+		return nil, ErrPaymentTokenInvalid
+	}
+	customer, err := s.customers.GetCustomerByID(customerID)
+	if err == gorm.ErrRecordNotFound {
+		return nil, ErrPaymentTokenInvalid
+	}
+	if err != nil {
+		return nil, ErrorServiceInternalError
+	}
+	// 每个周期的付款码只能使用一次，且不能使用比已使用过的更早的付款码
+	ok, err := s.customers.UsePayCounter(customer, counter)
+	if err != nil {
+		return nil, ErrorServiceInternalError
+	}
+	if !ok {
+		return nil, ErrPaymentTokenInvalid
+	}
+	info, err := s.GetCustomerDetailInfo(customer.Cellphone)
+	if err != nil {
+		return nil, err
+	}
+	payCode, err := s.CreatePayCode(customer)
+	if err != nil {
+		return nil, err
+	}
+	return &view.PaymentAuth{
+		Customer: info,
+		AuthCode: payCode.Code,
+		ExpireAt: payCode.ExpireAt,
+	}, nil
+}
+
+func signPaymentToken(secret string, customerID int, counter int64) string {
+	payload := fmt.Sprintf("%d.%d", customerID, counter)
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(payload))
+	sig := hex.EncodeToString(mac.Sum(nil))[:paymentTokenSigLen]
+	return payload + "." + sig
+}
+
+func paymentTokenSecret() string {
+	return currentConfig().Session.PaymentTokenSecret
+}
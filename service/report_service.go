@@ -0,0 +1,118 @@
+package service
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"code.bean.com/flamingo/handler/view"
+	"code.bean.com/flamingo/model"
+)
+
+const liabilityCacheTTL = time.Minute
+
+type ReportService struct {
+	accounts model.AccountRepository
+
+	mutex       sync.Mutex
+	liability   float64
+	liabilityAt time.Time
+}
+
+var reportService *ReportService
+var reportServiceOnce sync.Once
+
+func ReportServiceInstance() *ReportService {
+	reportServiceOnce.Do(
+		func() {
+			reportService = &ReportService{accounts: repos().Accounts}
+		})
+	return reportService
+}
+
+// Liability 所有会员的储值余额总和(元)，用于监控，结果缓存 liabilityCacheTTL
+func (s *ReportService) Liability() float64 {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if time.Since(s.liabilityAt) < liabilityCacheTTL {
+		return s.liability
+	}
+	total, err := s.accounts.GetTotalBalance()
+	if err != nil {
+		return s.liability
+	}
+	s.liability = float64(total) / 100
+	s.liabilityAt = time.Now()
+	return s.liability
+}
+
+// SettleReport 按日汇总流水，充值按付款方式分别汇总，用于与支付渠道的账单对账
+func (s *ReportService) SettleReport(date string) (*view.SettleReport, error) {
+	start, err := time.ParseInLocation("2006-01-02", date, time.Local)
+	if err != nil {
+		return nil, ErrInvalidParam
+	}
+	accounts, err := s.accounts.GetAccountsByDealTime(start, start.AddDate(0, 0, 1))
+	if err != nil {
+		return nil, ErrorServiceInternalError
+	}
+	report := &view.SettleReport{Date: date, PayMethods: make([]*view.PayMethodSummary, 0), Recharges: make([]*view.SettleRechargeItem, 0)}
+	var rechargeTotal, consumeTotal, refundTotal int
+	type methodTotal struct{ count, amount, tendered int }
+	methods := make(map[string]*methodTotal)
+	for _, account := range accounts {
+		switch account.AccountType {
+		case model.AccountTypeRecharge:
+			report.RechargeCount++
+			rechargeTotal += account.Amount
+			total, ok := methods[account.PayMethod]
+			if !ok {
+				total = &methodTotal{}
+				methods[account.PayMethod] = total
+			}
+			total.count++
+			total.amount += account.Amount
+			total.tendered += account.Tendered
+			report.Recharges = append(report.Recharges, &view.SettleRechargeItem{
+				DealTime:  account.DealTime.Format("2006-01-02 15:04:05"),
+				Operator:  account.Operator,
+				Amount:    formatAmount(account.Amount),
+				PayMethod: GetPayMethod(account.PayMethod),
+				PayRef:    account.PayRef,
+				Tendered:  formatAmount(account.Tendered),
+			})
+		case model.AccountTypeCunsume:
+			report.ConsumeCount++
+			consumeTotal += account.Amount
+		case model.AccountTypeOpening:
+			// 导入的期初余额不是当日收款，不参与对账
+		default:
+			report.RefundCount++
+			refundTotal += account.Amount
+		}
+	}
+	report.RechargeTotal = formatAmount(rechargeTotal)
+	report.ConsumeTotal = formatAmount(consumeTotal)
+	report.RefundTotal = formatAmount(refundTotal)
+	for method, total := range methods {
+		summary := &view.PayMethodSummary{
+			PayMethod:     method,
+			PayMethodDesc: GetPayMethod(method),
+			Count:         total.count,
+			Amount:        formatAmount(total.amount),
+			Tendered:      formatAmount(total.tendered),
+			Change:        formatAmount(total.tendered - total.amount),
+		}
+		if method == "" {
+			// 记录付款方式之前的历史流水
+			summary.PayMethodDesc = "未记录"
+			summary.Tendered = ""
+			summary.Change = ""
+		}
+		report.PayMethods = append(report.PayMethods, summary)
+	}
+	sort.Slice(report.PayMethods, func(i, j int) bool {
+		return report.PayMethods[i].PayMethod < report.PayMethods[j].PayMethod
+	})
+	return report, nil
+}
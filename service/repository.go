@@ -0,0 +1,36 @@
+package service
+
+import (
+	"sync"
+
+	"code.bean.com/flamingo/config"
+	"code.bean.com/flamingo/model"
+)
+
+var repositories *model.Repositories
+var repositoriesMutex sync.Mutex
+
+// SetRepositories 替换各服务使用的数据访问实现，需在第一次获取服务实例之前调用
+func SetRepositories(r *model.Repositories) {
+	repositoriesMutex.Lock()
+	defer repositoriesMutex.Unlock()
+	repositories = r
+}
+
+// repos 当前使用的数据访问实现，未设置时使用 gorm 实现
+func repos() *model.Repositories {
+	repositoriesMutex.Lock()
+	defer repositoriesMutex.Unlock()
+	if repositories == nil {
+		repositories = model.GormRepositories()
+	}
+	return repositories
+}
+
+// currentConfig 当前配置，未初始化时(如使用内存数据的测试)返回零值配置，各服务不依赖配置文件也能创建
+func currentConfig() *config.Config {
+	if config.ConfigInstance == nil {
+		return &config.Config{}
+	}
+	return config.ConfigInstance
+}
@@ -0,0 +1,231 @@
+package service
+
+import (
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"code.byted.org/gopkg/logs"
+	"github.com/jinzhu/gorm"
+
+	"code.bean.com/flamingo/handler/view"
+	"code.bean.com/flamingo/model"
+	"code.bean.com/flamingo/util"
+)
+
+// 运行时配置项，可通过管理员接口修改，无需重新部署
+const (
+	SettingSmsCooldown             = "sms_cooldown_seconds"
+	SettingConsumeConfirmThreshold = "consume_confirm_threshold"
+)
+
+// 配置项类型
+const (
+	SettingTypeInt   = "int"
+	SettingTypeFloat = "float"
+)
+
+const (
+	settingsCacheTTL     = 30 * time.Second // 多实例部署时，其他实例的修改最迟在该时间后生效
+	settingsHistoryLimit = 50
+)
+
+// SettingDef 配置项定义
+type SettingDef struct {
+	Key     string
+	Type    string
+	Default string
+	Desc    string
+	Min     float64 // 数值类型的取值范围，Min 和 Max 都为 0 时不限制
+	Max     float64
+}
+
+// validate 校验配置值的类型和取值范围
+func (def *SettingDef) validate(value string) error {
+	switch def.Type {
+	case SettingTypeInt, SettingTypeFloat:
+		var f float64
+		var err error
+		if def.Type == SettingTypeInt {
+			var n int
+			n, err = strconv.Atoi(value)
+			f = float64(n)
+		} else {
+			f, err = strconv.ParseFloat(value, 64)
+		}
+		if err != nil {
+			return fmt.Errorf("%s must be a number", def.Key)
+		}
+		if (def.Min != 0 || def.Max != 0) && (f < def.Min || f > def.Max) {
+			return fmt.Errorf("%s must be between %v and %v", def.Key, def.Min, def.Max)
+		}
+		return nil
+	}
+	return nil
+}
+
+type cachedSetting struct {
+	value    string
+	loadedAt time.Time
+}
+
+// SettingsService 运行时配置，存储在 ConfigItem 中，带进程内缓存
+type SettingsService struct {
+	configItems model.ConfigItemRepository
+	defs        []*SettingDef
+	defMap      map[string]*SettingDef
+
+	mutex      sync.Mutex
+	cache      map[string]*cachedSetting
+	generation uint64 // 每次 Invalidate 加一，读库期间缓存被清除时不写入读到的旧值
+}
+
+var settingsService *SettingsService
+var settingsServiceOnce sync.Once
+
+func SettingsServiceInstance() *SettingsService {
+	settingsServiceOnce.Do(
+		func() {
+			settingsService = NewSettingsService(repos().ConfigItems, currentConfig().ConsumeConfirmThreshold)
+		})
+	return settingsService
+}
+
+// NewSettingsService 使用指定的数据访问实现创建 SettingsService，consumeConfirmThreshold 为配置文件中的大额消费阈值，作为默认值
+func NewSettingsService(configItems model.ConfigItemRepository, consumeConfirmThreshold float64) *SettingsService {
+	s := &SettingsService{
+		configItems: configItems,
+		defMap:      make(map[string]*SettingDef),
+		cache:       make(map[string]*cachedSetting),
+	}
+	s.defs = []*SettingDef{
+		{Key: SettingSmsCooldown, Type: SettingTypeInt, Default: "120", Desc: "同一手机号两次发送验证码的最小间隔(秒)", Min: 30, Max: 3600},
+		{Key: SettingConsumeConfirmThreshold, Type: SettingTypeFloat, Default: strconv.FormatFloat(consumeConfirmThreshold, 'f', -1, 64),
+			Desc: "超过该金额(元)的消费需要会员确认码，0 表示不校验", Min: 0, Max: 1000000},
+	}
+	for _, def := range s.defs {
+		s.defMap[def.Key] = def
+	}
+	return s
+}
+
+// Get 获取配置值，未设置时返回默认值；读取失败时返回上次缓存的值，没有缓存时返回默认值且不缓存，下次重新读取
+// 读库时不持有锁，缓存过期时并发的请求可能各自读一次库
+func (s *SettingsService) Get(key string) string {
+	def, ok := s.defMap[key]
+	if !ok {
+		logs.Error("unknown setting:%s", key)
+		return ""
+	}
+	s.mutex.Lock()
+	cached, ok := s.cache[key]
+	generation := s.generation
+	s.mutex.Unlock()
+	if ok && time.Since(cached.loadedAt) < settingsCacheTTL {
+		return cached.value
+	}
+	value, err := s.configItems.GetConfigItem(key)
+	if err != nil && err != gorm.ErrRecordNotFound {
+		logs.Error("get setting error, key=%s, err=%+v", key, err)
+		if ok {
+			return cached.value
+		}
+		return def.Default
+	}
+	if err != nil || def.validate(value) != nil {
+		value = def.Default
+	}
+	s.mutex.Lock()
+	if s.generation == generation {
+		s.cache[key] = &cachedSetting{value: value, loadedAt: time.Now()}
+	}
+	s.mutex.Unlock()
+	return value
+}
+
+// Int 获取整数类型的配置
+func (s *SettingsService) Int(key string) int {
+	n, _ := strconv.Atoi(s.Get(key))
+	return n
+}
+
+// Float 获取浮点数类型的配置
+func (s *SettingsService) Float(key string) float64 {
+	f, _ := strconv.ParseFloat(s.Get(key), 64)
+	return f
+}
+
+// Set 校验并保存配置值，记录修改人
+func (s *SettingsService) Set(key, value string, operator *model.KroOperator) error {
+	def, ok := s.defMap[key]
+	if !ok {
+		return ErrSettingNotFound
+	}
+	if err := def.validate(value); err != nil {
+		return ErrInvalidSetting.WithDetail(err.Error())
+	}
+	if err := s.configItems.SetConfigItemWithHistory(key, value, operator.Cellphone); err != nil {
+		return ErrorServiceInternalError
+	}
+	logs.Info("setting changed, key=%s, value=%s, operator=%s", key, value, util.MaskPhone(operator.Cellphone))
+	s.Invalidate(key)
+	return nil
+}
+
+// Invalidate 清除配置项的缓存
+func (s *SettingsService) Invalidate(key string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	delete(s.cache, key)
+	s.generation++
+}
+
+// ListSettings 所有配置项的当前值
+func (s *SettingsService) ListSettings() ([]*view.Setting, error) {
+	keys := make([]string, 0, len(s.defs))
+	for _, def := range s.defs {
+		keys = append(keys, def.Key)
+	}
+	values, err := s.configItems.GetConfigItems(keys)
+	if err != nil {
+		return nil, ErrorServiceInternalError
+	}
+	settings := make([]*view.Setting, 0, len(s.defs))
+	for _, def := range s.defs {
+		value, ok := values[def.Key]
+		if !ok {
+			value = def.Default
+		}
+		settings = append(settings, &view.Setting{
+			Key:     def.Key,
+			Type:    def.Type,
+			Value:   value,
+			Default: def.Default,
+			Desc:    def.Desc,
+		})
+	}
+	return settings, nil
+}
+
+// GetSettingHistory 配置项的修改记录
+func (s *SettingsService) GetSettingHistory(key string) ([]*view.SettingHistory, error) {
+	if _, ok := s.defMap[key]; !ok {
+		return nil, ErrSettingNotFound
+	}
+	histories, err := s.configItems.GetConfigItemHistory(key, settingsHistoryLimit)
+	if err != nil {
+		return nil, ErrorServiceInternalError
+	}
+	result := make([]*view.SettingHistory, 0, len(histories))
+	for _, h := range histories {
+		result = append(result, &view.SettingHistory{
+			Key:        h.Key,
+			OldValue:   h.OldValue,
+			NewValue:   h.NewValue,
+			Operator:   h.Operator,
+			ChangeTime: h.ChangeTime.Format("2006-01-02 15:04:05"),
+		})
+	}
+	return result, nil
+}
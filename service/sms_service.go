@@ -0,0 +1,290 @@
+package service
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"code.byted.org/gopkg/logs"
+	"github.com/jinzhu/gorm"
+
+	"code.bean.com/flamingo/config"
+	"code.bean.com/flamingo/handler/view"
+	"code.bean.com/flamingo/metrics"
+	"code.bean.com/flamingo/model"
+	"code.bean.com/flamingo/util"
+)
+
+const (
+	smsMaxRetry        = 3                // 单条短信最多发送次数
+	smsRetryInterval   = 20 * time.Second // 重试队列扫描间隔
+	smsCodeValidPeriod = 3 * time.Minute  // 验证码有效期，超过有效期的短信不再重试
+	smsReportTimeFmt   = "2006-01-02 15:04:05"
+	smsLoginTplID      = 253094 // 登录验证码短信模板
+)
+
+const smsSendPath = "/v5/tlssmssvr/sendsms?sdkappid=%s&random=%s"
+
+var sigTpl = "appkey=%s&random=%s&time=%d&mobile=%s"
+
+type CheckCodeReqTemplate struct {
+	Params    []string `json:"params"`
+	Sig       string   `json:"sig"`
+	Tel       *TelInfo `json:"tel"`
+	TimeStamp int64    `json:"time"`
+	TplID     int      `json:"tpl_id"`
+}
+type CheckCodeResponse struct {
+	Result int    `json:"result"`
+	ErrMsg string `json:"errmsg"`
+	Sid    string `json:"sid"`
+}
+type TelInfo struct {
+	Mobile     string `json:"mobile"`
+	NationCode string `json:"nationcode"`
+}
+
+// SmsReport 短信服务商推送的状态报告
+type SmsReport struct {
+	UserReceiveTime string `json:"user_receive_time"`
+	NationCode      string `json:"nationcode"`
+	Mobile          string `json:"mobile"`
+	ReportStatus    string `json:"report_status"`
+	ErrMsg          string `json:"errmsg"`
+	Description     string `json:"description"`
+	Sid             string `json:"sid"`
+}
+
+type SmsService struct {
+	smsMsgs model.SmsMsgRepository
+	conf    config.SmsConfig
+}
+
+var smsService *SmsService
+var smsServiceOnce sync.Once
+
+func SmsServiceInstance() *SmsService {
+	smsServiceOnce.Do(
+		func() {
+			smsService = NewSmsService(repos().SmsMsgs, currentConfig().Sms)
+		})
+	return smsService
+}
+
+// NewSmsService 使用指定的数据访问实现和短信配置创建 SmsService
+func NewSmsService(smsMsgs model.SmsMsgRepository, conf config.SmsConfig) *SmsService {
+	return &SmsService{smsMsgs: smsMsgs, conf: conf}
+}
+
+// SendCode 记录并发送一条验证码短信，临时性失败会进入重试队列
+func (s *SmsService) SendCode(ctx context.Context, customerID int, phone, code string) error {
+	msg := &model.SmsMsg{CustomerID: customerID, Cellphone: phone, Code: code, Purpose: model.SmsPurposeLogin, TplID: smsLoginTplID}
+	return s.send(ctx, msg, []string{code})
+}
+
+// SendPayCode 记录并发送一条大额消费确认码短信，amount 为确认码允许的最大消费金额
+func (s *SmsService) SendPayCode(ctx context.Context, customerID int, phone, code string, amount int) error {
+	msg := &model.SmsMsg{CustomerID: customerID, Cellphone: phone, Code: code, Purpose: model.SmsPurposePay, TplID: s.conf.PayCodeTplID, Amount: amount}
+	return s.send(ctx, msg, []string{code, formatAmount(amount)})
+}
+
+// AddDisplayedCode 记录一条在会员页面展示、不通过短信发送的消费确认码
+func (s *SmsService) AddDisplayedCode(customerID int, phone, code string) (*model.SmsMsg, error) {
+	msg := &model.SmsMsg{CustomerID: customerID, Cellphone: phone, Code: code, Purpose: model.SmsPurposePay, SendTime: time.Now(), Status: model.SmsStatusDisplayed}
+	if err := s.smsMsgs.AddSmsMsg(msg); err != nil {
+		return nil, ErrorServiceInternalError
+	}
+	return msg, nil
+}
+
+// SendNotice 记录并发送一条通知短信
+func (s *SmsService) SendNotice(ctx context.Context, customerID int, phone string, tplID int, params []string) error {
+	msg := &model.SmsMsg{CustomerID: customerID, Cellphone: phone, Purpose: model.SmsPurposeNotify, TplID: tplID}
+	return s.send(ctx, msg, params)
+}
+
+// QueueNotice 记录一条通知短信，sendAfter 之后由重试队列发送，服务重启不会丢失
+func (s *SmsService) QueueNotice(customerID int, phone string, tplID int, params []string, sendAfter time.Time) error {
+	buf, _ := json.Marshal(params)
+	msg := &model.SmsMsg{CustomerID: customerID, Cellphone: phone, Purpose: model.SmsPurposeNotify, TplID: tplID, Params: string(buf),
+		SendTime: time.Now(), Status: model.SmsStatusQueued, SendAfter: &sendAfter}
+	if err := s.smsMsgs.AddSmsMsg(msg); err != nil {
+		return ErrorServiceInternalError
+	}
+	return nil
+}
+
+func (s *SmsService) send(ctx context.Context, msg *model.SmsMsg, params []string) error {
+	buf, _ := json.Marshal(params)
+	msg.Params = string(buf)
+	msg.SendTime = time.Now()
+	msg.Status = model.SmsStatusQueued
+	if err := s.smsMsgs.AddSmsMsg(msg); err != nil {
+		return ErrorServiceInternalError
+	}
+	return s.deliver(ctx, msg)
+}
+
+// RunRetryQueue 后台重试发送失败的短信并发送推迟到期的通知，ctx 取消时退出
+func (s *SmsService) RunRetryQueue(ctx context.Context) {
+	ticker := time.NewTicker(smsRetryInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		now := time.Now()
+		msgs, err := s.smsMsgs.GetRetrySmsList(now.Add(-smsCodeValidPeriod), now)
+		if err != nil {
+			continue
+		}
+		for _, msg := range msgs {
+			claimed, err := s.smsMsgs.ClaimSmsMsg(msg)
+			if err != nil || !claimed {
+				continue
+			}
+			logs.Info("retry sms, id=%d, retry=%d", msg.ID, msg.RetryCount)
+			s.deliver(ctx, msg)
+		}
+	}
+}
+
+// HandleReports 处理短信服务商回调的送达状态
+func (s *SmsService) HandleReports(reports []*SmsReport) {
+	for _, report := range reports {
+		msg, err := s.smsMsgs.GetSmsByMsgID(report.Sid)
+		if err != nil {
+			logs.Error("get sms by msg id error, sid=%s, err=%+v", report.Sid, err)
+			continue
+		}
+		if report.ReportStatus == "SUCCESS" {
+			msg.Status = model.SmsStatusDelivered
+			msg.ErrMsg = ""
+		} else {
+			msg.Status = model.SmsStatusFailed
+			msg.ErrMsg = fmt.Sprintf("%s:%s", report.ErrMsg, report.Description)
+		}
+		reportTime, err := time.ParseInLocation(smsReportTimeFmt, report.UserReceiveTime, time.Local)
+		if err != nil {
+			reportTime = time.Now()
+		}
+		msg.ReportTime = &reportTime
+		s.smsMsgs.UpdateSmsMsg(msg)
+	}
+}
+
+// GetPhoneSmsRecords 查询手机号最近的短信发送记录
+func (s *SmsService) GetPhoneSmsRecords(phone string) ([]*view.SmsRecord, error) {
+	if IsInvalidPhoneNo(phone) {
+		return nil, ErrIllegalPhoneNo
+	}
+	msgs, err := s.smsMsgs.GetPhoneSmsList(phone, 10)
+	if err != nil && err != gorm.ErrRecordNotFound {
+		return nil, ErrorServiceInternalError
+	}
+	records := make([]*view.SmsRecord, 0, len(msgs))
+	for _, msg := range msgs {
+		record := &view.SmsRecord{
+			SendTime:   msg.SendTime.Format("2006-01-02 15:04:05"),
+			Purpose:    msg.Purpose,
+			Status:     msg.Status,
+			StatusDesc: GetSmsStatus(msg.Status),
+			ErrMsg:     msg.ErrMsg,
+			RetryCount: msg.RetryCount,
+		}
+		if msg.ReportTime != nil {
+			record.ReportTime = msg.ReportTime.Format("2006-01-02 15:04:05")
+		}
+		records = append(records, record)
+	}
+	return records, nil
+}
+
+func (s *SmsService) deliver(ctx context.Context, msg *model.SmsMsg) error {
+	var params []string
+	json.Unmarshal([]byte(msg.Params), &params)
+	resp, err := s.sendSMSMsg(ctx, msg.Cellphone, msg.TplID, params)
+	switch {
+	case err != nil:
+		msg.ErrMsg = err.Error()
+		markSmsRetry(msg)
+	case resp.Result != 0:
+		msg.ErrMsg = fmt.Sprintf("%d:%s", resp.Result, resp.ErrMsg)
+		if isTransientSmsResult(resp.Result) {
+			markSmsRetry(msg)
+		} else {
+			msg.Status = model.SmsStatusFailed
+		}
+	default:
+		msg.Status = model.SmsStatusSent
+		msg.MsgID = resp.Sid
+		msg.ErrMsg = ""
+	}
+	s.smsMsgs.UpdateSmsMsg(msg)
+	metrics.ObserveSms(msg.Purpose, msg.Status)
+	if msg.Status == model.SmsStatusFailed {
+		logs.CtxError(ctx, "send sms failed, id=%d, err=%s", msg.ID, msg.ErrMsg)
+		return ErrSmsSendFailed
+	}
+	return nil
+}
+
+func markSmsRetry(msg *model.SmsMsg) {
+	msg.RetryCount++
+	if msg.RetryCount >= smsMaxRetry {
+		msg.Status = model.SmsStatusFailed
+	} else {
+		msg.Status = model.SmsStatusQueued
+	}
+}
+
+// isTransientSmsResult 服务商返回的错误码是否可以重试
+func isTransientSmsResult(result int) bool {
+	// 1008: 请求下发短信超时
+	return result == 1008
+}
+
+func GetSmsStatus(status string) string {
+	switch status {
+	case model.SmsStatusQueued:
+		return "等待发送"
+	case model.SmsStatusSending:
+		return "发送中"
+	case model.SmsStatusSent:
+		return "已发送"
+	case model.SmsStatusDelivered:
+		return "已送达"
+	case model.SmsStatusDisplayed:
+		return "页面展示"
+	default:
+		return "发送失败"
+	}
+}
+
+func (s *SmsService) sendSMSMsg(ctx context.Context, phone string, tplID int, params []string) (*CheckCodeResponse, error) {
+	telInfo := &TelInfo{Mobile: phone, NationCode: "86"}
+	random := CreateCaptcha()
+
+	timeStamp := time.Now().Unix()
+	url := strings.TrimRight(s.conf.APIBase, "/") + fmt.Sprintf(smsSendPath, s.conf.AppID, random)
+
+	sigInput := fmt.Sprintf(sigTpl, s.conf.AppKey, random, timeStamp, phone)
+	h := sha256.New()
+	h.Write([]byte(sigInput))
+	bs := h.Sum(nil)
+	sig := fmt.Sprintf("%x", bs)
+	reqInfo := CheckCodeReqTemplate{Params: params, Sig: sig, Tel: telInfo, TimeStamp: timeStamp, TplID: tplID}
+	var resp CheckCodeResponse
+	logs.CtxInfo(ctx, "send sms, tel=%s, tpl=%d", util.MaskPhone(phone), tplID)
+	err := util.PostWithObjResponse(ctx, url, reqInfo, &resp)
+	if err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
@@ -0,0 +1,382 @@
+package service
+
+import (
+	"context"
+	"crypto/hmac"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"code.byted.org/gopkg/logs"
+	"github.com/jinzhu/gorm"
+
+	"code.bean.com/flamingo/model"
+	"code.bean.com/flamingo/util"
+)
+
+// 微信消息类型及事件类型
+const (
+	WxMsgTypeText  = "text"
+	WxMsgTypeEvent = "event"
+
+	WxEventSubscribe   = "subscribe"
+	WxEventUnsubscribe = "unsubscribe"
+	WxEventClick       = "CLICK"
+
+	// WxMenuKeyBalance 查询余额菜单的 key
+	WxMenuKeyBalance = "BALANCE"
+)
+
+var wxOAuthURL = "https://open.weixin.qq.com/connect/oauth2/authorize?appid=%s&redirect_uri=%s&response_type=code&scope=%s&state=%s#wechat_redirect"
+
+const wxOAuthTokenPath = "/sns/oauth2/access_token?appid=%s&secret=%s&code=%s&grant_type=authorization_code"
+
+// wxSignatureMaxAge 微信服务器请求中 timestamp 与本机时间允许的最大偏差，超过时视为重放
+const wxSignatureMaxAge = 5 * time.Minute
+
+// WxOAuthTokenResp 网页授权换取 openid 的返回
+type WxOAuthTokenResp struct {
+	OpenID  string `json:"openid"`
+	ErrCode int    `json:"errcode"`
+	ErrMsg  string `json:"errmsg"`
+}
+
+// WxMessage 微信推送的消息及事件
+type WxMessage struct {
+	XMLName      xml.Name `xml:"xml"`
+	ToUserName   string   `xml:"ToUserName"`
+	FromUserName string   `xml:"FromUserName"`
+	CreateTime   int64    `xml:"CreateTime"`
+	MsgType      string   `xml:"MsgType"`
+	Content      string   `xml:"Content"`
+	MsgID        int64    `xml:"MsgId"`
+	Event        string   `xml:"Event"`
+	EventKey     string   `xml:"EventKey"`
+	Encrypt      string   `xml:"Encrypt"`
+}
+
+type wxCDATA struct {
+	Text string `xml:",cdata"`
+}
+
+// WxReply 被动回复的文本消息
+type WxReply struct {
+	XMLName      xml.Name `xml:"xml"`
+	ToUserName   wxCDATA  `xml:"ToUserName"`
+	FromUserName wxCDATA  `xml:"FromUserName"`
+	CreateTime   int64    `xml:"CreateTime"`
+	MsgType      wxCDATA  `xml:"MsgType"`
+	Content      wxCDATA  `xml:"Content"`
+}
+
+// WxEncryptedReply 安全模式下的回复
+type WxEncryptedReply struct {
+	XMLName      xml.Name `xml:"xml"`
+	Encrypt      wxCDATA  `xml:"Encrypt"`
+	MsgSignature wxCDATA  `xml:"MsgSignature"`
+	TimeStamp    string   `xml:"TimeStamp"`
+	Nonce        wxCDATA  `xml:"Nonce"`
+}
+
+// NewWxTextReply 构造一条回复给消息发送者的文本消息
+func NewWxTextReply(msg *WxMessage, content string) *WxReply {
+	return &WxReply{
+		ToUserName:   wxCDATA{msg.FromUserName},
+		FromUserName: wxCDATA{msg.ToUserName},
+		CreateTime:   time.Now().Unix(),
+		MsgType:      wxCDATA{WxMsgTypeText},
+		Content:      wxCDATA{content},
+	}
+}
+
+// WxReplyFunc 消息处理函数，返回 nil 表示不回复
+type WxReplyFunc func(msg *WxMessage) (*WxReply, error)
+
+// WxRouter 按文本内容、事件类型和菜单 key 分发消息
+type WxRouter struct {
+	texts       map[string]WxReplyFunc
+	events      map[string]WxReplyFunc
+	clicks      map[string]WxReplyFunc
+	defaultText WxReplyFunc
+}
+
+func NewWxRouter() *WxRouter {
+	return &WxRouter{
+		texts:  make(map[string]WxReplyFunc),
+		events: make(map[string]WxReplyFunc),
+		clicks: make(map[string]WxReplyFunc),
+	}
+}
+
+// HandleText 注册文本消息的回复，content 需完全匹配
+func (r *WxRouter) HandleText(content string, fn WxReplyFunc) {
+	r.texts[content] = fn
+}
+
+// HandleDefaultText 注册未匹配到的文本消息的回复
+func (r *WxRouter) HandleDefaultText(fn WxReplyFunc) {
+	r.defaultText = fn
+}
+
+// HandleEvent 注册事件的处理函数
+func (r *WxRouter) HandleEvent(event string, fn WxReplyFunc) {
+	r.events[event] = fn
+}
+
+// HandleClick 注册菜单点击事件的处理函数
+func (r *WxRouter) HandleClick(key string, fn WxReplyFunc) {
+	r.clicks[key] = fn
+}
+
+// Dispatch 分发消息，没有对应的处理函数时返回 nil
+func (r *WxRouter) Dispatch(msg *WxMessage) (*WxReply, error) {
+	var fn WxReplyFunc
+	switch msg.MsgType {
+	case WxMsgTypeText:
+		fn = r.texts[strings.TrimSpace(msg.Content)]
+		if fn == nil {
+			fn = r.defaultText
+		}
+	case WxMsgTypeEvent:
+		if msg.Event == WxEventClick {
+			fn = r.clicks[msg.EventKey]
+		} else {
+			fn = r.events[msg.Event]
+		}
+	}
+	if fn == nil {
+		return nil, nil
+	}
+	return fn(msg)
+}
+
+// WechatService 微信公众号消息服务
+type WechatService struct {
+	Router     *WxRouter
+	token      string
+	appID      string
+	appSecret  string
+	oauthScope string
+	aesKey     []byte
+	menu       json.RawMessage // 配置的自定义菜单
+	customers  model.CustomerRepository
+	accounts   model.AccountRepository
+}
+
+var wechatService *WechatService
+var wechatServiceOnce sync.Once
+
+func WechatServiceInstance() *WechatService {
+	wechatServiceOnce.Do(
+		func() {
+			wechatService = newWechatService()
+		})
+	return wechatService
+}
+
+func newWechatService() *WechatService {
+	conf := currentConfig().Wechat
+	s := &WechatService{
+		Router:     NewWxRouter(),
+		token:      conf.Token,
+		appID:      conf.AppID,
+		appSecret:  conf.AppSecret,
+		oauthScope: conf.OAuthScope,
+		customers:  repos().Customers,
+		accounts:   repos().Accounts,
+	}
+	if s.token == "" {
+		logs.Error("wechat token not configured")
+	}
+	if conf.EncodingAESKey != "" {
+		key, err := util.WXAESKey(conf.EncodingAESKey)
+		if err != nil {
+			logs.Error("invalid wechat encoding_aes_key, err=%+v", err)
+		}
+		s.aesKey = key
+	}
+	if len(conf.Menu) > 0 && string(conf.Menu) != "null" {
+		s.menu = conf.Menu
+	}
+	s.registerReplies()
+	return s
+}
+
+// CheckSignature 校验微信服务器请求的签名和时间戳
+func (s *WechatService) CheckSignature(signature, timestamp, nonce string) bool {
+	if s.token == "" || signature == "" || !wxTimestampValid(timestamp, time.Now()) {
+		return false
+	}
+	return hmac.Equal([]byte(util.WXSignature(s.token, timestamp, nonce)), []byte(signature))
+}
+
+// wxTimestampValid timestamp 为秒级 unix 时间，与 now 的偏差不超过 wxSignatureMaxAge
+func wxTimestampValid(timestamp string, now time.Time) bool {
+	ts, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return false
+	}
+	d := now.Sub(time.Unix(ts, 0))
+	return d <= wxSignatureMaxAge && d >= -wxSignatureMaxAge
+}
+
+// DecryptMessage 解密安全模式下的消息
+func (s *WechatService) DecryptMessage(msg *WxMessage, msgSignature, timestamp, nonce string) (*WxMessage, error) {
+	if s.aesKey == nil {
+		return nil, fmt.Errorf("wechat encoding_aes_key not configured")
+	}
+	if !hmac.Equal([]byte(util.WXSignature(s.token, timestamp, nonce, msg.Encrypt)), []byte(msgSignature)) {
+		return nil, fmt.Errorf("invalid msg_signature")
+	}
+	plain, appID, err := util.WXDecrypt(s.aesKey, msg.Encrypt)
+	if err != nil {
+		return nil, err
+	}
+	if appID != s.appID {
+		return nil, fmt.Errorf("unexpected appid:%s", appID)
+	}
+	var decrypted WxMessage
+	if err := xml.Unmarshal(plain, &decrypted); err != nil {
+		return nil, err
+	}
+	return &decrypted, nil
+}
+
+// EncryptReply 加密安全模式下的回复
+func (s *WechatService) EncryptReply(reply *WxReply, timestamp, nonce string) (*WxEncryptedReply, error) {
+	buf, err := xml.Marshal(reply)
+	if err != nil {
+		return nil, err
+	}
+	encrypted, err := util.WXEncrypt(s.aesKey, s.appID, buf)
+	if err != nil {
+		return nil, err
+	}
+	return &WxEncryptedReply{
+		Encrypt:      wxCDATA{encrypted},
+		MsgSignature: wxCDATA{util.WXSignature(s.token, timestamp, nonce, encrypted)},
+		TimeStamp:    timestamp,
+		Nonce:        wxCDATA{nonce},
+	}, nil
+}
+
+// OAuthURL 网页授权跳转地址，授权后微信会带上 code 和 state 跳转到 redirectURI
+func (s *WechatService) OAuthURL(redirectURI, state string) string {
+	return fmt.Sprintf(wxOAuthURL, s.appID, url.QueryEscape(redirectURI), s.oauthScope, url.QueryEscape(state))
+}
+
+// ExchangeOAuthCode 用网页授权的 code 换取用户 openid
+func (s *WechatService) ExchangeOAuthCode(code string) (string, error) {
+	var resp WxOAuthTokenResp
+	reqURL := wxAPIBase() + fmt.Sprintf(wxOAuthTokenPath, s.appID, s.appSecret, url.QueryEscape(code))
+	if err := util.GetWithObjResponse(context.Background(), reqURL, &resp); err != nil {
+		return "", err
+	}
+	if resp.ErrCode != 0 || resp.OpenID == "" {
+		return "", fmt.Errorf("exchange oauth code error, errcode=%d, errmsg=%s", resp.ErrCode, resp.ErrMsg)
+	}
+	return resp.OpenID, nil
+}
+
+// registerReplies 注册内置的回复
+func (s *WechatService) registerReplies() {
+	s.Router.HandleEvent(WxEventSubscribe, func(msg *WxMessage) (*WxReply, error) {
+		return NewWxTextReply(msg, "欢迎关注！回复“余额”查询会员卡余额"), nil
+	})
+	s.Router.HandleEvent(WxEventUnsubscribe, func(msg *WxMessage) (*WxReply, error) {
+		logs.Info("wechat user unsubscribe, openid=%s", msg.FromUserName)
+		return nil, nil
+	})
+	s.Router.HandleText("余额", s.replyBalance)
+	s.Router.HandleClick(WxMenuKeyBalance, s.replyBalance)
+	s.Router.HandleDefaultText(func(msg *WxMessage) (*WxReply, error) {
+		return NewWxTextReply(msg, "回复“余额”查询会员卡余额"), nil
+	})
+}
+
+func (s *WechatService) replyBalance(msg *WxMessage) (*WxReply, error) {
+	customer, err := s.customers.GetCustomerByOpenID(msg.FromUserName)
+	if err == gorm.ErrRecordNotFound {
+		return NewWxTextReply(msg, "您还没有绑定会员卡"), nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	info, err := CustomerServiceInstance().GetCustomerDetailInfo(customer.Cellphone)
+	if err != nil {
+		return nil, err
+	}
+	return NewWxTextReply(msg, fmt.Sprintf("%s，您的会员卡余额为 ¥%s", info.CustomerName, info.CustomerRestAmount)), nil
+}
+
+// PushMenu 将配置中的自定义菜单推送到公众号
+func (s *WechatService) PushMenu(ctx context.Context) error {
+	if s.menu == nil {
+		return ErrWxMenuNotConfigured
+	}
+	if err := WxAPIClientInstance().CreateMenu(ctx, s.menu); err != nil {
+		logs.CtxError(ctx, "push wechat menu error, err=%+v", err)
+		return ErrWxAPIError
+	}
+	return nil
+}
+
+// GetMenu 查询公众号当前的自定义菜单
+func (s *WechatService) GetMenu(ctx context.Context) (json.RawMessage, error) {
+	menu, err := WxAPIClientInstance().GetMenu(ctx)
+	if apiErr, ok := err.(*WxAPIError); ok && apiErr.ErrCode == 46003 {
+		// 46003 菜单不存在
+		return json.RawMessage("{}"), nil
+	}
+	if err != nil {
+		logs.CtxError(ctx, "get wechat menu error, err=%+v", err)
+		return nil, ErrWxAPIError
+	}
+	return menu, nil
+}
+
+// DeleteMenu 删除公众号的自定义菜单
+func (s *WechatService) DeleteMenu(ctx context.Context) error {
+	if err := WxAPIClientInstance().DeleteMenu(ctx); err != nil {
+		logs.CtxError(ctx, "delete wechat menu error, err=%+v", err)
+		return ErrWxAPIError
+	}
+	return nil
+}
+
+// SendBalanceReminder 给已绑定公众号的会员发送余额提醒
+func (s *WechatService) SendBalanceReminder(ctx context.Context, phone, remark string) error {
+	customer, err := s.customers.GetCustomerByCellphone(phone)
+	if err == gorm.ErrRecordNotFound {
+		return ErrorUserNotFound
+	}
+	if err != nil {
+		return ErrorServiceInternalError
+	}
+	if customer.WxOpenID == "" {
+		return ErrWxNotBound
+	}
+	accounts, err := s.accounts.GetCustomerAccounts(customer)
+	if err != nil {
+		return ErrorServiceInternalError
+	}
+	if !WxAPIClientInstance().HasTemplate(WxTemplateBalanceReminder) {
+		return ErrWxTemplateNotConfigured
+	}
+	err = WxAPIClientInstance().SendTemplate(ctx, &BalanceReminderTemplate{
+		OpenID:       customer.WxOpenID,
+		CustomerName: customer.Name,
+		Balance:      calcRestAmount(accounts),
+		Remark:       remark,
+	})
+	if err != nil {
+		logs.CtxError(ctx, "send balance reminder error, customer=%d, err=%+v", customer.ID, err)
+		return ErrWxAPIError
+	}
+	return nil
+}
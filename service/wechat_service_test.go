@@ -0,0 +1,39 @@
+package service
+
+import (
+	"strconv"
+	"testing"
+	"time"
+
+	"code.bean.com/flamingo/util"
+)
+
+func TestWechatCheckSignature(t *testing.T) {
+	s := &WechatService{token: "token"}
+	now := time.Now().Unix()
+	cases := []struct {
+		desc      string
+		timestamp int64
+		tamper    bool
+		ok        bool
+	}{
+		{"fresh", now, false, true},
+		{"within max age", now - 240, false, true},
+		{"expired", now - 600, false, false},
+		{"future", now + 600, false, false},
+		{"wrong signature", now, true, false},
+	}
+	for _, c := range cases {
+		ts := strconv.FormatInt(c.timestamp, 10)
+		signature := util.WXSignature("token", ts, "nonce")
+		if c.tamper {
+			signature = util.WXSignature("other", ts, "nonce")
+		}
+		if got := s.CheckSignature(signature, ts, "nonce"); got != c.ok {
+			t.Errorf("%s: CheckSignature = %v, want %v", c.desc, got, c.ok)
+		}
+	}
+	if s.CheckSignature(util.WXSignature("token", "abc", "nonce"), "abc", "nonce") {
+		t.Error("CheckSignature accepted a non-numeric timestamp")
+	}
+}
@@ -0,0 +1,235 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"code.byted.org/gopkg/logs"
+
+	"code.bean.com/flamingo/util"
+)
+
+// 微信模板消息类型，对应配置 wechat.templates 中的 key
+const (
+	WxTemplateRecharge        = "recharge"
+	WxTemplateConsume         = "consume"
+	WxTemplateBalanceReminder = "balance_reminder"
+)
+
+// WxAPIError 微信接口返回的错误
+type WxAPIError struct {
+	ErrCode int    `json:"errcode"`
+	ErrMsg  string `json:"errmsg"`
+}
+
+func (e *WxAPIError) Error() string {
+	return fmt.Sprintf("wechat api error, errcode=%d, errmsg=%s", e.ErrCode, e.ErrMsg)
+}
+
+// tokenExpired access token 失效(40001 无效，42001 过期)
+func (e *WxAPIError) tokenExpired() bool {
+	return e.ErrCode == 40001 || e.ErrCode == 42001
+}
+
+// WxTemplateData 模板消息中的一个字段
+type WxTemplateData struct {
+	Value string `json:"value"`
+	Color string `json:"color,omitempty"`
+}
+
+// WxTemplateMessage 模板消息
+type WxTemplateMessage struct {
+	ToUser     string                     `json:"touser"`
+	TemplateID string                     `json:"template_id"`
+	URL        string                     `json:"url,omitempty"`
+	Data       map[string]*WxTemplateData `json:"data"`
+}
+
+// WxTemplate 类型化的模板消息内容
+type WxTemplate interface {
+	// Kind 模板类型，用于查找配置的模板ID
+	Kind() string
+	Message(templateID string) *WxTemplateMessage
+}
+
+// RechargeTemplate 充值成功通知，退款也使用该模板
+type RechargeTemplate struct {
+	OpenID    string
+	StoreName string
+	Title     string // 为空时使用默认标题
+	Amount    int
+	Balance   int
+	DealTime  time.Time
+}
+
+func (t *RechargeTemplate) Kind() string {
+	return WxTemplateRecharge
+}
+
+func (t *RechargeTemplate) Message(templateID string) *WxTemplateMessage {
+	title := t.Title
+	if title == "" {
+		title = fmt.Sprintf("您的会员卡在%s充值成功", t.StoreName)
+	}
+	return &WxTemplateMessage{
+		ToUser:     t.OpenID,
+		TemplateID: templateID,
+		Data: map[string]*WxTemplateData{
+			"first":    {Value: title},
+			"keyword1": {Value: formatAmount(t.Amount)},
+			"keyword2": {Value: t.DealTime.Format("2006-01-02 15:04:05")},
+			"keyword3": {Value: formatAmount(t.Balance)},
+		},
+	}
+}
+
+// ConsumeTemplate 消费成功通知
+type ConsumeTemplate struct {
+	OpenID    string
+	StoreName string
+	Amount    int
+	Balance   int
+	DealTime  time.Time
+}
+
+func (t *ConsumeTemplate) Kind() string {
+	return WxTemplateConsume
+}
+
+func (t *ConsumeTemplate) Message(templateID string) *WxTemplateMessage {
+	return &WxTemplateMessage{
+		ToUser:     t.OpenID,
+		TemplateID: templateID,
+		Data: map[string]*WxTemplateData{
+			"first":    {Value: fmt.Sprintf("您的会员卡在%s消费成功，如非本人操作请立即联系门店", t.StoreName)},
+			"keyword1": {Value: formatAmount(t.Amount)},
+			"keyword2": {Value: t.DealTime.Format("2006-01-02 15:04:05")},
+			"keyword3": {Value: formatAmount(t.Balance)},
+		},
+	}
+}
+
+// BalanceReminderTemplate 余额提醒
+type BalanceReminderTemplate struct {
+	OpenID       string
+	CustomerName string
+	Balance      int
+	Remark       string
+}
+
+func (t *BalanceReminderTemplate) Kind() string {
+	return WxTemplateBalanceReminder
+}
+
+func (t *BalanceReminderTemplate) Message(templateID string) *WxTemplateMessage {
+	return &WxTemplateMessage{
+		ToUser:     t.OpenID,
+		TemplateID: templateID,
+		Data: map[string]*WxTemplateData{
+			"first":    {Value: fmt.Sprintf("%s，您的会员卡余额提醒", t.CustomerName)},
+			"keyword1": {Value: formatAmount(t.Balance)},
+			"keyword2": {Value: time.Now().Format("2006-01-02 15:04:05")},
+			"remark":   {Value: t.Remark},
+		},
+	}
+}
+
+// WxAPIClient 微信公众号 api 客户端，access token 统一由 WxTokenService 管理
+type WxAPIClient struct {
+	apiBase   string
+	templates map[string]string
+}
+
+var wxAPIClient *WxAPIClient
+var wxAPIClientOnce sync.Once
+
+func WxAPIClientInstance() *WxAPIClient {
+	wxAPIClientOnce.Do(
+		func() {
+			wxAPIClient = &WxAPIClient{apiBase: wxAPIBase(), templates: currentConfig().Wechat.Templates}
+		})
+	return wxAPIClient
+}
+
+// wxAPIBase 微信 api 地址，可配置为本地 mock 服务
+func wxAPIBase() string {
+	return strings.TrimRight(currentConfig().Wechat.APIBase, "/")
+}
+
+// HasTemplate 是否配置了该类型的模板
+func (c *WxAPIClient) HasTemplate(kind string) bool {
+	return c.templates[kind] != ""
+}
+
+// SendTemplate 发送类型化的模板消息
+func (c *WxAPIClient) SendTemplate(ctx context.Context, tpl WxTemplate) error {
+	templateID := c.templates[tpl.Kind()]
+	if templateID == "" {
+		return fmt.Errorf("wechat template %s not configured", tpl.Kind())
+	}
+	return c.call(ctx, "/cgi-bin/message/template/send", tpl.Message(templateID), nil)
+}
+
+// CreateMenu 创建自定义菜单，会覆盖公众号当前的菜单
+func (c *WxAPIClient) CreateMenu(ctx context.Context, menu json.RawMessage) error {
+	return c.call(ctx, "/cgi-bin/menu/create", menu, nil)
+}
+
+// GetMenu 查询公众号当前的自定义菜单
+func (c *WxAPIClient) GetMenu(ctx context.Context) (json.RawMessage, error) {
+	var menu json.RawMessage
+	err := c.call(ctx, "/cgi-bin/menu/get", nil, &menu)
+	return menu, err
+}
+
+// DeleteMenu 删除自定义菜单
+func (c *WxAPIClient) DeleteMenu(ctx context.Context) error {
+	return c.call(ctx, "/cgi-bin/menu/delete", nil, nil)
+}
+
+// call 调用微信 api，req 为 nil 时使用 GET 请求；access token 失效时强制刷新后重试一次
+func (c *WxAPIClient) call(ctx context.Context, path string, req interface{}, resp interface{}) error {
+	var err error
+	for i := 0; i < 2; i++ {
+		var token string
+		token, err = WxTokenServiceInstance().Token(ctx)
+		if err != nil {
+			return err
+		}
+		err = c.do(ctx, path, token, req, resp)
+		apiErr, ok := err.(*WxAPIError)
+		if !ok || !apiErr.tokenExpired() {
+			return err
+		}
+		logs.CtxError(ctx, "wechat access token expired, path=%s", path)
+		WxTokenServiceInstance().Invalidate(token)
+	}
+	return err
+}
+
+func (c *WxAPIClient) do(ctx context.Context, path, token string, req interface{}, resp interface{}) error {
+	url := fmt.Sprintf("%s%s?access_token=%s", c.apiBase, path, token)
+	var raw json.RawMessage
+	var err error
+	if req == nil {
+		err = util.GetWithObjResponse(ctx, url, &raw)
+	} else {
+		err = util.PostWithObjResponse(ctx, url, req, &raw)
+	}
+	if err != nil {
+		return err
+	}
+	var apiErr WxAPIError
+	json.Unmarshal(raw, &apiErr)
+	if apiErr.ErrCode != 0 {
+		return &apiErr
+	}
+	if resp != nil {
+		return json.Unmarshal(raw, resp)
+	}
+	return nil
+}
@@ -0,0 +1,183 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"code.byted.org/gopkg/logs"
+
+	"code.bean.com/flamingo/util"
+)
+
+const (
+	wxTokenRefreshAhead = 5 * time.Minute // 提前刷新的时间
+	wxTokenMinBackoff   = time.Second
+	wxTokenMaxBackoff   = 5 * time.Minute
+)
+
+const wxTokenPath = "/cgi-bin/token?grant_type=client_credential&appid=%s&secret=%s"
+
+// ErrWxTokenUnavailable 微信 access token 不可用
+var ErrWxTokenUnavailable = errors.New("wechat access token unavailable")
+
+// AccessTokenResp access token返回结构体
+type AccessTokenResp struct {
+	AccessToken string `json:"access_token"`
+	Expires     int    `json:"expires_in"`
+	ErrCode     int    `json:"errcode"`
+	ErrMsg      string `json:"errmsg"`
+}
+
+// WxTokenService 微信 access token 管理，token 通过 ConfigService 在多个实例间共享
+type WxTokenService struct {
+	configService *ConfigService
+	appID         string
+	appSecret     string
+
+	mutex    sync.Mutex
+	token    string
+	expireAt time.Time
+}
+
+var wxTokenService *WxTokenService
+var wxTokenServiceOnce sync.Once
+
+func WxTokenServiceInstance() *WxTokenService {
+	wxTokenServiceOnce.Do(
+		func() {
+			wxTokenService = &WxTokenService{
+				configService: NewConfigService(repos().ConfigItems),
+				appID:         currentConfig().Wechat.AppID,
+				appSecret:     currentConfig().Wechat.AppSecret,
+			}
+		})
+	return wxTokenService
+}
+
+// Token 获取可用的 access token，所有调用微信 api 的地方都应通过该方法获取
+func (s *WxTokenService) Token(ctx context.Context) (string, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.valid(time.Now()) {
+		return s.token, nil
+	}
+	if err := s.refresh(ctx); err != nil {
+		return "", err
+	}
+	return s.token, nil
+}
+
+// Run 在 token 过期前刷新，刷新失败时退避重试，ctx 取消时退出
+func (s *WxTokenService) Run(ctx context.Context) {
+	if s.appID == "" || s.appSecret == "" {
+		logs.Error("wechat app_id or app_secret not configured, stop refreshing access token")
+		return
+	}
+	backoff := wxTokenMinBackoff
+	for {
+		s.mutex.Lock()
+		err := s.refresh(ctx)
+		expireAt := s.expireAt
+		s.mutex.Unlock()
+		if err != nil {
+			logs.Error("refresh access token error, retry after %v, err=%+v", backoff, err)
+			if !sleepContext(ctx, backoff) {
+				return
+			}
+			backoff *= 2
+			if backoff > wxTokenMaxBackoff {
+				backoff = wxTokenMaxBackoff
+			}
+			continue
+		}
+		backoff = wxTokenMinBackoff
+		if !sleepContext(ctx, expireAt.Add(-wxTokenRefreshAhead).Sub(time.Now())) {
+			return
+		}
+	}
+}
+
+// sleepContext 等待 d 时间，ctx 取消时提前返回 false
+func sleepContext(ctx context.Context, d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return false
+	case <-timer.C:
+		return true
+	}
+}
+
+// Invalidate 微信返回 token 失效时调用，丢弃该 token 使下次获取时重新向微信请求
+func (s *WxTokenService) Invalidate(token string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.token != token {
+		return
+	}
+	s.token = ""
+	s.expireAt = time.Time{}
+	// 其他实例可能已经刷新过，只清除同一个 token
+	if s.configService.GetAccessToken() != token {
+		return
+	}
+	if err := s.configService.UpdateAccessToken("", s.expireAt); err != nil {
+		logs.Error("clear access token error, err=%+v", err)
+	}
+}
+
+func (s *WxTokenService) valid(now time.Time) bool {
+	return s.token != "" && now.Before(s.expireAt.Add(-wxTokenRefreshAhead))
+}
+
+// refresh 优先使用其他实例已刷新的 token，都已过期时再向微信请求，调用方需持有锁
+// 向微信请求前持有数据库锁并重新读取，避免多个实例同时刷新使彼此的 token 失效
+func (s *WxTokenService) refresh(ctx context.Context) error {
+	if s.loadShared(time.Now()) {
+		return nil
+	}
+	if s.appID == "" || s.appSecret == "" {
+		return ErrWxTokenUnavailable
+	}
+	return s.configService.WithAccessTokenLock(func() error {
+		if s.loadShared(time.Now()) {
+			return nil
+		}
+		return s.fetch(ctx)
+	})
+}
+
+// loadShared 读取共享的 token，返回是否可用
+func (s *WxTokenService) loadShared(now time.Time) bool {
+	token := s.configService.GetAccessToken()
+	if token == "" {
+		return false
+	}
+	s.token = token
+	s.expireAt = s.configService.GetAccessTokenExpire()
+	return s.valid(now)
+}
+
+// fetch 向微信请求新的 token 并保存为共享的 token，调用方需持有数据库锁
+func (s *WxTokenService) fetch(ctx context.Context) error {
+	now := time.Now()
+	var resp AccessTokenResp
+	err := util.GetWithObjResponse(ctx, wxAPIBase()+fmt.Sprintf(wxTokenPath, s.appID, s.appSecret), &resp)
+	if err != nil {
+		return err
+	}
+	if resp.ErrCode != 0 || resp.AccessToken == "" {
+		return fmt.Errorf("get access token error, errcode=%d, errmsg=%s", resp.ErrCode, resp.ErrMsg)
+	}
+	s.token = resp.AccessToken
+	s.expireAt = now.Add(time.Duration(resp.Expires) * time.Second)
+	if err := s.configService.UpdateAccessToken(s.token, s.expireAt); err != nil {
+		logs.CtxError(ctx, "save access token error, err=%+v", err)
+	}
+	logs.CtxInfo(ctx, "refresh access token, expire at %v", s.expireAt)
+	return nil
+}
@@ -1,3 +1,4 @@
 #!/bin/bash
 cd /home/workspace/src/code.bean.com/flamingo
-go run main.go
\ No newline at end of file
+# 使用 exec 运行编译后的程序，使 SIGTERM 直接发送给服务进程以便优雅退出
+go build -o flamingo . && exec ./flamingo
@@ -0,0 +1,23 @@
+// 接口出错时返回对应的 http 状态码，响应体仍为 {code, msg, data}
+// 将带有该结构的错误响应交给 success 回调处理，页面统一通过 data.code 判断结果
+$.ajaxPrefilter(function (options) {
+    var success = options.success
+    var error = options.error
+    options.error = function (xhr, status, err) {
+        var data = xhr.responseJSON
+        if (!data && xhr.responseText) {
+            try {
+                data = JSON.parse(xhr.responseText)
+            } catch (e) {
+                data = null
+            }
+        }
+        if (data && data.code !== undefined && success) {
+            success(data, status, xhr)
+            return
+        }
+        if (error) {
+            error(xhr, status, err)
+        }
+    }
+})
@@ -9,6 +9,11 @@ function getCookie(cname){
 }
 var x = getCookie("customer_id")
 if (x.length<10) {
-    alert("登录已失效，请重新登录~")
-    top.location="customer_login"
+    if (/MicroMessenger/i.test(navigator.userAgent)) {
+        //微信内打开时通过网页授权登录，已绑定会员卡的用户无需短信验证
+        top.location="../wx/oauth"
+    } else {
+        alert("登录已失效，请重新登录~")
+        top.location="customer_login"
+    }
 }
\ No newline at end of file
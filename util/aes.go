@@ -4,20 +4,33 @@ import (
 	"bytes"
 	"crypto/aes"
 	"crypto/cipher"
-	"sync"
+	"crypto/rand"
+	"errors"
 )
 
-// AESKEY 密钥
-const AESKEY = "abcdefghijklmnopkrstuvwsyz012345"
+// ErrInvalidCiphertext 密文长度或填充不正确，通常是被篡改的 cookie
+var ErrInvalidCiphertext = errors.New("invalid ciphertext")
 
-var (
-	block cipher.Block
-	mutex sync.Mutex
-)
+// aesKey cookie 加密密钥，通过 InitAES 设置
+var aesKey []byte
+
+// InitAES 设置加密密钥，key 为空时生成随机密钥，服务重启后之前加密的内容将无法解密
+func InitAES(key string) error {
+	if key == "" {
+		aesKey = make([]byte, 32)
+		_, err := rand.Read(aesKey)
+		return err
+	}
+	if _, err := aes.NewCipher([]byte(key)); err != nil {
+		return err
+	}
+	aesKey = []byte(key)
+	return nil
+}
 
 // AESEncrypt AES加密
 func AESEncrypt(origData []byte) ([]byte, error) {
-	key := []byte(AESKEY)
+	key := aesKey
 	block, err := aes.NewCipher(key)
 	if err != nil {
 		return nil, err
@@ -30,19 +43,21 @@ func AESEncrypt(origData []byte) ([]byte, error) {
 	return crypted, nil
 }
 
-// AESDecrypt AES解密
+// AESDecrypt AES解密，src 来自客户端，长度或填充不正确时返回 ErrInvalidCiphertext
 func AESDecrypt(src []byte) ([]byte, error) {
-	key := []byte(AESKEY)
+	key := aesKey
 	block, err := aes.NewCipher(key)
 	if err != nil {
 		return nil, err
 	}
 	blockSize := block.BlockSize()
+	if len(src) == 0 || len(src)%blockSize != 0 {
+		return nil, ErrInvalidCiphertext
+	}
 	blockMode := cipher.NewCBCDecrypter(block, key[:blockSize])
 	origData := make([]byte, len(src))
 	blockMode.CryptBlocks(origData, src)
-	origData = PKCS5UnPadding(origData)
-	return origData, nil
+	return PKCS5UnPadding(origData, blockSize)
 }
 
 // PKCS5Padding 填充
@@ -52,10 +67,21 @@ func PKCS5Padding(ciphertext []byte, blockSize int) []byte {
 	return append(ciphertext, padtext...)
 }
 
-// PKCS5UnPadding 去填充
-func PKCS5UnPadding(origData []byte) []byte {
+// PKCS5UnPadding 去填充，校验填充的长度和内容
+func PKCS5UnPadding(origData []byte, blockSize int) ([]byte, error) {
 	length := len(origData)
-	// 去掉最后一个字节 unpadding 次
+	if length == 0 {
+		return nil, ErrInvalidCiphertext
+	}
+	// 最后一个字节为填充的长度，填充的每个字节都等于该长度
 	unpadding := int(origData[length-1])
-	return origData[:(length - unpadding)]
+	if unpadding == 0 || unpadding > blockSize || unpadding > length {
+		return nil, ErrInvalidCiphertext
+	}
+	for _, b := range origData[length-unpadding:] {
+		if int(b) != unpadding {
+			return nil, ErrInvalidCiphertext
+		}
+	}
+	return origData[:length-unpadding], nil
 }
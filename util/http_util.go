@@ -6,6 +6,7 @@ import (
 	"encoding/json"
 	"io/ioutil"
 	"net/http"
+	"net/url"
 	"time"
 
 	"code.byted.org/gopkg/logs"
@@ -14,7 +15,7 @@ import (
 func PostWithObjResponse(c context.Context, url string, params interface{}, respObj interface{}) error {
 	bytesData, err := json.Marshal(params)
 	if err != nil {
-		logs.CtxError(c, "http post marshal failed, req:%+v, err:%v", params, err)
+		logs.CtxError(c, "http post marshal failed, err:%v", err)
 		return err
 	}
 	client := &http.Client{
@@ -22,22 +23,25 @@ func PostWithObjResponse(c context.Context, url string, params interface{}, resp
 	}
 	req, err := http.NewRequest("POST", url, bytes.NewReader(bytesData))
 	if err != nil {
-		logs.CtxError(c, "http post NewRequest failed, req:%+v, err:%v", params, err)
+		err = redactURLError(err)
+		logs.CtxError(c, "http post NewRequest failed, err:%v", err)
 		return err
 	}
 	req.Header.Set("Content-Type", "application/json;charset=UTF-8")
+	setRequestID(c, req)
 	resp, err := client.Do(req)
 	if err != nil {
-		logs.CtxError(c, "http post Do failed, req:%+v, err:%v", params, err)
+		err = redactURLError(err)
+		logs.CtxError(c, "http post Do failed, err:%v", err)
 		return err
 	}
 	defer resp.Body.Close()
 	body, err := ioutil.ReadAll(resp.Body)
 	if err != nil {
-		logs.CtxError(c, "http post read body failed, req:%+v, err:%v", params, err)
+		logs.CtxError(c, "http post read body failed, err:%v", err)
 		return err
 	}
-	logs.CtxInfo(c, "post response:%+v", string(body))
+	logs.CtxInfo(c, "post response:%s", Redact(string(body)))
 	err = json.Unmarshal(body, &respObj)
 	if err != nil {
 		logs.CtxError(c, "http response unmarshal failed %v", err)
@@ -45,3 +49,77 @@ func PostWithObjResponse(c context.Context, url string, params interface{}, resp
 	}
 	return nil
 }
+
+func GetWithObjResponse(c context.Context, url string, respObj interface{}) error {
+	client := &http.Client{
+		Timeout: time.Second * 5,
+	}
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		err = redactURLError(err)
+		logs.CtxError(c, "http get NewRequest failed, err:%v", err)
+		return err
+	}
+	setRequestID(c, req)
+	resp, err := client.Do(req)
+	if err != nil {
+		err = redactURLError(err)
+		logs.CtxError(c, "http get failed, err:%v", err)
+		return err
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		logs.CtxError(c, "http get read body failed, err:%v", err)
+		return err
+	}
+	err = json.Unmarshal(body, &respObj)
+	if err != nil {
+		logs.CtxError(c, "http response unmarshal failed %v", err)
+		return err
+	}
+	return nil
+}
+
+// PostRaw 以指定的 Content-Type 发送请求体，返回原始响应内容
+func PostRaw(c context.Context, url, contentType string, data []byte) ([]byte, error) {
+	client := &http.Client{
+		Timeout: time.Second * 5,
+	}
+	req, err := http.NewRequest("POST", url, bytes.NewReader(data))
+	if err != nil {
+		err = redactURLError(err)
+		logs.CtxError(c, "http post NewRequest failed, err:%v", err)
+		return nil, err
+	}
+	req.Header.Set("Content-Type", contentType)
+	setRequestID(c, req)
+	resp, err := client.Do(req)
+	if err != nil {
+		err = redactURLError(err)
+		logs.CtxError(c, "http post failed, err:%v", err)
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		logs.CtxError(c, "http post read body failed, err:%v", err)
+		return nil, err
+	}
+	return body, nil
+}
+
+// redactURLError 创建和发送请求返回的 *url.Error 中包含完整的请求地址，隐藏其中的 access_token、secret 等参数，避免写入日志
+func redactURLError(err error) error {
+	if urlErr, ok := err.(*url.Error); ok {
+		urlErr.URL = RedactURL(urlErr.URL)
+	}
+	return err
+}
+
+// setRequestID 将 context 中的请求 ID 透传给下游服务
+func setRequestID(c context.Context, req *http.Request) {
+	if id := RequestID(c); id != "" {
+		req.Header.Set(RequestIDHeader, id)
+	}
+}
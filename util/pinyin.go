@@ -0,0 +1,94 @@
+package util
+
+import (
+	"bytes"
+	"sync"
+	"unicode"
+)
+
+var (
+	pinyinSyllables     map[rune]string
+	pinyinSyllablesOnce sync.Once
+)
+
+// pinyinSurnames 多音字作为姓氏时的读音，只用于姓名的第一个字
+var pinyinSurnames = map[rune]string{
+	'单': "shan",
+	'翟': "zhai",
+	'查': "zha",
+	'曾': "zeng",
+	'解': "xie",
+	'区': "ou",
+	'仇': "qiu",
+	'朴': "piao",
+	'乐': "yue",
+	'盖': "ge",
+	'覃': "qin",
+	'缪': "miao",
+	'召': "shao",
+	'秘': "bi",
+	'员': "yun",
+	'繁': "po",
+	'种': "chong",
+	'重': "chong",
+	'黑': "he",
+	'隗': "wei",
+	'祭': "zhai",
+	'折': "she",
+	'句': "gou",
+	'洗': "xian",
+	'能': "nai",
+	'颉': "xie",
+}
+
+// PinyinInitials 返回姓名的拼音首字母，如 张三 返回 zs
+// 英文字母和数字转为小写后保留，空白和符号被忽略，有不认识的汉字时返回空字符串，避免生成不完整的首字母
+func PinyinInitials(name string) string {
+	var buf bytes.Buffer
+	if !eachPinyin(name, func(syllable string) { buf.WriteByte(syllable[0]) }) {
+		return ""
+	}
+	return buf.String()
+}
+
+// Pinyin 返回姓名不带声调和空格的全拼，如 张三 返回 zhangsan，规则同 PinyinInitials
+func Pinyin(name string) string {
+	var buf bytes.Buffer
+	if !eachPinyin(name, func(syllable string) { buf.WriteString(syllable) }) {
+		return ""
+	}
+	return buf.String()
+}
+
+// eachPinyin 依次把姓名中的汉字读音和小写的英文字母、数字传给 fn，有不认识的汉字时返回 false
+func eachPinyin(name string, fn func(syllable string)) bool {
+	pinyinSyllablesOnce.Do(func() {
+		pinyinSyllables = make(map[rune]string)
+		for syllable, chars := range pinyinChars {
+			for _, ch := range chars {
+				pinyinSyllables[ch] = syllable
+			}
+		}
+	})
+	first := true
+	for _, ch := range name {
+		if ch < unicode.MaxASCII {
+			if unicode.IsLetter(ch) || unicode.IsDigit(ch) {
+				fn(string(unicode.ToLower(ch)))
+				first = false
+			}
+			continue
+		}
+		syllable, ok := pinyinSyllables[ch]
+		if surname, isSurname := pinyinSurnames[ch]; isSurname && first {
+			syllable, ok = surname, true
+		}
+		if ok {
+			fn(syllable)
+			first = false
+		} else if unicode.Is(unicode.Han, ch) {
+			return false
+		}
+	}
+	return true
+}
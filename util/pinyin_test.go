@@ -0,0 +1,34 @@
+package util
+
+import "testing"
+
+func TestPinyin(t *testing.T) {
+	cases := []struct {
+		name     string
+		initials string
+		pinyin   string
+	}{
+		{"张三", "zs", "zhangsan"},
+		{"王琪", "wq", "wangqi"},
+		{"李昊钰", "lhy", "lihaoyu"},
+		{"赵璐晗", "zlh", "zhaoluhan"},
+		{"单雄信", "sxx", "shanxiongxin"},
+		{"翟晗", "zh", "zhaihan"},
+		{"查良镛", "zly", "zhaliangyong"},
+		{"曾国藩", "zgf", "zengguofan"},
+		{"简单", "jd", "jiandan"},
+		{"长孙无忌", "cswj", "changsunwuji"},
+		{"Amy 陈", "amyc", "amychen"},
+		{"刘 2号", "l2h", "liu2hao"},
+		{"", "", ""},
+		{"张䶿", "", ""},
+	}
+	for _, c := range cases {
+		if got := PinyinInitials(c.name); got != c.initials {
+			t.Errorf("PinyinInitials(%q) = %q, want %q", c.name, got, c.initials)
+		}
+		if got := Pinyin(c.name); got != c.pinyin {
+			t.Errorf("Pinyin(%q) = %q, want %q", c.name, got, c.pinyin)
+		}
+	}
+}
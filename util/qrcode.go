@@ -0,0 +1,17 @@
+package util
+
+import (
+	"encoding/base64"
+
+	qrcode "github.com/skip2/go-qrcode"
+)
+
+// QRCodeDataURI 把 content 编码为 size 像素见方的二维码 png，返回可以直接作为 img src 的 data URI
+// 二维码在服务端生成，页面不需要加载第三方的二维码脚本
+func QRCodeDataURI(content string, size int) (string, error) {
+	png, err := qrcode.Encode(content, qrcode.Medium, size)
+	if err != nil {
+		return "", err
+	}
+	return "data:image/png;base64," + base64.StdEncoding.EncodeToString(png), nil
+}
@@ -0,0 +1,91 @@
+package util
+
+import (
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+const redacted = "***"
+
+// sensitiveKeys 日志中需要隐藏的参数名，手机号只保留前 3 位和后 4 位，其他完全隐藏
+var sensitiveKeys = map[string]bool{
+	"code":          true,
+	"pwd":           true,
+	"password":      true,
+	"token":         true,
+	"pay_token":     true,
+	"sig":           true,
+	"secret":        true,
+	"access_token":  true,
+	"encrypt":       true,
+	"confirm_code":  true,
+	"payment_token": true,
+}
+
+var phoneKeys = map[string]bool{
+	"cell":      true,
+	"cellphone": true,
+	"phone":     true,
+	"mobile":    true,
+}
+
+// phonePattern 文本中的 11 位手机号
+var phonePattern = regexp.MustCompile(`(^|[^0-9])(1[3-9][0-9])[0-9]{4}([0-9]{4})($|[^0-9])`)
+
+// MaskPhone 隐藏手机号中间 4 位，如 138****1234
+func MaskPhone(phone string) string {
+	if len(phone) < 7 {
+		return redacted
+	}
+	return phone[:3] + "****" + phone[len(phone)-4:]
+}
+
+// Redact 隐藏文本中出现的手机号
+func Redact(s string) string {
+	// 相邻的手机号共用分隔字符，替换一次可能会漏掉，需要重复替换
+	for {
+		replaced := phonePattern.ReplaceAllString(s, "$1$2****$3$4")
+		if replaced == s {
+			return s
+		}
+		s = replaced
+	}
+}
+
+// RedactValues 返回隐藏敏感参数后的表单或 query 参数，用于日志输出
+func RedactValues(values url.Values) string {
+	if len(values) == 0 {
+		return ""
+	}
+	safe := make(url.Values, len(values))
+	for key, vs := range values {
+		lower := strings.ToLower(key)
+		for _, v := range vs {
+			switch {
+			case sensitiveKeys[lower]:
+				v = redacted
+			case phoneKeys[lower]:
+				v = MaskPhone(v)
+			default:
+				v = Redact(v)
+			}
+			safe.Add(key, v)
+		}
+	}
+	s, _ := url.QueryUnescape(safe.Encode())
+	return s
+}
+
+// RedactURL 返回隐藏 query 中敏感参数后的 url，用于日志输出，无法解析时去掉整个 query
+func RedactURL(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		if i := strings.IndexByte(rawURL, '?'); i >= 0 {
+			return rawURL[:i]
+		}
+		return rawURL
+	}
+	u.RawQuery = RedactValues(u.Query())
+	return u.String()
+}
@@ -0,0 +1,77 @@
+package util
+
+import (
+	"context"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestRedact(t *testing.T) {
+	cases := map[string]string{
+		"customer 13812345678 not found": "customer 138****5678 not found",
+		"13812345678,13912345678":        "138****5678,139****5678",
+		"order 201901021381234567890":    "order 201901021381234567890",
+		"no phone here":                  "no phone here",
+		"cell=12812345678":               "cell=12812345678",
+	}
+	for s, want := range cases {
+		if got := Redact(s); got != want {
+			t.Errorf("Redact(%q) = %q, want %q", s, got, want)
+		}
+	}
+}
+
+func TestMaskPhone(t *testing.T) {
+	cases := map[string]string{"13812345678": "138****5678", "1381234": "138****1234", "138123": "***", "": "***"}
+	for phone, want := range cases {
+		if got := MaskPhone(phone); got != want {
+			t.Errorf("MaskPhone(%q) = %q, want %q", phone, got, want)
+		}
+	}
+}
+
+func TestRedactValues(t *testing.T) {
+	values := url.Values{
+		"Cell":   {"13812345678"},
+		"pwd":    {"secret"},
+		"code":   {"1234"},
+		"desc":   {"call 13912345678"},
+		"amount": {"10"},
+	}
+	want := "Cell=138****5678&amount=10&code=***&desc=call 139****5678&pwd=***"
+	if got := RedactValues(values); got != want {
+		t.Errorf("RedactValues = %q, want %q", got, want)
+	}
+	if got := RedactValues(nil); got != "" {
+		t.Errorf("RedactValues(nil) = %q", got)
+	}
+}
+
+func TestRedactURL(t *testing.T) {
+	cases := []struct {
+		url  string
+		want string
+	}{
+		{"https://api.weixin.qq.com/cgi-bin/menu/create?access_token=abc", "https://api.weixin.qq.com/cgi-bin/menu/create?access_token=***"},
+		{"https://api.weixin.qq.com/cgi-bin/token?grant_type=client_credential&appid=wx1&secret=s3cret", "https://api.weixin.qq.com/cgi-bin/token?appid=wx1&grant_type=client_credential&secret=***"},
+		{"https://yun.tim.qq.com/v5/tlssmssvr/sendsms?sdkappid=1400&random=1234", "https://yun.tim.qq.com/v5/tlssmssvr/sendsms?random=1234&sdkappid=1400"},
+		{"http://example.com/path", "http://example.com/path"},
+		{"://bad?access_token=abc", "://bad"},
+	}
+	for _, c := range cases {
+		if got := RedactURL(c.url); got != c.want {
+			t.Errorf("RedactURL(%q) = %q, want %q", c.url, got, c.want)
+		}
+	}
+}
+
+func TestHTTPErrorRedactsURL(t *testing.T) {
+	err := GetWithObjResponse(context.Background(), "http://127.0.0.1:0/cgi-bin/token?secret=s3cret&access_token=abc", nil)
+	if err == nil {
+		t.Fatal("request to port 0 succeeded")
+	}
+	if strings.Contains(err.Error(), "s3cret") || strings.Contains(err.Error(), "abc") {
+		t.Errorf("error contains secrets: %v", err)
+	}
+}
@@ -0,0 +1,66 @@
+package util
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+)
+
+// RequestIDHeader 请求 ID 的 http header，调用下游服务时透传
+const RequestIDHeader = "X-Request-ID"
+
+// logsIDKey logs.CtxInfo 等方法从 context 中读取日志 ID 使用的 key
+const logsIDKey = "K_LOGID"
+
+// NewRequestID 生成随机的请求 ID
+func NewRequestID() string {
+	return RandomHex(16)
+}
+
+// RandomHex 使用 crypto/rand 生成 n 字节的随机数，返回其十六进制字符串
+func RandomHex(n int) string {
+	buf := make([]byte, n)
+	if _, err := rand.Read(buf); err != nil {
+		panic("read crypto/rand error: " + err.Error())
+	}
+	return hex.EncodeToString(buf)
+}
+
+// RandomDigits 使用 crypto/rand 生成 n 位随机数字
+func RandomDigits(n int) string {
+	buf := make([]byte, n)
+	if _, err := rand.Read(buf); err != nil {
+		panic("read crypto/rand error: " + err.Error())
+	}
+	for i := range buf {
+		buf[i] = '0' + buf[i]%10
+	}
+	return string(buf)
+}
+
+// WithRequestID 将请求 ID 写入 context，logs.Ctx* 系列方法会在日志中输出该 ID
+func WithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, logsIDKey, id)
+}
+
+// RequestID 从 context 中读取请求 ID，不存在时返回空字符串
+func RequestID(ctx context.Context) string {
+	if ctx == nil {
+		return ""
+	}
+	id, _ := ctx.Value(logsIDKey).(string)
+	return id
+}
+
+// ValidRequestID 上游传入的请求 ID 只允许字母、数字和 -_，长度不超过 64
+func ValidRequestID(id string) bool {
+	if id == "" || len(id) > 64 {
+		return false
+	}
+	for _, r := range id {
+		if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '-' || r == '_') {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,234 @@
+package util
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/csv"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// maxTableColumns 表格的最大列数，xlsx 的行号和列号来自文件内容，需要限制以免补齐空单元格时占用过多内存
+const maxTableColumns = 256
+
+// ReadTable 按扩展名读取 csv 或 xlsx 文件的所有行，xlsx 只读取第一个工作表
+// 行数超过 maxRows(包括表头和空行)或列数超过 maxTableColumns 时返回错误
+func ReadTable(filename string, data []byte, maxRows int) ([][]string, error) {
+	switch strings.ToLower(filepath.Ext(filename)) {
+	case ".csv":
+		return readCSV(data, maxRows)
+	case ".xlsx":
+		return readXLSX(data, maxRows)
+	default:
+		return nil, fmt.Errorf("unsupported file type %q, only .csv and .xlsx are supported", filepath.Ext(filename))
+	}
+}
+
+func readCSV(data []byte, maxRows int) ([][]string, error) {
+	// Excel 另存为 csv 时会带 utf-8 BOM
+	data = bytes.TrimPrefix(data, []byte("\xef\xbb\xbf"))
+	reader := csv.NewReader(bytes.NewReader(data))
+	reader.FieldsPerRecord = -1
+	rows := make([][]string, 0)
+	for {
+		row, err := reader.Read()
+		if err == io.EOF {
+			return rows, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if len(rows) >= maxRows {
+			return nil, fmt.Errorf("more than %d rows", maxRows)
+		}
+		if len(row) > maxTableColumns {
+			return nil, fmt.Errorf("more than %d columns", maxTableColumns)
+		}
+		rows = append(rows, row)
+	}
+}
+
+type xlsxWorkbook struct {
+	Sheets []struct {
+		RID string `xml:"http://schemas.openxmlformats.org/officeDocument/2006/relationships id,attr"`
+	} `xml:"sheets>sheet"`
+}
+
+type xlsxRelationships struct {
+	Relationships []struct {
+		ID     string `xml:"Id,attr"`
+		Target string `xml:"Target,attr"`
+	} `xml:"Relationship"`
+}
+
+// xlsxText 共享字符串和内联字符串，富文本由多个 r 组成
+type xlsxText struct {
+	T string `xml:"t"`
+	R []struct {
+		T string `xml:"t"`
+	} `xml:"r"`
+}
+
+func (t xlsxText) String() string {
+	if len(t.R) == 0 {
+		return t.T
+	}
+	var buf bytes.Buffer
+	for _, r := range t.R {
+		buf.WriteString(r.T)
+	}
+	return buf.String()
+}
+
+type xlsxSharedStrings struct {
+	Items []xlsxText `xml:"si"`
+}
+
+type xlsxSheet struct {
+	Rows []struct {
+		Num   int `xml:"r,attr"`
+		Cells []struct {
+			Ref    string   `xml:"r,attr"`
+			Type   string   `xml:"t,attr"`
+			Value  string   `xml:"v"`
+			Inline xlsxText `xml:"is"`
+		} `xml:"c"`
+	} `xml:"sheetData>row"`
+}
+
+// readXLSX 读取第一个工作表的单元格文本，数字和日期返回 Excel 存储的原始值，日期为距 1899-12-30 的天数
+func readXLSX(data []byte, maxRows int) ([][]string, error) {
+	zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return nil, fmt.Errorf("invalid xlsx file: %v", err)
+	}
+	files := make(map[string]*zip.File)
+	for _, f := range zr.File {
+		files[f.Name] = f
+	}
+	sheetPath, err := firstSheetPath(files)
+	if err != nil {
+		return nil, err
+	}
+	var shared xlsxSharedStrings
+	if f, ok := files["xl/sharedStrings.xml"]; ok {
+		if err := decodeZipXML(f, &shared); err != nil {
+			return nil, err
+		}
+	}
+	f, ok := files[sheetPath]
+	if !ok {
+		return nil, fmt.Errorf("invalid xlsx file: %s not found", sheetPath)
+	}
+	var sheet xlsxSheet
+	if err := decodeZipXML(f, &sheet); err != nil {
+		return nil, err
+	}
+	rows := make([][]string, 0, len(sheet.Rows))
+	for _, r := range sheet.Rows {
+		if r.Num > maxRows || len(rows) >= maxRows {
+			return nil, fmt.Errorf("more than %d rows", maxRows)
+		}
+		// 空行不会出现在 sheetData 中，补齐以保持行号一致
+		for r.Num > 0 && len(rows) < r.Num-1 {
+			rows = append(rows, nil)
+		}
+		var row []string
+		for i, c := range r.Cells {
+			col := columnIndex(c.Ref)
+			if col < 0 {
+				col = i
+			}
+			if col >= maxTableColumns {
+				return nil, fmt.Errorf("more than %d columns", maxTableColumns)
+			}
+			for len(row) <= col {
+				row = append(row, "")
+			}
+			switch c.Type {
+			case "s":
+				idx, err := strconv.Atoi(c.Value)
+				if err != nil || idx < 0 || idx >= len(shared.Items) {
+					return nil, fmt.Errorf("invalid xlsx file: bad shared string index %q", c.Value)
+				}
+				row[col] = shared.Items[idx].String()
+			case "inlineStr":
+				row[col] = c.Inline.String()
+			case "b":
+				row[col] = map[string]string{"1": "TRUE", "0": "FALSE"}[c.Value]
+			default:
+				row[col] = c.Value
+			}
+		}
+		rows = append(rows, row)
+	}
+	return rows, nil
+}
+
+// firstSheetPath 从 workbook.xml 和其关系文件中找到第一个工作表的路径
+func firstSheetPath(files map[string]*zip.File) (string, error) {
+	const fallback = "xl/worksheets/sheet1.xml"
+	wbFile, ok := files["xl/workbook.xml"]
+	if !ok {
+		return "", fmt.Errorf("invalid xlsx file: xl/workbook.xml not found")
+	}
+	var wb xlsxWorkbook
+	if err := decodeZipXML(wbFile, &wb); err != nil {
+		return "", err
+	}
+	relFile, ok := files["xl/_rels/workbook.xml.rels"]
+	if len(wb.Sheets) == 0 || !ok {
+		return fallback, nil
+	}
+	var rels xlsxRelationships
+	if err := decodeZipXML(relFile, &rels); err != nil {
+		return "", err
+	}
+	for _, rel := range rels.Relationships {
+		if rel.ID == wb.Sheets[0].RID {
+			if strings.HasPrefix(rel.Target, "/") {
+				return strings.TrimPrefix(rel.Target, "/"), nil
+			}
+			return path.Join("xl", rel.Target), nil
+		}
+	}
+	return fallback, nil
+}
+
+func decodeZipXML(f *zip.File, v interface{}) error {
+	rc, err := f.Open()
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+	body, err := ioutil.ReadAll(io.LimitReader(rc, 64<<20))
+	if err != nil {
+		return err
+	}
+	if err := xml.Unmarshal(body, v); err != nil {
+		return fmt.Errorf("invalid xlsx file: parse %s error: %v", f.Name, err)
+	}
+	return nil
+}
+
+// columnIndex 单元格引用的列号，从 0 开始，如 A1 为 0，AB3 为 27，没有列号时返回 -1
+func columnIndex(ref string) int {
+	col := 0
+	for _, ch := range ref {
+		if ch < 'A' || ch > 'Z' {
+			break
+		}
+		col = col*26 + int(ch-'A') + 1
+		if col > maxTableColumns {
+			// 避免超长的单元格引用溢出
+			return maxTableColumns
+		}
+	}
+	return col - 1
+}
@@ -0,0 +1,90 @@
+package util
+
+import (
+	"archive/zip"
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// buildXLSX 生成测试用的 xlsx 文件，files 为 zip 中的文件名和内容
+func buildXLSX(t *testing.T, files map[string]string) []byte {
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for name, content := range files {
+		w, err := zw.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.Write([]byte(content))
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestReadTableCSV(t *testing.T) {
+	rows, err := ReadTable("a.CSV", []byte("\xef\xbb\xbf姓名,手机号\n张三,13800000000,多余\n\n李四\n"), 10)
+	want := [][]string{{"姓名", "手机号"}, {"张三", "13800000000", "多余"}, {"李四"}}
+	if err != nil || !reflect.DeepEqual(rows, want) {
+		t.Errorf("ReadTable csv = %q, %v, want %q", rows, err, want)
+	}
+	if _, err := ReadTable("a.csv", []byte("a\nb\nc\n"), 2); err == nil {
+		t.Error("ReadTable csv with too many rows succeeded")
+	}
+	if _, err := ReadTable("a.csv", []byte(strings.Repeat("a,", maxTableColumns)+"a\n"), 2); err == nil {
+		t.Error("ReadTable csv with too many columns succeeded")
+	}
+	if _, err := ReadTable("a.xls", []byte("a"), 2); err == nil {
+		t.Error("ReadTable xls succeeded")
+	}
+}
+
+func TestReadTableXLSX(t *testing.T) {
+	files := map[string]string{
+		"xl/workbook.xml": `<workbook xmlns:r="http://schemas.openxmlformats.org/officeDocument/2006/relationships">` +
+			`<sheets><sheet name="会员" sheetId="1" r:id="rId2"/></sheets></workbook>`,
+		"xl/_rels/workbook.xml.rels": `<Relationships><Relationship Id="rId1" Target="worksheets/sheet1.xml"/>` +
+			`<Relationship Id="rId2" Target="worksheets/members.xml"/></Relationships>`,
+		"xl/sharedStrings.xml": `<sst><si><t>姓名</t></si><si><r><t>张</t></r><r><t>三</t></r></si></sst>`,
+		"xl/worksheets/members.xml": `<worksheet><sheetData>` +
+			`<row r="1"><c r="A1" t="s"><v>0</v></c><c r="C1" t="inlineStr"><is><t>余额</t></is></c></row>` +
+			`<row r="3"><c r="A3" t="s"><v>1</v></c><c r="B3" t="b"><v>1</v></c><c r="C3"><v>12.5</v></c></row>` +
+			`</sheetData></worksheet>`,
+	}
+	rows, err := ReadTable("a.xlsx", buildXLSX(t, files), 10)
+	want := [][]string{{"姓名", "", "余额"}, nil, {"张三", "TRUE", "12.5"}}
+	if err != nil || !reflect.DeepEqual(rows, want) {
+		t.Errorf("ReadTable xlsx = %q, %v, want %q", rows, err, want)
+	}
+	if _, err := ReadTable("a.xlsx", buildXLSX(t, files), 2); err == nil {
+		t.Error("ReadTable xlsx with too many rows succeeded")
+	}
+
+	broken := []map[string]string{
+		{"xl/worksheets/sheet1.xml": `<worksheet/>`},
+		{"xl/workbook.xml": `<workbook/>`},
+		{"xl/workbook.xml": `<workbook/>`, "xl/worksheets/sheet1.xml": `<worksheet><sheetData><row r="1"><c r="A1" t="s"><v>5</v></c></row></sheetData></worksheet>`},
+		{"xl/workbook.xml": `<workbook/>`, "xl/worksheets/sheet1.xml": `<worksheet><sheetData><row r="1"><c r="ZZZ1"><v>1</v></c></row></sheetData></worksheet>`},
+		{"xl/workbook.xml": `<workbook/>`, "xl/worksheets/sheet1.xml": `<worksheet><sheetData><row r="1000000"><c r="A1"><v>1</v></c></row></sheetData></worksheet>`},
+	}
+	for _, files := range broken {
+		if _, err := ReadTable("a.xlsx", buildXLSX(t, files), 10); err == nil {
+			t.Errorf("ReadTable xlsx %v succeeded", files)
+		}
+	}
+	if _, err := ReadTable("a.xlsx", []byte("not a zip"), 10); err == nil {
+		t.Error("ReadTable invalid zip succeeded")
+	}
+}
+
+func TestColumnIndex(t *testing.T) {
+	cases := map[string]int{"A1": 0, "Z9": 25, "AA1": 26, "AB3": 27, "1": -1, "": -1, "ZZZZZZZZZZ1": maxTableColumns}
+	for ref, want := range cases {
+		if got := columnIndex(ref); got != want {
+			t.Errorf("columnIndex(%q) = %d, want %d", ref, got, want)
+		}
+	}
+}
@@ -0,0 +1,98 @@
+package util
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+// wxBlockSize 微信消息加解密使用 32 字节的 PKCS7 填充
+const wxBlockSize = 32
+
+// ErrWXDecrypt 微信消息解密失败
+var ErrWXDecrypt = errors.New("wechat message decrypt failed")
+
+// WXSignature 微信签名：将参数按字典序排序拼接后做 sha1
+func WXSignature(params ...string) string {
+	strs := make([]string, len(params))
+	copy(strs, params)
+	sort.Strings(strs)
+	s := sha1.New()
+	io.WriteString(s, strings.Join(strs, ""))
+	return fmt.Sprintf("%x", s.Sum(nil))
+}
+
+// WXAESKey 由公众号后台配置的 EncodingAESKey 得到 AES 密钥
+func WXAESKey(encodingAESKey string) ([]byte, error) {
+	key, err := base64.StdEncoding.DecodeString(encodingAESKey + "=")
+	if err != nil {
+		return nil, err
+	}
+	if len(key) != 32 {
+		return nil, errors.New("invalid EncodingAESKey length")
+	}
+	return key, nil
+}
+
+// WXEncrypt 安全模式下加密回复的消息
+func WXEncrypt(key []byte, appID string, msg []byte) (string, error) {
+	buf := make([]byte, 16, 16+4+len(msg)+len(appID))
+	if _, err := io.ReadFull(rand.Reader, buf); err != nil {
+		return "", err
+	}
+	msgLen := make([]byte, 4)
+	binary.BigEndian.PutUint32(msgLen, uint32(len(msg)))
+	buf = append(buf, msgLen...)
+	buf = append(buf, msg...)
+	buf = append(buf, appID...)
+	buf = PKCS5Padding(buf, wxBlockSize)
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return "", err
+	}
+	crypted := make([]byte, len(buf))
+	cipher.NewCBCEncrypter(block, key[:aes.BlockSize]).CryptBlocks(crypted, buf)
+	return base64.StdEncoding.EncodeToString(crypted), nil
+}
+
+// WXDecrypt 安全模式下解密收到的消息，返回消息内容和消息所属的 appID
+func WXDecrypt(key []byte, encrypted string) ([]byte, string, error) {
+	crypted, err := base64.StdEncoding.DecodeString(encrypted)
+	if err != nil {
+		return nil, "", err
+	}
+	if len(crypted) == 0 || len(crypted)%aes.BlockSize != 0 {
+		return nil, "", ErrWXDecrypt
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, "", err
+	}
+	plain := make([]byte, len(crypted))
+	cipher.NewCBCDecrypter(block, key[:aes.BlockSize]).CryptBlocks(plain, crypted)
+
+	padding := int(plain[len(plain)-1])
+	if padding < 1 || padding > wxBlockSize || padding > len(plain) ||
+		!bytes.Equal(plain[len(plain)-padding:], bytes.Repeat([]byte{byte(padding)}, padding)) {
+		return nil, "", ErrWXDecrypt
+	}
+	plain = plain[:len(plain)-padding]
+	if len(plain) < 20 {
+		return nil, "", ErrWXDecrypt
+	}
+	msgLen := int(binary.BigEndian.Uint32(plain[16:20]))
+	if msgLen > len(plain)-20 {
+		return nil, "", ErrWXDecrypt
+	}
+	return plain[20 : 20+msgLen], string(plain[20+msgLen:]), nil
+}